@@ -0,0 +1,118 @@
+package agent
+
+import (
+	"errors"
+	"github.com/realbmail/go-bmail-account"
+	"net"
+	"os"
+	"sync"
+)
+
+//Client is a connection to the agent, it is safe for concurrent use
+type Client struct {
+	lock sync.Mutex
+	c    net.Conn
+}
+
+//Dial connect the agent at path, the path of SockEnv when empty
+func Dial(path string) (*Client, error) {
+	if path == "" {
+		path = os.Getenv(SockEnv)
+	}
+	if path == "" {
+		return nil, errors.New("no agent, " + SockEnv + " is not set")
+	}
+	c, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{c: c}, nil
+}
+
+func (ac *Client) Close() error {
+	return ac.c.Close()
+}
+
+func (ac *Client) call(req *request) (*response, error) {
+	ac.lock.Lock()
+	defer ac.lock.Unlock()
+
+	if err := writeMsg(ac.c, req); err != nil {
+		return nil, err
+	}
+	resp := &response{}
+	if err := readMsg(ac.c, resp); err != nil {
+		return nil, err
+	}
+	if resp.Err != "" {
+		switch resp.Err {
+		case ErrNoKey.Error():
+			return nil, ErrNoKey
+		case ErrRefused.Error():
+			return nil, ErrRefused
+		}
+		return nil, errors.New(resp.Err)
+	}
+	return resp, nil
+}
+
+func (ac *Client) List() ([]*KeyInfo, error) {
+	resp, err := ac.call(&request{Op: opList})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Keys, nil
+}
+
+//Key find the key of a mail name in the agent
+func (ac *Client) Key(mailName string) (*Key, error) {
+	keys, err := ac.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, ki := range keys {
+		if ki.MailName == mailName {
+			return &Key{ac: ac, info: *ki}, nil
+		}
+	}
+	return nil, ErrNoKey
+}
+
+//Key is a signer.Key and a signer.DHKey whose private key stays in the
+//agent
+type Key struct {
+	ac   *Client
+	info KeyInfo
+}
+
+func (k *Key) Address() bmail.Address {
+	return k.info.Addr
+}
+
+func (k *Key) MailAddress() string {
+	return k.info.MailName
+}
+
+func (k *Key) Sign(message []byte) ([]byte, error) {
+	resp, err := k.ac.call(&request{Op: opSign, Addr: k.info.Addr, Data: message})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Data, nil
+}
+
+func (k *Key) AesKeyOf(peer bmail.Address) ([]byte, error) {
+	resp, err := k.ac.call(&request{Op: opAgree, Addr: k.info.Addr, Peer: peer})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Data, nil
+}
+
+func (k *Key) X25519(peer []byte) ([]byte, error) {
+	resp, err := k.ac.call(&request{Op: opDH, Addr: k.info.Addr, Pub: peer})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Data, nil
+}
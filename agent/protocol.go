@@ -0,0 +1,87 @@
+package agent
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"github.com/realbmail/go-bmail-account"
+	"io"
+)
+
+//the agent keeps unlocked keys in a process of its own and signs or agrees
+//aes keys for the mail apps over a unix socket, like ssh-agent:
+//
+//mail app --request--> agent --response--> mail app
+//
+//a message is a 4 byte big endian length and the json of a request or a
+//response. Private keys never cross the socket.
+
+const (
+	//SockEnv is the environment variable with the socket path
+	SockEnv = "BMAIL_AGENT_SOCK"
+
+	MaxMsgSize = 1 << 16
+)
+
+const (
+	opList  = "list"
+	opSign  = "sign"
+	opAgree = "agree"
+	opDH    = "x25519"
+)
+
+var (
+	ErrMsgTooLarge = errors.New("agent message too large")
+	ErrNoKey       = errors.New("agent has no such key")
+	ErrRefused     = errors.New("agent refused the request")
+)
+
+type KeyInfo struct {
+	Addr     bmail.Address `json:"addr"`
+	MailName string        `json:"mail_name"`
+}
+
+type request struct {
+	Op   string        `json:"op"`
+	Addr bmail.Address `json:"addr,omitempty"`
+	Data []byte        `json:"data,omitempty"` //the message to sign
+	Peer bmail.Address `json:"peer,omitempty"` //the peer to agree a key with
+	Pub  []byte        `json:"pub,omitempty"`  //the X25519 key to do X25519 with
+}
+
+type response struct {
+	Err  string     `json:"err,omitempty"`
+	Keys []*KeyInfo `json:"keys,omitempty"`
+	Data []byte     `json:"data,omitempty"` //signature, aes key or shared secret
+}
+
+func writeMsg(w io.Writer, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if len(data) > MaxMsgSize {
+		return ErrMsgTooLarge
+	}
+	buf := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(buf, uint32(len(data)))
+	copy(buf[4:], data)
+	_, err = w.Write(buf)
+	return err
+}
+
+func readMsg(r io.Reader, v interface{}) error {
+	var head [4]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return err
+	}
+	n := binary.BigEndian.Uint32(head[:])
+	if n > MaxMsgSize {
+		return ErrMsgTooLarge
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
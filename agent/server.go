@@ -0,0 +1,230 @@
+package agent
+
+import (
+	"errors"
+	"github.com/realbmail/go-bmail-account"
+	"github.com/realbmail/go-bmail-protocol/signer"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+var ErrClosed = errors.New("agent closed")
+
+//Server hold the keys and answer the requests on the socket
+type Server struct {
+	//Confirm is asked before every sign or agree when set, a false refuses
+	//the request
+	Confirm func(op string, key *KeyInfo) bool
+
+	lock sync.Mutex
+	keys map[bmail.Address]signer.Key
+	ln   net.Listener
+	conn map[net.Conn]struct{}
+	wg   sync.WaitGroup
+	quit bool
+}
+
+func NewServer() *Server {
+	return &Server{
+		keys: make(map[bmail.Address]signer.Key),
+		conn: make(map[net.Conn]struct{}),
+	}
+}
+
+func (s *Server) Add(k signer.Key) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.keys[k.Address()] = k
+}
+
+func (s *Server) Remove(addr bmail.Address) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, ok := s.keys[addr]
+	delete(s.keys, addr)
+	return ok
+}
+
+//ListenAndServe serve on a unix socket only the user can open, a socket
+//left by a dead agent is replaced. The socket is made in a directory of
+//mode 0700, others can't reach it before its own mode is set.
+func (s *Server) ListenAndServe(path string) error {
+	if err := privateDir(filepath.Dir(path)); err != nil {
+		return err
+	}
+	if c, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		c.Close()
+		return errors.New("an agent is serving " + path)
+	}
+	os.Remove(path)
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		ln.Close()
+		return err
+	}
+	return s.Serve(ln)
+}
+
+//privateDir make dir of mode 0700 or tighten it, it fails on a directory
+//of another user
+func privateDir(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	fi, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return errors.New(dir + " is not a directory")
+	}
+	if fi.Mode().Perm() == 0700 {
+		return nil
+	}
+	if err := os.Chmod(dir, 0700); err != nil {
+		return err
+	}
+	if fi, err = os.Lstat(dir); err != nil {
+		return err
+	}
+	if fi.Mode().Perm() != 0700 {
+		return errors.New(dir + " is open to others")
+	}
+	return nil
+}
+
+//Serve accept clients on ln until Close
+func (s *Server) Serve(ln net.Listener) error {
+	s.lock.Lock()
+	if s.quit {
+		s.lock.Unlock()
+		ln.Close()
+		return ErrClosed
+	}
+	s.ln = ln
+	s.lock.Unlock()
+
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			s.lock.Lock()
+			quit := s.quit
+			s.lock.Unlock()
+			if quit {
+				return ErrClosed
+			}
+			return err
+		}
+
+		//a client accepted while Close runs is not served, Close does
+		//not see it
+		s.lock.Lock()
+		if s.quit {
+			s.lock.Unlock()
+			c.Close()
+			return ErrClosed
+		}
+		s.conn[c] = struct{}{}
+		s.wg.Add(1)
+		s.lock.Unlock()
+
+		go func() {
+			defer s.wg.Done()
+			s.serveConn(c)
+
+			s.lock.Lock()
+			delete(s.conn, c)
+			s.lock.Unlock()
+		}()
+	}
+}
+
+//Close stop accepting, drop the clients and wait for them
+func (s *Server) Close() error {
+	s.lock.Lock()
+	s.quit = true
+	if s.ln != nil {
+		s.ln.Close()
+	}
+	for c := range s.conn {
+		c.Close()
+	}
+	s.lock.Unlock()
+
+	s.wg.Wait()
+	return nil
+}
+
+func (s *Server) serveConn(c net.Conn) {
+	defer c.Close()
+	for {
+		req := &request{}
+		if err := readMsg(c, req); err != nil {
+			return
+		}
+		if err := writeMsg(c, s.handle(req)); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) key(addr bmail.Address) signer.Key {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.keys[addr]
+}
+
+func (s *Server) handle(req *request) *response {
+	if req.Op == opList {
+		s.lock.Lock()
+		resp := &response{}
+		for addr, k := range s.keys {
+			resp.Keys = append(resp.Keys, &KeyInfo{Addr: addr, MailName: k.MailAddress()})
+		}
+		s.lock.Unlock()
+		sort.Slice(resp.Keys, func(i, j int) bool {
+			return resp.Keys[i].Addr < resp.Keys[j].Addr
+		})
+		return resp
+	}
+
+	k := s.key(req.Addr)
+	if k == nil {
+		return &response{Err: ErrNoKey.Error()}
+	}
+	if s.Confirm != nil && !s.Confirm(req.Op, &KeyInfo{Addr: req.Addr, MailName: k.MailAddress()}) {
+		return &response{Err: ErrRefused.Error()}
+	}
+
+	var (
+		data []byte
+		err  error
+	)
+	switch req.Op {
+	case opSign:
+		data, err = k.Sign(req.Data)
+	case opAgree:
+		data, err = k.AesKeyOf(req.Peer)
+	case opDH:
+		dh, ok := k.(signer.DHKey)
+		if !ok {
+			err = errors.New("key can't do X25519")
+			break
+		}
+		data, err = dh.X25519(req.Pub)
+	default:
+		err = errors.New("unknown agent op [" + req.Op + "]")
+	}
+	if err != nil {
+		return &response{Err: err.Error()}
+	}
+	return &response{Data: data}
+}
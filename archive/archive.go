@@ -0,0 +1,164 @@
+package archive
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/realbmail/go-bmail-protocol/bmp"
+	"github.com/realbmail/go-bmail-protocol/eml"
+	"math"
+)
+
+//an export pages through the mailbox from the newest mail back, by the
+//time the server took each, opens every mail by the wallet and writes it
+//to a Maildir or an mbox file. A manifest beside the archive lists the
+//Eids written, an export to the same place again only adds the mails not
+//listed.
+
+const (
+	FormatMaildir = "maildir"
+	FormatMbox    = "mbox"
+
+	DefaultPageSize = 50
+)
+
+//Source is the mailbox exported, client.BMailClient is one. ReceivePage
+//give the time the server took each mail of the page by Eid, a mail of no
+//time pages by its DateSince1970.
+type Source interface {
+	ReceivePage(timeSince1970 int64, olderThanSince bool, maxCount int) ([]*bmp.BMailEnvelope, map[string]int64, error)
+	DownloadAttachment(eid string, att *bmp.Attachment) ([]byte, error)
+}
+
+type Config struct {
+	Format   string
+	Path     string //the Maildir directory or the mbox file
+	PageSize int    //mails asked for at a time, DefaultPageSize when 0
+	Wallet   eml.Wallet
+	Source   Source
+}
+
+type Result struct {
+	Added   int
+	Known   int      //in the manifest already
+	Skipped []string //Eids of the mails the wallet can't open
+}
+
+type writer interface {
+	manifestPath() string
+	write(env *bmp.BMailEnvelope, data []byte) (string, error)
+	Close() error
+}
+
+//Run export the mailbox, calling it again resumes after the last mail
+//written
+func Run(conf *Config) (*Result, error) {
+	if conf.Wallet == nil || conf.Source == nil || conf.Path == "" {
+		return nil, errors.New("export needs wallet, source and path")
+	}
+	pageSize := conf.PageSize
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+
+	var (
+		w   writer
+		err error
+	)
+	switch conf.Format {
+	case FormatMaildir:
+		w, err = openMaildir(conf.Path)
+	case FormatMbox:
+		w, err = openMbox(conf.Path)
+	default:
+		return nil, fmt.Errorf("unknown export format [%s]", conf.Format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer w.Close()
+
+	mf, err := openManifest(w.manifestPath())
+	if err != nil {
+		return nil, err
+	}
+	defer mf.Close()
+
+	r := &Result{}
+	var (
+		pivot  int64 = math.MaxInt64
+		size         = pageSize
+		newest int64
+		seen   = make(map[string]bool)
+	)
+	for {
+		envs, received, err := conf.Source.ReceivePage(pivot, true, size)
+		if err != nil {
+			return r, err
+		}
+
+		n := len(received)
+		if len(envs) > n {
+			n = len(envs)
+		}
+		oldest := pivot
+		took := func(at int64) {
+			if at < oldest {
+				oldest = at
+			}
+			if at > newest {
+				newest = at
+			}
+		}
+		for _, at := range received {
+			took(at)
+		}
+		for _, env := range envs {
+			if _, ok := received[env.Eid]; !ok {
+				took(int64(env.DateSince1970))
+			}
+			//the mails at the pivot come again in the next page
+			if seen[env.Eid] {
+				continue
+			}
+			seen[env.Eid] = true
+			if mf.has(env.Eid) {
+				r.Known++
+				continue
+			}
+
+			rec := &Record{Eid: env.Eid, Date: env.DateSince1970, Sig: env.FromSig}
+			buf := &bytes.Buffer{}
+			if err := eml.Render(buf, env, conf.Wallet, conf.Source.DownloadAttachment); err != nil {
+				r.Skipped = append(r.Skipped, env.Eid)
+				continue
+			}
+			if rec.File, err = w.write(env, buf.Bytes()); err != nil {
+				return r, err
+			}
+			if err := mf.add(rec); err != nil {
+				return r, err
+			}
+			r.Added++
+		}
+
+		//a short page is the end of the mailbox, mails the server took
+		//before the last finished export are all written
+		if n < size {
+			break
+		}
+		if mf.done > 0 && uint64(oldest) <= mf.done {
+			break
+		}
+		//the next page takes the time of the oldest mail in too, the mails
+		//of that time that did not fit are not lost. A page all of one
+		//time is asked again bigger.
+		if oldest+1 < pivot {
+			pivot, size = oldest+1, pageSize
+		} else {
+			size *= 2
+		}
+	}
+
+	return r, mf.finish(uint64(newest))
+}
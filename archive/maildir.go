@@ -0,0 +1,56 @@
+package archive
+
+import (
+	"fmt"
+	"github.com/realbmail/go-bmail-protocol/bmp"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const manifestName = ".bmail-manifest"
+
+//maildir write a mail to tmp and move it to cur, readers never see a half
+//written one. The name is made of date and Eid, so writing a mail again
+//after a crash replaces it.
+type maildir struct {
+	root string
+}
+
+func openMaildir(root string) (*maildir, error) {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(root, sub), 0700); err != nil {
+			return nil, err
+		}
+	}
+	return &maildir{root: root}, nil
+}
+
+func (md *maildir) manifestPath() string {
+	return filepath.Join(md.root, manifestName)
+}
+
+func (md *maildir) write(env *bmp.BMailEnvelope, data []byte) (string, error) {
+	//an Eid from outside may hold anything, keep the name safe
+	eid := strings.Map(func(r rune) rune {
+		if r == '/' || r == ':' || r == os.PathSeparator || r < ' ' {
+			return '_'
+		}
+		return r
+	}, env.Eid)
+	name := fmt.Sprintf("%d.%s.bmail:2,S", env.DateSince1970/1000, eid)
+
+	tmp := filepath.Join(md.root, "tmp", name)
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp, filepath.Join(md.root, "cur", name)); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+func (md *maildir) Close() error {
+	return nil
+}
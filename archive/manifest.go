@@ -0,0 +1,92 @@
+package archive
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+)
+
+//Record is a line of the manifest, the lines are only appended so a broken
+//export loses at most the mail being written
+type Record struct {
+	Eid  string `json:"eid,omitempty"`
+	Date uint64 `json:"date"`
+	Sig  []byte `json:"sig,omitempty"`  //FromSig of the envelope, the sender's signature
+	File string `json:"file,omitempty"` //file in the Maildir, empty for mbox
+	//an export got to the end, every mail the server took before Date is
+	//written
+	Done bool `json:"done,omitempty"`
+}
+
+type manifest struct {
+	f    *os.File
+	eids map[string]bool
+	done uint64
+}
+
+func openManifest(path string) (*manifest, error) {
+	mf := &manifest{eids: make(map[string]bool)}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		rec := &Record{}
+		//a line cut by a crash is dropped, its mail is written again
+		if err := json.Unmarshal(line, rec); err != nil {
+			continue
+		}
+		if rec.Done {
+			mf.done = rec.Date
+		} else {
+			mf.eids[rec.Eid] = true
+		}
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	mf.f = f
+
+	//the next record starts on a line of its own after a cut one
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		if _, err := f.Write([]byte("\n")); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return mf, nil
+}
+
+func (mf *manifest) has(eid string) bool {
+	return mf.eids[eid]
+}
+
+func (mf *manifest) add(rec *Record) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := mf.f.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	if !rec.Done {
+		mf.eids[rec.Eid] = true
+	}
+	return mf.f.Sync()
+}
+
+func (mf *manifest) finish(date uint64) error {
+	if err := mf.add(&Record{Date: date, Done: true}); err != nil {
+		return err
+	}
+	mf.done = date
+	return nil
+}
+
+func (mf *manifest) Close() error {
+	return mf.f.Close()
+}
@@ -0,0 +1,73 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/realbmail/go-bmail-protocol/bmp"
+	"os"
+	"regexp"
+	"time"
+)
+
+//mbox is the mboxrd flavour: a "From " line starts a mail, a body line
+//matching ">*From " gets one more '>' and lines end in LF
+type mbox struct {
+	path string
+	f    *os.File
+}
+
+var fromLine = regexp.MustCompile(`^>*From `)
+
+func openMbox(path string) (*mbox, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &mbox{path: path, f: f}, nil
+}
+
+func (mb *mbox) manifestPath() string {
+	return mb.path + manifestName
+}
+
+func (mb *mbox) write(env *bmp.BMailEnvelope, data []byte) (string, error) {
+	bw := bufio.NewWriter(mb.f)
+
+	date := time.Unix(0, int64(env.DateSince1970)*int64(time.Millisecond)).UTC()
+	bw.WriteString("From " + mboxSender(env.FromName) + " " + date.Format(time.ANSIC) + "\n")
+
+	data = bytes.Replace(data, []byte("\r\n"), []byte("\n"), -1)
+	data = bytes.TrimSuffix(data, []byte("\n"))
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if fromLine.Match(line) {
+			bw.WriteByte('>')
+		}
+		bw.Write(line)
+		bw.WriteByte('\n')
+	}
+	bw.WriteByte('\n')
+
+	if err := bw.Flush(); err != nil {
+		return "", err
+	}
+	return "", mb.f.Sync()
+}
+
+//mboxSender keep the visible ascii of name, a space or line end in it
+//would start a forged mail
+func mboxSender(name string) string {
+	b := make([]byte, 0, len(name))
+	for i := 0; i < len(name); i++ {
+		if name[i] > 0x20 && name[i] < 0x7f {
+			b = append(b, name[i])
+		}
+	}
+	if len(b) == 0 {
+		return "MAILER-DAEMON"
+	}
+	return string(b)
+}
+
+func (mb *mbox) Close() error {
+	return mb.f.Close()
+}
@@ -0,0 +1,361 @@
+package blobstore
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+//Store keep attachment data once per FileProperty.Hash (sha256 of the
+//data), however many envelopes carry it. Every envelope holding a blob is
+//a ref, the blob is garbage when its last ref is released.
+//
+//layout under root:
+//
+//	blobs/<hex[:2]>/<hex>   finished blobs
+//	partial/<hex>           uploads in progress, see Append
+//	index.json              refs of every blob
+type Store struct {
+	lock  sync.Mutex
+	root  string
+	refs  map[string]map[string]bool //hash hex -> refs
+	byRef map[string]map[string]bool //ref -> hash hex
+}
+
+var (
+	ErrNotFound     = errors.New("blob not found")
+	ErrHashMismatch = errors.New("blob hash not match")
+	ErrBadOffset    = errors.New("blob offset not match received size")
+)
+
+const (
+	blobDir    = "blobs"
+	partialDir = "partial"
+	indexFile  = "index.json"
+)
+
+func Open(root string) (*Store, error) {
+	for _, d := range []string{blobDir, partialDir} {
+		if err := os.MkdirAll(filepath.Join(root, d), 0700); err != nil {
+			return nil, err
+		}
+	}
+
+	s := &Store{
+		root:  root,
+		refs:  make(map[string]map[string]bool),
+		byRef: make(map[string]map[string]bool),
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(root, indexFile))
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	index := make(map[string][]string)
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, err
+	}
+	for h, refs := range index {
+		for _, ref := range refs {
+			s.addRef(h, ref)
+		}
+	}
+
+	return s, nil
+}
+
+func hashKey(hash []byte) (string, error) {
+	if len(hash) != sha256.Size {
+		return "", errors.New("blob hash must be sha256")
+	}
+	return hex.EncodeToString(hash), nil
+}
+
+func (s *Store) blobPath(h string) string {
+	return filepath.Join(s.root, blobDir, h[:2], h)
+}
+
+func (s *Store) partialPath(h string) string {
+	return filepath.Join(s.root, partialDir, h)
+}
+
+//Has report if the blob is stored, a client can skip the upload then
+func (s *Store) Has(hash []byte) bool {
+	h, err := hashKey(hash)
+	if err != nil {
+		return false
+	}
+	return s.has(h)
+}
+
+func (s *Store) has(h string) bool {
+	_, err := os.Stat(s.blobPath(h))
+	return err == nil
+}
+
+//Received is how many bytes of hash the store has, the full size once the
+//blob is finished
+func (s *Store) Received(hash []byte) (int64, error) {
+	h, err := hashKey(hash)
+	if err != nil {
+		return 0, err
+	}
+	if fi, err := os.Stat(s.blobPath(h)); err == nil {
+		return fi.Size(), nil
+	}
+	fi, err := os.Stat(s.partialPath(h))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+//Append add a chunk to an unfinished upload, offset must be what Received
+//returns. The blob is finished and checked when size bytes are in.
+func (s *Store) Append(hash []byte, offset, size int64, r io.Reader) (int64, error) {
+	h, err := hashKey(hash)
+	if err != nil {
+		return 0, err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.append(h, hash, offset, size, r)
+}
+
+//append is Append with s.lock held
+func (s *Store) append(h string, hash []byte, offset, size int64, r io.Reader) (int64, error) {
+	if fi, err := os.Stat(s.blobPath(h)); err == nil {
+		return fi.Size(), nil
+	}
+
+	f, err := os.OpenFile(s.partialPath(h), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return 0, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return 0, err
+	}
+	if fi.Size() != offset {
+		f.Close()
+		return fi.Size(), ErrBadOffset
+	}
+
+	n, err := io.Copy(f, io.LimitReader(r, size-offset))
+	received := offset + n
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return received, err
+	}
+
+	if received < size {
+		return received, nil
+	}
+
+	return received, s.finish(h, hash)
+}
+
+func (s *Store) finish(h string, hash []byte) error {
+	f, err := os.Open(s.partialPath(h))
+	if err != nil {
+		return err
+	}
+	sum := sha256.New()
+	_, err = io.Copy(sum, f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(sum.Sum(nil), hash) {
+		os.Remove(s.partialPath(h))
+		return ErrHashMismatch
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.blobPath(h)), 0700); err != nil {
+		return err
+	}
+	return os.Rename(s.partialPath(h), s.blobPath(h))
+}
+
+//Put store a whole blob in one go. A blob stored already is renewed, GC
+//keeps it for grace again.
+func (s *Store) Put(hash []byte, size int64, r io.Reader) error {
+	h, err := hashKey(hash)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.has(h) {
+		now := time.Now()
+		return os.Chtimes(s.blobPath(h), now, now)
+	}
+	if err := os.Remove(s.partialPath(h)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	_, err = s.append(h, hash, 0, size, r)
+	return err
+}
+
+//Open the finished blob, *os.File serves range requests by ReadAt
+func (s *Store) Open(hash []byte) (*os.File, error) {
+	h, err := hashKey(hash)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(s.blobPath(h))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+func (s *Store) addRef(h, ref string) {
+	if s.refs[h] == nil {
+		s.refs[h] = make(map[string]bool)
+	}
+	s.refs[h][ref] = true
+
+	if s.byRef[ref] == nil {
+		s.byRef[ref] = make(map[string]bool)
+	}
+	s.byRef[ref][h] = true
+}
+
+//AddRef tie a blob to an envelope, ref is normally the Eid, or owner+Eid
+//when every mailbox keeps its own copy of the envelope
+func (s *Store) AddRef(hash []byte, ref string) error {
+	h, err := hashKey(hash)
+	if err != nil {
+		return err
+	}
+
+	//under the lock, GC can't remove the blob before the ref is in
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !s.has(h) {
+		return ErrNotFound
+	}
+	if s.refs[h][ref] {
+		return nil
+	}
+	s.addRef(h, ref)
+
+	return s.saveIndex()
+}
+
+//Release drop all blobs of an envelope, call it when the envelope is
+//deleted. It returns the hashes left without any ref.
+func (s *Store) Release(ref string) ([][]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	hs, ok := s.byRef[ref]
+	if !ok {
+		return nil, nil
+	}
+	delete(s.byRef, ref)
+
+	var orphans [][]byte
+	for h := range hs {
+		delete(s.refs[h], ref)
+		if len(s.refs[h]) == 0 {
+			delete(s.refs, h)
+			hash, _ := hex.DecodeString(h)
+			orphans = append(orphans, hash)
+		}
+	}
+
+	return orphans, s.saveIndex()
+}
+
+func (s *Store) RefCount(hash []byte) int {
+	h, err := hashKey(hash)
+	if err != nil {
+		return 0
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return len(s.refs[h])
+}
+
+//GC remove blobs and unfinished uploads without ref, files younger than
+//grace are kept, they may be uploads whose envelope is not sent yet.
+func (s *Store) GC(grace time.Duration) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	deadline := time.Now().Add(-grace)
+	removed := 0
+
+	walk := func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() || fi.ModTime().After(deadline) {
+			return nil
+		}
+		if len(s.refs[fi.Name()]) > 0 {
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		removed++
+		return nil
+	}
+
+	for _, d := range []string{blobDir, partialDir} {
+		if err := filepath.Walk(filepath.Join(s.root, d), walk); err != nil {
+			return removed, err
+		}
+	}
+
+	return removed, nil
+}
+
+func (s *Store) saveIndex() error {
+	index := make(map[string][]string, len(s.refs))
+	for h, refs := range s.refs {
+		for ref := range refs {
+			index[h] = append(index[h], ref)
+		}
+	}
+
+	data, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(s.root, indexFile+".tmp")
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(s.root, indexFile))
+}
@@ -2,18 +2,22 @@ package bmclient
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"github.com/realbmail/go-bmail-protocol/bmprotocol"
 	"github.com/realbmail/go-bmail-protocol/translayer"
 	"github.com/pkg/errors"
+	"io"
 	"net"
 	"strconv"
 	"time"
 )
 
 type BMClient struct {
-	sn      []byte
-	c       *net.TCPConn
-	timeout int //second
+	sn       []byte
+	c        *net.TCPConn
+	timeout  int    //second
+	compress uint16 //codec the server accepts, chosen by hello
+	ver      uint16 //version of the frames sent, chosen by hello
 }
 
 func NewClient(serverIP net.IP, timeout int) *BMClient {
@@ -27,6 +31,7 @@ func NewClient(serverIP net.IP, timeout int) *BMClient {
 	c := &BMClient{}
 	c.c = conn
 	c.timeout = timeout
+	c.ver = translayer.BMAILVER1
 
 	conn.SetDeadline(time.Now().Add(time.Second * time.Duration(timeout)))
 
@@ -53,10 +58,15 @@ func (c *BMClient) SendEnvelope(envelope *bmprotocol.SendEnvelope) (rse *bmproto
 
 	var data []byte
 
+	envelope.SetVersion(c.ver)
 	data, err = envelope.Pack()
 	if err != nil {
 		return nil, err
 	}
+	data, err = bmprotocol.CompressPack(data, c.compress)
+	if err != nil {
+		return nil, err
+	}
 
 	var n int
 	n, err = c.c.Write(data)
@@ -83,6 +93,10 @@ func (c *BMClient) SendEnvelope(envelope *bmprotocol.SendEnvelope) (rse *bmproto
 	if n != int(bmtl.GetDataLen()) || err != nil {
 		return nil, errors.New("Read a bad bmail data")
 	}
+	buf, err = bmprotocol.UnCompressData(bmtl, buf)
+	if err != nil {
+		return nil, err
+	}
 
 	resp := &bmprotocol.RespSendEnvelope{}
 	resp.BMTransLayer = *bmtl
@@ -103,6 +117,8 @@ func (c *BMClient) HeloSendAndRcv() (err error) {
 	}
 
 	helo := bmprotocol.NewBMHello()
+	helo.SetAccept(translayer.SupportedCompress())
+	helo.SetVersions(translayer.SupportedVersion())
 	data, _ := helo.Pack()
 
 	var n int
@@ -142,6 +158,214 @@ func (c *BMClient) HeloSendAndRcv() (err error) {
 	}
 
 	c.sn = ha.GetSn()
+	c.compress = translayer.ChooseCompress(ha.GetAccept())
+	c.ver = translayer.ChooseVersion(ha.GetVersions())
 
 	return nil
 }
+
+//SendAttachment upload the file of sa in chunks. It asks the server how
+//much of the file it has first, so calling it again after a broken
+//connection resumes from the last acknowledged chunk. All chunks carry the
+//EnvelopeSig of this session, NewSn of the last response is the next sn.
+func (c *BMClient) SendAttachment(sa *bmprotocol.SendAttachment, chunkSize int64) (*bmprotocol.RespSendAttachment, error) {
+	if c.c == nil {
+		return nil, errors.New("client is not initialized")
+	}
+	if bytes.Compare(sa.EnvelopeSig.Sn, c.sn) != 0 {
+		return nil, errors.New("Attachment not correct")
+	}
+	if chunkSize <= 0 {
+		chunkSize = bmprotocol.DefaultChunkSize
+	}
+
+	sa.NextChunk(0, 0)
+	resp, err := c.sendChunk(sa)
+	if err != nil {
+		return nil, err
+	}
+
+	for resp.Received < int64(sa.FileSize) {
+		if resp.Received < 0 {
+			return nil, errors.New("Bad received size: " + strconv.FormatInt(resp.Received, 10))
+		}
+		sa.NextChunk(resp.Received, chunkSize)
+
+		resp, err = c.sendChunk(sa)
+		if err != nil {
+			return nil, err
+		}
+		if resp.Received < sa.Offset+sa.Length {
+			return nil, errors.New("Chunk not accepted at: " + strconv.FormatInt(sa.Offset, 10))
+		}
+	}
+
+	c.sn = resp.NewSn
+
+	return resp, nil
+}
+
+func (c *BMClient) sendChunk(sa *bmprotocol.SendAttachment) (*bmprotocol.RespSendAttachment, error) {
+	sar, err := sa.GetReader()
+	if err != nil {
+		return nil, err
+	}
+
+	var n64 int64
+	n64, err = io.Copy(c.c, sar)
+	if n64 != int64(sar.GetTotalSize()) || err != nil {
+		return nil, errors.New("Send attachment chunk Failed")
+	}
+
+	buf := make([]byte, translayer.BMHeadSize())
+	if _, err = io.ReadFull(c.c, buf); err != nil {
+		return nil, errors.New("Read a bad bmail head")
+	}
+
+	bmtl := &translayer.BMTransLayer{}
+	bmtl.UnPack(buf)
+
+	if bmtl.GetMsgType() != translayer.RESP_ATTACHMENT || bmtl.GetDataLen() == 0 {
+		return nil, errors.New("Received a error message: " + strconv.Itoa(int(bmtl.GetMsgType())))
+	}
+
+	buf = make([]byte, bmtl.GetDataLen())
+	if _, err = io.ReadFull(c.c, buf); err != nil {
+		return nil, errors.New("Read a bad bmail data")
+	}
+	if buf, err = bmprotocol.UnCompressData(bmtl, buf); err != nil {
+		return nil, err
+	}
+
+	resp := &bmprotocol.RespSendAttachment{}
+	resp.BMTransLayer = *bmtl
+	_, err = resp.UnPack(buf)
+	if err != nil {
+		return nil, err
+	}
+	if resp.ErrId != 0 {
+		return nil, errors.New("Send attachment error: " + strconv.Itoa(resp.ErrId))
+	}
+	if resp.EId != sa.EId || bytes.Compare(resp.Hash, sa.Hash) != 0 {
+		return nil, errors.New("Response not for this attachment")
+	}
+
+	return resp, nil
+}
+
+//RetrAttachment stream the file of ra from ra.Offset into w, chunk by
+//chunk. ra.Length > 0 asks for that many bytes, 0 for the rest of the
+//file. A download that ends at the end of the file is checked against
+//ra.Hash, a resumed one reads the ra.Offset bytes before it back from w,
+//which must be the io.ReaderAt of the file it resumes. A range that ends
+//before the end of the file is not checked.
+func (c *BMClient) RetrAttachment(ra *bmprotocol.RetrAttachment, chunkSize int64, w io.Writer) (int64, error) {
+	if c.c == nil {
+		return 0, errors.New("client is not initialized")
+	}
+	if bytes.Compare(ra.EnvelopeSig.Sn, c.sn) != 0 {
+		return 0, errors.New("Attachment not correct")
+	}
+	if chunkSize <= 0 {
+		chunkSize = bmprotocol.DefaultChunkSize
+	}
+
+	size := int64(ra.FileSize)
+	if ra.Offset < 0 || ra.Length < 0 || ra.Offset > size {
+		return 0, errors.New("Attachment range out of file")
+	}
+	end := size
+	if ra.Length > 0 && ra.Length < size-ra.Offset {
+		end = ra.Offset + ra.Length
+	}
+
+	var (
+		total int64
+		h     = sha256.New()
+		check = end == size
+	)
+	if check && ra.Offset > 0 {
+		prev, ok := w.(io.ReaderAt)
+		if !ok {
+			return 0, errors.New("Resumed attachment can't be checked without its file")
+		}
+		n, err := io.Copy(h, io.NewSectionReader(prev, 0, ra.Offset))
+		if err != nil {
+			return 0, err
+		}
+		if n != ra.Offset {
+			return 0, errors.New("Resumed attachment file is short")
+		}
+	}
+	if check {
+		w = io.MultiWriter(w, h)
+	}
+
+	for ra.Offset < end {
+		ra.Length = chunkSize
+		if ra.Length > end-ra.Offset {
+			ra.Length = end - ra.Offset
+		}
+
+		resp, f, err := c.retrChunk(ra)
+		if err != nil {
+			return total, err
+		}
+		if resp.Length == 0 {
+			f.Release()
+			return total, errors.New("Server sent an empty chunk at: " + strconv.FormatInt(ra.Offset, 10))
+		}
+
+		_, err = w.Write(resp.Data)
+		f.Release()
+		if err != nil {
+			return total, err
+		}
+		total += resp.Length
+		ra.Offset += resp.Length
+	}
+
+	if check && bytes.Compare(h.Sum(nil), ra.Hash) != 0 {
+		return total, errors.New("Attachment hash not match")
+	}
+
+	return total, nil
+}
+
+//retrChunk give the response and the frame it is read from, resp.Data is
+//in the frame, which the caller releases after using it
+func (c *BMClient) retrChunk(ra *bmprotocol.RetrAttachment) (*bmprotocol.RespRetrAttachment, *bmprotocol.Frame, error) {
+	ra.SetVersion(c.ver)
+	if _, err := ra.WriteTo(c.c); err != nil {
+		return nil, nil, errors.New("Send attachment request Failed")
+	}
+
+	//a chunk never exceeds what was asked, plus the head
+	f, err := bmprotocol.ReadFrame(c.c, int(ra.Length)+ra.Size())
+	if err != nil {
+		return nil, nil, errors.New("Read a bad bmail data: " + err.Error())
+	}
+
+	if f.GetMsgType() != translayer.RETR_ATTACHMENT_RESP || len(f.Data) == 0 {
+		f.Release()
+		return nil, nil, errors.New("Received a error message: " + strconv.Itoa(int(f.GetMsgType())))
+	}
+
+	resp := &bmprotocol.RespRetrAttachment{}
+	resp.BMTransLayer = f.BMTransLayer
+	if _, err = resp.UnPack(f.Data); err != nil {
+		f.Release()
+		return nil, nil, err
+	}
+	if resp.ErrId != 0 {
+		f.Release()
+		return nil, nil, errors.New("Retrieve attachment error: " + strconv.Itoa(resp.ErrId))
+	}
+	if resp.EId != ra.EId || bytes.Compare(resp.Hash, ra.Hash) != 0 ||
+		resp.Offset != ra.Offset || resp.Length > ra.Length {
+		f.Release()
+		return nil, nil, errors.New("Response not for this request")
+	}
+
+	return resp, f, nil
+}
@@ -0,0 +1,183 @@
+package bmp
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"github.com/realbmail/go-bmail-protocol/subkey"
+	"github.com/realbmail/go-bmail-protocol/translayer"
+)
+
+//Attachment.Scheme
+const (
+	AttachSchemeBuffer int = iota //whole file by bmailcrypt.Encrypt
+	AttachSchemeStream            //chunked AEAD of package stream
+)
+
+//the file is encrypted by its own key, Key is that file key encrypted by
+//the envelope key, so every recipient who can open Recipient.AESKey can
+//open the file, and forwarding only wraps Key again.
+type Attachment struct {
+	Hash     []byte `json:"hash"`     //sha256 of the uploaded (encrypted) data
+	FileName string `json:"fileName"` //encrypted by the file key, base64
+	FileType string `json:"fileType"`
+	Size     int64  `json:"size"` //size of the uploaded data
+	Key      []byte `json:"key"`
+	Scheme   int    `json:"scheme,omitempty"`
+	Path     string `json:"path,omitempty"` //set by server for big files
+}
+
+func (a *Attachment) ToString() string {
+	return fmt.Sprintf("\n=================================="+
+		"\n\tHash:\t%20x"+
+		"\n\tFileName:\t%20s"+
+		"\n\tFileType:\t%20s"+
+		"\n\tSize:\t%20d"+
+		"\n\tPath:\t%20s"+
+		"\n==================================",
+		a.Hash,
+		a.FileName,
+		a.FileType,
+		a.Size,
+		a.Path)
+}
+
+//client --AttachmentSyn + Size raw bytes--> server
+//server --AttachmentAck--> client
+type AttachmentSyn struct {
+	SN   BMailSN      `json:"sn"`
+	Sig  []byte       `json:"sig"`
+	Eid  string       `json:"eid"`
+	Hash []byte       `json:"hash"`
+	Size int64        `json:"size"`
+	Cert *subkey.Cert `json:"cert,omitempty"` //of the sub-key Sig is made by
+}
+
+func (as *AttachmentSyn) MsgType() uint16 {
+	return translayer.SEND_ATTACHMNENT
+}
+
+func (as *AttachmentSyn) VerifyHeader(header *Header) bool {
+	return header.MsgTyp == translayer.SEND_ATTACHMNENT &&
+		header.MsgLen != 0
+}
+
+func (as *AttachmentSyn) GetBytes() ([]byte, error) {
+	return json.Marshal(*as)
+}
+
+type AttachmentAck struct {
+	NextSN    BMailSN `json:"nextSN"`
+	Hash      []byte  `json:"hash"`
+	Sig       []byte  `json:"sig"`
+	ErrorCode int     `json:"errorCode"`
+	Path      string  `json:"path,omitempty"`
+}
+
+func (aa *AttachmentAck) MsgType() uint16 {
+	return translayer.RESP_ATTACHMENT
+}
+
+func (aa *AttachmentAck) VerifyHeader(header *Header) bool {
+	return header.MsgTyp == translayer.RESP_ATTACHMENT &&
+		header.MsgLen != 0
+}
+
+func (aa *AttachmentAck) GetBytes() ([]byte, error) {
+	return json.Marshal(*aa)
+}
+
+//client --AttachmentRetr--> server
+//server --AttachmentRetrAck + Size raw bytes--> client
+type AttachmentRetr struct {
+	SN   BMailSN      `json:"sn"`
+	Sig  []byte       `json:"sig"`
+	Eid  string       `json:"eid"`
+	Hash []byte       `json:"hash"`
+	Cert *subkey.Cert `json:"cert,omitempty"` //of the sub-key Sig is made by
+}
+
+func (ar *AttachmentRetr) MsgType() uint16 {
+	return translayer.RETR_ATTACHMENT
+}
+
+func (ar *AttachmentRetr) VerifyHeader(header *Header) bool {
+	return header.MsgTyp == translayer.RETR_ATTACHMENT &&
+		header.MsgLen != 0
+}
+
+func (ar *AttachmentRetr) GetBytes() ([]byte, error) {
+	return json.Marshal(*ar)
+}
+
+type AttachmentRetrAck struct {
+	Hash      []byte `json:"hash"`
+	Sig       []byte `json:"sig"`
+	ErrorCode int    `json:"errorCode"`
+	Size      int64  `json:"size"`
+}
+
+func (ara *AttachmentRetrAck) MsgType() uint16 {
+	return translayer.RETR_ATTACHMENT_RESP
+}
+
+func (ara *AttachmentRetrAck) VerifyHeader(header *Header) bool {
+	return header.MsgTyp == translayer.RETR_ATTACHMENT_RESP &&
+		header.MsgLen != 0
+}
+
+func (ara *AttachmentRetrAck) GetBytes() ([]byte, error) {
+	return json.Marshal(*ara)
+}
+
+//client --AttachmentCheck--> server
+//server --AttachmentCheckAck--> client
+//
+//the server links every hash it already stores to Eid, the client only
+//uploads the attachments with Has[i] false.
+type AttachmentCheck struct {
+	SN     BMailSN      `json:"sn"`
+	Sig    []byte       `json:"sig"`
+	Eid    string       `json:"eid"`
+	Hashes [][]byte     `json:"hashes"`
+	Cert   *subkey.Cert `json:"cert,omitempty"` //of the sub-key Sig is made by
+}
+
+func (ac *AttachmentCheck) MsgType() uint16 {
+	return translayer.CHECK_ATTACHMENT
+}
+
+func (ac *AttachmentCheck) VerifyHeader(header *Header) bool {
+	return header.MsgTyp == translayer.CHECK_ATTACHMENT &&
+		header.MsgLen != 0
+}
+
+func (ac *AttachmentCheck) GetBytes() ([]byte, error) {
+	return json.Marshal(*ac)
+}
+
+func (ac *AttachmentCheck) Hash() []byte {
+	data, _ := json.Marshal(*ac)
+	hash := sha256.Sum256(data)
+	return hash[:]
+}
+
+type AttachmentCheckAck struct {
+	Hash      []byte `json:"hash"` //Hash of the AttachmentCheck
+	Sig       []byte `json:"sig"`
+	ErrorCode int    `json:"errorCode"`
+	Has       []bool `json:"has"` //same order as AttachmentCheck.Hashes
+}
+
+func (aca *AttachmentCheckAck) MsgType() uint16 {
+	return translayer.CHECK_ATTACHMENT_RESP
+}
+
+func (aca *AttachmentCheckAck) VerifyHeader(header *Header) bool {
+	return header.MsgTyp == translayer.CHECK_ATTACHMENT_RESP &&
+		header.MsgLen != 0
+}
+
+func (aca *AttachmentCheckAck) GetBytes() ([]byte, error) {
+	return json.Marshal(*aca)
+}
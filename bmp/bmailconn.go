@@ -1,14 +1,16 @@
 package bmp
 
 import (
-	"encoding/json"
 	"fmt"
 	"github.com/realbmail/go-bmail-protocol/translayer"
+	"io"
 	"net"
 )
 
 type BMailConn struct {
 	*net.TCPConn
+	compress uint16
+	ver      uint16
 }
 
 func NewBMConn(ip net.IP) (*BMailConn, error) {
@@ -17,9 +19,10 @@ func NewBMConn(ip net.IP) (*BMailConn, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &BMailConn{conn}, nil
+	return &BMailConn{TCPConn: conn}, nil
 }
 
+//Helo is sent in BMAILVER1, the server may not know the others yet
 func (bc *BMailConn) Helo() error {
 	header := Header{
 		Ver:    translayer.BMAILVER1,
@@ -35,26 +38,85 @@ func (bc *BMailConn) Helo() error {
 
 }
 
+func (bc *BMailConn) IdleDone() error {
+	header := Header{
+		Ver:    bc.version(),
+		MsgTyp: translayer.IDLE_DONE,
+		MsgLen: 0,
+	}
+	if _, err := bc.Write(header.GetBytes()); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+//SetCompress set the codec for frames sent after, it must be one the peer
+//listed. Frames read are decompressed by the codec in their header.
+func (bc *BMailConn) SetCompress(compress uint16) {
+	bc.compress = compress
+}
+
+//SetVersion set the version of frames sent after, it must be one in the
+//SupportVersion of the peer. Frames read are unpacked by the version in
+//their header.
+func (bc *BMailConn) SetVersion(ver uint16) {
+	bc.ver = ver
+}
+
+func (bc *BMailConn) version() uint16 {
+	if bc.ver == 0 {
+		return translayer.BMAILVER1
+	}
+	return bc.ver
+}
+
 func (bc *BMailConn) SendWithHeader(v EnvelopeMsg) error {
-	dataV, err := json.Marshal(v)
+	data, err := PackFrame(bc.version(), bc.compress, v)
 	if err != nil {
 		return err
 	}
 
+	if n, err := bc.Write(data); err != nil {
+		fmt.Println("write frame len:", n)
+		return err
+	}
+	return nil
+}
+
+//PackFrame pack v by the codec of version ver after its header, the body is
+//compressed by compress when that makes it smaller
+func PackFrame(ver, compress uint16, v EnvelopeMsg) ([]byte, error) {
+	codec, err := CodecOf(ver)
+	if err != nil {
+		return nil, err
+	}
+	dataV, err := codec.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	msgTyp := v.MsgType()
+	if cData, ok := translayer.Compress(compress, dataV); ok {
+		dataV = cData
+		msgTyp = translayer.WithCompress(msgTyp, compress)
+	}
+
 	header := Header{
-		Ver:    translayer.BMAILVER1,
-		MsgTyp: v.MsgType(),
+		Ver:    ver,
+		MsgTyp: msgTyp,
 		MsgLen: len(dataV),
 	}
 
-	data := header.GetBytes()
-	if n, err := bc.Write(data); err != nil {
-		fmt.Println("write header len:", n)
+	return append(header.GetBytes(), dataV...), nil
+}
+
+//SendWithData send v, then size raw bytes from r
+func (bc *BMailConn) SendWithData(v EnvelopeMsg, r io.Reader, size int64) error {
+	if err := bc.SendWithHeader(v); err != nil {
 		return err
 	}
-	fmt.Println("send with header: body:=>", string(dataV))
-	if n, err := bc.Write(dataV); err != nil {
-		fmt.Println("write body len:", n)
+	if _, err := io.CopyN(bc, r, size); err != nil {
 		return err
 	}
 	return nil
@@ -70,6 +132,12 @@ func (bc *BMailConn) ReadWithHeader(v EnvelopeMsg) error {
 		fmt.Println("header.Derive:", err)
 		return err
 	}
+	codec, err := CodecOf(header.Ver)
+	if err != nil {
+		return err
+	}
+	compress := translayer.CompressOf(header.MsgTyp)
+	header.MsgTyp = translayer.MsgTypeOf(header.MsgTyp)
 
 	if !v.VerifyHeader(header) {
 		return fmt.Errorf("unexcept data")
@@ -92,10 +160,16 @@ func (bc *BMailConn) ReadWithHeader(v EnvelopeMsg) error {
 		}
 	}
 
+	buf, err = translayer.Decompress(compress, buf)
+	if err != nil {
+		fmt.Println("translayer.Decompress:", err)
+		return err
+	}
+
 	fmt.Println("read with header: body:=>", string(buf))
 
-	if err := json.Unmarshal(buf, v); err != nil {
-		fmt.Println("json.Unmarshal:", err)
+	if err := codec.Unmarshal(buf, v); err != nil {
+		fmt.Println("codec.Unmarshal:", err)
 		return err
 	}
 	return nil
@@ -4,7 +4,9 @@ import (
 	"encoding/binary"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/realbmail/go-bmail-account"
+	"github.com/realbmail/go-bmail-protocol/subkey"
 	"github.com/realbmail/go-bmail-protocol/translayer"
 )
 
@@ -55,6 +57,45 @@ func (h *Header) Derive(data []byte) (int, error) {
 	return int(h.GetLen()), nil
 }
 
+//Codec pack the body of a frame, the Ver of its Header selects it
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type cborCodec struct{}
+
+func (cborCodec) Marshal(v interface{}) ([]byte, error) {
+	return translayer.CborMarshal(v)
+}
+
+func (cborCodec) Unmarshal(data []byte, v interface{}) error {
+	return translayer.CborUnmarshal(data, v)
+}
+
+//CodecOf give the codec of version ver, BMAILVER1 is JSON, BMAILVER2 is
+//the CBOR of spec/bmailv2.cddl. Hash and sign inputs stay JSON in every
+//version.
+func CodecOf(ver uint16) (Codec, error) {
+	switch ver {
+	case translayer.BMAILVER1:
+		return jsonCodec{}, nil
+	case translayer.BMAILVER2:
+		return cborCodec{}, nil
+	}
+	return nil, fmt.Errorf("version %d not supported", ver)
+}
+
 type HELO struct {
 }
 
@@ -67,6 +108,7 @@ type HELOACK struct {
 	SrvBca         bmail.Address `json:"srv"`
 	ErrCode        int           `json:"errCode"`
 	SupportVersion []uint16      `json:"support_version"`
+	Compress       []uint16      `json:"compress,omitempty"` //codecs the server reads and writes
 }
 
 func (ha *HELOACK) MsgType() uint16 {
@@ -86,6 +128,7 @@ type EnvelopeSyn struct {
 	Sig  []byte         `json:"sig"`
 	Hash []byte         `json:"hash"`
 	Env  *BMailEnvelope `json:"env"`
+	Cert *subkey.Cert   `json:"cert,omitempty"` //of the sub-key Sig is made by
 }
 
 func (es *EnvelopeSyn) MsgType() uint16 {
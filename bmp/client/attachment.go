@@ -0,0 +1,346 @@
+package client
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"github.com/realbmail/go-bas-mail-server/bmailcrypt"
+	"github.com/realbmail/go-bmail-account"
+	"github.com/realbmail/go-bmail-protocol/bmp"
+	"github.com/realbmail/go-bmail-protocol/stream"
+	"io"
+)
+
+const FileKeySize = 32
+
+//SealAttachment encrypt a file by a new file key, envKey is the key the
+//envelope subject and body are encrypted by. The returned data is what
+//UploadAttachment sends.
+func SealAttachment(envKey []byte, fileName, fileType string, data []byte) (*bmp.Attachment, []byte, error) {
+	fileKey := make([]byte, FileKeySize)
+	if _, err := io.ReadFull(rand.Reader, fileKey); err != nil {
+		return nil, nil, err
+	}
+
+	cipherData, err := bmailcrypt.Encrypt(fileKey, data)
+	if err != nil {
+		return nil, nil, err
+	}
+	cipherName, err := bmailcrypt.Encrypt(fileKey, []byte(fileName))
+	if err != nil {
+		return nil, nil, err
+	}
+	wrapped, err := bmailcrypt.Encrypt(envKey, fileKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hash := sha256.Sum256(cipherData)
+	att := &bmp.Attachment{
+		Hash:     hash[:],
+		FileName: base64.StdEncoding.EncodeToString(cipherName),
+		FileType: fileType,
+		Size:     int64(len(cipherData)),
+		Key:      wrapped,
+	}
+	return att, cipherData, nil
+}
+
+type countWriter struct {
+	n int64
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	cw.n += int64(len(p))
+	return len(p), nil
+}
+
+//SealAttachmentStream encrypt src into dst by the stream scheme, memory use
+//does not grow with the file. dst is then uploaded by UploadAttachment.
+func SealAttachmentStream(envKey []byte, fileName, fileType string, src io.Reader, dst io.Writer) (*bmp.Attachment, error) {
+	fileKey := make([]byte, FileKeySize)
+	if _, err := io.ReadFull(rand.Reader, fileKey); err != nil {
+		return nil, err
+	}
+
+	cipherName, err := bmailcrypt.Encrypt(fileKey, []byte(fileName))
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := bmailcrypt.Encrypt(envKey, fileKey)
+	if err != nil {
+		return nil, err
+	}
+
+	h := sha256.New()
+	cnt := &countWriter{}
+	sw, err := stream.NewWriter(fileKey, io.MultiWriter(dst, h, cnt))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(sw, src); err != nil {
+		return nil, err
+	}
+	if err := sw.Close(); err != nil {
+		return nil, err
+	}
+
+	return &bmp.Attachment{
+		Hash:     h.Sum(nil),
+		FileName: base64.StdEncoding.EncodeToString(cipherName),
+		FileType: fileType,
+		Size:     cnt.n,
+		Key:      wrapped,
+		Scheme:   bmp.AttachSchemeStream,
+	}, nil
+}
+
+//OpenAttachmentStream decrypt a stream scheme attachment from src into dst.
+//Every chunk is authenticated before it is written, a cut or changed
+//stream fails, the hash of src is checked at the end.
+func OpenAttachmentStream(envKey []byte, att *bmp.Attachment, src io.Reader, dst io.Writer) (string, error) {
+	if att.Scheme != bmp.AttachSchemeStream {
+		return "", fmt.Errorf("attachment is not a stream")
+	}
+
+	fileKey, err := openFileKey(envKey, att)
+	if err != nil {
+		return "", err
+	}
+	cipherName, err := base64.StdEncoding.DecodeString(att.FileName)
+	if err != nil {
+		return "", err
+	}
+	name, err := bmailcrypt.Decrypt(fileKey, cipherName)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	sr, err := stream.NewReader(fileKey, io.TeeReader(src, h))
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(dst, sr); err != nil {
+		return "", err
+	}
+	if !bytes.Equal(h.Sum(nil), att.Hash) {
+		return "", fmt.Errorf("attachment hash not match")
+	}
+	return string(name), nil
+}
+
+func openFileKey(envKey []byte, att *bmp.Attachment) ([]byte, error) {
+	fileKey, err := bmailcrypt.Decrypt(envKey, att.Key)
+	if err != nil {
+		return nil, err
+	}
+	if len(fileKey) != FileKeySize {
+		return nil, fmt.Errorf("invalid attachment key")
+	}
+	return fileKey, nil
+}
+
+//OpenAttachment check the hash of downloaded data and decrypt it
+func OpenAttachment(envKey []byte, att *bmp.Attachment, cipherData []byte) (string, []byte, error) {
+	hash := sha256.Sum256(cipherData)
+	if !bytes.Equal(hash[:], att.Hash) {
+		return "", nil, fmt.Errorf("attachment hash not match")
+	}
+
+	fileKey, err := openFileKey(envKey, att)
+	if err != nil {
+		return "", nil, err
+	}
+
+	cipherName, err := base64.StdEncoding.DecodeString(att.FileName)
+	if err != nil {
+		return "", nil, err
+	}
+	name, err := bmailcrypt.Decrypt(fileKey, cipherName)
+	if err != nil {
+		return "", nil, err
+	}
+	data, err := bmailcrypt.Decrypt(fileKey, cipherData)
+	if err != nil {
+		return "", nil, err
+	}
+	return string(name), data, nil
+}
+
+//RewrapAttachment move an attachment to a new envelope key, for forward,
+//the uploaded data is not changed.
+func RewrapAttachment(oldEnvKey, newEnvKey []byte, att *bmp.Attachment) (*bmp.Attachment, error) {
+	fileKey, err := openFileKey(oldEnvKey, att)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := bmailcrypt.Encrypt(newEnvKey, fileKey)
+	if err != nil {
+		return nil, err
+	}
+
+	r := *att
+	r.Key = wrapped
+	r.Path = ""
+	return &r, nil
+}
+
+//CheckAttachments ask the server which attachments it stores already,
+//those are linked to eid and need no upload.
+func (bmc *BMailClient) CheckAttachments(eid string, atts []*bmp.Attachment) ([]bool, error) {
+	conn, err := bmp.NewBMConn(bmc.SrvIP)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	ack, err := bmc.HandShake(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, cert, err := bmc.sign(ack.SN.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	check := &bmp.AttachmentCheck{
+		SN:   ack.SN,
+		Sig:  sig,
+		Cert: cert,
+		Eid:  eid,
+	}
+	for _, a := range atts {
+		check.Hashes = append(check.Hashes, a.Hash)
+	}
+	if err := conn.SendWithHeader(check); err != nil {
+		return nil, err
+	}
+
+	checkAck := &bmp.AttachmentCheckAck{}
+	if err := conn.ReadWithHeader(checkAck); err != nil {
+		return nil, err
+	}
+	if checkAck.ErrorCode != 0 {
+		return nil, fmt.Errorf("check attachment failed, server error:%d", checkAck.ErrorCode)
+	}
+	if !bytes.Equal(checkAck.Hash, check.Hash()) || !bmail.Verify(ack.SrvBca, checkAck.Hash, checkAck.Sig) {
+		return nil, fmt.Errorf("verify attachment check failed:[%s]", ack.SrvBca)
+	}
+	if len(checkAck.Has) != len(atts) {
+		return nil, fmt.Errorf("attachment check result count not match")
+	}
+	return checkAck.Has, nil
+}
+
+//UploadAttachment send the sealed data of att, eid is the envelope the
+//attachment belongs to. The server may set att.Path. A forwarded
+//attachment keeps its hash, CheckAttachments saves the upload then.
+func (bmc *BMailClient) UploadAttachment(eid string, att *bmp.Attachment, data io.Reader) error {
+	conn, err := bmp.NewBMConn(bmc.SrvIP)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ack, err := bmc.HandShake(conn)
+	if err != nil {
+		return err
+	}
+
+	sig, cert, err := bmc.sign(ack.SN.Bytes())
+	if err != nil {
+		return err
+	}
+	syn := &bmp.AttachmentSyn{
+		SN:   ack.SN,
+		Sig:  sig,
+		Cert: cert,
+		Eid:  eid,
+		Hash: att.Hash,
+		Size: att.Size,
+	}
+	if err := conn.SendWithData(syn, data, att.Size); err != nil {
+		return err
+	}
+
+	synAck := &bmp.AttachmentAck{}
+	if err := conn.ReadWithHeader(synAck); err != nil {
+		return err
+	}
+	if synAck.ErrorCode != 0 {
+		return fmt.Errorf("upload attachment failed, server error:%d", synAck.ErrorCode)
+	}
+	if !bytes.Equal(synAck.Hash, att.Hash) || !bmail.Verify(ack.SrvBca, synAck.Hash, synAck.Sig) {
+		return fmt.Errorf("verify attachment ack failed:[%s]", ack.SrvBca)
+	}
+
+	att.Path = synAck.Path
+	return nil
+}
+
+//DownloadAttachment fetch the sealed data of att, open it by OpenAttachment
+func (bmc *BMailClient) DownloadAttachment(eid string, att *bmp.Attachment) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := bmc.DownloadAttachmentTo(eid, att, buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+//DownloadAttachmentTo stream the sealed data of att into w, the hash is
+//checked after the last byte. A stream scheme attachment is opened by
+//OpenAttachmentStream, so a big file never sits in memory.
+func (bmc *BMailClient) DownloadAttachmentTo(eid string, att *bmp.Attachment, w io.Writer) error {
+	conn, err := bmp.NewBMConn(bmc.SrvIP)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ack, err := bmc.HandShake(conn)
+	if err != nil {
+		return err
+	}
+
+	sig, cert, err := bmc.sign(ack.SN.Bytes())
+	if err != nil {
+		return err
+	}
+	retr := &bmp.AttachmentRetr{
+		SN:   ack.SN,
+		Sig:  sig,
+		Cert: cert,
+		Eid:  eid,
+		Hash: att.Hash,
+	}
+	if err := conn.SendWithHeader(retr); err != nil {
+		return err
+	}
+
+	retrAck := &bmp.AttachmentRetrAck{}
+	if err := conn.ReadWithHeader(retrAck); err != nil {
+		return err
+	}
+	if retrAck.ErrorCode != 0 {
+		return fmt.Errorf("download attachment failed, server error:%d", retrAck.ErrorCode)
+	}
+	if !bytes.Equal(retrAck.Hash, att.Hash) || !bmail.Verify(ack.SrvBca, retrAck.Hash, retrAck.Sig) {
+		return fmt.Errorf("verify attachment ack failed:[%s]", ack.SrvBca)
+	}
+	if retrAck.Size != att.Size {
+		return fmt.Errorf("attachment size not match:%d", retrAck.Size)
+	}
+
+	h := sha256.New()
+	if _, err := io.CopyN(io.MultiWriter(w, h), conn, retrAck.Size); err != nil {
+		return err
+	}
+
+	if !bytes.Equal(h.Sum(nil), att.Hash) {
+		return fmt.Errorf("attachment hash not match")
+	}
+	return nil
+}
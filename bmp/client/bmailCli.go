@@ -1,25 +1,49 @@
 package client
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"github.com/google/uuid"
 	"github.com/realbmail/go-bmail-account"
 	"github.com/realbmail/go-bmail-protocol/bmp"
 	"github.com/realbmail/go-bmail-protocol/bpop"
-	resolver "github.com/realbmail/go-bmail-resolver"
+	"github.com/realbmail/go-bmail-protocol/signer"
+	"github.com/realbmail/go-bmail-protocol/subkey"
+	"github.com/realbmail/go-bmail-protocol/translayer"
 	"net"
 	"strings"
+	"time"
 )
 
+var ErrTokenExpired = errors.New("change token expired, sync again from 0")
+
+//Wallet is what the client uses of a mail identity, signer.WalletSigner
+//makes a bmail.Wallet one
+type Wallet interface {
+	signer.Signer
+	MailAddress() string
+}
+
+//Resolver find the servers of a mail domain, resolver.NameResolver is one
+type Resolver interface {
+	DomainMX(domain string) ([]net.IP, []bmail.Address)
+}
+
 type ClientConf struct {
-	Resolver resolver.NameResolver
-	Wallet   bmail.Wallet
+	Resolver Resolver
+	Wallet   Wallet
+	//Checker verify the sub-key chains of the wallet before SendMail and
+	//of the senders in ReceiveEnv, nothing is checked when nil
+	Checker *subkey.Checker
 }
 
 type BMailClient struct {
-	Wallet   bmail.Wallet
+	Wallet   Wallet
 	SrvIP    net.IP
 	SrvBcas  map[bmail.Address]bool
-	resolver resolver.NameResolver
+	resolver Resolver
+	checker  *subkey.Checker
 }
 
 func NewClient(cc *ClientConf) (*BMailClient, error) {
@@ -47,6 +71,7 @@ func NewClient(cc *ClientConf) (*BMailClient, error) {
 		SrvIP:    srvIP,
 		SrvBcas:  make(map[bmail.Address]bool),
 		resolver: r,
+		checker:  cc.Checker,
 	}
 	for _, bca := range bcas {
 		obj.SrvBcas[bca] = true
@@ -78,14 +103,28 @@ func (bmc *BMailClient) SendMail(bme *bmp.BMailEnvelope) error {
 	if err != nil {
 		return err
 	}
+	signature, cert, err := bmc.sign(ack.SN.Bytes())
+	if err != nil {
+		return err
+	}
+	if cert != nil && bmc.checker != nil {
+		if err := bmc.checker.CheckCert(bmc.Wallet.Address(), cert, nowMs()); err != nil {
+			return err
+		}
+	}
+	bme.FromCert = cert
+	bme.FromSig, err = bmc.Wallet.Sign(bme.SigHash())
+	if err != nil {
+		return err
+	}
 	synHash := bme.Hash()
-	signature := bmc.Wallet.Sign(ack.SN.Bytes())
 
 	msg := &bmp.EnvelopeSyn{
 		SN:   ack.SN,
 		Sig:  signature,
 		Hash: synHash,
 		Env:  bme,
+		Cert: cert,
 	}
 	if err := conn.SendWithHeader(msg); err != nil {
 		return err
@@ -114,27 +153,41 @@ func (bmc *BMailClient) HandShake(conn *bmp.BMailConn) (*bmp.HELOACK, error) {
 	if bmc.SrvBcas[ack.SrvBca] == false {
 		return nil, fmt.Errorf("invalid bmail server block chain address:[%s]", ack.SrvBca)
 	}
+	conn.SetCompress(translayer.ChooseCompress(ack.Compress))
+	conn.SetVersion(translayer.ChooseVersion(ack.SupportVersion))
 	return ack, nil
 }
 
 func (bmc *BMailClient) ReceiveEnv(timeSince1970 int64, olderThanSince bool, maxCount int) ([]*bmp.BMailEnvelope, error) {
+	envs, _, err := bmc.ReceivePage(timeSince1970, olderThanSince, maxCount)
+	return envs, err
+}
+
+//ReceivePage is ReceiveEnv with the time the server took each mail of the
+//page by Eid, the pivot is compared with it. The mails dropped for their
+//sender signature are in it too, a page is full when it holds maxCount.
+func (bmc *BMailClient) ReceivePage(timeSince1970 int64, olderThanSince bool, maxCount int) ([]*bmp.BMailEnvelope, map[string]int64, error) {
 	conn, err := bmp.NewBMConn(bmc.SrvIP)
 	if err != nil {
 		fmt.Println("NewBMConn------>", err)
-		return nil, err
+		return nil, nil, err
 	}
 	defer conn.Close()
 
 	ack, err := bmc.HandShake(conn)
 	if err != nil {
 		fmt.Println("HandShake------>", err)
-		return nil, err
+		return nil, nil, err
 	}
 	fmt.Println("HandShake------success>")
-	sig := bmc.Wallet.Sign(ack.SN[:])
+	sig, cert, err := bmc.sign(ack.SN[:])
+	if err != nil {
+		return nil, nil, err
+	}
 	cmd := &bpop.CommandSyn{
-		Sig: sig,
-		SN:  ack.SN,
+		Sig:  sig,
+		SN:   ack.SN,
+		Cert: cert,
 		Cmd: &bpop.CmdDownload{
 			MailCnt:   maxCount,
 			TimePivot: timeSince1970,
@@ -142,11 +195,12 @@ func (bmc *BMailClient) ReceiveEnv(timeSince1970 int64, olderThanSince bool, max
 			Owner:     bmc.Wallet.Address(),
 			MailAddr:  bmc.Wallet.MailAddress(),
 		},
+		Accept: translayer.SupportedCompress(),
 	}
 
 	if err := conn.SendWithHeader(cmd); err != nil {
 		fmt.Println("SendWithHeader------>", err)
-		return nil, err
+		return nil, nil, err
 	}
 
 	fmt.Println("======>:SendWithHeader success>", timeSince1970)
@@ -154,7 +208,7 @@ func (bmc *BMailClient) ReceiveEnv(timeSince1970 int64, olderThanSince bool, max
 	cmdAck.CmdCxt = &bpop.CmdDownloadAck{}
 	if err := conn.ReadWithHeader(cmdAck); err != nil {
 		fmt.Println("ReadWithHeader------>", err)
-		return nil, err
+		return nil, nil, err
 	}
 	//hash := resp.CmdCxt.Hash()
 	//if bytes.Compare(hash[:], resp.Hash) != 0 {
@@ -164,9 +218,9 @@ func (bmc *BMailClient) ReceiveEnv(timeSince1970 int64, olderThanSince bool, max
 
 	if cmdAck.ErrorCode != 0 {
 		if cmdAck.ErrorCode == 1 {
-			return make([]*bmp.BMailEnvelope, 0), nil
+			return make([]*bmp.BMailEnvelope, 0), map[string]int64{}, nil
 		}
-		return nil, fmt.Errorf("fetch data failed, server error:%d", ack.ErrCode)
+		return nil, nil, fmt.Errorf("fetch data failed, server error:%d", ack.ErrCode)
 	}
 
 	//if !bmail.Verify(ack.SrvBca, cmdAck.Hash, cmdAck.Sig) {
@@ -175,5 +229,251 @@ func (bmc *BMailClient) ReceiveEnv(timeSince1970 int64, olderThanSince bool, max
 
 	envs := cmdAck.CmdCxt.(*bpop.CmdDownloadAck)
 	fmt.Println("======>:envelope loaded success=>", len(envs.CryptEps))
-	return envs.CryptEps, nil
+	received := receivedOf(envs)
+	return bmc.verifySenders(envs.CryptEps, received), received, nil
+}
+
+//receivedOf give the time the server took each mail by Eid, a mail the
+//server gives no time of is not in it
+func receivedOf(ack *bpop.CmdDownloadAck) map[string]int64 {
+	received := make(map[string]int64)
+	for _, m := range ack.Meta {
+		if m != nil && m.Received != 0 {
+			received[m.Eid.String()] = m.Received
+		}
+	}
+	return received
+}
+
+//verifySenders drop the envelopes whose sender signature does not check,
+//a revoked sub-key signs nothing and an unsigned envelope is dropped too.
+//A cert is checked at the time the server received the mail, now when
+//the server gives none.
+func (bmc *BMailClient) verifySenders(envs []*bmp.BMailEnvelope, received map[string]int64) []*bmp.BMailEnvelope {
+	if bmc.checker == nil {
+		return envs
+	}
+	now := nowMs()
+	r := envs[:0]
+	for _, env := range envs {
+		at := received[env.Eid]
+		if at == 0 {
+			at = now
+		}
+		if err := env.VerifySender(bmc.checker, at); err != nil {
+			fmt.Println("drop envelope:", env.Eid, err)
+			continue
+		}
+		r = append(r, env)
+	}
+	return r
+}
+
+//sign the sn of a session, the cert is there when the wallet signs by a
+//sub-key
+func (bmc *BMailClient) sign(sn []byte) ([]byte, *subkey.Cert, error) {
+	sig, err := bmc.Wallet.Sign(sn)
+	if err != nil {
+		return nil, nil, err
+	}
+	var cert *subkey.Cert
+	if c, ok := bmc.Wallet.(subkey.Certified); ok {
+		cert = c.SubCert()
+	}
+	return sig, cert, nil
+}
+
+func nowMs() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
+func (bmc *BMailClient) sendCommand(cmd bpop.Command, cxt bpop.CommandContent) (*bpop.CommandAck, error) {
+	conn, err := bmp.NewBMConn(bmc.SrvIP)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	ack, err := bmc.HandShake(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, cert, err := bmc.sign(ack.SN[:])
+	if err != nil {
+		return nil, err
+	}
+	syn := &bpop.CommandSyn{
+		Sig:    sig,
+		SN:     ack.SN,
+		Cmd:    cmd,
+		Accept: translayer.SupportedCompress(),
+		Cert:   cert,
+	}
+	if err := conn.SendWithHeader(syn); err != nil {
+		return nil, err
+	}
+
+	cmdAck := &bpop.CommandAck{CmdCxt: cxt}
+	if err := conn.ReadWithHeader(cmdAck); err != nil {
+		return nil, err
+	}
+	if cmdAck.ErrorCode != bpop.EC_Success {
+		return cmdAck, nil
+	}
+	if !bytes.Equal(cxt.Hash(), cmdAck.Hash) {
+		return nil, fmt.Errorf("command ack hash not match")
+	}
+	if !bmail.Verify(ack.SrvBca, cmdAck.Hash, cmdAck.Sig) {
+		return nil, fmt.Errorf("verify command ack failed:[%s]", ack.SrvBca)
+	}
+	return cmdAck, nil
+}
+
+//SyncEnv fetch mailbox changes after changeToken, call it again with the
+//returned ChangeToken while More is true.
+func (bmc *BMailClient) SyncEnv(changeToken uint64, maxCount int) (*bpop.CmdSyncAck, error) {
+	cmd := &bpop.CmdSync{
+		MailAddr:    bmc.Wallet.MailAddress(),
+		Owner:       bmc.Wallet.Address(),
+		ChangeToken: changeToken,
+		MailCnt:     maxCount,
+	}
+	syncAck := &bpop.CmdSyncAck{}
+	cmdAck, err := bmc.sendCommand(cmd, syncAck)
+	if err != nil {
+		return nil, err
+	}
+
+	switch cmdAck.ErrorCode {
+	case bpop.EC_Success:
+		return syncAck, nil
+	case bpop.EC_No_Mail:
+		return &bpop.CmdSyncAck{ChangeToken: changeToken}, nil
+	case bpop.EC_Token_Expired:
+		return nil, ErrTokenExpired
+	default:
+		return nil, fmt.Errorf("sync failed, server error:%d", cmdAck.ErrorCode)
+	}
+}
+
+func (bmc *BMailClient) SetFlags(eids []uuid.UUID, setFlags, clearFlags uint32) ([]bpop.CmdResult, error) {
+	cmd := &bpop.CmdSetFlags{
+		MailAddr:   bmc.Wallet.MailAddress(),
+		Owner:      bmc.Wallet.Address(),
+		Eids:       eids,
+		SetFlags:   setFlags,
+		ClearFlags: clearFlags,
+	}
+	flagsAck := &bpop.CmdSetFlagsAck{}
+	cmdAck, err := bmc.sendCommand(cmd, flagsAck)
+	if err != nil {
+		return nil, err
+	}
+	if cmdAck.ErrorCode != bpop.EC_Success {
+		return nil, fmt.Errorf("set flags failed, server error:%d", cmdAck.ErrorCode)
+	}
+	return flagsAck.Result, nil
+}
+
+func (bmc *BMailClient) MoveMail(eids []uuid.UUID, folder string) ([]bpop.CmdResult, error) {
+	cmd := &bpop.CmdMove{
+		MailAddr: bmc.Wallet.MailAddress(),
+		Owner:    bmc.Wallet.Address(),
+		Eids:     eids,
+		Folder:   folder,
+	}
+	moveAck := &bpop.CmdMoveAck{}
+	cmdAck, err := bmc.sendCommand(cmd, moveAck)
+	if err != nil {
+		return nil, err
+	}
+	if cmdAck.ErrorCode != bpop.EC_Success {
+		return nil, fmt.Errorf("move mail failed, server error:%d", cmdAck.ErrorCode)
+	}
+	return moveAck.Result, nil
+}
+
+func (bmc *BMailClient) DeleteMail(eids []uuid.UUID) ([]bpop.CmdResult, error) {
+	cmd := &bpop.CmdDelete{
+		MailAddr: bmc.Wallet.MailAddress(),
+		Owner:    bmc.Wallet.Address(),
+		Eids:     eids,
+	}
+	deleteAck := &bpop.CmdDeleteAck{}
+	cmdAck, err := bmc.sendCommand(cmd, deleteAck)
+	if err != nil {
+		return nil, err
+	}
+	if cmdAck.ErrorCode != bpop.EC_Success {
+		return nil, fmt.Errorf("delete mail failed, server error:%d", cmdAck.ErrorCode)
+	}
+	return deleteAck.Result, nil
+}
+
+func (bmc *BMailClient) ListFolders() ([]*bpop.Folder, error) {
+	cmd := &bpop.CmdListFolders{
+		MailAddr: bmc.Wallet.MailAddress(),
+		Owner:    bmc.Wallet.Address(),
+	}
+	foldersAck := &bpop.CmdListFoldersAck{}
+	cmdAck, err := bmc.sendCommand(cmd, foldersAck)
+	if err != nil {
+		return nil, err
+	}
+	if cmdAck.ErrorCode != bpop.EC_Success {
+		return nil, fmt.Errorf("list folders failed, server error:%d", cmdAck.ErrorCode)
+	}
+	return foldersAck.Folders, nil
+}
+
+//FetchThread load the mails of a conversation, call again with the
+//DateSince1970 of the last mail while more is true.
+func (bmc *BMailClient) FetchThread(sessionID string, timeSince1970 int64, maxCount int) ([]*bmp.BMailEnvelope, bool, error) {
+	cmd := &bpop.CmdThread{
+		MailAddr:  bmc.Wallet.MailAddress(),
+		Owner:     bmc.Wallet.Address(),
+		SessionID: sessionID,
+		MailCnt:   maxCount,
+		TimePivot: timeSince1970,
+	}
+	threadAck := &bpop.CmdThreadAck{}
+	cmdAck, err := bmc.sendCommand(cmd, threadAck)
+	if err != nil {
+		return nil, false, err
+	}
+
+	switch cmdAck.ErrorCode {
+	case bpop.EC_Success:
+		return threadAck.CryptEps, threadAck.More, nil
+	case bpop.EC_No_Mail:
+		return make([]*bmp.BMailEnvelope, 0), false, nil
+	default:
+		return nil, false, fmt.Errorf("fetch thread failed, server error:%d", cmdAck.ErrorCode)
+	}
+}
+
+//Search run the filters of query on server, MailAddr and Owner are filled
+//from the wallet. Page with query.Offset until Offset reaches Total.
+func (bmc *BMailClient) Search(query *bpop.CmdSearch) (*bpop.CmdSearchAck, error) {
+	query.MailAddr = bmc.Wallet.MailAddress()
+	query.Owner = bmc.Wallet.Address()
+	if query.MailCnt <= 0 {
+		query.MailCnt = bpop.DefaultMailCount
+	}
+
+	searchAck := &bpop.CmdSearchAck{}
+	cmdAck, err := bmc.sendCommand(query, searchAck)
+	if err != nil {
+		return nil, err
+	}
+
+	switch cmdAck.ErrorCode {
+	case bpop.EC_Success:
+		return searchAck, nil
+	case bpop.EC_No_Mail:
+		return &bpop.CmdSearchAck{}, nil
+	default:
+		return nil, fmt.Errorf("search failed, server error:%d", cmdAck.ErrorCode)
+	}
 }
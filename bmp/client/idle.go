@@ -0,0 +1,199 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/realbmail/go-bmail-account"
+	"github.com/realbmail/go-bmail-protocol/bmp"
+	"github.com/realbmail/go-bmail-protocol/bpop"
+	"github.com/realbmail/go-bmail-protocol/translayer"
+	"sync"
+	"time"
+)
+
+type IdleSession struct {
+	conn      *bmp.BMailConn
+	srvBca    bmail.Address
+	sn        bmp.BMailSN
+	keepAlive time.Duration
+	notify    chan *bpop.MailSummary
+	done      chan struct{}
+	exited    chan struct{}
+	closeOnce sync.Once
+	err       error
+
+	lock    sync.Mutex
+	closeBy time.Time //read deadline set by Close, the loop keeps it
+}
+
+//Idle keep a bpop session open, the server push a summary of every new mail
+//to the channel returned by Notify. keepAlive is in seconds, 0 means
+//bpop.DefaultKeepAlive.
+func (bmc *BMailClient) Idle(keepAlive int) (*IdleSession, error) {
+	if keepAlive <= 0 {
+		keepAlive = bpop.DefaultKeepAlive
+	}
+
+	conn, err := bmp.NewBMConn(bmc.SrvIP)
+	if err != nil {
+		return nil, err
+	}
+
+	ack, err := bmc.HandShake(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	sig, cert, err := bmc.sign(ack.SN[:])
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	syn := &bpop.CommandSyn{
+		Sig: sig,
+		SN:  ack.SN,
+		Cmd: &bpop.CmdIdle{
+			MailAddr:  bmc.Wallet.MailAddress(),
+			Owner:     bmc.Wallet.Address(),
+			KeepAlive: keepAlive,
+		},
+		Accept: translayer.SupportedCompress(),
+		Cert:   cert,
+	}
+	if err := conn.SendWithHeader(syn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	is := &IdleSession{
+		conn:      conn,
+		srvBca:    ack.SrvBca,
+		sn:        ack.SN,
+		keepAlive: time.Duration(keepAlive) * time.Second,
+		notify:    make(chan *bpop.MailSummary, bpop.DefaultMailCount),
+		done:      make(chan struct{}),
+		exited:    make(chan struct{}),
+	}
+	go is.loop()
+
+	return is, nil
+}
+
+//Notify is closed when the session ends, check Err after that.
+func (is *IdleSession) Notify() <-chan *bpop.MailSummary {
+	return is.notify
+}
+
+func (is *IdleSession) Err() error {
+	select {
+	case <-is.exited:
+		return is.err
+	default:
+		return nil
+	}
+}
+
+func (is *IdleSession) Close() error {
+	var err error
+	is.closeOnce.Do(func() {
+		close(is.done)
+		err = is.conn.IdleDone()
+
+		is.lock.Lock()
+		is.closeBy = time.Now().Add(is.keepAlive)
+		is.conn.SetReadDeadline(is.closeBy)
+		is.lock.Unlock()
+
+		<-is.exited
+		is.conn.Close()
+	})
+	return err
+}
+
+func (is *IdleSession) loop() {
+	defer close(is.exited)
+	defer close(is.notify)
+
+	var (
+		seq  uint64
+		seen bool
+	)
+	for {
+		is.extendDeadline()
+
+		n := &bpop.CmdIdleNotify{}
+		cmdAck := &bpop.CommandAck{CmdCxt: n}
+		if err := is.conn.ReadWithHeader(cmdAck); err != nil {
+			if !is.closing() {
+				is.err = err
+			}
+			return
+		}
+		if cmdAck.ErrorCode != bpop.EC_Success {
+			is.err = fmt.Errorf("idle failed, server error:%d", cmdAck.ErrorCode)
+			return
+		}
+		if err := is.verify(cmdAck, n, seq, seen); err != nil {
+			is.err = err
+			return
+		}
+		seq, seen = n.Seq, true
+
+		switch n.Kind {
+		case bpop.NotifyKeepAlive:
+			continue
+		case bpop.NotifyDone:
+			return
+		}
+
+		for _, m := range n.Mails {
+			select {
+			case is.notify <- m:
+			case <-is.done:
+				//drain until NotifyDone
+			}
+		}
+	}
+}
+
+//extendDeadline give the server two keep alive intervals to send a frame,
+//else it is gone. Once Close set its deadline it is kept.
+func (is *IdleSession) extendDeadline() {
+	is.lock.Lock()
+	defer is.lock.Unlock()
+
+	if is.closeBy.IsZero() {
+		is.conn.SetReadDeadline(time.Now().Add(2 * is.keepAlive))
+	}
+}
+
+//verify a notify is signed for this session and comes in order, seen is
+//false for the first one, its seq is 0
+func (is *IdleSession) verify(cmdAck *bpop.CommandAck, n *bpop.CmdIdleNotify, lastSeq uint64, seen bool) error {
+	if !bytes.Equal(n.Hash(), cmdAck.Hash) {
+		return fmt.Errorf("idle notify hash not match")
+	}
+	if !bmail.Verify(is.srvBca, cmdAck.Hash, cmdAck.Sig) {
+		return fmt.Errorf("verify idle notify failed:[%s]", is.srvBca)
+	}
+	if n.SN != is.sn {
+		return fmt.Errorf("idle notify of another session")
+	}
+	if !seen && n.Seq != 0 {
+		return fmt.Errorf("idle notify out of order:%d first", n.Seq)
+	}
+	if seen && n.Seq != lastSeq+1 {
+		return fmt.Errorf("idle notify out of order:%d after %d", n.Seq, lastSeq)
+	}
+	return nil
+}
+
+func (is *IdleSession) closing() bool {
+	select {
+	case <-is.done:
+		return true
+	default:
+		return false
+	}
+}
@@ -3,8 +3,10 @@ package bmp
 import (
 	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/realbmail/go-bmail-account"
+	"github.com/realbmail/go-bmail-protocol/subkey"
 )
 
 const (
@@ -18,6 +20,14 @@ const (
 	RcpMonitor
 )
 
+//BMailEnvelope.CryptMode, 0 is Recipient.AESKey wrapped by the static keys
+//of sender and recipient. The flag is the one of the binary stack.
+const (
+	CryptModeEphemeral = 1 << 8
+
+	cryptModeKnown = CryptModeEphemeral
+)
+
 type Recipient struct {
 	ToName   string        `json:"to"`
 	ToAddr   bmail.Address `json:"toAddr"`
@@ -47,6 +57,13 @@ type BMailEnvelope struct {
 	Subject       string        `json:"subject"`
 	MailBody      string        `json:"mailBody"`
 	SessionID     string        `json:"sessionID"`
+	InReplyTo     string        `json:"inReplyTo,omitempty"`  //Eid of the mail replied to
+	References    []string      `json:"references,omitempty"` //Eids of the thread, oldest first
+	Attachments   []*Attachment `json:"attachments,omitempty"`
+	CryptMode     int           `json:"cryptMode,omitempty"`
+	EphKey        []byte        `json:"ephKey,omitempty"`   //X25519 key of CryptModeEphemeral
+	FromCert      *subkey.Cert  `json:"fromCert,omitempty"` //when a sub-key signs
+	FromSig       []byte        `json:"fromSig,omitempty"`  //of SigHash by the sender
 }
 
 func (re *BMailEnvelope) Hash() []byte {
@@ -55,22 +72,55 @@ func (re *BMailEnvelope) Hash() []byte {
 	return hash[:]
 }
 
+func (re *BMailEnvelope) CheckCryptMode() error {
+	if re.CryptMode&^cryptModeKnown != 0 {
+		return fmt.Errorf("crypt mode %#x not supported", re.CryptMode)
+	}
+	if re.CryptMode&CryptModeEphemeral != 0 && len(re.EphKey) == 0 {
+		return errors.New("ephemeral crypt mode without the ephemeral key")
+	}
+	return nil
+}
+
+//SigHash is what the sender signs, the envelope without FromSig
+func (re *BMailEnvelope) SigHash() []byte {
+	cp := *re
+	cp.FromSig = nil
+	return cp.Hash()
+}
+
+//VerifySender check FromSig is made by FromAddr or a sub-key it certified
+//at received, the time the server took the envelope; DateSince1970 is the
+//sender's word and not used. An envelope without FromSig is unverified.
+func (re *BMailEnvelope) VerifySender(c *subkey.Checker, received int64) error {
+	if len(re.FromSig) == 0 {
+		return subkey.ErrUnsigned
+	}
+	return c.Verify(re.FromAddr, re.FromCert, re.SigHash(), re.FromSig, received)
+}
+
 func (re *BMailEnvelope) ToString() string {
 
 	str := fmt.Sprintf("\n======================BMailEnvelope========================"+
 		"\n\tEid:\t%20s"+
 		"\n\tFrom:\t%20s"+
 		"\n\tFromAddr:\t%20s"+
-		"\n\tSessionEid:\t%20s",
+		"\n\tSessionEid:\t%20s"+
+		"\n\tInReplyTo:\t%20s",
 		re.Eid,
 		re.FromName,
 		re.FromAddr,
-		re.SessionID)
+		re.SessionID,
+		re.InReplyTo)
 
 	for _, r := range re.RCPTs {
 		str += r.ToString()
 	}
 
+	for _, a := range re.Attachments {
+		str += a.ToString()
+	}
+
 	str += "\n==========================================================="
 	return str
 }
@@ -0,0 +1,175 @@
+package bmp
+
+import (
+	"github.com/google/uuid"
+	"github.com/realbmail/go-bmail-account"
+	"sort"
+)
+
+//ThreadID is the SessionID of a mail, a mail without SessionID starts its
+//own thread.
+func (re *BMailEnvelope) ThreadID() string {
+	if re.SessionID != "" {
+		return re.SessionID
+	}
+	return re.Eid
+}
+
+func (re *BMailEnvelope) follow(fromName string, fromAddr bmail.Address) *BMailEnvelope {
+	env := &BMailEnvelope{
+		Eid:       uuid.New().String(),
+		FromName:  fromName,
+		FromAddr:  fromAddr,
+		SessionID: re.ThreadID(),
+	}
+	env.References = append(env.References, re.References...)
+	env.References = append(env.References, re.Eid)
+
+	return env
+}
+
+//Reply create a plain envelope answering re, only the sender of re is
+//the recipient. Subject, body and AESKey are left to the caller.
+func (re *BMailEnvelope) Reply(fromName string, fromAddr bmail.Address) *BMailEnvelope {
+	env := re.follow(fromName, fromAddr)
+	env.InReplyTo = re.Eid
+	env.RCPTs = []*Recipient{{
+		ToName:   re.FromName,
+		ToAddr:   re.FromAddr,
+		RcptType: RcpTypeTo,
+	}}
+
+	return env
+}
+
+//ReplyAll is Reply with the To and CC recipients of re copied as CC,
+//the replier and Bcc recipients are left out.
+func (re *BMailEnvelope) ReplyAll(fromName string, fromAddr bmail.Address) *BMailEnvelope {
+	env := re.Reply(fromName, fromAddr)
+
+	for _, r := range re.RCPTs {
+		if r.ToAddr == fromAddr || r.ToAddr == re.FromAddr {
+			continue
+		}
+		if r.RcptType != RcpTypeTo && r.RcptType != RcpTypeCC {
+			continue
+		}
+		env.RCPTs = append(env.RCPTs, &Recipient{
+			ToName:   r.ToName,
+			ToAddr:   r.ToAddr,
+			RcptType: RcpTypeCC,
+		})
+	}
+
+	return env
+}
+
+//Forward keep re in the thread but answer nobody, the caller add the
+//recipients.
+func (re *BMailEnvelope) Forward(fromName string, fromAddr bmail.Address) *BMailEnvelope {
+	return re.follow(fromName, fromAddr)
+}
+
+type ThreadNode struct {
+	Env      *BMailEnvelope
+	Parent   *ThreadNode
+	Children []*ThreadNode
+}
+
+type Thread struct {
+	SessionID string
+	Roots     []*ThreadNode //more than one root when the first mails are missing
+	Count     int
+	Latest    uint64 //DateSince1970 of the newest mail
+}
+
+//GroupThreads build conversation trees from envelopes, newest thread first.
+//A mail hangs under InReplyTo, or under the nearest References entry found
+//when the replied mail is not downloaded.
+func GroupThreads(envs []*BMailEnvelope) []*Thread {
+	threads := make(map[string]*Thread)
+	nodes := make(map[string]*ThreadNode)
+	var order []*ThreadNode
+
+	for _, env := range envs {
+		if env == nil || nodes[env.Eid] != nil {
+			continue
+		}
+		n := &ThreadNode{Env: env}
+		nodes[env.Eid] = n
+		order = append(order, n)
+	}
+
+	for _, n := range order {
+		sid := n.Env.ThreadID()
+		t, ok := threads[sid]
+		if !ok {
+			t = &Thread{SessionID: sid}
+			threads[sid] = t
+		}
+		t.Count++
+		if n.Env.DateSince1970 > t.Latest {
+			t.Latest = n.Env.DateSince1970
+		}
+
+		n.Parent = findParent(n.Env, sid, nodes)
+		if n.Parent == nil {
+			t.Roots = append(t.Roots, n)
+		} else {
+			n.Parent.Children = append(n.Parent.Children, n)
+		}
+	}
+
+	r := make([]*Thread, 0, len(threads))
+	for _, t := range threads {
+		sortNodes(t.Roots)
+		r = append(r, t)
+	}
+	sort.SliceStable(r, func(i, j int) bool {
+		return r[i].Latest > r[j].Latest
+	})
+
+	return r
+}
+
+func findParent(env *BMailEnvelope, sid string, nodes map[string]*ThreadNode) *ThreadNode {
+	candidates := make([]string, 0, len(env.References)+1)
+	if env.InReplyTo != "" {
+		candidates = append(candidates, env.InReplyTo)
+	}
+	for i := len(env.References) - 1; i >= 0; i-- {
+		candidates = append(candidates, env.References[i])
+	}
+
+	for _, eid := range candidates {
+		p, ok := nodes[eid]
+		if !ok || eid == env.Eid || p.Env.ThreadID() != sid {
+			continue
+		}
+		if isAncestor(env.Eid, p) {
+			continue
+		}
+		return p
+	}
+
+	return nil
+}
+
+//isAncestor guard against reference loops in bad envelopes
+func isAncestor(eid string, n *ThreadNode) bool {
+	for p := n; p != nil; p = p.Parent {
+		if p.Env.Eid == eid {
+			return true
+		}
+	}
+	return false
+}
+
+func sortNodes(ns []*ThreadNode) {
+	sort.SliceStable(ns, func(i, j int) bool {
+		return ns[i].Env.DateSince1970 < ns[j].Env.DateSince1970
+	})
+	for _, n := range ns {
+		sortNodes(n.Children)
+	}
+}
@@ -4,10 +4,8 @@ import (
 	"fmt"
 	"github.com/realbmail/go-bmail-protocol/translayer"
 	"github.com/btcsuite/btcutil/base58"
+	"io"
 	"math/rand"
-
-	"encoding/binary"
-	"errors"
 )
 
 //client hello ===IV,cipher text{self mail address,sn from client}==> server
@@ -52,59 +50,26 @@ func (mabh *ContactHello) String() string {
 }
 
 func (mabh *ContactHello) Pack() ([]byte, error) {
-	var (
-		r, tmp []byte
-		err    error
-	)
-
-	tmp, err = PackShortString(mabh.SelfMA)
-	if err != nil {
-		return nil, err
-	}
-
-	r = append(r, tmp...)
-
-	tmp, err = PackShortBytes(mabh.sn[:])
-	if err != nil {
-		return nil, err
-	}
-
-	r = append(r, tmp...)
+	return Marshal(mabh)
+}
 
-	return r, err
+func (mabh *ContactHello) Size() int {
+	return Size(mabh)
+}
 
+func (mabh *ContactHello) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, mabh)
 }
 
 func (mabh *ContactHello) UnPack(data []byte) (int, error) {
-	var (
-		offset, of int
-		err        error
-	)
-
-	mabh.SelfMA, of, err = UnPackShortString(data[offset:])
-	if err != nil {
-		return 0, err
-	}
-	offset += of
-	var sn []byte
-
-	sn, of, err = UnPackShortBytes(data[offset:])
-	if err != nil {
-		return 0, err
-	}
-	offset += of
-
-	copy(mabh.sn[:], sn)
-
-	return offset, nil
-
+	return Unmarshal(data, mabh)
 }
 
 //client hello
 type CryptContactHello struct {
 	translayer.BMTransLayer
 	iv         IV
-	cipherText []byte //-->MAoBHello
+	cipherText []byte `bm:"long"` //-->MAoBHello
 }
 
 func NewCryptContactHello() *CryptContactHello {
@@ -143,49 +108,19 @@ func (cm *CryptContactHello) String() string {
 }
 
 func (cm *CryptContactHello) Pack() ([]byte, error) {
-	var (
-		r, tmp []byte
-		err    error
-	)
-
-	tmp, err = PackShortBytes(cm.iv[:])
-	if err != nil {
-		return nil, err
-	}
-	r = append(r, tmp...)
-
-	tmp, err = PackLongBytes(cm.cipherText)
-	if err != nil {
-		return nil, err
-	}
-	r = append(r, tmp...)
-
-	//cm.BMTransLayer.SetData(r)
+	return packMsg(&(cm.BMTransLayer), cm)
+}
 
-	return cm.BMTransLayer.Pack()
+func (cm *CryptContactHello) Size() int {
+	return msgSize(&(cm.BMTransLayer), cm)
+}
 
+func (cm *CryptContactHello) WriteTo(w io.Writer) (int64, error) {
+	return writeMsg(w, &(cm.BMTransLayer), cm)
 }
 
 func (cm *CryptContactHello) UnPack(data []byte) (int, error) {
-	var (
-		offset, of int
-		err        error
-	)
-
-	var iv []byte
-	iv, of, err = UnPackShortBytes(data[offset:])
-	if err != nil {
-		return 0, nil
-	}
-	copy(cm.iv[:], iv)
-
-	offset += of
-
-	cm.cipherText, of, err = UnPackLongBytes(data[offset:])
-
-	offset += of
-	return offset, nil
-
+	return unpackMsg(&(cm.BMTransLayer), data, cm)
 }
 
 //server response hello ===IV,sig{sn(from client)},sn(from server) ==>client
@@ -245,75 +180,19 @@ func (mr *ContactHelloResp) String() string {
 }
 
 func (mr *ContactHelloResp) Pack() ([]byte, error) {
-	var (
-		r, tmp []byte
-		err    error
-	)
-	tmp, err = PackShortBytes(mr.iv[:])
-	if err != nil {
-		return nil, err
-	}
-	r = append(r, tmp...)
-
-	tmp, err = PackShortBytes(mr.clientSN[:])
-	if err != nil {
-		return nil, err
-	}
-	r = append(r, tmp...)
-
-	tmp, err = PackShortBytes(mr.sigClientSN)
-	if err != nil {
-		return nil, err
-	}
-	r = append(r, tmp...)
-
-	tmp, err = PackShortBytes(mr.serverSN[:])
-	if err != nil {
-		return nil, err
-	}
-	r = append(r, tmp...)
-
-	//mr.BMTransLayer.SetData(r)
+	return packMsg(&(mr.BMTransLayer), mr)
+}
 
-	return mr.BMTransLayer.Pack()
+func (mr *ContactHelloResp) Size() int {
+	return msgSize(&(mr.BMTransLayer), mr)
+}
 
+func (mr *ContactHelloResp) WriteTo(w io.Writer) (int64, error) {
+	return writeMsg(w, &(mr.BMTransLayer), mr)
 }
 
 func (mr *ContactHelloResp) UnPack(data []byte) (int, error) {
-	var (
-		offset, of int
-		err        error
-		buf        []byte
-	)
-	buf, of, err = UnPackShortBytes(data[offset:])
-	if err != nil {
-		return 0, err
-	}
-	offset += of
-	copy(mr.iv[:], buf)
-
-	buf, of, err = UnPackShortBytes(data[offset:])
-	if err != nil {
-		return 0, err
-	}
-	offset += of
-	copy(mr.clientSN[:], buf)
-
-	mr.sigClientSN, of, err = UnPackShortBytes(data[offset:])
-	if err != nil {
-		return 0, err
-	}
-	offset += of
-
-	buf, of, err = UnPackShortBytes(data[offset:])
-	if err != nil {
-		return 0, err
-	}
-	offset += of
-	copy(mr.serverSN[:], buf)
-
-	return offset, nil
-
+	return unpackMsg(&(mr.BMTransLayer), data, mr)
 }
 
 type Gid [32]byte
@@ -324,48 +203,19 @@ type Cell struct {
 }
 
 func (c *Cell) Pack() ([]byte, error) {
-	var (
-		r, tmp []byte
-		err    error
-	)
-
-	tmp, err = PackShortString(c.PhoneNum)
-	if err != nil {
-		return nil, err
-	}
-	r = append(r, tmp...)
+	return Marshal(c)
+}
 
-	tmp, err = PackShortString(c.PhoneType)
-	if err != nil {
-		return nil, err
-	}
-	r = append(r, tmp...)
+func (c *Cell) Size() int {
+	return Size(c)
+}
 
-	return r, nil
+func (c *Cell) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, c)
 }
 
 func (c *Cell) UnPack(data []byte) (int, error) {
-	var (
-		offset, of int
-		err        error
-	)
-
-	c.PhoneNum, of, err = UnPackShortString(data[offset:])
-	if err != nil {
-		return 0, err
-	}
-
-	offset += of
-
-	c.PhoneType, of, err = UnPackShortString(data[offset:])
-	if err != nil {
-		return 0, err
-	}
-
-	offset += of
-
-	return offset, nil
-
+	return Unmarshal(data, c)
 }
 
 type BMailAddrss struct {
@@ -377,87 +227,19 @@ type BMailAddrss struct {
 }
 
 func (bma *BMailAddrss) Pack() ([]byte, error) {
-	var (
-		r, tmp []byte
-		err    error
-	)
-
-	tmp, err = PackShortString(bma.MailAddress)
-	if err != nil {
-		return nil, err
-	}
-	r = append(r, tmp...)
-
-	tmp, err = PackShortString(bma.Alias)
-	if err != nil {
-		return nil, err
-	}
-	r = append(r, tmp...)
-
-	tmp, err = PackShortString(bma.Desc)
-	if err != nil {
-		return nil, err
-	}
-	r = append(r, tmp...)
-
-	c := &bma.Phone
-	tmp, err = c.Pack()
-	if err != nil {
-		return nil, err
-	}
-	r = append(r, tmp...)
+	return Marshal(bma)
+}
 
-	tmp, err = PackShortBytes(bma.GroupId[:])
-	if err != nil {
-		return nil, err
-	}
-	r = append(r, tmp...)
+func (bma *BMailAddrss) Size() int {
+	return Size(bma)
+}
 
-	return r, nil
+func (bma *BMailAddrss) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, bma)
 }
 
 func (bma *BMailAddrss) UnPack(data []byte) (int, error) {
-	var (
-		offset, of int
-		err        error
-		tmp        []byte
-	)
-
-	bma.MailAddress, of, err = UnPackShortString(data[offset:])
-	if err != nil {
-		return 0, err
-	}
-	offset += of
-
-	bma.Alias, of, err = UnPackShortString(data[offset:])
-	if err != nil {
-		return 0, err
-	}
-	offset += of
-
-	bma.Desc, of, err = UnPackShortString(data[offset:])
-	if err != nil {
-		return 0, err
-	}
-	offset += of
-
-	c := &bma.Phone
-	of, err = c.UnPack(data[offset:])
-	if err != nil {
-		return 0, err
-	}
-	offset += of
-
-	tmp, of, err = UnPackShortBytes(data[offset:])
-	if err != nil {
-		return 0, err
-	}
-	offset += of
-
-	copy(bma.GroupId[:], tmp)
-
-	return offset, nil
-
+	return Unmarshal(data, bma)
 }
 
 type GroupDesc struct {
@@ -467,59 +249,19 @@ type GroupDesc struct {
 }
 
 func (gd *GroupDesc) Pack() ([]byte, error) {
-	var (
-		r, tmp []byte
-		err    error
-	)
-
-	tmp, err = PackShortBytes(gd.GroupId[:])
-	if err != nil {
-		return nil, err
-	}
-	r = append(r, tmp...)
-
-	tmp = translayer.UInt32ToBuf(uint32(gd.GroupType))
-	r = append(r, tmp...)
-
-	tmp, err = PackShortString(gd.GroupName)
-	if err != nil {
-		return nil, err
-	}
-	r = append(r, tmp...)
+	return Marshal(gd)
+}
 
-	return r, nil
+func (gd *GroupDesc) Size() int {
+	return Size(gd)
+}
 
+func (gd *GroupDesc) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, gd)
 }
 
 func (gd *GroupDesc) UnPack(data []byte) (int, error) {
-	var (
-		offset, of int
-		err        error
-		tmp        []byte
-	)
-
-	tmp, of, err = UnPackShortBytes(data[offset:])
-	if err != nil {
-		return 0, err
-	}
-
-	copy(gd.GroupId[:], tmp)
-	offset += of
-
-	if len(data) < offset+translayer.Uint32Size {
-		return 0, errors.New("unpack group type error")
-	}
-	gd.GroupType = int(binary.BigEndian.Uint32(data[offset:]))
-	offset += translayer.Uint32Size
-
-	gd.GroupName, of, err = UnPackShortString(data[offset:])
-	if err != nil {
-		return 0, err
-	}
-	offset += of
-
-	return offset, nil
-
+	return Unmarshal(data, gd)
 }
 
 //client remote add===>IV,sig{sn(from server)},sn(from server),cipher text{mail list,group list} ==>server
@@ -532,112 +274,19 @@ type ContactAdd struct {
 }
 
 func (ca *ContactAdd) Pack() ([]byte, error) {
-	var (
-		r, tmp []byte
-		err    error
-	)
-	tmp, err = PackShortBytes(ca.iv[:])
-	if err != nil {
-		return nil, err
-	}
-	r = append(r, tmp...)
-
-	tmp, err = PackShortBytes(ca.sigServerSn[:])
-	if err != nil {
-		return nil, err
-	}
-	r = append(r, tmp...)
-	tmp, err = PackShortBytes(ca.serverSN[:])
-	if err != nil {
-		return nil, err
-	}
-	r = append(r, tmp...)
-
-	tmp = translayer.UInt32ToBuf(uint32(len(ca.mailAddrs)))
-	r = append(r, tmp...)
-	for i := 0; i < len(ca.mailAddrs); i++ {
-		ma := &ca.mailAddrs[i]
-
-		tmp, err = ma.Pack()
-		if err != nil {
-			return nil, err
-		}
-		r = append(r, tmp...)
-
-	}
+	return Marshal(ca)
+}
 
-	tmp = translayer.UInt32ToBuf(uint32(len(ca.groups)))
-	r = append(r, tmp...)
-	for i := 0; i < len(ca.groups); i++ {
-		g := &ca.groups[i]
+func (ca *ContactAdd) Size() int {
+	return Size(ca)
+}
 
-		tmp, err = g.Pack()
-		if err != nil {
-			return nil, err
-		}
-		r = append(r, tmp...)
-	}
-	return r, nil
+func (ca *ContactAdd) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, ca)
 }
 
 func (ca *ContactAdd) UnPack(data []byte) (int, error) {
-	var (
-		offset, of int
-		err        error
-		tmp        []byte
-	)
-	tmp, of, err = UnPackShortBytes(data[offset:])
-	if err != nil {
-		return 0, err
-	}
-	offset += of
-	copy(ca.iv[:], tmp)
-
-	ca.sigServerSn, of, err = UnPackShortBytes(data[offset:])
-	if err != nil {
-		return 0, err
-	}
-	offset += of
-
-	tmp, of, err = UnPackShortBytes(data[offset:])
-	if err != nil {
-		return 0, err
-	}
-	offset += of
-	copy(ca.serverSN[:], tmp)
-
-	if len(data) < offset+translayer.Uint32Size {
-		return 0, errors.New("unpack mail address error")
-	}
-	l := int(binary.BigEndian.Uint32(data[offset:]))
-	offset += translayer.Uint32Size
-
-	for i := 0; i < l; i++ {
-		ma := &BMailAddrss{}
-		of, err = ma.UnPack(data[offset:])
-		if err != nil {
-			return 0, err
-		}
-		offset += of
-		ca.mailAddrs = append(ca.mailAddrs, *ma)
-	}
-	if len(data) < offset+translayer.Uint32Size {
-		return 0, errors.New("unpack groups error")
-	}
-	l = int(binary.BigEndian.Uint32(data[offset:]))
-	offset += translayer.Uint32Size
-
-	for i := 0; i < l; i++ {
-		g := &GroupDesc{}
-		of, err = g.UnPack(data[offset:])
-		if err != nil {
-			return 0, err
-		}
-		offset += of
-		ca.groups = append(ca.groups, *g)
-	}
-
-	return offset, nil
+	return Unmarshal(data, ca)
 }
 
 type CryptContactAdd struct {
@@ -645,7 +294,7 @@ type CryptContactAdd struct {
 	iv          IV
 	sigServerSn []byte
 	serverSN    SN
-	cipherTxt   []byte
+	cipherTxt   []byte `bm:"long"`
 }
 
 func (cca *CryptContactAdd) SetIV(iv IV) {
@@ -680,71 +329,19 @@ func NewCryptContactAdd() *CryptContactAdd {
 }
 
 func (cca *CryptContactAdd) Pack() ([]byte, error) {
-	var (
-		r, tmp []byte
-		err    error
-	)
-	tmp, err = PackShortBytes(cca.iv[:])
-	if err != nil {
-		return nil, err
-	}
-	r = append(r, tmp...)
-	tmp, err = PackShortBytes(cca.sigServerSn)
-	if err != nil {
-		return nil, err
-	}
-	r = append(r, tmp...)
-	tmp, err = PackShortBytes(cca.serverSN[:])
-	if err != nil {
-		return nil, err
-	}
-	r = append(r, tmp...)
-	tmp, err = PackLongBytes(cca.cipherTxt)
-	if err != nil {
-		return nil, err
-	}
-	r = append(r, tmp...)
-
-	//cca.BMTransLayer.SetData(r)
+	return packMsg(&(cca.BMTransLayer), cca)
+}
 
-	return cca.BMTransLayer.Pack()
+func (cca *CryptContactAdd) Size() int {
+	return msgSize(&(cca.BMTransLayer), cca)
+}
 
+func (cca *CryptContactAdd) WriteTo(w io.Writer) (int64, error) {
+	return writeMsg(w, &(cca.BMTransLayer), cca)
 }
 
 func (cca *CryptContactAdd) UnPack(data []byte) (int, error) {
-	var (
-		offset, of int
-		err        error
-		tmp        []byte
-	)
-
-	tmp, of, err = UnPackShortBytes(data[offset:])
-	if err != nil {
-		return 0, nil
-	}
-	offset += of
-	copy(cca.iv[:], tmp)
-
-	cca.sigServerSn, of, err = UnPackShortBytes(data[offset:])
-	if err != nil {
-		return 0, nil
-	}
-	offset += of
-
-	tmp, of, err = UnPackShortBytes(data[offset:])
-	if err != nil {
-		return 0, nil
-	}
-	offset += of
-	copy(cca.serverSN[:], tmp)
-
-	cca.cipherTxt, of, err = UnPackLongBytes(data[offset:])
-	if err != nil {
-		return 0, nil
-	}
-	offset += of
-
-	return offset, nil
+	return unpackMsg(&(cca.BMTransLayer), data, cca)
 }
 
 //server response remote add ===> IV,cipher text{sn(old),sn(new from server)},error code===>client
@@ -756,34 +353,19 @@ type ContactAddResp struct {
 }
 
 func (car *ContactAddResp) Pack() ([]byte, error) {
-	var (
-		r, tmp []byte
-		err    error
-	)
-
-	tmp, err = PackShortBytes(car.iv[:])
-	if err != nil {
-		return nil, err
-	}
-	r = append(r, tmp...)
-
-	tmp, err = PackShortBytes(car.sn[:])
-	if err != nil {
-		return nil, err
-	}
-	r = append(r, tmp...)
-
-	tmp, err = PackShortBytes(car.snNew[:])
-	if err != nil {
-		return nil, err
-	}
-	r = append(r, tmp...)
+	return Marshal(car)
+}
 
-	tmp = translayer.UInt32ToBuf(uint32(car.errCode))
-	r = append(r, tmp...)
+func (car *ContactAddResp) Size() int {
+	return Size(car)
+}
 
-	return r, nil
+func (car *ContactAddResp) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, car)
+}
 
+func (car *ContactAddResp) UnPack(data []byte) (int, error) {
+	return Unmarshal(data, car)
 }
 
 type CryptContactAddResp struct {
@@ -1,20 +1,20 @@
 package bmprotocol
 
 import (
-	"encoding/binary"
+	"bytes"
+	"crypto/sha256"
 	"fmt"
 	"github.com/realbmail/go-bmail-protocol/translayer"
 	"github.com/btcsuite/btcutil/base58"
 	"github.com/pkg/errors"
 	"io"
-	"os"
 )
 
 type FileProperty struct {
 	Hash      []byte
 	FileName  string
 	FileType  int
-	IsEnCrypt bool
+	IsEnCrypt bool //true: file is in the chunked AEAD format of package stream
 	FileSize  int
 }
 
@@ -29,88 +29,19 @@ func (fp *FileProperty) String() string {
 }
 
 func (fp *FileProperty) Pack() ([]byte, error) {
-	var (
-		r, tmp []byte
-		err    error
-	)
-
-	tmp, err = PackShortBytes(fp.Hash)
-	if err != nil {
-		return nil, err
-	}
-	r = append(r, tmp...)
-
-	tmp, err = PackShortString(fp.FileName)
-	if err != nil {
-		return nil, err
-	}
-
-	r = append(r, tmp...)
-
-	tmp = translayer.UInt32ToBuf(uint32(fp.FileType))
-	r = append(r, tmp...)
-
-	var isEnc uint8
-
-	if fp.IsEnCrypt {
-		isEnc = 1
-	}
-
-	r = append(r, isEnc)
+	return Marshal(fp)
+}
 
-	tmp = translayer.UInt32ToBuf(uint32(fp.FileSize))
-	r = append(r, tmp...)
+func (fp *FileProperty) Size() int {
+	return Size(fp)
+}
 
-	return r, nil
+func (fp *FileProperty) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, fp)
 }
 
 func (fp *FileProperty) UnPack(data []byte) (int, error) {
-	var (
-		offset, of int
-		err        error
-	)
-	fp.Hash, of, err = UnPackShortBytes(data[offset:])
-	if err != nil {
-		return 0, err
-	}
-
-	offset += of
-
-	fp.FileName, of, err = UnPackShortString(data[offset:])
-	if err != nil {
-		return 0, err
-	}
-
-	offset += of
-
-	if len(data) < offset+translayer.Uint32Size {
-		return 0, errors.New("unpack FileType error")
-	}
-
-	fp.FileType = int(binary.BigEndian.Uint32(data[offset:]))
-
-	offset += translayer.Uint32Size
-
-	if len(data) < offset+translayer.Uin8Size {
-		return 0, errors.New("unpack IsEncrypt error")
-	}
-
-	if data[offset] == 1 {
-		fp.IsEnCrypt = true
-	} else {
-		fp.IsEnCrypt = false
-	}
-	offset += translayer.Uin8Size
-
-	if len(data) < offset+translayer.Uint32Size {
-		return 0, errors.New("unpack FileSize error")
-	}
-
-	fp.FileSize = int(binary.BigEndian.Uint32(data[offset:]))
-	offset += translayer.Uint32Size
-
-	return offset, nil
-
+	return Unmarshal(data, fp)
 }
 
 type Attachment struct {
@@ -126,58 +57,40 @@ func (a *Attachment) String() string {
 }
 
 func (a *Attachment) Pack() ([]byte, error) {
-	var (
-		r, tmp []byte
-		err    error
-	)
-
-	tmp, err = PackShortString(a.Path)
-	if err != nil {
-		return nil, err
-	}
-
-	r = append(r, tmp...)
-
-	fp := &a.FileProperty
-
-	tmp, err = fp.Pack()
+	return Marshal(a)
+}
 
-	r = append(r, tmp...)
+func (a *Attachment) Size() int {
+	return Size(a)
+}
 
-	return r, nil
+func (a *Attachment) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, a)
 }
 
 func (a *Attachment) UnPack(data []byte) (int, error) {
-	var (
-		offset, of int
-		err        error
-	)
-	a.Path, of, err = UnPackShortString(data[offset:])
-	if err != nil {
-		return 0, err
-	}
-
-	offset += of
-
-	fp := &a.FileProperty
-
-	of, err = fp.UnPack(data[offset:])
-	if err != nil {
-		return 0, err
-	}
+	return Unmarshal(data, a)
+}
 
-	offset += of
+//client --SendAttachment{Offset, Length} + Length bytes--> server
+//server --RespSendAttachment{Received}--> client
+//
+//a file is sent in chunks, the server keeps the bytes received for
+//EId + FileProperty.Hash. A chunk with Length 0 asks the server how many
+//bytes it already has, so a broken upload resumes from Received. A server
+//that stores the same Hash already answers Received == FileSize and the
+//upload is skipped.
 
-	return offset, nil
-
-}
+const DefaultChunkSize int64 = 1 << 20
 
 type SendAttachment struct {
 	translayer.BMTransLayer
 	FileProperty
 	EnvelopeSig
-	EId  translayer.EnveUniqID
-	File *os.File
+	EId    translayer.EnveUniqID
+	Offset int64
+	Length int64
+	File   io.ReaderAt `bm:"-" json:"-"`
 }
 
 type SAReader struct {
@@ -201,6 +114,8 @@ func (sa *SendAttachment) String() string {
 	s += sa.FileProperty.String()
 	s += sa.EnvelopeSig.String()
 	s += fmt.Sprintf("%-30s", base58.Encode(sa.EId[:]))
+	s += fmt.Sprintf("Offset: %-12d", sa.Offset)
+	s += fmt.Sprintf("Length: %-12d", sa.Length)
 
 	return s
 }
@@ -210,82 +125,140 @@ func (sar *SAReader) GetTotalSize() int {
 }
 
 func (sar *SAReader) Read(p []byte) (n int, err error) {
-
-	var toread int
+	if sar.readSize >= sar.totalSize {
+		return 0, io.EOF
+	}
 
 	if sar.readSize < len(sar.head) {
-		toread = len(sar.head) - sar.readSize
-		if toread > len(p) {
-			toread = len(p)
-			copy(p, sar.head)
-			sar.readSize += toread
-			return toread, nil
-		} else {
-			copy(p, sar.head)
+		n = copy(p, sar.head[sar.readSize:])
+		sar.readSize += n
+		if n == len(p) {
+			return n, nil
 		}
+	}
 
+	if sar.readSize >= sar.totalSize {
+		return n, nil
 	}
 
-	if sar.totalSize <= sar.readSize {
-		return toread, nil
+	left := sar.totalSize - sar.readSize
+	buf := p[n:]
+	if len(buf) > left {
+		buf = buf[:left]
 	}
 
-	nn, err := sar.Reader.Read(p[toread:])
-	n = nn + toread
+	nn, err := sar.Reader.Read(buf)
+	n += nn
+	sar.readSize += nn
 
-	sar.readSize += n
+	if err == io.EOF && sar.readSize < sar.totalSize {
+		err = io.ErrUnexpectedEOF
+	}
+	if err == io.EOF {
+		err = nil
+	}
 
-	return
+	return n, err
+}
 
+//NextChunk move to the chunk at offset, Length is cut at the file size
+func (sa *SendAttachment) NextChunk(offset, chunkSize int64) {
+	sa.Offset = offset
+	sa.Length = chunkSize
+	if sa.Length > int64(sa.FileSize)-sa.Offset {
+		sa.Length = int64(sa.FileSize) - sa.Offset
+	}
+	if sa.Length < 0 {
+		sa.Length = 0
+	}
 }
 
-func (sa *SendAttachment) GetReader() (*SAReader, error) {
-	var (
-		r, tmp []byte
-		err    error
-	)
+//inFile report if the chunk is in the file, Offset+Length may overflow
+func (sa *SendAttachment) inFile() bool {
+	return sa.FileSize >= 0 && sa.Offset >= 0 && sa.Length >= 0 &&
+		sa.Length <= int64(sa.FileSize)-sa.Offset
+}
 
-	r = NewHeadBuf()
+func (sa *SendAttachment) headSize() int {
+	return translayer.BMHeadSize() + Size(sa)
+}
 
-	fp := &sa.FileProperty
+//Size is the bytes of the current chunk and its head
+func (sa *SendAttachment) Size() int {
+	return sa.headSize() + int(sa.Length)
+}
 
-	tmp, err = fp.Pack()
+//WriteTo write the current chunk and its head to w, see GetReader
+func (sa *SendAttachment) WriteTo(w io.Writer) (int64, error) {
+	sar, err := sa.GetReader()
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
-	r = append(r, tmp...)
+	return io.Copy(w, sar)
+}
 
-	es := &sa.EnvelopeSig
-	tmp, err = es.Pack()
+func (sa *SendAttachment) packHead() ([]byte, error) {
+	r, err := appendMarshal(make([]byte, translayer.BMHeadSize(), sa.headSize()), sa)
 	if err != nil {
 		return nil, err
 	}
-	r = append(r, tmp...)
 
-	tmp, err = PackShortBytes(sa.EId[:])
-	if err != nil {
+	//data length counts the chunk after the head
+	sa.BMTransLayer.SetDataLen(uint32(int64(len(r)-translayer.BMHeadSize()) + sa.Length))
+	if err := sa.BMTransLayer.PackTo(r); err != nil {
 		return nil, err
 	}
-	r = append(r, tmp...)
 
-	AddPackHead(&(sa.BMTransLayer), r)
+	return r, nil
+}
+
+//GetReader stream the current chunk, see NextChunk
+func (sa *SendAttachment) GetReader() (*SAReader, error) {
+	if !sa.inFile() {
+		return nil, errors.New("attachment chunk out of file")
+	}
+	if sa.Length > 0 && sa.File == nil {
+		return nil, errors.New("attachment has no file")
+	}
+
+	r, err := sa.packHead()
+	if err != nil {
+		return nil, err
+	}
 
 	sar := &SAReader{}
 
 	sar.head = r
-	sar.totalSize = len(r) + sa.FileSize
-	sar.Reader = sa.File
+	sar.totalSize = len(r) + int(sa.Length)
+	if sa.Length > 0 {
+		sar.Reader = io.NewSectionReader(sa.File, sa.Offset, sa.Length)
+	}
 
 	return sar, nil
 }
 
+//UnPack read the head of a chunk, the Length bytes of the chunk follow
+func (sa *SendAttachment) UnPack(data []byte) (int, error) {
+	offset, err := Unmarshal(data, sa)
+	if err != nil {
+		return 0, err
+	}
+
+	if !sa.inFile() {
+		return 0, errors.New("attachment chunk out of file")
+	}
+
+	return offset, nil
+}
+
 type RespSendAttachment struct {
 	translayer.BMTransLayer
 	FileProperty
-	Sn    []byte
-	NewSn []byte
-	EId   translayer.EnveUniqID
-	ErrId int
+	Sn       []byte
+	NewSn    []byte
+	EId      translayer.EnveUniqID
+	ErrId    int
+	Received int64 //bytes of the file the server has, the next chunk starts here
 }
 
 func NewRespSendAttachment() *RespSendAttachment {
@@ -305,94 +278,183 @@ func (rsa *RespSendAttachment) String() string {
 	s += fmt.Sprintf("%-30s", base58.Encode(rsa.NewSn))
 	s += fmt.Sprintf("%-30s", base58.Encode(rsa.EId[:]))
 	s += fmt.Sprintf("%d", rsa.ErrId)
+	s += fmt.Sprintf("Received: %-12d", rsa.Received)
 
 	return s
 
 }
 
 func (rsa *RespSendAttachment) Pack() ([]byte, error) {
-	var (
-		r, tmp []byte
-		err    error
-	)
-	r = NewHeadBuf()
+	return packMsg(&(rsa.BMTransLayer), rsa)
+}
 
-	fp := &rsa.FileProperty
+func (rsa *RespSendAttachment) Size() int {
+	return msgSize(&(rsa.BMTransLayer), rsa)
+}
 
-	tmp, err = fp.Pack()
-	if err != nil {
-		return nil, err
-	}
-	r = append(r, tmp...)
+func (rsa *RespSendAttachment) WriteTo(w io.Writer) (int64, error) {
+	return writeMsg(w, &(rsa.BMTransLayer), rsa)
+}
+
+func (rsa *RespSendAttachment) UnPack(data []byte) (int, error) {
+	return unpackMsg(&(rsa.BMTransLayer), data, rsa)
+}
 
-	tmp, err = PackShortBytes(rsa.Sn)
+//files bigger than AttachmentPathSize are stored apart, Attachment.Path
+//is where the server put it
+const AttachmentPathSize int = 30 << 20
+
+//Verify check data against Hash, Hash is sha256 of the whole file
+func (fp *FileProperty) Verify(r io.Reader) error {
+	h := sha256.New()
+	n, err := io.Copy(h, r)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if n != int64(fp.FileSize) {
+		return errors.New("file size not match")
 	}
-	r = append(r, tmp...)
+	if !bytes.Equal(h.Sum(nil), fp.Hash) {
+		return errors.New("file hash not match")
+	}
+	return nil
+}
+
+//client --RetrAttachment{Offset, Length}--> server
+//server --RespRetrAttachment{Offset, Length} + Length bytes--> client
+//
+//the file is found by Path when set, else by EId + FileProperty.Hash.
+//Length 0 lets the server choose, it may send less than asked, never more.
+type RetrAttachment struct {
+	translayer.BMTransLayer
+	FileProperty
+	EnvelopeSig
+	EId    translayer.EnveUniqID
+	Path   string
+	Offset int64
+	Length int64
+}
+
+func NewRetrAttachment() *RetrAttachment {
+	bmtl := translayer.NewBMTL(translayer.RETR_ATTACHMENT)
+
+	ra := &RetrAttachment{}
+	ra.BMTransLayer = *bmtl
+
+	return ra
+}
+
+func (ra *RetrAttachment) String() string {
+	s := ra.BMTransLayer.String()
+	s += ra.FileProperty.String()
+	s += ra.EnvelopeSig.String()
+	s += fmt.Sprintf("%-30s", base58.Encode(ra.EId[:]))
+	s += "Path: " + ra.Path
+	s += fmt.Sprintf("Offset: %-12d", ra.Offset)
+	s += fmt.Sprintf("Length: %-12d", ra.Length)
 
-	tmp, err = PackShortBytes(rsa.NewSn)
+	return s
+}
+
+func (ra *RetrAttachment) Pack() ([]byte, error) {
+	return packMsg(&(ra.BMTransLayer), ra)
+}
+
+func (ra *RetrAttachment) Size() int {
+	return msgSize(&(ra.BMTransLayer), ra)
+}
+
+func (ra *RetrAttachment) WriteTo(w io.Writer) (int64, error) {
+	return writeMsg(w, &(ra.BMTransLayer), ra)
+}
+
+func (ra *RetrAttachment) UnPack(data []byte) (int, error) {
+	offset, err := unpackMsg(&(ra.BMTransLayer), data, ra)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
-	r = append(r, tmp...)
 
-	tmp, err = PackShortBytes(rsa.EId[:])
-	if err != nil {
-		return nil, err
+	if ra.Offset < 0 || ra.Length < 0 {
+		return 0, errors.New("bad range")
 	}
-	r = append(r, tmp...)
 
-	tmp = translayer.UInt32ToBuf(uint32(rsa.ErrId))
-	r = append(r, tmp...)
+	return offset, nil
+}
+
+/*
+ErrId:
+0: success
+1: attachment not found
+2: range out of file
+*/
+type RespRetrAttachment struct {
+	translayer.BMTransLayer
+	FileProperty
+	EId    translayer.EnveUniqID
+	Offset int64
+	Length int64
+	ErrId  int
+	Data   []byte `bm:"-"` //Length bytes after the fields
+}
 
-	AddPackHead(&(rsa.BMTransLayer), r)
+func NewRespRetrAttachment() *RespRetrAttachment {
+	bmtl := translayer.NewBMTL(translayer.RETR_ATTACHMENT_RESP)
 
-	return r, nil
+	rra := &RespRetrAttachment{}
+	rra.BMTransLayer = *bmtl
+
+	return rra
 }
 
-func (rsa *RespSendAttachment) UnPack(data []byte) (int, error) {
-	var (
-		offset, of int
-		err        error
-		tmp        []byte
-	)
-
-	fp := &rsa.FileProperty
-	of, err = fp.UnPack(data[offset:])
-	if err != nil {
-		return 0, nil
-	}
-	offset += of
+func (rra *RespRetrAttachment) String() string {
+	s := rra.BMTransLayer.String()
+	s += rra.FileProperty.String()
+	s += fmt.Sprintf("%-30s", base58.Encode(rra.EId[:]))
+	s += fmt.Sprintf("Offset: %-12d", rra.Offset)
+	s += fmt.Sprintf("Length: %-12d", rra.Length)
+	s += fmt.Sprintf("%d", rra.ErrId)
 
-	rsa.Sn, of, err = UnPackShortBytes(data[offset:])
-	if err != nil {
-		return 0, nil
+	return s
+}
+
+func (rra *RespRetrAttachment) checkPack() error {
+	if int64(len(rra.Data)) != rra.Length {
+		return errors.New("data not match length")
 	}
-	offset += of
+	return nil
+}
 
-	rsa.NewSn, of, err = UnPackShortBytes(data[offset:])
+func (rra *RespRetrAttachment) Pack() ([]byte, error) {
+	head := translayer.BMHeadSize()
+	r, err := appendMarshal(make([]byte, head, rra.Size()), rra)
 	if err != nil {
-		return 0, nil
+		return nil, err
 	}
 
-	offset += of
+	r = append(r, rra.Data...)
 
-	tmp, of, err = UnPackShortBytes(data[offset:])
-	if err != nil {
-		return 0, nil
-	}
-	offset += of
+	return AddPackHead(&(rra.BMTransLayer), r)
+}
+
+func (rra *RespRetrAttachment) Size() int {
+	return translayer.BMHeadSize() + Size(rra) + len(rra.Data)
+}
 
-	copy(rsa.EId[:], tmp)
+func (rra *RespRetrAttachment) WriteTo(w io.Writer) (int64, error) {
+	return writeBM(w, &(rra.BMTransLayer), rra, rra.Data)
+}
 
-	if len(data) < offset+translayer.Uint32Size {
-		return 0, errors.New("unpack errid error")
+func (rra *RespRetrAttachment) UnPack(data []byte) (int, error) {
+	offset, err := Unmarshal(data, rra)
+	if err != nil {
+		return 0, err
 	}
-	rsa.ErrId = int(binary.BigEndian.Uint32(data[offset:]))
 
-	offset += of
+	if rra.Offset < 0 || rra.Length < 0 || int64(len(data)-offset) < rra.Length {
+		return 0, errors.New("unpack data error")
+	}
+	rra.Data = data[offset : offset+int(rra.Length)]
+	offset += int(rra.Length)
 
 	return offset, nil
-
 }
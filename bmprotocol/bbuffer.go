@@ -0,0 +1,162 @@
+package bmprotocol
+
+import (
+	"errors"
+	"github.com/realbmail/go-bmail-protocol/translayer"
+	"io"
+	"sync"
+)
+
+//maxPooled a buffer larger than this is left to the GC, a chunk of an
+//attachment and its head fit
+const maxPooled = 2 * DefaultChunkSize
+
+var bufPool = sync.Pool{New: func() interface{} { return new([]byte) }}
+
+//getBuf give an empty pooled buffer that holds size bytes
+func getBuf(size int) *[]byte {
+	b := bufPool.Get().(*[]byte)
+	if cap(*b) < size {
+		*b = make([]byte, 0, size)
+	}
+	*b = (*b)[:0]
+	return b
+}
+
+func putBuf(b *[]byte) {
+	if int64(cap(*b)) > maxPooled {
+		return
+	}
+	bufPool.Put(b)
+}
+
+//writeTo write the packed struct v points to to w, by one Write
+func writeTo(w io.Writer, v interface{}) (int64, error) {
+	return writeBM(w, nil, v, nil)
+}
+
+//writeMsg write the frame of v to w in the codec of its version
+func writeMsg(w io.Writer, bmtl *translayer.BMTransLayer, v interface{}) (int64, error) {
+	if isV2(bmtl) {
+		data, err := packMsg(bmtl, v)
+		if err != nil {
+			return 0, err
+		}
+		n, err := w.Write(data)
+		return int64(n), err
+	}
+	return writeBM(w, bmtl, v, nil)
+}
+
+//writeBM write the head bmtl, v and tail to w by one Write of a pooled
+//buffer, the head is left out when bmtl is nil
+func writeBM(w io.Writer, bmtl *translayer.BMTransLayer, v interface{}, tail []byte) (int64, error) {
+	rv, err := structOf(v)
+	if err != nil {
+		return 0, err
+	}
+
+	head := 0
+	if bmtl != nil {
+		head = translayer.BMHeadSize()
+	}
+	b := getBuf(head + sizeOfStruct(rv) + len(tail))
+	defer putBuf(b)
+
+	r, err := appendStruct((*b)[:head], rv)
+	if err != nil {
+		return 0, err
+	}
+	r = append(r, tail...)
+	*b = r[:0]
+
+	if bmtl != nil {
+		bmtl.SetDataLen(uint32(len(r) - head))
+		if err := bmtl.PackTo(r); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := w.Write(r)
+	return int64(n), err
+}
+
+//writePacked write what p packs, for the messages packed by hand
+func writePacked(w io.Writer, p interface{ Pack() ([]byte, error) }) (int64, error) {
+	data, err := p.Pack()
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(data)
+	return int64(n), err
+}
+
+//Frame is a message read by ReadFrame, Data is the bytes after the head.
+//Data is in a pooled buffer, what is unpacked from it aliases the buffer
+//and must not be used after Release.
+type Frame struct {
+	translayer.BMTransLayer
+	Data []byte
+	buf  []byte
+}
+
+var framePool = sync.Pool{New: func() interface{} { return &Frame{} }}
+
+var ErrFrameTooLarge = errors.New("frame too large")
+
+//ReadFrame read a head and its data from r, data longer than max is
+//refused before it is read, max <= 0 is the MaxBytes of DefaultLimits. A
+//compressed frame is decompressed, the head then tells the plain data.
+func ReadFrame(r io.Reader, max int) (*Frame, error) {
+	if max <= 0 {
+		max = DefaultLimits.MaxBytes
+	}
+
+	f := framePool.Get().(*Frame)
+	head := translayer.BMHeadSize()
+	if cap(f.buf) < head {
+		f.buf = make([]byte, head)
+	}
+	f.buf = f.buf[:head]
+	if _, err := io.ReadFull(r, f.buf); err != nil {
+		f.Release()
+		return nil, err
+	}
+	if _, err := f.BMTransLayer.UnPack(f.buf); err != nil {
+		f.Release()
+		return nil, err
+	}
+
+	l := int64(f.GetDataLen())
+	if l > int64(max) {
+		f.Release()
+		return nil, ErrFrameTooLarge
+	}
+	if int64(cap(f.buf)) < l {
+		f.buf = make([]byte, l)
+	}
+	f.buf = f.buf[:l]
+	if _, err := io.ReadFull(r, f.buf); err != nil {
+		f.Release()
+		return nil, err
+	}
+
+	data, err := UnCompressData(&f.BMTransLayer, f.buf)
+	if err != nil {
+		f.Release()
+		return nil, err
+	}
+	f.Data = data
+
+	return f, nil
+}
+
+//Release give the buffer of f back, f and its data are not used after
+func (f *Frame) Release() {
+	f.BMTransLayer = translayer.BMTransLayer{}
+	f.Data = nil
+	if int64(cap(f.buf)) > maxPooled {
+		f.buf = nil
+	}
+	framePool.Put(f)
+}
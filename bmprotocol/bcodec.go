@@ -0,0 +1,448 @@
+package bmprotocol
+
+import (
+	"errors"
+	"fmt"
+	"github.com/realbmail/go-bmail-protocol/translayer"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"unsafe"
+)
+
+//Marshal and Unmarshal pack the fields of a message in order, by the bm
+//tag of each field:
+//
+//short            uint16 length + bytes, default of string, []byte, [N]byte
+//long             uint32 length + bytes
+//u8 u16 u32 u64   big endian, int is u32, int64 is u64, bool is u8
+//n16 n32          width of the count of a slice, n32 by default
+//-                not packed
+//
+//a struct field is packed in place, an embedded translayer.BMTransLayer is
+//the head and is packed apart, a struct can't hold itself. The elements of
+//a slice of string or []byte take short or long, e.g. `bm:"long,n16"`. The
+//bytes are the same as the Pack helpers of bmcommon.go give. The BPOP
+//messages, CryptContactHello, ContactHelloResp and CryptContactAdd sent
+//only their head before the codec, they send their fields now.
+
+const (
+	kindShort = iota + 1
+	kindLong
+	kindU8
+	kindU16
+	kindU32
+	kindU64
+	kindStruct
+	kindSlice
+)
+
+var headType = reflect.TypeOf(translayer.BMTransLayer{})
+
+//a message that implements packChecker is checked before it is packed
+type packChecker interface {
+	checkPack() error
+}
+
+type fieldCodec struct {
+	index int
+	name  string
+	kind  int
+	count int         //kind of the count of a slice
+	elem  *fieldCodec //element of a slice
+	ptr   bool        //slice of pointers to struct
+	min   int         //bytes packed at the least
+}
+
+type structCodec struct {
+	fields []*fieldCodec
+	min    int
+}
+
+var codecs sync.Map //reflect.Type -> *structCodec
+
+func codecOf(t reflect.Type) (*structCodec, error) {
+	if sc, ok := codecs.Load(t); ok {
+		return sc.(*structCodec), nil
+	}
+
+	sc := &structCodec{}
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("bm")
+		if tag == "-" || sf.Type == headType {
+			continue
+		}
+		fc, err := newFieldCodec(sf.Name, sf.Type, tag)
+		if err != nil {
+			return nil, err
+		}
+		fc.index = i
+		sc.fields = append(sc.fields, fc)
+		sc.min += fc.min
+	}
+
+	codecs.Store(t, sc)
+	return sc, nil
+}
+
+func newFieldCodec(name string, t reflect.Type, tag string) (*fieldCodec, error) {
+	fc := &fieldCodec{name: name, count: kindU32}
+
+	var kind string
+	for _, w := range strings.Split(tag, ",") {
+		switch w {
+		case "":
+		case "n16":
+			fc.count = kindU16
+		case "n32":
+			fc.count = kindU32
+		default:
+			kind = w
+		}
+	}
+
+	isBytes := t.Kind() == reflect.String ||
+		(t.Kind() == reflect.Slice || t.Kind() == reflect.Array) && t.Elem().Kind() == reflect.Uint8
+
+	switch {
+	case isBytes:
+		fc.kind, fc.min = kindShort, translayer.Uint16Size
+		if kind == "long" {
+			fc.kind, fc.min = kindLong, translayer.Uint32Size
+		} else if kind != "" && kind != "short" {
+			return nil, fmt.Errorf("bm codec: %s can't be %s", name, kind)
+		}
+		return fc, nil
+
+	case t.Kind() == reflect.Slice:
+		et := t.Elem()
+		if et.Kind() == reflect.Ptr && et.Elem().Kind() == reflect.Struct {
+			fc.ptr = true
+			et = et.Elem()
+		}
+		elem, err := newFieldCodec(name, et, kind)
+		if err != nil {
+			return nil, err
+		}
+		fc.kind = kindSlice
+		fc.elem = elem
+		fc.min = uintSize(fc.count)
+		return fc, nil
+
+	case t.Kind() == reflect.Struct:
+		if kind != "" {
+			return nil, fmt.Errorf("bm codec: %s can't be %s", name, kind)
+		}
+		sc, err := codecOf(t)
+		if err != nil {
+			return nil, err
+		}
+		fc.kind = kindStruct
+		fc.min = sc.min
+		return fc, nil
+	}
+
+	switch t.Kind() {
+	case reflect.Bool, reflect.Int8, reflect.Uint8:
+		fc.kind = kindU8
+	case reflect.Int16, reflect.Uint16:
+		fc.kind = kindU16
+	case reflect.Int, reflect.Uint, reflect.Int32, reflect.Uint32:
+		fc.kind = kindU32
+	case reflect.Int64, reflect.Uint64:
+		fc.kind = kindU64
+	default:
+		return nil, fmt.Errorf("bm codec: type of %s not supported", name)
+	}
+
+	switch kind {
+	case "":
+	case "u8":
+		fc.kind = kindU8
+	case "u16":
+		fc.kind = kindU16
+	case "u32":
+		fc.kind = kindU32
+	case "u64":
+		fc.kind = kindU64
+	default:
+		return nil, fmt.Errorf("bm codec: %s can't be %s", name, kind)
+	}
+	if t.Kind() == reflect.Bool && fc.kind != kindU8 {
+		return nil, fmt.Errorf("bm codec: %s can't be %s", name, kind)
+	}
+	fc.min = uintSize(fc.kind)
+
+	return fc, nil
+}
+
+//Layout give the bm layout of each field of struct type t packed by
+//Marshal, by the name of the field, e.g. "short", "u32", "struct",
+//"n16 of long". The messages packed by hand are not told.
+func Layout(t reflect.Type) (map[string]string, error) {
+	sc, err := codecOf(t)
+	if err != nil {
+		return nil, err
+	}
+	layout := make(map[string]string, len(sc.fields))
+	for _, fc := range sc.fields {
+		layout[fc.name] = fc.layout()
+	}
+	return layout, nil
+}
+
+var kindNames = map[int]string{
+	kindShort:  "short",
+	kindLong:   "long",
+	kindU8:     "u8",
+	kindU16:    "u16",
+	kindU32:    "u32",
+	kindU64:    "u64",
+	kindStruct: "struct",
+}
+
+func (fc *fieldCodec) layout() string {
+	if fc.kind == kindSlice {
+		return "n" + strconv.Itoa(8*uintSize(fc.count)) + " of " + fc.elem.layout()
+	}
+	return kindNames[fc.kind]
+}
+
+//field give a settable field, unexported ones too
+func field(v reflect.Value, i int) reflect.Value {
+	f := v.Field(i)
+	if f.CanSet() {
+		return f
+	}
+	return reflect.NewAt(f.Type(), unsafe.Pointer(f.UnsafeAddr())).Elem()
+}
+
+//Marshal pack the struct v points to
+func Marshal(v interface{}) ([]byte, error) {
+	rv, err := structOf(v)
+	if err != nil {
+		return nil, err
+	}
+	return appendStruct(make([]byte, 0, sizeOfStruct(rv)), rv)
+}
+
+//Size is the bytes Marshal gives for the struct v points to
+func Size(v interface{}) int {
+	rv, err := structOf(v)
+	if err != nil {
+		return 0
+	}
+	return sizeOfStruct(rv)
+}
+
+//appendMarshal append the packed struct v points to to dst
+func appendMarshal(dst []byte, v interface{}) ([]byte, error) {
+	rv, err := structOf(v)
+	if err != nil {
+		return nil, err
+	}
+	return appendStruct(dst, rv)
+}
+
+func structOf(v interface{}) (reflect.Value, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return reflect.Value{}, errors.New("bm codec: need a pointer to struct")
+	}
+	return rv.Elem(), nil
+}
+
+//sizeOfStruct is 0 for a type the codec can't pack, Marshal tells why
+func sizeOfStruct(v reflect.Value) int {
+	sc, err := codecOf(v.Type())
+	if err != nil {
+		return 0
+	}
+	size := 0
+	for _, fc := range sc.fields {
+		size += fc.size(field(v, fc.index))
+	}
+	return size
+}
+
+func (fc *fieldCodec) size(f reflect.Value) int {
+	switch fc.kind {
+	case kindShort:
+		return translayer.Uint16Size + f.Len()
+	case kindLong:
+		return translayer.Uint32Size + f.Len()
+	case kindStruct:
+		return sizeOfStruct(f)
+	case kindSlice:
+		size := uintSize(fc.count)
+		for i := 0; i < f.Len(); i++ {
+			e := f.Index(i)
+			if fc.ptr {
+				if e.IsNil() {
+					continue
+				}
+				e = e.Elem()
+			}
+			size += fc.elem.size(e)
+		}
+		return size
+	}
+	return uintSize(fc.kind)
+}
+
+func appendStruct(dst []byte, v reflect.Value) ([]byte, error) {
+	if c, ok := v.Addr().Interface().(packChecker); ok {
+		if err := c.checkPack(); err != nil {
+			return nil, err
+		}
+	}
+
+	sc, err := codecOf(v.Type())
+	if err != nil {
+		return nil, err
+	}
+	for _, fc := range sc.fields {
+		dst, err = fc.append(dst, field(v, fc.index))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return dst, nil
+}
+
+//checkPack check the struct v points to and the structs in it as
+//appendStruct does, for the codecs that don't pack by appendStruct
+func checkPack(v interface{}) error {
+	rv, err := structOf(v)
+	if err != nil {
+		return err
+	}
+	return checkStruct(rv)
+}
+
+func checkStruct(v reflect.Value) error {
+	if c, ok := v.Addr().Interface().(packChecker); ok {
+		if err := c.checkPack(); err != nil {
+			return err
+		}
+	}
+
+	sc, err := codecOf(v.Type())
+	if err != nil {
+		return err
+	}
+	for _, fc := range sc.fields {
+		f := field(v, fc.index)
+		switch {
+		case fc.kind == kindStruct:
+			err = checkStruct(f)
+		case fc.kind == kindSlice && fc.elem.kind == kindStruct:
+			for i := 0; i < f.Len() && err == nil; i++ {
+				e := f.Index(i)
+				if fc.ptr {
+					if e.IsNil() {
+						continue
+					}
+					e = e.Elem()
+				}
+				err = checkStruct(e)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//appendUint append u big endian, no buffer of translayer is made
+func appendUint(dst []byte, kind int, u uint64) []byte {
+	for i := uintSize(kind) - 1; i >= 0; i-- {
+		dst = append(dst, byte(u>>(8*uint(i))))
+	}
+	return dst
+}
+
+func (fc *fieldCodec) append(dst []byte, f reflect.Value) ([]byte, error) {
+	switch fc.kind {
+	case kindShort, kindLong:
+		l := f.Len()
+		if fc.kind == kindShort {
+			if l > 0xffff {
+				return nil, fmt.Errorf("pack %s error: too long", fc.name)
+			}
+			dst = appendUint(dst, kindU16, uint64(l))
+		} else {
+			if uint64(l) > 0xffffffff {
+				return nil, fmt.Errorf("pack %s error: too long", fc.name)
+			}
+			dst = appendUint(dst, kindU32, uint64(l))
+		}
+		if f.Kind() == reflect.String {
+			return append(dst, f.String()...), nil
+		}
+		return append(dst, f.Bytes()...), nil
+
+	case kindStruct:
+		return appendStruct(dst, f)
+
+	case kindSlice:
+		n := f.Len()
+		if fc.count == kindU16 && n > 0xffff {
+			return nil, fmt.Errorf("pack %s error: too many", fc.name)
+		}
+		dst = appendUint(dst, fc.count, uint64(n))
+		var err error
+		for i := 0; i < n; i++ {
+			e := f.Index(i)
+			if fc.ptr {
+				if e.IsNil() {
+					return nil, fmt.Errorf("pack %s error: nil element", fc.name)
+				}
+				e = e.Elem()
+			}
+			dst, err = fc.elem.append(dst, e)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return dst, nil
+	}
+
+	switch f.Kind() {
+	case reflect.Bool:
+		if f.Bool() {
+			return append(dst, 1), nil
+		}
+		return append(dst, 0), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return appendUint(dst, fc.kind, uint64(f.Int())), nil
+	}
+	return appendUint(dst, fc.kind, f.Uint()), nil
+}
+
+//packMsg pack v after the head bmtl, in one buffer of the size, by the
+//codec of the version of bmtl
+func packMsg(bmtl *translayer.BMTransLayer, v interface{}) ([]byte, error) {
+	if isV2(bmtl) {
+		data, err := PackAs(translayer.BMAILVER2, v)
+		if err != nil {
+			return nil, err
+		}
+		return AddPackHead(bmtl, append(NewHeadBuf(), data...))
+	}
+
+	rv, err := structOf(v)
+	if err != nil {
+		return nil, err
+	}
+
+	head := translayer.BMHeadSize()
+	r, err := appendStruct(make([]byte, head, head+sizeOfStruct(rv)), rv)
+	if err != nil {
+		return nil, err
+	}
+	return AddPackHead(bmtl, r)
+}
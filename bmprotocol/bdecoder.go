@@ -0,0 +1,219 @@
+package bmprotocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/realbmail/go-bmail-protocol/translayer"
+	"reflect"
+)
+
+//Limits bound what is unpacked from the bytes of a peer
+type Limits struct {
+	MaxBytes int //of a short or long field
+	MaxCount int //elements of a slice
+	MaxDepth int //structs packed in structs
+}
+
+//DefaultLimits are the limits of the UnPack of every message
+var DefaultLimits = Limits{
+	MaxBytes: translayer.DefaultCborLimits.MaxBytes,
+	MaxCount: translayer.DefaultCborLimits.MaxCount,
+	MaxDepth: 8,
+}
+
+var ErrLimit = errors.New("bm codec: over the limit")
+
+//Decoder unpack messages packed by Marshal. A length or count read is
+//checked against the bytes left and the limits before it is used, a count
+//can't ask for more elements than the bytes left can hold.
+type Decoder struct {
+	Limits
+	data   []byte
+	offset int
+	depth  int
+}
+
+func NewDecoder(data []byte) *Decoder {
+	return &Decoder{Limits: DefaultLimits, data: data}
+}
+
+//Offset is the bytes decoded
+func (d *Decoder) Offset() int {
+	return d.offset
+}
+
+//Decode unpack the struct v points to from the bytes left, nothing is
+//consumed on an error
+func (d *Decoder) Decode(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return errors.New("bm codec: need a pointer to struct")
+	}
+
+	offset := d.offset
+	d.depth = 0
+	if err := d.decodeStruct(rv.Elem()); err != nil {
+		d.offset = offset
+		return err
+	}
+	return nil
+}
+
+//Unmarshal unpack data to the struct v points to with DefaultLimits, it
+//gives the bytes read
+func Unmarshal(data []byte, v interface{}) (int, error) {
+	d := NewDecoder(data)
+	if err := d.Decode(v); err != nil {
+		return 0, err
+	}
+	return d.offset, nil
+}
+
+func (d *Decoder) left() int {
+	return len(d.data) - d.offset
+}
+
+func (d *Decoder) decodeStruct(v reflect.Value) error {
+	d.depth++
+	defer func() { d.depth-- }()
+	if d.depth > d.MaxDepth {
+		return fmt.Errorf("unpack %s error: %w", v.Type().Name(), ErrLimit)
+	}
+
+	sc, err := codecOf(v.Type())
+	if err != nil {
+		return err
+	}
+	for _, fc := range sc.fields {
+		if err := d.decodeField(fc, field(v, fc.index)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func uintSize(kind int) int {
+	switch kind {
+	case kindU8:
+		return translayer.Uin8Size
+	case kindU16:
+		return translayer.Uint16Size
+	case kindU32:
+		return translayer.Uint32Size
+	}
+	return translayer.Uint64Size
+}
+
+func (d *Decoder) readUint(fc *fieldCodec, kind int) (uint64, error) {
+	size := uintSize(kind)
+	if d.left() < size {
+		return 0, fmt.Errorf("unpack %s error", fc.name)
+	}
+
+	data := d.data[d.offset:]
+	d.offset += size
+	switch kind {
+	case kindU8:
+		return uint64(data[0]), nil
+	case kindU16:
+		return uint64(binary.BigEndian.Uint16(data)), nil
+	case kindU32:
+		return uint64(binary.BigEndian.Uint32(data)), nil
+	}
+	return binary.BigEndian.Uint64(data), nil
+}
+
+func (d *Decoder) readBytes(fc *fieldCodec) ([]byte, error) {
+	lk := kindU16
+	if fc.kind == kindLong {
+		lk = kindU32
+	}
+	l, err := d.readUint(fc, lk)
+	if err != nil {
+		return nil, err
+	}
+	if l > uint64(d.MaxBytes) {
+		return nil, fmt.Errorf("unpack %s error: %w", fc.name, ErrLimit)
+	}
+	if l > uint64(d.left()) {
+		return nil, fmt.Errorf("unpack %s error", fc.name)
+	}
+
+	//no copy, the same as UnPackShortBytes
+	var b []byte
+	if l > 0 {
+		b = d.data[d.offset : d.offset+int(l)]
+	}
+	d.offset += int(l)
+	return b, nil
+}
+
+func (d *Decoder) decodeField(fc *fieldCodec, f reflect.Value) error {
+	switch fc.kind {
+	case kindShort, kindLong:
+		b, err := d.readBytes(fc)
+		if err != nil {
+			return err
+		}
+		switch f.Kind() {
+		case reflect.String:
+			f.SetString(string(b))
+		case reflect.Array:
+			if len(b) != f.Len() {
+				return fmt.Errorf("unpack %s error: %d bytes for %d", fc.name, len(b), f.Len())
+			}
+			reflect.Copy(f, reflect.ValueOf(b))
+		default:
+			f.SetBytes(b)
+		}
+		return nil
+
+	case kindStruct:
+		return d.decodeStruct(f)
+
+	case kindSlice:
+		n, err := d.readUint(fc, fc.count)
+		if err != nil {
+			return err
+		}
+		if n > uint64(d.MaxCount) {
+			return fmt.Errorf("unpack %s error: %w", fc.name, ErrLimit)
+		}
+		if n*uint64(fc.elem.min) > uint64(d.left()) {
+			return fmt.Errorf("unpack %s error: count %d over the data", fc.name, n)
+		}
+		if n == 0 {
+			f.Set(reflect.Zero(f.Type()))
+			return nil
+		}
+
+		s := reflect.MakeSlice(f.Type(), int(n), int(n))
+		for i := 0; i < int(n); i++ {
+			e := s.Index(i)
+			if fc.ptr {
+				e.Set(reflect.New(e.Type().Elem()))
+				e = e.Elem()
+			}
+			if err := d.decodeField(fc.elem, e); err != nil {
+				return err
+			}
+		}
+		f.Set(s)
+		return nil
+	}
+
+	u, err := d.readUint(fc, fc.kind)
+	if err != nil {
+		return err
+	}
+	switch f.Kind() {
+	case reflect.Bool:
+		f.SetBool(u == 1)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		f.SetInt(int64(u))
+	default:
+		f.SetUint(u)
+	}
+	return nil
+}
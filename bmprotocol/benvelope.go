@@ -2,11 +2,11 @@ package bmprotocol
 
 import (
 	"bytes"
-	"encoding/binary"
 	"errors"
 	"fmt"
 	"github.com/realbmail/go-bmail-protocol/translayer"
 	"github.com/btcsuite/btcutil/base58"
+	"io"
 )
 
 const (
@@ -63,84 +63,36 @@ func (eh *EnvelopeRoute) String() string {
 	return s
 }
 
-func (eh *EnvelopeRoute) Pack() ([]byte, error) {
+func (eh *EnvelopeRoute) checkPack() error {
 	if eh.From == "" || eh.RecpAddr == "" {
-		return nil, errors.New("")
-	}
-
-	var (
-		r, tmp []byte
-		err    error
-	)
-
-	tmp, err = PackShortString(eh.From)
-	if err != nil {
-		return nil, err
+		return errors.New("Envelope Must have From and Recipient Address")
 	}
-	r = append(r, tmp...)
-
-	tmp, err = PackShortString(eh.RecpAddr)
-	if err != nil {
-		return nil, err
-	}
-	r = append(r, tmp...)
-
-	tmp = translayer.UInt32ToBuf(uint32(eh.RecpAddrType))
-	r = append(r, tmp...)
+	return nil
+}
 
-	tmp, err = PackShortBytes(eh.EId[:])
-	if err != nil {
-		return nil, err
-	}
+func (eh *EnvelopeRoute) Pack() ([]byte, error) {
+	return Marshal(eh)
+}
 
-	r = append(r, tmp...)
+func (eh *EnvelopeRoute) Size() int {
+	return Size(eh)
+}
 
-	return r, nil
+func (eh *EnvelopeRoute) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, eh)
 }
 
 func (eh *EnvelopeRoute) UnPack(data []byte) (int, error) {
-	var (
-		offset, of int
-		err        error
-	)
-	eh.From, of, err = UnPackShortString(data[offset:])
-	if err != nil {
-		return 0, nil
-	}
-
-	offset += of
-
-	eh.RecpAddr, of, err = UnPackShortString(data[offset:])
-	if err != nil {
-		return 0, nil
-	}
-	offset += of
-
-	if len(data) < offset+translayer.Uint32Size {
-		return 0, errors.New("unpack recp addr type error")
-	}
-	eh.RecpAddrType = int(binary.BigEndian.Uint32(data[offset:]))
-	offset += translayer.Uint32Size
-
-	var tmp []byte
-	tmp, of, err = UnPackShortBytes(data[offset:])
-	if err != nil {
-		return 0, nil
-	}
-	offset += of
-
-	copy(eh.EId[:], tmp)
-
-	return offset, nil
+	return Unmarshal(data, eh)
 }
 
 type EnvelopeContent struct {
 	To      []string
 	CC      []string
 	BC      []string
-	Subject string
-	Data    string
-	Files   []Attachment
+	Subject string       `bm:"long"`
+	Data    string       `bm:"long"`
+	Files   []Attachment `bm:"n16"`
 }
 
 func (ec *EnvelopeContent) String() string {
@@ -186,127 +138,27 @@ func (ec *EnvelopeContent) String() string {
 	return s
 }
 
-func (ec *EnvelopeContent) Pack() ([]byte, error) {
-
+func (ec *EnvelopeContent) checkPack() error {
 	if len(ec.To) == 0 || len(ec.Subject) == 0 {
-		return nil, errors.New("Envelope Must have TO Address and Subject")
-	}
-
-	var r []byte
-
-	rto, err := PackShortStringArray(ec.To)
-	if err != nil {
-		return nil, errors.New("Pack Envelope Content TO addres error")
-	}
-
-	r = append(r, rto...)
-
-	rcc, err := PackShortStringArray(ec.CC)
-	if err != nil {
-		return nil, errors.New("Pack Envelope Content CC addres error")
-	}
-
-	r = append(r, rcc...)
-
-	rbc, err := PackShortStringArray(ec.BC)
-	if err != nil {
-		return nil, errors.New("Pack Envelope Content BC addres error")
-	}
-
-	r = append(r, rbc...)
-
-	rsubj, err := PackLongString(ec.Subject)
-	if err != nil {
-		return nil, errors.New("Pack Envelope Content Subject error")
-	}
-
-	r = append(r, rsubj...)
-
-	rdata, err := PackLongString(ec.Data)
-	if err != nil {
-		return nil, errors.New("Pack Envelope Content Data error")
+		return errors.New("Envelope Must have TO Address and Subject")
 	}
+	return nil
+}
 
-	r = append(r, rdata...)
-
-	fcnt := len(ec.Files)
-	tmp := translayer.UInt16ToBuf(uint16(fcnt))
-	r = append(r, tmp...)
+func (ec *EnvelopeContent) Pack() ([]byte, error) {
+	return Marshal(ec)
+}
 
-	for i := 0; i < len(ec.Files); i++ {
-		a := &ec.Files[i]
-		tmp, err = a.Pack()
-		if err != nil {
-			return nil, err
-		}
-		r = append(r, tmp...)
-	}
+func (ec *EnvelopeContent) Size() int {
+	return Size(ec)
+}
 
-	return r, nil
+func (ec *EnvelopeContent) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, ec)
 }
 
 func (ec *EnvelopeContent) UnPack(data []byte) (int, error) {
-	offset := 0
-	to, of, err := UnPackShortStringArray(data[offset:])
-	if err != nil {
-		return 0, err
-	}
-
-	ec.To = to
-	offset += of
-
-	var cc []string
-	cc, of, err = UnPackShortStringArray(data[offset:])
-	if err != nil {
-		return 0, err
-	}
-
-	ec.CC = cc
-	offset += of
-
-	var bc []string
-	bc, of, err = UnPackShortStringArray(data[offset:])
-	if err != nil {
-		return 0, err
-	}
-
-	ec.BC = bc
-	offset += of
-
-	var sbj string
-	sbj, of, err = UnPackLongString(data[offset:])
-	if err != nil {
-		return 0, err
-	}
-
-	ec.Subject = sbj
-	offset += of
-
-	var ed string
-	ed, of, err = UnPackLongString(data[offset:])
-
-	ec.Data = ed
-	offset += of
-
-	if len(data) < offset+translayer.Uint16Size {
-		return 0, errors.New("unpack Files error")
-	}
-
-	cnt := binary.BigEndian.Uint16(data[offset:])
-	offset += translayer.Uint16Size
-
-	for i := 0; i < int(cnt); i++ {
-		a := &Attachment{}
-		of, err = a.UnPack(data[offset:])
-		if err != nil {
-			return 0, err
-		}
-		offset += of
-
-		ec.Files = append(ec.Files, *a)
-	}
-
-	return offset, nil
+	return Unmarshal(data, ec)
 }
 
 type EnvelopeSig struct {
@@ -332,50 +184,27 @@ func (et *EnvelopeSig) String() string {
 	return s
 }
 
-func (ee *EnvelopeSig) Pack() ([]byte, error) {
+func (ee *EnvelopeSig) checkPack() error {
 	if len(ee.Sn) == 0 || len(ee.Sig) == 0 {
-		return nil, errors.New("Not a Correct Envelope Tail")
-	}
-
-	var r []byte
-
-	riv, err := PackShortBytes(ee.Sn)
-	if err != nil {
-		return nil, errors.New("Pack Envelope Tail IV error")
+		return errors.New("Not a Correct Envelope Tail")
 	}
-	r = append(r, riv...)
+	return nil
+}
 
-	var rsig []byte
-	rsig, err = PackShortBytes(ee.Sig)
-	if err != nil {
-		return nil, errors.New("Pack Envelope Tail Signature error")
-	}
+func (ee *EnvelopeSig) Pack() ([]byte, error) {
+	return Marshal(ee)
+}
 
-	r = append(r, rsig...)
+func (ee *EnvelopeSig) Size() int {
+	return Size(ee)
+}
 
-	return r, nil
+func (ee *EnvelopeSig) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, ee)
 }
 
 func (ee *EnvelopeSig) UnPack(data []byte) (int, error) {
-	offset := 0
-	var of int
-	var err error
-	ee.Sn, of, err = UnPackShortBytes(data[offset:])
-
-	if err != nil {
-		return 0, err
-	}
-
-	offset += of
-
-	ee.Sig, of, err = UnPackShortBytes(data[offset:])
-	if err != nil {
-		return 0, err
-	}
-
-	offset += of
-
-	return offset, nil
+	return Unmarshal(data, ee)
 }
 
 //mode:
@@ -384,11 +213,34 @@ func (ee *EnvelopeSig) UnPack(data []byte) (int, error) {
 // CryptModePSP uint16 = 11
 // CryptModePSSP uint16 = 15
 
+//CryptModeEphemeral is or'ed into the mode when the key of the envelope is
+//agreed by a new X25519 key of the sender, Pubkeys[0] is its public key.
+//A reader refuse a mode with bits it does not know.
+const (
+	CryptModeEphemeral = 1 << 8
+
+	cryptModeKnown = 0xff | CryptModeEphemeral
+)
+
 type EnvelopeCryptDesc struct {
 	Mode    int //ps, pp, psp, pssp
 	Pubkeys [][]byte
 }
 
+func (ecd *EnvelopeCryptDesc) Ephemeral() bool {
+	return ecd.Mode&CryptModeEphemeral != 0
+}
+
+func (ecd *EnvelopeCryptDesc) CheckMode() error {
+	if ecd.Mode&^cryptModeKnown != 0 {
+		return fmt.Errorf("crypt mode %#x not supported", ecd.Mode)
+	}
+	if ecd.Ephemeral() && len(ecd.Pubkeys) == 0 {
+		return errors.New("ephemeral crypt mode without the ephemeral key")
+	}
+	return nil
+}
+
 func (ecd *EnvelopeCryptDesc) String() string {
 	s := fmt.Sprintf("mode: %d", ecd.Mode)
 	s += fmt.Sprintf("     pubkey count:%d\r\n", len(ecd.Pubkeys))
@@ -420,44 +272,19 @@ func (ecd *EnvelopeCryptDesc) CopyTo(ecd1 *EnvelopeCryptDesc) *EnvelopeCryptDesc
 }
 
 func (ecd *EnvelopeCryptDesc) Pack() ([]byte, error) {
-	var (
-		tmp, r []byte
-		err    error
-	)
-	tmp = translayer.UInt32ToBuf(uint32(ecd.Mode))
-	r = append(r, tmp...)
-
-	tmp, err = PackShortBytesArray(ecd.Pubkeys)
-	if err != nil {
-		return nil, err
-	}
+	return Marshal(ecd)
+}
 
-	r = append(r, tmp...)
+func (ecd *EnvelopeCryptDesc) Size() int {
+	return Size(ecd)
+}
 
-	return r, nil
+func (ecd *EnvelopeCryptDesc) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, ecd)
 }
 
 func (ecd *EnvelopeCryptDesc) UnPack(data []byte) (int, error) {
-	var (
-		offset, of int
-		err        error
-	)
-
-	if len(data) < translayer.Uint32Size {
-		return 0, errors.New("unpack mode error")
-	}
-	ecd.Mode = int(binary.BigEndian.Uint32(data[offset:]))
-	offset += translayer.Uint32Size
-
-	ecd.Pubkeys, of, err = UnPackShortBytesArray(data[offset:])
-	if err != nil {
-		return 0, err
-	}
-
-	offset += of
-
-	return offset, nil
-
+	return Unmarshal(data, ecd)
 }
 
 type Envelope struct {
@@ -497,94 +324,26 @@ func (e *Envelope) ForCrypt() ([]byte, error) {
 }
 
 func (e *Envelope) Pack() ([]byte, error) {
+	return Marshal(e)
+}
 
-	var r []byte
-
-	es := &e.EnvelopeSig
-
-	b, err := es.Pack()
-	if err != nil {
-		return nil, err
-	}
-
-	r = append(r, b...)
-
-	eh := &e.EnvelopeRoute
-
-	b, err = eh.Pack()
-	if err != nil {
-		return nil, err
-	}
-
-	r = append(r, b...)
-
-	ecd := &e.EnvelopeCryptDesc
-	b, err = ecd.Pack()
-	if err != nil {
-		return nil, err
-	}
-
-	r = append(r, b...)
-
-	ec := &e.EnvelopeContent
-	b, err = ec.Pack()
-	if err != nil {
-		return nil, err
-	}
-
-	r = append(r, b...)
+func (e *Envelope) Size() int {
+	return Size(e)
+}
 
-	return r, nil
+func (e *Envelope) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, e)
 }
 
 func (e *Envelope) UnPack(data []byte) (int, error) {
-
-	var (
-		of, offset int
-		err        error
-	)
-
-	es := &e.EnvelopeSig
-
-	of, err = es.UnPack(data[offset:])
-	if err != nil {
-		return 0, err
-	}
-
-	offset += of
-
-	eh := &e.EnvelopeRoute
-
-	of, err = eh.UnPack(data[offset:])
-	if err != nil {
-		return 0, err
-	}
-	offset += of
-
-	ecd := &e.EnvelopeCryptDesc
-
-	of, err = ecd.UnPack(data[offset:])
-	if err != nil {
-		return 0, err
-	}
-	offset += of
-
-	ec := &e.EnvelopeContent
-	of, err = ec.UnPack(data[offset:])
-	if err != nil {
-		return 0, err
-	}
-	offset += of
-
-	return offset, nil
-
+	return Unmarshal(data, e)
 }
 
 type CryptEnvelope struct {
 	EnvelopeSig
 	EnvelopeRoute
 	EnvelopeCryptDesc
-	CipherTxt []byte
+	CipherTxt []byte `bm:"long"`
 }
 
 func (ce *CryptEnvelope) String() string {
@@ -601,75 +360,19 @@ func (ce *CryptEnvelope) String() string {
 }
 
 func (ce *CryptEnvelope) Pack() ([]byte, error) {
-	var r []byte
-
-	es := &ce.EnvelopeSig
-	b, err := es.Pack()
-	if err != nil {
-		return nil, err
-	}
-	r = append(r, b...)
-
-	eh := &ce.EnvelopeRoute
-
-	b, err = eh.Pack()
-	if err != nil {
-		return nil, err
-	}
-	r = append(r, b...)
-
-	ecd := &ce.EnvelopeCryptDesc
-
-	b, err = ecd.Pack()
-	if err != nil {
-		return nil, err
-	}
-	r = append(r, b...)
+	return Marshal(ce)
+}
 
-	b, err = PackLongBytes(ce.CipherTxt)
-	if err != nil {
-		return nil, err
-	}
-	r = append(r, b...)
+func (ce *CryptEnvelope) Size() int {
+	return Size(ce)
+}
 
-	return r, nil
+func (ce *CryptEnvelope) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, ce)
 }
 
 func (ce *CryptEnvelope) UnPack(data []byte) (int, error) {
-
-	var (
-		of, offset int
-		err        error
-	)
-
-	es := &ce.EnvelopeSig
-	of, err = es.UnPack(data[offset:])
-	if err != nil {
-		return 0, err
-	}
-	offset += of
-
-	eh := &ce.EnvelopeRoute
-	of, err = eh.UnPack(data[offset:])
-	if err != nil {
-		return 0, err
-	}
-	offset += of
-
-	ecd := &ce.EnvelopeCryptDesc
-	of, err = ecd.UnPack(data[offset:])
-	if err != nil {
-		return 0, err
-	}
-	offset += of
-
-	ce.CipherTxt, of, err = UnPackLongBytes(data[offset:])
-	if err != nil {
-		return 0, err
-	}
-	offset += of
-
-	return offset, nil
+	return Unmarshal(data, ce)
 }
 
 func EncodeEnvelope(e *Envelope, key []byte) *CryptEnvelope {
@@ -755,81 +458,17 @@ func (ce *ConfirmEnvelope) String() string {
 }
 
 func (ce *ConfirmEnvelope) Pack() ([]byte, error) {
-	var (
-		r, tmp []byte
-		err    error
-	)
-
-	tmp, err = PackShortBytes(ce.Sn)
-	if err != nil {
-		return nil, err
-	}
-	r = append(r, tmp...)
-
-	tmp, err = PackShortBytes(ce.NewSn)
-	if err != nil {
-		return nil, err
-	}
-	r = append(r, tmp...)
-
-	tmp, err = PackShortBytes(ce.EId[:])
-	if err != nil {
-		return nil, err
-	}
-	r = append(r, tmp...)
-
-	tmp, err = PackShortBytes(ce.CxtHashSig)
-	if err != nil {
-		return nil, err
-	}
-	r = append(r, tmp...)
-
-	tmp = translayer.UInt32ToBuf(uint32(ce.ErrId))
-	r = append(r, tmp...)
+	return Marshal(ce)
+}
 
-	return r, nil
+func (ce *ConfirmEnvelope) Size() int {
+	return Size(ce)
+}
 
+func (ce *ConfirmEnvelope) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, ce)
 }
 
 func (ce *ConfirmEnvelope) UnPack(data []byte) (int, error) {
-	var (
-		offset, of int
-		err        error
-		tmp        []byte
-	)
-
-	ce.Sn, of, err = UnPackShortBytes(data[offset:])
-	if err != nil {
-		return 0, err
-	}
-	offset += of
-
-	ce.NewSn, of, err = UnPackShortBytes(data[offset:])
-	if err != nil {
-		return 0, err
-	}
-	offset += of
-
-	tmp, of, err = UnPackShortBytes(data[offset:])
-	if err != nil {
-		return 0, err
-	}
-	offset += of
-
-	copy(ce.EId[:], tmp)
-
-	ce.CxtHashSig, of, err = UnPackShortBytes(data[offset:])
-	if err != nil {
-		return 0, err
-	}
-	offset += of
-
-	if len(data) < offset+translayer.Uint32Size {
-		return 0, errors.New("unpack errid error")
-	}
-	ce.ErrId = int(binary.BigEndian.Uint32(data[offset:]))
-	offset += translayer.Uint32Size
-
-	return offset, nil
-
+	return Unmarshal(data, ce)
 }
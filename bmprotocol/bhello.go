@@ -4,11 +4,18 @@ import (
 	"fmt"
 	"github.com/realbmail/go-bmail-protocol/translayer"
 	"github.com/btcsuite/btcutil/base58"
+	"io"
 	"time"
 )
 
-//client --helo--> server
-//server -->helo_resp{sn}-->client
+//client --helo{accept, versions}--> server
+//server -->helo_resp{sn, accept, versions}-->client
+//
+//accept lists the compress codecs each side reads, best first. A side
+//compresses a message only with a codec the other side listed. versions
+//lists the versions of the frame data each side reads, the client sends the
+//frames after in translayer.ChooseVersion of the server's list. A hello is
+//sent in BMAILVER1, the server answers in the version of the hello.
 
 func GetNowMsTime() int64 {
 	return time.Now().UnixNano() / 1e6
@@ -16,6 +23,8 @@ func GetNowMsTime() int64 {
 
 type BMHello struct {
 	translayer.BMTransLayer
+	accept   []uint16
+	versions []uint16
 }
 
 func NewBMHello() *BMHello {
@@ -26,22 +35,116 @@ func NewBMHello() *BMHello {
 	return bmh
 }
 
+func (bmh *BMHello) SetAccept(accept []uint16) {
+	bmh.accept = accept
+}
+
+func (bmh *BMHello) GetAccept() []uint16 {
+	return bmh.accept
+}
+
+func (bmh *BMHello) SetVersions(versions []uint16) {
+	bmh.versions = versions
+}
+
+//GetVersions is empty for a peer before BMAILVER2, it reads BMAILVER1 only
+func (bmh *BMHello) GetVersions() []uint16 {
+	return bmh.versions
+}
+
 func (bmh *BMHello) Pack() ([]byte, error) {
-	return bmh.BMTransLayer.Pack()
+	if isV2(&bmh.BMTransLayer) {
+		return packMsg(&(bmh.BMTransLayer), bmh)
+	}
+	if len(bmh.accept) == 0 && len(bmh.versions) == 0 {
+		return bmh.BMTransLayer.Pack()
+	}
+
+	r := NewHeadBuf()
+
+	tmp, err := packCompress(bmh.accept)
+	if err != nil {
+		return nil, err
+	}
+	r = append(r, tmp...)
+
+	//versions are ids of a byte too
+	if len(bmh.versions) > 0 {
+		tmp, err = packCompress(bmh.versions)
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, tmp...)
+	}
+
+	return AddPackHead(&(bmh.BMTransLayer), r)
+}
+
+func (bmh *BMHello) Size() int {
+	if isV2(&bmh.BMTransLayer) {
+		return msgSize(&(bmh.BMTransLayer), bmh)
+	}
+	if len(bmh.accept) == 0 && len(bmh.versions) == 0 {
+		return translayer.BMHeadSize()
+	}
+	size := translayer.BMHeadSize() + translayer.Uint16Size + len(bmh.accept)
+	if len(bmh.versions) > 0 {
+		size += translayer.Uint16Size + len(bmh.versions)
+	}
+	return size
+}
+
+func (bmh *BMHello) WriteTo(w io.Writer) (int64, error) {
+	return writePacked(w, bmh)
 }
 
 func (bmh *BMHello) UnPack(data []byte) (int, error) {
-	//nothing todo
-	return 0, nil
+	if isV2(&bmh.BMTransLayer) {
+		return unpackMsg(&(bmh.BMTransLayer), data, bmh)
+	}
+	//a hello without data accepts no codec
+	if len(data) == 0 {
+		return 0, nil
+	}
+
+	var (
+		of  int
+		err error
+	)
+
+	bmh.accept, of, err = unPackCompress(data)
+	if err != nil {
+		return 0, err
+	}
+
+	//clients before BMAILVER2 send accept only
+	if of == len(data) {
+		return of, nil
+	}
+
+	var offset int
+	bmh.versions, offset, err = unPackCompress(data[of:])
+	if err != nil {
+		return 0, err
+	}
+
+	return of + offset, nil
 }
 
 func (bmh *BMHello) String() string {
-	return bmh.BMTransLayer.String()
+	s := bmh.BMTransLayer.String()
+
+	s += fmt.Sprintf("accept: %v", bmh.accept)
+	s += fmt.Sprintf("versions: %v", bmh.versions)
+
+	return s
 }
 
 type BMHelloACK struct {
 	translayer.BMTransLayer
-	sn []byte
+	sn       []byte
+	accept   []uint16
+	versions []uint16
 }
 
 func NewBMHelloACK(sn []byte) *BMHelloACK {
@@ -60,7 +163,26 @@ func (bmha *BMHelloACK) GetSn() []byte {
 	return bmha.sn
 }
 
+func (bmha *BMHelloACK) SetAccept(accept []uint16) {
+	bmha.accept = accept
+}
+
+func (bmha *BMHelloACK) GetAccept() []uint16 {
+	return bmha.accept
+}
+
+func (bmha *BMHelloACK) SetVersions(versions []uint16) {
+	bmha.versions = versions
+}
+
+func (bmha *BMHelloACK) GetVersions() []uint16 {
+	return bmha.versions
+}
+
 func (bmha *BMHelloACK) Pack() ([]byte, error) {
+	if isV2(&bmha.BMTransLayer) {
+		return packMsg(&(bmha.BMTransLayer), bmha)
+	}
 
 	var (
 		tmp []byte
@@ -76,18 +198,57 @@ func (bmha *BMHelloACK) Pack() ([]byte, error) {
 
 	r = append(r, tmp...)
 
+	if len(bmha.accept) > 0 || len(bmha.versions) > 0 {
+		tmp, err = packCompress(bmha.accept)
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, tmp...)
+	}
+
+	if len(bmha.versions) > 0 {
+		tmp, err = packCompress(bmha.versions)
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, tmp...)
+	}
+
 	return AddPackHead(&(bmha.BMTransLayer), r)
 }
 
+func (bmha *BMHelloACK) Size() int {
+	if isV2(&bmha.BMTransLayer) {
+		return msgSize(&(bmha.BMTransLayer), bmha)
+	}
+	size := translayer.BMHeadSize() + translayer.Uint16Size + len(bmha.sn)
+	if len(bmha.accept) > 0 || len(bmha.versions) > 0 {
+		size += translayer.Uint16Size + len(bmha.accept)
+	}
+	if len(bmha.versions) > 0 {
+		size += translayer.Uint16Size + len(bmha.versions)
+	}
+	return size
+}
+
+func (bmha *BMHelloACK) WriteTo(w io.Writer) (int64, error) {
+	return writePacked(w, bmha)
+}
+
 func (bmha *BMHelloACK) String() string {
 	s := bmha.BMTransLayer.String()
 
 	s += fmt.Sprintf("sn: %s", base58.Encode(bmha.sn))
+	s += fmt.Sprintf("accept: %v", bmha.accept)
+	s += fmt.Sprintf("versions: %v", bmha.versions)
 
 	return s
 }
 
 func (bmha *BMHelloACK) UnPack(data []byte) (int, error) {
+	if isV2(&bmha.BMTransLayer) {
+		return unpackMsg(&(bmha.BMTransLayer), data, bmha)
+	}
 
 	var (
 		of  int
@@ -100,5 +261,27 @@ func (bmha *BMHelloACK) UnPack(data []byte) (int, error) {
 		return 0, err
 	}
 
-	return of, nil
+	//servers before compress send the sn only
+	if of == len(data) {
+		return of, nil
+	}
+
+	var offset int
+	bmha.accept, offset, err = unPackCompress(data[of:])
+	if err != nil {
+		return 0, err
+	}
+	of += offset
+
+	//servers before BMAILVER2 send no versions
+	if of == len(data) {
+		return of, nil
+	}
+
+	bmha.versions, offset, err = unPackCompress(data[of:])
+	if err != nil {
+		return 0, err
+	}
+
+	return of + offset, nil
 }
@@ -181,6 +181,9 @@ func UnPackShortStringArray(data []byte) ([]string, int, error) {
 	if cnt == 0 {
 		return rs, offset, nil
 	}
+	if uint64(cnt)*uint64(translayer.Uint16Size) > uint64(len(data)-offset) {
+		return nil, 0, errors.New("Unpack Short String Array Failed")
+	}
 
 	for i := 0; i < int(cnt); i++ {
 		s, of1, e := UnPackShortString(data[offset:])
@@ -210,6 +213,9 @@ func UnPackShortBytesArray(data []byte) ([][]byte, int, error) {
 	if cnt == 0 {
 		return rs, offset, nil
 	}
+	if uint64(cnt)*uint64(translayer.Uint16Size) > uint64(len(data)-offset) {
+		return nil, 0, errors.New("Unpack Short bytes Array Failed")
+	}
 
 	for i := 0; i < int(cnt); i++ {
 		s, of1, e := UnPackShortBytes(data[offset:])
@@ -262,6 +268,9 @@ func UnPackLongStringArray(data []byte) ([]string, int, error) {
 	if cnt == 0 {
 		return rs, offset, nil
 	}
+	if uint64(cnt)*uint64(translayer.Uint32Size) > uint64(len(data)-offset) {
+		return nil, 0, errors.New("Unpack Long String Array Failed")
+	}
 
 	for i := 0; i < int(cnt); i++ {
 		s, of1, e := UnPackLongString(data[offset:])
@@ -290,12 +299,70 @@ func AddPackHead(bmtl *translayer.BMTransLayer, appendData []byte) ([]byte, erro
 
 	bmtl.SetDataLen(uint32(datalen))
 
-	r, err := bmtl.Pack()
+	if err := bmtl.PackTo(appendData); err != nil {
+		return nil, err
+	}
+
+	return appendData, nil
+}
+
+//CompressPack compress the data of a packed message with codec compress,
+//the message is returned as it is when compressing gains nothing
+func CompressPack(data []byte, compress uint16) ([]byte, error) {
+	bmtl := &translayer.BMTransLayer{}
+	if _, err := bmtl.UnPack(data); err != nil {
+		return nil, err
+	}
+	if bmtl.GetCompress() != translayer.CompressNone {
+		return data, nil
+	}
+
+	cData, ok := translayer.Compress(compress, data[translayer.BMHeadSize():])
+	if !ok {
+		return data, nil
+	}
+
+	bmtl.SetCompress(compress)
+	r := NewHeadBuf()
+	r = append(r, cData...)
+
+	return AddPackHead(bmtl, r)
+}
+
+//UnCompressData decompress data read after the head bmtl, bmtl is changed
+//to describe the plain data
+func UnCompressData(bmtl *translayer.BMTransLayer, data []byte) ([]byte, error) {
+	compress := bmtl.GetCompress()
+	if compress == translayer.CompressNone {
+		return data, nil
+	}
+
+	r, err := translayer.Decompress(compress, data)
 	if err != nil {
 		return nil, err
 	}
+	bmtl.SetCompress(translayer.CompressNone)
+	bmtl.SetDataLen(uint32(len(r)))
+
+	return r, nil
+}
 
-	copy(appendData[:translayer.BMHeadSize()], r)
+func packCompress(compress []uint16) ([]byte, error) {
+	ids := make([]byte, len(compress))
+	for i, c := range compress {
+		ids[i] = byte(c)
+	}
+	return PackShortBytes(ids)
+}
 
-	return appendData, nil
+func unPackCompress(data []byte) ([]uint16, int, error) {
+	ids, of, err := UnPackShortBytes(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	var compress []uint16
+	for _, id := range ids {
+		compress = append(compress, uint16(id))
+	}
+	return compress, of, nil
 }
@@ -2,6 +2,7 @@ package bmprotocol
 
 import (
 	"github.com/realbmail/go-bmail-protocol/translayer"
+	"io"
 )
 
 //client -> server
@@ -27,24 +28,19 @@ func NewSendCryptEnvelope() *SendCryptEnvelope {
 }
 
 func (se *SendCryptEnvelope) Pack() ([]byte, error) {
+	return packMsg(&(se.BMTransLayer), se)
+}
 
-	r := NewHeadBuf()
-
-	tmp, err := se.CryptEnvelope.Pack()
-	if err != nil {
-		return nil, err
-	}
-
-	r = append(r, tmp...)
+func (se *SendCryptEnvelope) Size() int {
+	return msgSize(&(se.BMTransLayer), se)
+}
 
-	return AddPackHead(&(se.BMTransLayer), r)
+func (se *SendCryptEnvelope) WriteTo(w io.Writer) (int64, error) {
+	return writeMsg(w, &(se.BMTransLayer), se)
 }
 
 func (se *SendCryptEnvelope) UnPack(data []byte) (int, error) {
-
-	ce := &se.CryptEnvelope
-
-	return ce.UnPack(data)
+	return unpackMsg(&(se.BMTransLayer), data, se)
 }
 
 /*
@@ -83,25 +79,19 @@ func NewRespSendCryptEnvelope() *RespSendCryptEnvelope {
 }
 
 func (rse *RespSendCryptEnvelope) Pack() ([]byte, error) {
+	return packMsg(&(rse.BMTransLayer), rse)
+}
 
-	r := NewHeadBuf()
-
-	ce := &rse.ConfirmEnvelope
-
-	tmp, err := ce.Pack()
-	if err != nil {
-		return nil, err
-	}
-	r = append(r, tmp...)
+func (rse *RespSendCryptEnvelope) Size() int {
+	return msgSize(&(rse.BMTransLayer), rse)
+}
 
-	return AddPackHead(&(rse.BMTransLayer), r)
+func (rse *RespSendCryptEnvelope) WriteTo(w io.Writer) (int64, error) {
+	return writeMsg(w, &(rse.BMTransLayer), rse)
 }
 
 func (rse *RespSendCryptEnvelope) UnPack(data []byte) (int, error) {
-
-	ce := &rse.ConfirmEnvelope
-
-	return ce.UnPack(data)
+	return unpackMsg(&(rse.BMTransLayer), data, rse)
 }
 
 type SendEnvelope struct {
@@ -126,24 +116,19 @@ func NewSendEnvelope() *SendEnvelope {
 }
 
 func (se *SendEnvelope) Pack() ([]byte, error) {
+	return packMsg(&(se.BMTransLayer), se)
+}
 
-	r := NewHeadBuf()
-
-	tmp, err := se.Envelope.Pack()
-	if err != nil {
-		return nil, err
-	}
-
-	r = append(r, tmp...)
+func (se *SendEnvelope) Size() int {
+	return msgSize(&(se.BMTransLayer), se)
+}
 
-	return AddPackHead(&(se.BMTransLayer), r)
+func (se *SendEnvelope) WriteTo(w io.Writer) (int64, error) {
+	return writeMsg(w, &(se.BMTransLayer), se)
 }
 
 func (se *SendEnvelope) UnPack(data []byte) (int, error) {
-
-	ce := &se.Envelope
-
-	return ce.UnPack(data)
+	return unpackMsg(&(se.BMTransLayer), data, se)
 }
 
 //server -> client
@@ -170,23 +155,17 @@ func NewRespSendEnvelope() *RespSendEnvelope {
 }
 
 func (rse *RespSendEnvelope) Pack() ([]byte, error) {
+	return packMsg(&(rse.BMTransLayer), rse)
+}
 
-	r := NewHeadBuf()
-
-	ce := &rse.ConfirmEnvelope
-
-	tmp, err := ce.Pack()
-	if err != nil {
-		return nil, err
-	}
-	r = append(r, tmp...)
+func (rse *RespSendEnvelope) Size() int {
+	return msgSize(&(rse.BMTransLayer), rse)
+}
 
-	return AddPackHead(&(rse.BMTransLayer), r)
+func (rse *RespSendEnvelope) WriteTo(w io.Writer) (int64, error) {
+	return writeMsg(w, &(rse.BMTransLayer), rse)
 }
 
 func (rse *RespSendEnvelope) UnPack(data []byte) (int, error) {
-	ce := &rse.ConfirmEnvelope
-
-	return ce.UnPack(data)
-
+	return unpackMsg(&(rse.BMTransLayer), data, rse)
 }
@@ -1,11 +1,10 @@
 package bmprotocol
 
 import (
-	"encoding/binary"
 	"fmt"
 	"github.com/realbmail/go-bmail-protocol/translayer"
 	"github.com/btcsuite/btcutil/base58"
-	"github.com/pkg/errors"
+	"io"
 )
 
 type BPOPStat struct {
@@ -26,6 +25,14 @@ func (bps *BPOPStat) Pack() ([]byte, error) {
 	return bps.BMTransLayer.Pack()
 }
 
+func (bps *BPOPStat) Size() int {
+	return translayer.BMHeadSize()
+}
+
+func (bps *BPOPStat) WriteTo(w io.Writer) (int64, error) {
+	return writeMsg(w, &(bps.BMTransLayer), bps)
+}
+
 func (bps *BPOPStat) UnPack(data []byte) (int, error) {
 	return 0, nil
 }
@@ -59,61 +66,19 @@ func NewBPOPStatResp() *BPOPStatResp {
 }
 
 func (br *BPOPStatResp) Pack() ([]byte, error) {
-	var (
-		r, tmp []byte
-	)
-	tmp = translayer.UInt32ToBuf(uint32(br.Total))
-	r = append(r, tmp...)
-
-	tmp = translayer.UInt32ToBuf(uint32(br.Received))
-	r = append(r, tmp...)
-
-	tmp = translayer.UInt64ToBuf(uint64((br.TotalStoredBytes)))
-	r = append(r, tmp...)
-
-	tmp = translayer.UInt64ToBuf(uint64(br.TotalSpaceBytes))
-	r = append(r, tmp...)
+	return packMsg(&(br.BMTransLayer), br)
+}
 
-	//br.BMTransLayer.SetData(r)
+func (br *BPOPStatResp) Size() int {
+	return msgSize(&(br.BMTransLayer), br)
+}
 
-	return br.BMTransLayer.Pack()
+func (br *BPOPStatResp) WriteTo(w io.Writer) (int64, error) {
+	return writeMsg(w, &(br.BMTransLayer), br)
 }
 
 func (br *BPOPStatResp) UnPack(data []byte) (int, error) {
-	var (
-		offset int
-	)
-
-	if len(data) < offset+translayer.Uint32Size {
-		return 0, errors.New("unpack total error")
-	}
-
-	br.Total = int(binary.BigEndian.Uint32(data[offset:]))
-	offset += translayer.Uint32Size
-
-	if len(data) < offset+translayer.Uint32Size {
-		return 0, errors.New("unpack Received error")
-	}
-
-	br.Received = int(binary.BigEndian.Uint32(data[offset:]))
-	offset += translayer.Uint32Size
-
-	if len(data) < offset+translayer.Uint64Size {
-		return 0, errors.New("unpack TotalStoredBytes error")
-	}
-
-	br.TotalStoredBytes = int64(binary.BigEndian.Uint64(data[offset:]))
-	offset += translayer.Uint64Size
-
-	if len(data) < offset+translayer.Uint64Size {
-		return 0, errors.New("unpack TotalSpaceBytes error")
-	}
-	br.TotalSpaceBytes = int64(binary.BigEndian.Uint64(data[offset:]))
-
-	offset += translayer.Uint64Size
-
-	return offset, nil
-
+	return unpackMsg(&(br.BMTransLayer), data, br)
 }
 
 type BPOPList struct {
@@ -141,40 +106,19 @@ func (bl *BPOPList) String() string {
 }
 
 func (bl *BPOPList) Pack() ([]byte, error) {
-	var (
-		r, tmp []byte
-	)
-
-	tmp = translayer.UInt32ToBuf(uint32(bl.BeginID))
-	r = append(r, tmp...)
-
-	tmp = translayer.UInt32ToBuf(uint32(bl.ListCount))
-	r = append(r, tmp...)
+	return packMsg(&(bl.BMTransLayer), bl)
+}
 
-	//bl.BMTransLayer.SetData(r)
+func (bl *BPOPList) Size() int {
+	return msgSize(&(bl.BMTransLayer), bl)
+}
 
-	return bl.BMTransLayer.Pack()
+func (bl *BPOPList) WriteTo(w io.Writer) (int64, error) {
+	return writeMsg(w, &(bl.BMTransLayer), bl)
 }
 
 func (bl *BPOPList) UnPack(data []byte) (int, error) {
-	var (
-		offset int
-	)
-
-	if len(data) < translayer.Uint32Size {
-		return 0, errors.New("unpack BeginID error")
-	}
-
-	bl.BeginID = int(binary.BigEndian.Uint32(data[offset:]))
-	offset += translayer.Uint32Size
-
-	if len(data) < offset+translayer.Uint32Size {
-		return 0, errors.New("unpack ListCount error")
-	}
-	bl.ListCount = int(binary.BigEndian.Uint32(data[offset:]))
-	offset += translayer.Uint32Size
-
-	return offset, nil
+	return unpackMsg(&(bl.BMTransLayer), data, bl)
 }
 
 type ListNode struct {
@@ -190,39 +134,19 @@ func (ln *ListNode) String() string {
 }
 
 func (ln *ListNode) Pack() ([]byte, error) {
-	var (
-		r, tmp []byte
-	)
-
-	tmp = translayer.UInt32ToBuf(uint32(ln.ID))
-	r = append(r, tmp...)
-
-	tmp = translayer.UInt32ToBuf(uint32(ln.SizeOfBytes))
+	return Marshal(ln)
+}
 
-	r = append(r, tmp...)
+func (ln *ListNode) Size() int {
+	return Size(ln)
+}
 
-	return r, nil
+func (ln *ListNode) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, ln)
 }
 
 func (ln *ListNode) UnPack(data []byte) (int, error) {
-	var (
-		offset int
-	)
-	if len(data) < translayer.Uint32Size {
-		return 0, errors.New("unpack ID error")
-	}
-
-	ln.ID = int(binary.BigEndian.Uint32(data))
-	offset += translayer.Uint32Size
-
-	if len(data) < offset+translayer.Uint32Size {
-		return 0, errors.New("unpack sizeofbytes error")
-	}
-
-	ln.SizeOfBytes = int(binary.BigEndian.Uint32(data[offset:]))
-	offset += translayer.Uint32Size
-
-	return offset, nil
+	return Unmarshal(data, ln)
 }
 
 type BPOPListResp struct {
@@ -254,72 +178,19 @@ func (bl *BPOPListResp) String() string {
 }
 
 func (bl *BPOPListResp) Pack() ([]byte, error) {
-	var (
-		r, tmp []byte
-		err    error
-	)
-
-	tmp = translayer.UInt32ToBuf(uint32(bl.BeginID))
-	r = append(r, tmp...)
-
-	tmp = translayer.UInt32ToBuf(uint32(bl.ListCount))
-	r = append(r, tmp...)
-
-	tmp = translayer.UInt32ToBuf(uint32(len(bl.Nodes)))
-	r = append(r, tmp...)
-
-	for i := 0; i < len(bl.Nodes); i++ {
-		n := bl.Nodes[i]
-
-		tmp, err = n.Pack()
-		if err != nil {
-			return nil, err
-		}
-		r = append(r, tmp...)
-
-	}
-
-	//bl.BMTransLayer.SetData(r)
+	return packMsg(&(bl.BMTransLayer), bl)
+}
 
-	return bl.BMTransLayer.Pack()
+func (bl *BPOPListResp) Size() int {
+	return msgSize(&(bl.BMTransLayer), bl)
+}
 
+func (bl *BPOPListResp) WriteTo(w io.Writer) (int64, error) {
+	return writeMsg(w, &(bl.BMTransLayer), bl)
 }
 
 func (bl *BPOPListResp) UnPack(data []byte) (int, error) {
-	var (
-		offset, of int
-		err        error
-	)
-	if len(data) < offset+translayer.Uint32Size {
-		return 0, errors.New("unpack BeginID error")
-	}
-
-	bl.BeginID = int(binary.BigEndian.Uint32(data[offset:]))
-	offset += translayer.Uint32Size
-
-	if len(data) < offset+translayer.Uint32Size {
-		return 0, errors.New("unpack ListCount error")
-	}
-	bl.ListCount = int(binary.BigEndian.Uint32(data[offset:]))
-	offset += translayer.Uint32Size
-
-	if len(data) < offset+translayer.Uint32Size {
-		return 0, errors.New("unpack Node count error")
-	}
-	l := int(binary.BigEndian.Uint32(data[offset:]))
-	offset += translayer.Uint32Size
-
-	for i := 0; i < l; i++ {
-		n := &ListNode{}
-		of, err = n.UnPack(data[offset:])
-		if err != nil {
-			return 0, err
-		}
-		bl.Nodes = append(bl.Nodes, n)
-		offset += of
-	}
-
-	return offset, nil
+	return unpackMsg(&(bl.BMTransLayer), data, bl)
 }
 
 type BPOPRetr struct {
@@ -347,38 +218,19 @@ func (br *BPOPRetr) String() string {
 }
 
 func (br *BPOPRetr) Pack() ([]byte, error) {
-	var (
-		r, tmp []byte
-	)
-	tmp = translayer.UInt32ToBuf(uint32(br.BeginID))
-	r = append(r, tmp...)
-
-	tmp = translayer.UInt32ToBuf(uint32(br.RetrCount))
-	r = append(r, tmp...)
+	return packMsg(&(br.BMTransLayer), br)
+}
 
-	//br.BMTransLayer.SetData(r)
+func (br *BPOPRetr) Size() int {
+	return msgSize(&(br.BMTransLayer), br)
+}
 
-	return br.BMTransLayer.Pack()
+func (br *BPOPRetr) WriteTo(w io.Writer) (int64, error) {
+	return writeMsg(w, &(br.BMTransLayer), br)
 }
 
 func (br *BPOPRetr) UnPack(data []byte) (int, error) {
-	var (
-		offset int
-	)
-	if len(data) < offset+translayer.Uint32Size {
-		return 0, errors.New("unpack BeginID error")
-	}
-
-	br.BeginID = int(binary.BigEndian.Uint32(data[offset:]))
-	offset += translayer.Uint32Size
-
-	if len(data) < offset+translayer.Uint32Size {
-		return 0, errors.New("unpack ListCount error")
-	}
-	br.RetrCount = int(binary.BigEndian.Uint32(data[offset:]))
-	offset += translayer.Uint32Size
-
-	return offset, nil
+	return unpackMsg(&(br.BMTransLayer), data, br)
 }
 
 type BPOPRetrResp struct {
@@ -415,80 +267,19 @@ func (br *BPOPRetrResp) String() string {
 }
 
 func (br *BPOPRetrResp) Pack() ([]byte, error) {
-	var (
-		r, tmp []byte
-		err    error
-	)
-	tmp = translayer.UInt32ToBuf(uint32(len(br.Mails)))
-
-	r = append(r, tmp...)
-
-	for i := 0; i < len(br.Mails); i++ {
-		ce := &br.Mails[i]
-
-		tmp, err = ce.Pack()
-		if err != nil {
-			return nil, err
-		}
-		r = append(r, tmp...)
-	}
-	tmp = translayer.UInt32ToBuf(uint32(br.BeginID))
-	r = append(r, tmp...)
-
-	tmp = translayer.UInt32ToBuf(uint32(br.RetrCount))
-	r = append(r, tmp...)
-
-	tmp = translayer.UInt32ToBuf(uint32(br.TotalCount))
-	r = append(r, tmp...)
-
-	//br.BMTransLayer.SetData(r)
+	return packMsg(&(br.BMTransLayer), br)
+}
 
-	return br.BMTransLayer.Pack()
+func (br *BPOPRetrResp) Size() int {
+	return msgSize(&(br.BMTransLayer), br)
+}
 
+func (br *BPOPRetrResp) WriteTo(w io.Writer) (int64, error) {
+	return writeMsg(w, &(br.BMTransLayer), br)
 }
 
 func (br *BPOPRetrResp) UnPack(data []byte) (int, error) {
-	var (
-		offset, of int
-		err        error
-	)
-
-	if offset+translayer.Uint32Size > len(data) {
-		return 0, errors.New("unpack mails error")
-	}
-	mailcnt := int(binary.BigEndian.Uint32(data[offset:]))
-	offset += translayer.Uint32Size
-
-	for i := 0; i < mailcnt; i++ {
-		ce := &CryptEnvelope{}
-		of, err = ce.UnPack(data[offset:])
-		if err != nil {
-			return 0, nil
-		}
-		offset += of
-		br.Mails = append(br.Mails, *ce)
-	}
-
-	if offset+translayer.Uint32Size > len(data) {
-		return 0, errors.New("unpack begin id error")
-	}
-	br.BeginID = int(binary.BigEndian.Uint32(data[offset:]))
-	offset += translayer.Uint32Size
-
-	if offset+translayer.Uint32Size > len(data) {
-		return 0, errors.New("unpack retr count error")
-	}
-	br.RetrCount = int(binary.BigEndian.Uint32(data[offset:]))
-	offset += translayer.Uint32Size
-
-	if offset+translayer.Uint32Size > len(data) {
-		return 0, errors.New("unpack total count error")
-	}
-	br.TotalCount = int(binary.BigEndian.Uint32(data[offset:]))
-	offset += translayer.Uint32Size
-
-	return offset, nil
-
+	return unpackMsg(&(br.BMTransLayer), data, br)
 }
 
 type DelSection struct {
@@ -504,40 +295,19 @@ func (ds *DelSection) String() string {
 }
 
 func (ds *DelSection) Pack() ([]byte, error) {
-	var (
-		r, tmp []byte
-	)
-
-	tmp = translayer.UInt32ToBuf(uint32(ds.Begin))
-	r = append(r, tmp...)
-
-	tmp = translayer.UInt32ToBuf(uint32(ds.End))
-	r = append(r, tmp...)
+	return Marshal(ds)
+}
 
-	return r, nil
+func (ds *DelSection) Size() int {
+	return Size(ds)
+}
 
+func (ds *DelSection) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, ds)
 }
 
 func (ds *DelSection) UnPack(data []byte) (int, error) {
-	var (
-		offset int
-	)
-
-	if len(data) < offset+translayer.Uint32Size {
-		return 0, errors.New("unpack begin error")
-	}
-	ds.Begin = int(binary.BigEndian.Uint32(data[offset:]))
-	offset += translayer.Uint32Size
-
-	if len(data) < offset+translayer.Uint32Size {
-		return 0, errors.New("unpack begin error")
-	}
-
-	ds.End = int(binary.BigEndian.Uint32(data[offset:]))
-	offset += translayer.Uint32Size
-
-	return offset, nil
-
+	return Unmarshal(data, ds)
 }
 
 type DelSectionResult struct {
@@ -553,46 +323,19 @@ func (dsr *DelSectionResult) String() string {
 }
 
 func (dsr *DelSectionResult) Pack() ([]byte, error) {
-	var (
-		r, tmp []byte
-		err    error
-	)
-
-	tmp, err = dsr.DelSection.Pack()
-	if err != nil {
-		return nil, err
-	}
-	r = append(r, tmp...)
+	return Marshal(dsr)
+}
 
-	tmp = translayer.UInt32ToBuf(uint32(dsr.ErroCode))
-	r = append(r, tmp...)
+func (dsr *DelSectionResult) Size() int {
+	return Size(dsr)
+}
 
-	return r, nil
+func (dsr *DelSectionResult) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, dsr)
 }
 
 func (dsr *DelSectionResult) UnPack(data []byte) (int, error) {
-	var (
-		offset, of int
-		err        error
-	)
-
-	ds := &dsr.DelSection
-
-	of, err = ds.UnPack(data[offset:])
-	if err != nil {
-		return 0, err
-	}
-	offset += of
-
-	if len(data) < offset+translayer.Uint32Size {
-		return 0, errors.New("unpack errcode error")
-	}
-
-	dsr.ErroCode = int(binary.BigEndian.Uint32(data[offset:]))
-
-	offset += translayer.Uint32Size
-
-	return offset, nil
+	return Unmarshal(data, dsr)
 }
 
 type BPOPDelete struct {
@@ -626,73 +369,19 @@ func (bd *BPOPDelete) String() string {
 }
 
 func (bd *BPOPDelete) Pack() ([]byte, error) {
-	var (
-		r, tmp []byte
-		err    error
-	)
-
-	tmp = translayer.UInt32ToBuf(uint32(len(bd.Section)))
-	r = append(r, tmp...)
-	for i := 0; i < len(bd.Section); i++ {
-		sec := &bd.Section[i]
-		tmp, err = sec.Pack()
-		if err != nil {
-			return nil, err
-		}
-		r = append(r, tmp...)
-	}
-
-	tmp, err = PackShortBytes(bd.Sn)
-	if err != nil {
-		return nil, err
-	}
-	r = append(r, tmp...)
-
-	tmp, err = PackShortBytes(bd.Sig)
-	if err != nil {
-		return nil, err
-	}
-	r = append(r, tmp...)
-
-	//bd.BMTransLayer.SetData(r)
+	return packMsg(&(bd.BMTransLayer), bd)
+}
 
-	return bd.BMTransLayer.Pack()
+func (bd *BPOPDelete) Size() int {
+	return msgSize(&(bd.BMTransLayer), bd)
+}
 
+func (bd *BPOPDelete) WriteTo(w io.Writer) (int64, error) {
+	return writeMsg(w, &(bd.BMTransLayer), bd)
 }
 
 func (bd *BPOPDelete) UnPack(data []byte) (int, error) {
-	var (
-		offset, of int
-		err        error
-	)
-	if len(data) < offset+translayer.Uint32Size {
-		return 0, errors.New("unpack section error")
-	}
-	cnt := int(binary.BigEndian.Uint32(data[offset:]))
-
-	offset += translayer.Uint32Size
-
-	for i := 0; i < cnt; i++ {
-		sec := &DelSection{}
-		of, err = sec.UnPack(data[offset:])
-		if err != nil {
-			return 0, err
-		}
-		offset += of
-		bd.Section = append(bd.Section, *sec)
-	}
-	bd.Sn, of, err = UnPackShortBytes(data[offset:])
-	if err != nil {
-		return 0, err
-	}
-	offset += of
-
-	bd.Sig, of, err = UnPackShortBytes(data[offset:])
-	if err != nil {
-		return 0, err
-	}
-	offset += of
-	return offset, nil
+	return unpackMsg(&(bd.BMTransLayer), data, bd)
 }
 
 type BPOPDeleteResp struct {
@@ -721,60 +410,17 @@ func (br *BPOPDeleteResp) String() string {
 }
 
 func (bd *BPOPDeleteResp) Pack() ([]byte, error) {
-	var (
-		r, tmp []byte
-		err    error
-	)
-
-	tmp = translayer.UInt32ToBuf(uint32(len(bd.Result)))
-	r = append(r, tmp...)
-	for i := 0; i < len(bd.Result); i++ {
-		result := &bd.Result[i]
-		tmp, err = result.Pack()
-		if err != nil {
-			return nil, err
-		}
-		r = append(r, tmp...)
-	}
-
-	tmp, err = PackShortBytes(bd.Sn)
-	if err != nil {
-		return nil, err
-	}
-	r = append(r, tmp...)
-
-	//bd.BMTransLayer.SetData(r)
+	return packMsg(&(bd.BMTransLayer), bd)
+}
 
-	return bd.BMTransLayer.Pack()
+func (bd *BPOPDeleteResp) Size() int {
+	return msgSize(&(bd.BMTransLayer), bd)
+}
 
+func (bd *BPOPDeleteResp) WriteTo(w io.Writer) (int64, error) {
+	return writeMsg(w, &(bd.BMTransLayer), bd)
 }
 
 func (bd *BPOPDeleteResp) UnPack(data []byte) (int, error) {
-	var (
-		offset, of int
-		err        error
-	)
-	if len(data) < offset+translayer.Uint32Size {
-		return 0, errors.New("unpack result section error")
-	}
-	cnt := int(binary.BigEndian.Uint32(data[offset:]))
-
-	offset += translayer.Uint32Size
-
-	for i := 0; i < cnt; i++ {
-		sec := &DelSectionResult{}
-		of, err = sec.UnPack(data[offset:])
-		if err != nil {
-			return 0, err
-		}
-		offset += of
-		bd.Result = append(bd.Result, *sec)
-	}
-	bd.Sn, of, err = UnPackShortBytes(data[offset:])
-	if err != nil {
-		return 0, err
-	}
-	offset += of
-
-	return offset, nil
+	return unpackMsg(&(bd.BMTransLayer), data, bd)
 }
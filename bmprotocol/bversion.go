@@ -0,0 +1,55 @@
+package bmprotocol
+
+import (
+	"github.com/realbmail/go-bmail-protocol/translayer"
+)
+
+//the version in the head of a frame selects the codec of its data,
+//BMAILVER1 is the bm layout of bcodec.go, BMAILVER2 is the CBOR of
+//translayer/cbor.go, see spec/bmailv2.cddl. SendAttachment and
+//RespRetrAttachment carry file bytes after the fields, they keep the
+//BMAILVER1 layout in every version.
+
+func isV2(bmtl *translayer.BMTransLayer) bool {
+	return bmtl != nil && bmtl.GetVersion() == translayer.BMAILVER2
+}
+
+//PackAs pack the struct v points to by the codec of version ver, for the
+//plain text of crypted messages, which has no head of its own
+func PackAs(ver uint16, v interface{}) ([]byte, error) {
+	if ver != translayer.BMAILVER2 {
+		return Marshal(v)
+	}
+	if err := checkPack(v); err != nil {
+		return nil, err
+	}
+	return translayer.CborMarshal(v)
+}
+
+//UnPackAs unpack data packed by PackAs, it gives the bytes read
+func UnPackAs(ver uint16, data []byte, v interface{}) (int, error) {
+	if ver != translayer.BMAILVER2 {
+		return Unmarshal(data, v)
+	}
+	l := translayer.CborLimits{MaxBytes: DefaultLimits.MaxBytes, MaxCount: DefaultLimits.MaxCount}
+	if err := translayer.CborUnmarshalLimits(data, v, l); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+func unpackMsg(bmtl *translayer.BMTransLayer, data []byte, v interface{}) (int, error) {
+	return UnPackAs(bmtl.GetVersion(), data, v)
+}
+
+//msgSize is the bytes of the frame of v and its head
+func msgSize(bmtl *translayer.BMTransLayer, v interface{}) int {
+	if !isV2(bmtl) {
+		return translayer.BMHeadSize() + Size(v)
+	}
+	data, err := translayer.CborMarshal(v)
+	if err != nil {
+		return 0
+	}
+	return translayer.BMHeadSize() + len(data)
+}
@@ -20,7 +20,8 @@ type CmdDownload struct {
 	Owner     bmail.Address `json:"owner"`
 	MailCnt   int           `json:"mail_cnt"`
 	Direction bool          `json:"direction"` //false -> after TimePivot, true -> before TimePivot
-	TimePivot int64         `json:"time_pivot"`
+	TimePivot int64         `json:"time_pivot"` //compared with MailMeta.Received, newest first
+	Folder    string        `json:"folder,omitempty"` //empty -> FolderInbox
 }
 
 func (cd *CmdDownload) Hash() []byte {
@@ -73,6 +74,7 @@ func (cs *CmdDelete) MsgType() uint16 {
 
 type CmdDownloadAck struct {
 	CryptEps []*bmp.BMailEnvelope
+	Meta     []*MailMeta `json:"meta,omitempty"` //same order as CryptEps
 }
 
 func (cda *CmdDownloadAck) MsgType() uint16 {
@@ -98,8 +100,9 @@ type State struct {
 }
 
 type CmdStateAck struct {
-	SendMail    State `json:"send_mail_space"`
-	ReceiptMail State `json:"receipt_mail"`
+	SendMail    State     `json:"send_mail_space"`
+	ReceiptMail State     `json:"receipt_mail"`
+	Folders     []*Folder `json:"folders,omitempty"`
 }
 
 func (csa *CmdStateAck) MsgType() uint16 {
@@ -123,6 +126,7 @@ const (
 	MailDeleteSuccess int = iota
 	MailNotFound
 	MailDeleteFailed
+	MailOpFailed
 )
 
 type CmdResult struct {
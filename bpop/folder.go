@@ -0,0 +1,156 @@
+package bpop
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/realbmail/go-bmail-account"
+	"github.com/realbmail/go-bmail-protocol/translayer"
+)
+
+//message flags, kept on server so every device sees the same state
+const (
+	FlagSeen uint32 = 1 << iota
+	FlagStarred
+	FlagArchived
+	FlagAnswered
+	FlagForwarded
+)
+
+//folders every mailbox has, other names are custom folders
+const (
+	FolderInbox string = "INBOX"
+	FolderSent  string = "Sent"
+	FolderTrash string = "Trash"
+)
+
+type MailMeta struct {
+	Eid      uuid.UUID `json:"eid"`
+	Flags    uint32    `json:"flags"`
+	Folder   string    `json:"folder"`
+	Received int64     `json:"received,omitempty"` //ms since 1970 the server took the mail
+}
+
+type Folder struct {
+	Name   string `json:"name"`
+	Total  int    `json:"total"`
+	Unread int    `json:"unread"`
+}
+
+//SetFlags is applied before ClearFlags
+type CmdSetFlags struct {
+	MailAddr   string        `json:"mail_addr"`
+	Owner      bmail.Address `json:"owner"`
+	Eids       []uuid.UUID   `json:"eid"`
+	SetFlags   uint32        `json:"set_flags"`
+	ClearFlags uint32        `json:"clear_flags"`
+}
+
+func (csf *CmdSetFlags) Hash() []byte {
+	data, _ := json.Marshal(*csf)
+
+	hash := sha256.Sum256(data)
+
+	return hash[:]
+}
+
+func (csf *CmdSetFlags) MsgType() uint16 {
+	return translayer.SET_FLAGS
+}
+
+type CmdSetFlagsAck struct {
+	Result []CmdResult `json:"result"`
+}
+
+func (csfa *CmdSetFlagsAck) MsgType() uint16 {
+	return translayer.SET_FLAGS_RESP
+}
+
+func (csfa *CmdSetFlagsAck) GetBytes() ([]byte, error) {
+	return json.Marshal(*csfa)
+}
+
+func (csfa *CmdSetFlagsAck) Hash() []byte {
+	data, _ := json.Marshal(*csfa)
+
+	hash := sha256.Sum256(data)
+
+	return hash[:]
+}
+
+//a folder not exists yet is created by the server
+type CmdMove struct {
+	MailAddr string        `json:"mail_addr"`
+	Owner    bmail.Address `json:"owner"`
+	Eids     []uuid.UUID   `json:"eid"`
+	Folder   string        `json:"folder"`
+}
+
+func (cm *CmdMove) Hash() []byte {
+	data, _ := json.Marshal(*cm)
+
+	hash := sha256.Sum256(data)
+
+	return hash[:]
+}
+
+func (cm *CmdMove) MsgType() uint16 {
+	return translayer.MOVE
+}
+
+type CmdMoveAck struct {
+	Result []CmdResult `json:"result"`
+}
+
+func (cma *CmdMoveAck) MsgType() uint16 {
+	return translayer.MOVE_RESP
+}
+
+func (cma *CmdMoveAck) GetBytes() ([]byte, error) {
+	return json.Marshal(*cma)
+}
+
+func (cma *CmdMoveAck) Hash() []byte {
+	data, _ := json.Marshal(*cma)
+
+	hash := sha256.Sum256(data)
+
+	return hash[:]
+}
+
+type CmdListFolders struct {
+	MailAddr string        `json:"mail_addr"`
+	Owner    bmail.Address `json:"owner"`
+}
+
+func (clf *CmdListFolders) Hash() []byte {
+	data, _ := json.Marshal(*clf)
+
+	hash := sha256.Sum256(data)
+
+	return hash[:]
+}
+
+func (clf *CmdListFolders) MsgType() uint16 {
+	return translayer.LIST_FOLDERS
+}
+
+type CmdListFoldersAck struct {
+	Folders []*Folder `json:"folders"`
+}
+
+func (clfa *CmdListFoldersAck) MsgType() uint16 {
+	return translayer.LIST_FOLDERS_RESP
+}
+
+func (clfa *CmdListFoldersAck) GetBytes() ([]byte, error) {
+	return json.Marshal(*clfa)
+}
+
+func (clfa *CmdListFoldersAck) Hash() []byte {
+	data, _ := json.Marshal(*clfa)
+
+	hash := sha256.Sum256(data)
+
+	return hash[:]
+}
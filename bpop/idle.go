@@ -0,0 +1,79 @@
+package bpop
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/realbmail/go-bmail-account"
+	"github.com/realbmail/go-bmail-protocol/bmp"
+	"github.com/realbmail/go-bmail-protocol/translayer"
+)
+
+//client --CmdIdle--> server
+//server --CmdIdleNotify{NotifyNewMail}--> client    when new mail arrives
+//server --CmdIdleNotify{NotifyKeepAlive}--> client  every KeepAlive seconds
+//client --IDLE_DONE(header only)--> server
+//server --CmdIdleNotify{NotifyDone}--> client, then close the connection
+//
+//every notify frame is a signed CommandAck, SN is the sn of the HELOACK of
+//the session so a notify can't be replayed in another one, Seq is 0 in the
+//first frame and grows by one per frame.
+
+const (
+	NotifyNewMail int = iota
+	NotifyKeepAlive
+	NotifyDone
+)
+
+const DefaultKeepAlive int = 30 //second
+
+type CmdIdle struct {
+	MailAddr  string        `json:"mail_addr"`
+	Owner     bmail.Address `json:"owner"`
+	Folder    string        `json:"folder,omitempty"` //empty -> FolderInbox
+	KeepAlive int           `json:"keep_alive"`       //second
+}
+
+func (ci *CmdIdle) Hash() []byte {
+	data, _ := json.Marshal(*ci)
+
+	hash := sha256.Sum256(data)
+
+	return hash[:]
+}
+
+func (ci *CmdIdle) MsgType() uint16 {
+	return translayer.IDLE
+}
+
+type MailSummary struct {
+	Eid           uuid.UUID     `json:"eid"`
+	FromName      string        `json:"fromName"`
+	FromAddr      bmail.Address `json:"fromAddr"`
+	DateSince1970 uint64        `json:"timeSince1970"`
+	Folder        string        `json:"folder"`
+	Size          int           `json:"size"`
+}
+
+type CmdIdleNotify struct {
+	SN    bmp.BMailSN    `json:"sn"`
+	Seq   uint64         `json:"seq"`
+	Kind  int            `json:"kind"`
+	Mails []*MailSummary `json:"mails,omitempty"`
+}
+
+func (cin *CmdIdleNotify) MsgType() uint16 {
+	return translayer.IDLE_NOTIFY
+}
+
+func (cin *CmdIdleNotify) GetBytes() ([]byte, error) {
+	return json.Marshal(*cin)
+}
+
+func (cin *CmdIdleNotify) Hash() []byte {
+	data, _ := json.Marshal(*cin)
+
+	hash := sha256.Sum256(data)
+
+	return hash[:]
+}
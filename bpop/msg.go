@@ -3,6 +3,7 @@ package bpop
 import (
 	"encoding/json"
 	"github.com/realbmail/go-bmail-protocol/bmp"
+	"github.com/realbmail/go-bmail-protocol/subkey"
 	"github.com/realbmail/go-bmail-protocol/translayer"
 )
 
@@ -15,6 +16,9 @@ type CommandSyn struct {
 	SN  bmp.BMailSN `json:"sn"`
 	Sig []byte      `json:"sig"`
 	Cmd Command     `json:"cmd"`
+	//codecs the client reads, the server compress the ack with one of them
+	Accept []uint16     `json:"accept,omitempty"`
+	Cert   *subkey.Cert `json:"cert,omitempty"` //of the sub-key Sig is made by
 }
 
 func (cs *CommandSyn) MsgType() uint16 {
@@ -38,6 +42,7 @@ type CommandContent interface {
 const (
 	EC_Success int = iota
 	EC_No_Mail
+	EC_Token_Expired
 )
 
 type CommandAck struct {
@@ -57,6 +62,10 @@ func (cs *CommandAck) GetBytes() ([]byte, error) {
 }
 
 func (cs *CommandAck) VerifyHeader(header *bmp.Header) bool {
-	return header.MsgTyp == translayer.RETR_RESP &&
+	typ := translayer.RETR_RESP
+	if cs.CmdCxt != nil {
+		typ = cs.CmdCxt.MsgType()
+	}
+	return header.MsgTyp == typ &&
 		header.MsgLen != 0
 }
@@ -0,0 +1,128 @@
+package bpop
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"github.com/realbmail/go-bmail-account"
+	"github.com/realbmail/go-bmail-protocol/bmp"
+	"github.com/realbmail/go-bmail-protocol/translayer"
+	"strings"
+)
+
+//search on routing metadata only, subject and body are encrypted and must
+//be searched by the client. zero value of a filter field means no filter.
+type CmdSearch struct {
+	MailAddr   string        `json:"mail_addr"`
+	Owner      bmail.Address `json:"owner"`
+	FromName   string        `json:"from_name,omitempty"` //case insensitive sub string
+	FromAddr   bmail.Address `json:"from_addr,omitempty"`
+	ToName     string        `json:"to_name,omitempty"` //case insensitive sub string
+	ToAddr     bmail.Address `json:"to_addr,omitempty"`
+	Since      int64         `json:"since,omitempty"`  //DateSince1970 >= Since
+	Before     int64         `json:"before,omitempty"` //DateSince1970 < Before
+	MinSize    int           `json:"min_size,omitempty"`
+	MaxSize    int           `json:"max_size,omitempty"`
+	FlagsSet   uint32        `json:"flags_set,omitempty"`   //all these flags set
+	FlagsUnset uint32        `json:"flags_unset,omitempty"` //all these flags clear
+	Folder     string        `json:"folder,omitempty"`
+	Offset     int           `json:"offset"` //skip Offset matched mails, newest first
+	MailCnt    int           `json:"mail_cnt"`
+}
+
+func (cs *CmdSearch) Hash() []byte {
+	data, _ := json.Marshal(*cs)
+
+	hash := sha256.Sum256(data)
+
+	return hash[:]
+}
+
+func (cs *CmdSearch) MsgType() uint16 {
+	return translayer.SEARCH
+}
+
+//Match report if a stored mail passes the filters, size is the stored size
+//of the envelope in bytes. meta can be nil for a server without flags.
+func (cs *CmdSearch) Match(env *bmp.BMailEnvelope, meta *MailMeta, size int) bool {
+	if cs.FromName != "" && !containsFold(env.FromName, cs.FromName) {
+		return false
+	}
+	if cs.FromAddr != "" && env.FromAddr != cs.FromAddr {
+		return false
+	}
+	if cs.ToName != "" || cs.ToAddr != "" {
+		found := false
+		for _, r := range env.RCPTs {
+			if cs.ToName != "" && !containsFold(r.ToName, cs.ToName) {
+				continue
+			}
+			if cs.ToAddr != "" && r.ToAddr != cs.ToAddr {
+				continue
+			}
+			found = true
+			break
+		}
+		if !found {
+			return false
+		}
+	}
+
+	date := int64(env.DateSince1970)
+	if cs.Since != 0 && date < cs.Since {
+		return false
+	}
+	if cs.Before != 0 && date >= cs.Before {
+		return false
+	}
+	if cs.MinSize != 0 && size < cs.MinSize {
+		return false
+	}
+	if cs.MaxSize != 0 && size > cs.MaxSize {
+		return false
+	}
+
+	var (
+		flags  uint32
+		folder = FolderInbox
+	)
+	if meta != nil {
+		flags = meta.Flags
+		if meta.Folder != "" {
+			folder = meta.Folder
+		}
+	}
+	if flags&cs.FlagsSet != cs.FlagsSet || flags&cs.FlagsUnset != 0 {
+		return false
+	}
+	if cs.Folder != "" && cs.Folder != folder {
+		return false
+	}
+
+	return true
+}
+
+func containsFold(s, sub string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(sub))
+}
+
+type CmdSearchAck struct {
+	CryptEps []*bmp.BMailEnvelope `json:"crypt_eps"`
+	Meta     []*MailMeta          `json:"meta,omitempty"` //same order as CryptEps
+	Total    int                  `json:"total"`          //matched mails, all pages
+}
+
+func (csa *CmdSearchAck) MsgType() uint16 {
+	return translayer.SEARCH_RESP
+}
+
+func (csa *CmdSearchAck) GetBytes() ([]byte, error) {
+	return json.Marshal(*csa)
+}
+
+func (csa *CmdSearchAck) Hash() []byte {
+	data, _ := json.Marshal(*csa)
+
+	hash := sha256.Sum256(data)
+
+	return hash[:]
+}
@@ -0,0 +1,75 @@
+package bpop
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/realbmail/go-bmail-account"
+	"github.com/realbmail/go-bmail-protocol/bmp"
+	"github.com/realbmail/go-bmail-protocol/translayer"
+)
+
+//client --CmdSync{change token}--> server
+//server --CmdSyncAck{changes after token, new token}--> client
+//
+//the change token is a server side counter, it grows with every change of
+//the mailbox (new mail, delete, flags). token 0 asks for the whole mailbox.
+//EC_Token_Expired means the server can't serve changes after the token any
+//more, the client must drop its local state and sync again from 0.
+
+const (
+	ChangeNew int = iota
+	ChangeDeleted
+	ChangeFlags
+	ChangeMoved
+)
+
+type CmdSync struct {
+	MailAddr    string        `json:"mail_addr"`
+	Owner       bmail.Address `json:"owner"`
+	ChangeToken uint64        `json:"change_token"`
+	MailCnt     int           `json:"mail_cnt"`
+}
+
+func (cs *CmdSync) Hash() []byte {
+	data, _ := json.Marshal(*cs)
+
+	hash := sha256.Sum256(data)
+
+	return hash[:]
+}
+
+func (cs *CmdSync) MsgType() uint16 {
+	return translayer.SYNC
+}
+
+type MailChange struct {
+	Token  uint64             `json:"token"`
+	Kind   int                `json:"kind"`
+	Eid    uuid.UUID          `json:"eid"`
+	Flags  uint32             `json:"flags"`  //valid when Kind is ChangeNew or ChangeFlags
+	Folder string             `json:"folder"` //valid when Kind is ChangeNew or ChangeMoved
+	Env    *bmp.BMailEnvelope `json:"env,omitempty"`
+}
+
+type CmdSyncAck struct {
+	ChangeToken uint64        `json:"change_token"` //token of the last change in Changes
+	More        bool          `json:"more"`         //true -> more changes after ChangeToken
+	Changes     []*MailChange `json:"changes"`
+}
+
+func (csa *CmdSyncAck) MsgType() uint16 {
+	return translayer.SYNC_RESP
+}
+
+func (csa *CmdSyncAck) GetBytes() ([]byte, error) {
+	return json.Marshal(*csa)
+}
+
+func (csa *CmdSyncAck) Hash() []byte {
+	data, _ := json.Marshal(*csa)
+
+	hash := sha256.Sum256(data)
+
+	return hash[:]
+}
@@ -0,0 +1,52 @@
+package bpop
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"github.com/realbmail/go-bmail-account"
+	"github.com/realbmail/go-bmail-protocol/bmp"
+	"github.com/realbmail/go-bmail-protocol/translayer"
+)
+
+//fetch all mails of a conversation, oldest first
+type CmdThread struct {
+	MailAddr  string        `json:"mail_addr"`
+	Owner     bmail.Address `json:"owner"`
+	SessionID string        `json:"session_id"`
+	MailCnt   int           `json:"mail_cnt"`
+	TimePivot int64         `json:"time_pivot"` //only mails after TimePivot, for paging
+}
+
+func (ct *CmdThread) Hash() []byte {
+	data, _ := json.Marshal(*ct)
+
+	hash := sha256.Sum256(data)
+
+	return hash[:]
+}
+
+func (ct *CmdThread) MsgType() uint16 {
+	return translayer.THREAD
+}
+
+type CmdThreadAck struct {
+	CryptEps []*bmp.BMailEnvelope `json:"crypt_eps"`
+	Meta     []*MailMeta          `json:"meta,omitempty"` //same order as CryptEps
+	More     bool                 `json:"more"`
+}
+
+func (cta *CmdThreadAck) MsgType() uint16 {
+	return translayer.THREAD_RESP
+}
+
+func (cta *CmdThreadAck) GetBytes() ([]byte, error) {
+	return json.Marshal(*cta)
+}
+
+func (cta *CmdThreadAck) Hash() []byte {
+	data, _ := json.Marshal(*cta)
+
+	hash := sha256.Sum256(data)
+
+	return hash[:]
+}
@@ -3,23 +3,15 @@ package bpopclient
 import (
 	"bytes"
 	"crypto/ed25519"
-	"crypto/rand"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/BASChain/go-account"
-	"github.com/realbmail/go-bas-mail-server/bmailcrypt"
 	"github.com/realbmail/go-bmail-account"
 	"github.com/realbmail/go-bmail-protocol/bmp"
 	"github.com/realbmail/go-bmail-protocol/bpop"
+	"github.com/realbmail/go-bmail-protocol/signer"
 	"github.com/realbmail/go-bmail-protocol/translayer"
-	"github.com/howeyc/gopass"
-	"github.com/kprc/nbsnetwork/tools"
-	"log"
 	"net"
-	"os"
-	"path"
-	"path/filepath"
 	"strconv"
 	"time"
 )
@@ -28,8 +20,7 @@ type BMClient2 struct {
 	sn      []byte
 	c       *net.TCPConn
 	timeout int //second
-	PK      ed25519.PublicKey
-	Priv    ed25519.PrivateKey
+	Signer  signer.Signer
 	Hash    []byte
 	SrvPk   ed25519.PublicKey
 }
@@ -38,136 +29,27 @@ func (c *BMClient2) GetSn() []byte {
 	return c.sn
 }
 
-const (
-	keyfile string = "ed25519key.testb"
-)
-
-func GetKeyfile() string {
-	fn, _ := filepath.Abs(os.Args[0])
-	name := filepath.Base(fn)
-
-	dir := string(fn[:len(fn)-len(name)])
-
-	return path.Join(dir, keyfile)
-}
-
-func keyISGenerated() bool {
-	if tools.FileExists(GetKeyfile()) {
-		return true
-	}
-
-	return false
-}
-
-func LoadKey(password string) (ed25519.PublicKey, ed25519.PrivateKey) {
-	data, err := tools.OpenAndReadAll(GetKeyfile())
-	if err != nil {
-		log.Fatal("Load From key file error")
-		return nil, nil
-	}
-
-	kj := &bmailcrypt.KeyJson{}
-
-	err = json.Unmarshal(data, kj)
-	if err != nil {
-		log.Fatal("Load From json error")
-		return nil, nil
-	}
-
-	pk := bmail.Address(kj.PubKey).ToPubKey()
-	var priv ed25519.PrivateKey
-	priv, err = account.DecryptSubPriKey(pk, kj.CipherKey, password)
-	if err != nil {
-		log.Fatal("Decrypt PrivKey failed")
-		return nil, nil
-	}
-
-	return pk, priv
-}
-
-func GenEd25519KeyAndSave(password string) error {
-
-	var (
-		priv ed25519.PrivateKey
-		pub  ed25519.PublicKey
-		err  error
-	)
-	cnt := 0
-	for {
-		cnt++
-		pub, priv, err = ed25519.GenerateKey(rand.Reader)
-		if err != nil {
-			if cnt > 10 {
-				return err
-			}
-			continue
-		} else {
-			break
-		}
-	}
-
-	var cipherTxt string
-	cipherTxt, err = account.EncryptSubPriKey(priv, pub, password)
-	if err != nil {
-		return err
-	}
-
-	kj := &bmailcrypt.KeyJson{PubKey: bmail.ToAddress(pub[:]).String(), CipherKey: cipherTxt}
-
-	fmt.Println("PubKey:", kj.PubKey)
-
-	var data []byte
-	data, err = json.Marshal(*kj)
-	fmt.Println("save to: ", GetKeyfile())
-	err = tools.Save2File(data, GetKeyfile())
-	if err != nil {
-		return err
+//NewClient2 dial the server, the key is the signer's, the client does no
+//key or password I/O itself
+func NewClient2(serverIP net.IP, timeout int, s signer.Signer) (*BMClient2, error) {
+	if s == nil {
+		return nil, errors.New("no signer")
 	}
-
-	return nil
-}
-
-func inputpassword() (password string, err error) {
-	passwd, err := gopass.GetPasswdPrompt("Please Enter Password: ", true, os.Stdin, os.Stdout)
-	if err != nil {
-		return "", err
-	}
-
-	if len(passwd) < 1 {
-		return "", errors.New("Please input valid password")
-	}
-
-	return string(passwd), nil
-}
-
-func NewClient2(serverIP net.IP, timeout int) *BMClient2 {
 	laddr := &net.TCPAddr{}
 	raddr := &net.TCPAddr{IP: serverIP, Port: 1110}
 	conn, err := net.DialTCP("tcp4", laddr, raddr)
 	if err != nil {
-		return nil
-	}
-
-	var passwd string
-
-	passwd, _ = inputpassword()
-
-	if !keyISGenerated() {
-
-		GenEd25519KeyAndSave(passwd)
+		return nil, err
 	}
 
-	pk, priv := LoadKey(passwd)
-
 	c := &BMClient2{}
 	c.c = conn
 	c.timeout = timeout
-	c.PK = pk
-	c.Priv = priv
+	c.Signer = s
 
 	conn.SetDeadline(time.Now().Add(time.Second * time.Duration(timeout)))
 
-	return c
+	return c, nil
 }
 
 func (c *BMClient2) Close() {
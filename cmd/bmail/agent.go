@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"github.com/realbmail/go-bmail-protocol/agent"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+)
+
+//runAgent unlock the keys once and serve them until killed, the other
+//commands use them when BMAIL_AGENT_SOCK is set
+func runAgent(g *global, args []string) error {
+	fs := newFlags("agent")
+	sock := fs.String("sock", "", "socket path, default is agent/agent.sock beside the config")
+	mails := fs.String("mail", g.conf.MailName, "mail names to unlock, comma separated")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if *sock == "" {
+		*sock = filepath.Join(filepath.Dir(g.confPath), "agent", "agent.sock")
+	}
+	names := splitNames(*mails)
+	if len(names) == 0 {
+		return usageErr("-mail is needed")
+	}
+
+	srv := agent.NewServer()
+	for _, name := range names {
+		id, err := unlock(g.conf, name)
+		if err != nil {
+			return err
+		}
+		defer id.Close()
+		srv.Add(id)
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sig
+		srv.Close()
+	}()
+
+	fmt.Fprintf(stdout, "%s=%s; export %s\n", agent.SockEnv, *sock, agent.SockEnv)
+	err := srv.ListenAndServe(*sock)
+	if err == agent.ErrClosed {
+		os.Remove(*sock)
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,439 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/realbmail/go-bmail-account"
+	"github.com/realbmail/go-bmail-protocol/archive"
+	"github.com/realbmail/go-bmail-protocol/bmp"
+	"github.com/realbmail/go-bmail-protocol/bmp/client"
+	"github.com/realbmail/go-bmail-protocol/bpop"
+	"github.com/realbmail/go-bmail-protocol/eml"
+	"github.com/realbmail/go-bmail-protocol/signer"
+	"io/ioutil"
+	"mime"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+func newFlags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet("bmail "+name, flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	return fs
+}
+
+func parse(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		return &exitError{exitUsage, err}
+	}
+	return nil
+}
+
+func (g *global) dial() (*client.BMailClient, signer.Key, error) {
+	w, err := loadWallet(g.conf)
+	if err != nil {
+		return nil, nil, err
+	}
+	cli, err := client.NewClient(&client.ClientConf{Resolver: g.conf, Wallet: w})
+	if err != nil {
+		return nil, nil, err
+	}
+	return cli, w, nil
+}
+
+func nowMs() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
+func runKeygen(g *global, args []string) error {
+	fs := newFlags("keygen")
+	mail := fs.String("mail", g.conf.MailName, "mail name of the account")
+	force := fs.Bool("force", false, "replace the key of the mail name")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if *mail == "" {
+		return usageErr("-mail is needed")
+	}
+	ks, err := openKeystore(g.conf)
+	if err != nil {
+		return err
+	}
+	create := ks.Create
+	if _, err := ks.Entry(*mail); err == nil {
+		if !*force {
+			return keyErr(fmt.Errorf("%s has a key, -force to replace it", *mail))
+		}
+		//the old key goes only when the new one is saved
+		create = ks.Replace
+	}
+
+	id, err := create(*mail, *mail)
+	if err != nil {
+		return keyErr(err)
+	}
+	addr := id.Address()
+	g.conf.MailName = *mail
+	if err := g.conf.Save(); err != nil {
+		return err
+	}
+
+	t := &table{head: []string{"MAIL", "ADDRESS", "KEY FILE"}}
+	t.add(*mail, addr, g.conf.KeyPath())
+	t.v = map[string]string{"mail": *mail, "address": addr.String(), "key_file": g.conf.KeyPath()}
+	return g.print(t)
+}
+
+type fileList []string
+
+func (fl *fileList) String() string {
+	return strings.Join(*fl, ",")
+}
+
+func (fl *fileList) Set(s string) error {
+	*fl = append(*fl, s)
+	return nil
+}
+
+func splitNames(s string) []string {
+	var r []string
+	for _, n := range strings.Split(s, ",") {
+		if n = strings.TrimSpace(n); n != "" {
+			r = append(r, n)
+		}
+	}
+	return r
+}
+
+func runSend(g *global, args []string) error {
+	fs := newFlags("send")
+	to := fs.String("to", "", "recipients, comma separated")
+	cc := fs.String("cc", "", "copy recipients")
+	bcc := fs.String("bcc", "", "blind copy recipients")
+	subject := fs.String("subject", "", "subject")
+	body := fs.String("body", "", "body text, - reads stdin")
+	emlFile := fs.String("eml", "", "send a .eml file instead")
+	ephemeral := fs.Bool("ephemeral", false, "forward secret: wrap the mail key by a one time key")
+	var attach fileList
+	fs.Var(&attach, "attach", "file to attach, may repeat")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+
+	var (
+		env   *bmp.BMailEnvelope
+		files []*eml.File
+	)
+	if *emlFile != "" {
+		f, err := os.Open(*emlFile)
+		if err != nil {
+			return err
+		}
+		env, files, err = eml.Import(f, g.conf.Resolve)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		env.Eid = uuid.New().String()
+		env.SessionID = env.Eid
+	} else {
+		text := *body
+		if text == "-" {
+			data, err := ioutil.ReadAll(os.Stdin)
+			if err != nil {
+				return err
+			}
+			text = string(data)
+		}
+		env = &bmp.BMailEnvelope{
+			Eid:      uuid.New().String(),
+			Subject:  *subject,
+			MailBody: text,
+		}
+		env.SessionID = env.Eid
+		for _, l := range []struct {
+			names string
+			typ   int8
+		}{{*to, bmp.RcpTypeTo}, {*cc, bmp.RcpTypeCC}, {*bcc, bmp.RcpTypeBcc}} {
+			for _, name := range splitNames(l.names) {
+				addr, err := g.conf.Resolve(name)
+				if err != nil {
+					return usageErr("%s", err)
+				}
+				env.RCPTs = append(env.RCPTs, &bmp.Recipient{ToName: name, ToAddr: addr, RcptType: l.typ})
+			}
+		}
+	}
+	for _, path := range attach {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		files = append(files, &eml.File{
+			Name: filepath.Base(path),
+			Type: mime.TypeByExtension(filepath.Ext(path)),
+			Data: data,
+		})
+	}
+	if len(env.RCPTs) == 0 {
+		return usageErr("no recipient")
+	}
+	env.DateSince1970 = uint64(nowMs())
+
+	cli, w, err := g.dial()
+	if err != nil {
+		return err
+	}
+	seal := eml.Seal
+	if *ephemeral {
+		seal = eml.SealEphemeral
+	}
+	envKey, err := seal(env, w)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		att, data, err := client.SealAttachment(envKey, f.Name, f.Type, f.Data)
+		if err != nil {
+			return err
+		}
+		if err := cli.UploadAttachment(env.Eid, att, bytes.NewReader(data)); err != nil {
+			return err
+		}
+		env.Attachments = append(env.Attachments, att)
+	}
+	if err := cli.SendMail(env); err != nil {
+		return err
+	}
+
+	t := &table{head: []string{"EID", "RECIPIENTS", "ATTACHMENTS"}}
+	t.add(env.Eid, len(env.RCPTs), len(env.Attachments))
+	t.v = map[string]interface{}{"eid": env.Eid, "recipients": len(env.RCPTs), "attachments": len(env.Attachments)}
+	return g.print(t)
+}
+
+type mailInfo struct {
+	Eid         string `json:"eid"`
+	Date        string `json:"date"`
+	From        string `json:"from"`
+	Subject     string `json:"subject"`
+	Attachments int    `json:"attachments"`
+	File        string `json:"file,omitempty"`
+}
+
+func runFetch(g *global, args []string) error {
+	fs := newFlags("fetch")
+	n := fs.Int("n", bpop.DefaultMailCount, "mails to fetch")
+	before := fs.Int64("before", 0, "mails older than this time (ms since 1970), 0 is now")
+	dir := fs.String("eml", "", "save every mail as a .eml file in this directory")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	pivot := *before
+	if pivot == 0 {
+		pivot = nowMs()
+	}
+	if *dir != "" {
+		if err := os.MkdirAll(*dir, 0700); err != nil {
+			return err
+		}
+	}
+
+	cli, w, err := g.dial()
+	if err != nil {
+		return err
+	}
+	envs, err := cli.ReceiveEnv(pivot, bpop.DirectionToLeft, *n)
+	if err != nil {
+		return err
+	}
+
+	t := &table{head: []string{"EID", "DATE", "FROM", "SUBJECT", "FILES"}}
+	var infos []*mailInfo
+	for _, env := range envs {
+		buf := &bytes.Buffer{}
+		if err := eml.Render(buf, env, w, cli.DownloadAttachment); err != nil {
+			fmt.Fprintln(os.Stderr, "skip", env.Eid+":", err)
+			continue
+		}
+		mi := &mailInfo{
+			Eid:         env.Eid,
+			Date:        time.Unix(0, int64(env.DateSince1970)*int64(time.Millisecond)).Format(time.RFC3339),
+			From:        env.FromName,
+			Subject:     env.Subject,
+			Attachments: len(env.Attachments),
+		}
+		if *dir != "" {
+			if mi.File, err = emlPath(*dir, env.Eid); err != nil {
+				fmt.Fprintln(os.Stderr, "skip", env.Eid+":", err)
+				continue
+			}
+			if err := ioutil.WriteFile(mi.File, buf.Bytes(), 0600); err != nil {
+				return err
+			}
+		}
+		infos = append(infos, mi)
+		t.add(mi.Eid, mi.Date, mi.From, mi.Subject, mi.Attachments)
+	}
+	t.v = infos
+	return g.print(t)
+}
+
+//emlPath give the file of a mail in dir, the Eid comes from the sender and
+//must be a uuid to name a file
+func emlPath(dir, eid string) (string, error) {
+	id, err := uuid.Parse(eid)
+	if err != nil {
+		return "", fmt.Errorf("bad eid: %v", err)
+	}
+	return filepath.Join(dir, id.String()+".eml"), nil
+}
+
+func runStat(g *global, args []string) error {
+	fs := newFlags("stat")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	cli, _, err := g.dial()
+	if err != nil {
+		return err
+	}
+	folders, err := cli.ListFolders()
+	if err != nil {
+		return err
+	}
+
+	t := &table{head: []string{"FOLDER", "TOTAL", "UNREAD"}, v: folders}
+	for _, f := range folders {
+		t.add(f.Name, f.Total, f.Unread)
+	}
+	return g.print(t)
+}
+
+var resultNames = map[int]string{
+	bpop.MailDeleteSuccess: "deleted",
+	bpop.MailNotFound:      "not found",
+	bpop.MailDeleteFailed:  "failed",
+	bpop.MailOpFailed:      "failed",
+}
+
+func runDelete(g *global, args []string) error {
+	fs := newFlags("delete")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return usageErr("usage: bmail delete <eid>...")
+	}
+	var eids []uuid.UUID
+	for _, s := range fs.Args() {
+		eid, err := uuid.Parse(s)
+		if err != nil {
+			return usageErr("bad eid %s", s)
+		}
+		eids = append(eids, eid)
+	}
+
+	cli, _, err := g.dial()
+	if err != nil {
+		return err
+	}
+	results, err := cli.DeleteMail(eids)
+	if err != nil {
+		return err
+	}
+
+	t := &table{head: []string{"EID", "RESULT"}, v: results}
+	failed := 0
+	for _, r := range results {
+		if r.Result != bpop.MailDeleteSuccess {
+			failed++
+		}
+		t.add(r.Eid, resultNames[r.Result])
+	}
+	if err := g.print(t); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d mails not deleted", failed)
+	}
+	return nil
+}
+
+func runContacts(g *global, args []string) error {
+	fs := newFlags("contacts")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	sub := "list"
+	if fs.NArg() > 0 {
+		sub = fs.Arg(0)
+	}
+
+	switch sub {
+	case "list":
+		t := &table{head: []string{"NAME", "ADDRESS"}, v: g.conf.Contacts}
+		names := make([]string, 0, len(g.conf.Contacts))
+		for name := range g.conf.Contacts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			t.add(name, g.conf.Contacts[name])
+		}
+		return g.print(t)
+	case "add":
+		if fs.NArg() != 3 {
+			return usageErr("usage: bmail contacts add <mail name> <address>")
+		}
+		g.conf.Contacts[fs.Arg(1)] = bmail.Address(fs.Arg(2))
+		return g.conf.Save()
+	case "remove":
+		if fs.NArg() != 2 {
+			return usageErr("usage: bmail contacts remove <mail name>")
+		}
+		if _, ok := g.conf.Contacts[fs.Arg(1)]; !ok {
+			return fmt.Errorf("no contact %s", fs.Arg(1))
+		}
+		delete(g.conf.Contacts, fs.Arg(1))
+		return g.conf.Save()
+	default:
+		return usageErr("usage: bmail contacts [list|add|remove]")
+	}
+}
+
+func runExport(g *global, args []string) error {
+	fs := newFlags("export")
+	format := fs.String("format", archive.FormatMaildir, "maildir or mbox")
+	path := fs.String("path", "", "Maildir directory or mbox file")
+	page := fs.Int("page", archive.DefaultPageSize, "mails fetched at a time")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if *path == "" {
+		return usageErr("-path is needed")
+	}
+
+	cli, w, err := g.dial()
+	if err != nil {
+		return err
+	}
+	r, err := archive.Run(&archive.Config{
+		Format:   *format,
+		Path:     *path,
+		PageSize: *page,
+		Wallet:   w,
+		Source:   cli,
+	})
+	if r != nil {
+		t := &table{head: []string{"ADDED", "KNOWN", "SKIPPED"}, v: r}
+		t.add(r.Added, r.Known, len(r.Skipped))
+		g.print(t)
+	}
+	return err
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/realbmail/go-bmail-account"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	FormatTable = "table"
+	FormatJSON  = "json"
+)
+
+//Server is a mail domain with the servers answering it, it stands in for
+//the name resolver
+type Server struct {
+	IPs  []string        `json:"ips"`
+	Bcas []bmail.Address `json:"bcas"`
+}
+
+type Config struct {
+	MailName string                   `json:"mail_name"`
+	KeyFile  string                   `json:"key_file"` //relative to the config
+	Output   string                   `json:"output"`
+	Servers  map[string]*Server       `json:"servers"` //by mail domain
+	Contacts map[string]bmail.Address `json:"contacts"`
+
+	path string
+}
+
+func DefaultConfigPath() string {
+	if p := os.Getenv("BMAIL_CONFIG"); p != "" {
+		return p
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "bmail.json"
+	}
+	return filepath.Join(home, ".bmail", "config.json")
+}
+
+//LoadConfig read the config, a missing file is an empty config
+func LoadConfig(path string) (*Config, error) {
+	conf := &Config{
+		KeyFile:  "key.json",
+		Output:   FormatTable,
+		Servers:  make(map[string]*Server),
+		Contacts: make(map[string]bmail.Address),
+		path:     path,
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return conf, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, conf); err != nil {
+		return nil, errors.New("bad config " + path + ": " + err.Error())
+	}
+	if conf.Servers == nil {
+		conf.Servers = make(map[string]*Server)
+	}
+	if conf.Contacts == nil {
+		conf.Contacts = make(map[string]bmail.Address)
+	}
+	return conf, nil
+}
+
+func (c *Config) Save() error {
+	data, err := json.MarshalIndent(c, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0700); err != nil {
+		return err
+	}
+	tmp := c.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path)
+}
+
+func (c *Config) KeyPath() string {
+	if filepath.IsAbs(c.KeyFile) {
+		return c.KeyFile
+	}
+	return filepath.Join(filepath.Dir(c.path), c.KeyFile)
+}
+
+//DomainMX make the config a client.Resolver
+func (c *Config) DomainMX(domain string) ([]net.IP, []bmail.Address) {
+	srv, ok := c.Servers[strings.ToLower(domain)]
+	if !ok {
+		return nil, nil
+	}
+	var ips []net.IP
+	for _, s := range srv.IPs {
+		if ip := net.ParseIP(s); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	return ips, srv.Bcas
+}
+
+//Resolve find the block chain address of a mail name in the contacts
+func (c *Config) Resolve(name string) (bmail.Address, error) {
+	if addr, ok := c.Contacts[name]; ok {
+		return addr, nil
+	}
+	return "", errors.New("unknown recipient " + name + ", add it by: bmail contacts add " + name + " <address>")
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+//bmail is the command line client of the library, every subcommand is
+//built on bmp/client and the packages around it:
+//
+//bmail keygen -mail alice@bmail.com
+//bmail send -to bob@bmail.com -subject hi -body "hello"
+//bmail fetch -n 20
+//bmail stat
+//bmail delete <eid>...
+//bmail contacts add bob@bmail.com <address>
+//bmail export -format mbox -path inbox.mbox
+//bmail agent
+
+const (
+	exitOK    = 0
+	exitErr   = 1 //the command failed
+	exitUsage = 2 //bad arguments
+	exitKey   = 3 //no key, bad password, bad config
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(g *global, args []string) error
+}
+
+var commands = []*command{
+	{"keygen", "create the key of an account", runKeygen},
+	{"send", "send a mail", runSend},
+	{"fetch", "list or save received mails", runFetch},
+	{"stat", "show the folders of the mailbox", runStat},
+	{"delete", "delete mails by eid", runDelete},
+	{"contacts", "list, add or remove contacts", runContacts},
+	{"export", "export the mailbox to Maildir or mbox", runExport},
+	{"agent", "hold unlocked keys for the other commands", runAgent},
+}
+
+//global is what every subcommand gets: the config and the output format
+type global struct {
+	confPath string
+	conf     *Config
+	format   string
+}
+
+//exitError carries the exit code of a failure
+type exitError struct {
+	code int
+	err  error
+}
+
+func (ee *exitError) Error() string {
+	return ee.err.Error()
+}
+
+func usageErr(format string, a ...interface{}) error {
+	return &exitError{exitUsage, fmt.Errorf(format, a...)}
+}
+
+func keyErr(err error) error {
+	return &exitError{exitKey, err}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: bmail [-config file] [-o table|json] <command> [arguments]")
+	fmt.Fprintln(os.Stderr, "\ncommands:")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", c.name, c.usage)
+	}
+}
+
+func main() {
+	//the library prints its trace on os.Stdout, keep stdout for the output
+	os.Stdout = os.Stderr
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	fs := flag.NewFlagSet("bmail", flag.ContinueOnError)
+	fs.Usage = usage
+	g := &global{}
+	fs.StringVar(&g.confPath, "config", DefaultConfigPath(), "config file")
+	fs.StringVar(&g.format, "o", "", "output format, table or json")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if fs.NArg() == 0 {
+		usage()
+		return exitUsage
+	}
+
+	conf, err := LoadConfig(g.confPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "bmail:", err)
+		return exitKey
+	}
+	g.conf = conf
+	if g.format == "" {
+		g.format = conf.Output
+	}
+	if g.format != FormatTable && g.format != FormatJSON {
+		fmt.Fprintf(os.Stderr, "bmail: unknown output format [%s]\n", g.format)
+		return exitUsage
+	}
+
+	name := fs.Arg(0)
+	for _, c := range commands {
+		if c.name != name {
+			continue
+		}
+		err := c.run(g, fs.Args()[1:])
+		if err == nil {
+			return exitOK
+		}
+		fmt.Fprintf(os.Stderr, "bmail %s: %s\n", name, err)
+		if ee, ok := err.(*exitError); ok {
+			return ee.code
+		}
+		return exitErr
+	}
+
+	fmt.Fprintf(os.Stderr, "bmail: unknown command [%s]\n", name)
+	usage()
+	return exitUsage
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/realbmail/go-bmail-protocol/agent"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//bmailRun run the cli with the config in dir, what it prints is returned
+func bmailRun(dir string, args ...string) (int, string) {
+	out := &bytes.Buffer{}
+	stdout = out
+	defer func() { stdout = os.Stdout }()
+	code := run(append([]string{"-config", filepath.Join(dir, "config.json")}, args...))
+	return code, out.String()
+}
+
+func testDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "bmail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("BMAIL_PASSWORD", "123")
+	os.Unsetenv(agent.SockEnv)
+	return dir
+}
+
+func Test_ExitCodes(t *testing.T) {
+	dir := testDir(t)
+	defer os.RemoveAll(dir)
+
+	for _, c := range []struct {
+		args []string
+		code int
+	}{
+		{nil, exitUsage},
+		{[]string{"nope"}, exitUsage},
+		{[]string{"-o", "xml", "stat"}, exitUsage},
+		{[]string{"keygen"}, exitUsage},
+		{[]string{"keygen", "-bad"}, exitUsage},
+		{[]string{"stat"}, exitKey},
+		{[]string{"contacts", "add", "bob@bmail.com"}, exitUsage},
+		{[]string{"contacts", "remove", "bob@bmail.com"}, exitErr},
+		{[]string{"export"}, exitUsage},
+		{[]string{"keygen", "-mail", "alice@bmail.com"}, exitOK},
+		{[]string{"keygen", "-mail", "alice@bmail.com"}, exitKey},
+		{[]string{"keygen", "-mail", "alice@bmail.com", "-force"}, exitOK},
+	} {
+		if code, _ := bmailRun(dir, c.args...); code != c.code {
+			t.Fatal(c.args, "exit", code, "not", c.code)
+		}
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "config.json"), []byte("{"), 0600)
+	if code, _ := bmailRun(dir, "stat"); code != exitKey {
+		t.Fatal("bad config exit", code)
+	}
+	t.Log("pass")
+}
+
+func Test_Output(t *testing.T) {
+	dir := testDir(t)
+	defer os.RemoveAll(dir)
+
+	code, out := bmailRun(dir, "-o", "json", "keygen", "-mail", "alice@bmail.com")
+	if code != exitOK {
+		t.Fatal("exit", code)
+	}
+	key := map[string]string{}
+	if err := json.Unmarshal([]byte(out), &key); err != nil || key["mail"] != "alice@bmail.com" || key["address"] == "" {
+		t.Fatal("failed", out, err)
+	}
+
+	bmailRun(dir, "contacts", "add", "carol@bmail.com", "BMcarol")
+	bmailRun(dir, "contacts", "add", "bob@bmail.com", "BMbob")
+
+	//the config keeps the output format, -o overrides it
+	code, out = bmailRun(dir, "contacts")
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if code != exitOK || len(lines) != 3 || strings.Fields(lines[0])[0] != "NAME" ||
+		strings.Join(strings.Fields(lines[1]), " ") != "bob@bmail.com BMbob" {
+		t.Fatal("failed", out)
+	}
+	code, out = bmailRun(dir, "-o", "json", "contacts", "list")
+	contacts := map[string]string{}
+	if err := json.Unmarshal([]byte(out), &contacts); err != nil || code != exitOK ||
+		len(contacts) != 2 || contacts["carol@bmail.com"] != "BMcarol" {
+		t.Fatal("failed", out, err)
+	}
+	t.Log("pass")
+}
+
+func Test_EmlPath(t *testing.T) {
+	p, err := emlPath("mails", "3F1C8A52-0000-4000-8000-000000000001")
+	if err != nil || p != filepath.Join("mails", "3f1c8a52-0000-4000-8000-000000000001.eml") {
+		t.Fatal("failed", p, err)
+	}
+	for _, eid := range []string{"../../x", "3f1c8a52-0000-4000-8000-00000000000/", ""} {
+		if _, err := emlPath("mails", eid); err == nil {
+			t.Fatal("eid", eid, "names a file")
+		}
+	}
+	t.Log("pass")
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"github.com/howeyc/gopass"
+	"github.com/realbmail/go-bmail-protocol/agent"
+	"github.com/realbmail/go-bmail-protocol/keystore"
+	"github.com/realbmail/go-bmail-protocol/signer"
+	"os"
+)
+
+//termPassword is the password provider of the cli: BMAIL_PASSWORD, or the
+//terminal
+type termPassword struct{}
+
+func (termPassword) Password(name string, create bool) (string, error) {
+	if p := os.Getenv("BMAIL_PASSWORD"); p != "" {
+		return p, nil
+	}
+	if !create {
+		return prompt("Password of " + name + ": ")
+	}
+
+	pass, err := prompt("New password of " + name + ": ")
+	if err != nil {
+		return "", err
+	}
+	again, err := prompt("Password again: ")
+	if err != nil {
+		return "", err
+	}
+	if again != pass {
+		return "", errors.New("passwords not match")
+	}
+	return pass, nil
+}
+
+func prompt(s string) (string, error) {
+	p, err := gopass.GetPasswdPrompt(s, true, os.Stdin, os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	if len(p) == 0 {
+		return "", errors.New("empty password")
+	}
+	return string(p), nil
+}
+
+func openKeystore(conf *Config) (*keystore.Keystore, error) {
+	ks, err := keystore.Open(conf.KeyPath(), termPassword{})
+	if err != nil {
+		return nil, keyErr(err)
+	}
+	return ks, nil
+}
+
+//loadWallet use the key of the mail name in the agent when one is running,
+//else unlock it from the keystore
+func loadWallet(conf *Config) (signer.Key, error) {
+	if conf.MailName == "" {
+		return nil, keyErr(errors.New("no account, run: bmail keygen -mail <name>"))
+	}
+	if os.Getenv(agent.SockEnv) == "" {
+		return unlock(conf, conf.MailName)
+	}
+	ac, err := agent.Dial("")
+	if err != nil {
+		return nil, keyErr(err)
+	}
+	k, err := ac.Key(conf.MailName)
+	if err != nil {
+		ac.Close()
+		return nil, keyErr(err)
+	}
+	return k, nil
+}
+
+//unlock the identity of a mail name, a key file of an older cli holds only
+//keystore.DefaultName and is bound to the mail name here
+func unlock(conf *Config, mailName string) (*keystore.Identity, error) {
+	ks, err := openKeystore(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	name := mailName
+	if _, err := ks.Entry(name); err == keystore.ErrNoIdentity {
+		e, err := ks.Entry(keystore.DefaultName)
+		if err != nil || (e.MailName != "" && e.MailName != name) {
+			return nil, keyErr(errors.New("no key of " + name + ", run: bmail keygen -mail " + name))
+		}
+		if e.MailName == "" {
+			if err := ks.SetMailName(keystore.DefaultName, name); err != nil {
+				return nil, keyErr(err)
+			}
+		}
+		name = keystore.DefaultName
+	}
+
+	id, err := ks.Unlock(name)
+	if err != nil {
+		return nil, keyErr(err)
+	}
+	return id, nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/realbmail/go-bmail-protocol/spec"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+//bmspec write the protocol document of package spec, run it after a change
+//of the messages:
+//
+//go run ./cmd/bmspec -dir spec
+
+func main() {
+	dir := flag.String("dir", "spec", "directory of the document")
+	flag.Parse()
+
+	files, err := spec.Files()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(*dir, name), data, 0644); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package ecies
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"github.com/realbmail/go-bmail-account"
+	"github.com/realbmail/go-bmail-protocol/signer"
+	"io"
+	"math/big"
+)
+
+//the ephemeral mode: the sender makes a new X25519 key for every envelope
+//and agrees the key of a recipient with the recipient's static key, turned
+//from ed25519 to X25519. The ephemeral private key is dropped once the
+//envelope is sealed, a stolen sender key opens no sent mail.
+//
+//It is forward secret for the sender only. There is no ratchet, the static
+//key of a recipient opens every mail sent to it, past ones too, when it is
+//stolen. Nor does the key say who sent the mail, the sender is known by
+//the FromSig of the envelope alone.
+//
+//key = HKDF-SHA256(X25519(eph, rcpt), salt = eph pub | rcpt pub, info)
+
+const KeySize = 32
+
+var info = []byte("BMAIL-ECIES-1")
+
+var (
+	ErrBadPubKey = errors.New("bad public key for X25519")
+	ErrCipher    = errors.New("ecies cipher text broken")
+)
+
+var curveP, _ = new(big.Int).SetString("7fffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffed", 16)
+
+//PublicKey turn an ed25519 public key to the X25519 one, u = (1+y)/(1-y)
+func PublicKey(pub ed25519.PublicKey) (*ecdh.PublicKey, error) {
+	if len(pub) != ed25519.PublicKeySize {
+		return nil, ErrBadPubKey
+	}
+	le := make([]byte, 32)
+	copy(le, pub)
+	le[31] &= 0x7f
+	y := new(big.Int).SetBytes(reverse(le))
+	if y.Cmp(curveP) >= 0 {
+		return nil, ErrBadPubKey
+	}
+
+	one := big.NewInt(1)
+	den := new(big.Int).Sub(one, y)
+	den.Mod(den, curveP)
+	if den.Sign() == 0 {
+		return nil, ErrBadPubKey
+	}
+	u := new(big.Int).Add(one, y)
+	u.Mul(u, den.ModInverse(den, curveP))
+	u.Mod(u, curveP)
+
+	ub := make([]byte, 32)
+	u.FillBytes(ub)
+	return ecdh.X25519().NewPublicKey(reverse(ub))
+}
+
+//PrivateKey turn an ed25519 private key to the X25519 one, the scalar of
+//ed25519 is the first half of sha512 of the seed
+func PrivateKey(priv ed25519.PrivateKey) (*ecdh.PrivateKey, error) {
+	if len(priv) != ed25519.PrivateKeySize {
+		return nil, errors.New("bad ed25519 private key")
+	}
+	h := sha512.Sum512(priv.Seed())
+	return ecdh.X25519().NewPrivateKey(h[:32])
+}
+
+func reverse(b []byte) []byte {
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return b
+}
+
+func Ephemeral() (*ecdh.PrivateKey, error) {
+	return ecdh.X25519().GenerateKey(rand.Reader)
+}
+
+//derive is HKDF-SHA256 of one block
+func derive(shared, ephPub, rcptPub []byte) []byte {
+	salt := append(append([]byte{}, ephPub...), rcptPub...)
+	ext := hmac.New(sha256.New, salt)
+	ext.Write(shared)
+	prk := ext.Sum(nil)
+
+	exp := hmac.New(sha256.New, prk)
+	exp.Write(info)
+	exp.Write([]byte{1})
+	return exp.Sum(nil)[:KeySize]
+}
+
+//SenderKey agree the key of a recipient by the ephemeral key
+func SenderKey(eph *ecdh.PrivateKey, rcpt bmail.Address) ([]byte, error) {
+	pub, err := PublicKey(rcpt.ToPubKey())
+	if err != nil {
+		return nil, err
+	}
+	shared, err := eph.ECDH(pub)
+	if err != nil {
+		return nil, err
+	}
+	return derive(shared, eph.PublicKey().Bytes(), pub.Bytes()), nil
+}
+
+//RecipientKey agree the same key on the side of the recipient
+func RecipientKey(dh signer.DHKey, ephPub []byte) ([]byte, error) {
+	pub, err := PublicKey(dh.Address().ToPubKey())
+	if err != nil {
+		return nil, err
+	}
+	shared, err := dh.X25519(ephPub)
+	if err != nil {
+		return nil, err
+	}
+	return derive(shared, ephPub, pub.Bytes()), nil
+}
+
+//X25519 is signer.DHKey.X25519 for a key in the process
+func X25519(priv ed25519.PrivateKey, peer []byte) ([]byte, error) {
+	sk, err := PrivateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	pk, err := ecdh.X25519().NewPublicKey(peer)
+	if err != nil {
+		return nil, err
+	}
+	return sk.ECDH(pk)
+}
+
+//Wrap encrypt by AES-256-GCM, the nonce leads the cipher text
+func Wrap(key, plain []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plain)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plain, nil), nil
+}
+
+func Unwrap(key, data []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize()+aead.Overhead() {
+		return nil, ErrCipher
+	}
+	plain, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, ErrCipher
+	}
+	return plain, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
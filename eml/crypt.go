@@ -0,0 +1,232 @@
+package eml
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"github.com/realbmail/go-bas-mail-server/bmailcrypt"
+	"github.com/realbmail/go-bmail-account"
+	"github.com/realbmail/go-bmail-protocol/bmp"
+	"github.com/realbmail/go-bmail-protocol/bmprotocol"
+	"github.com/realbmail/go-bmail-protocol/ecies"
+	"github.com/realbmail/go-bmail-protocol/signer"
+	"io"
+)
+
+//EnvKeySize is the size of the key a sealed envelope is encrypted by
+const EnvKeySize = 32
+
+var (
+	ErrNotRecipient = errors.New("wallet is no recipient of the envelope")
+	ErrNoDH         = errors.New("wallet can't open forward secret mail, it does no X25519")
+)
+
+//Wallet is a mail identity that agrees an aes key with a peer, the key of
+//the wallet never leaves it
+type Wallet interface {
+	signer.KeyAgreer
+	MailAddress() string
+}
+
+//Seal encrypt subject and body of an imported envelope by a new envelope
+//key, the key is wrapped for every recipient by the key w agrees with it.
+//The envelope key is returned for sealing the attachments.
+func Seal(env *bmp.BMailEnvelope, w Wallet) ([]byte, error) {
+	env.CryptMode = 0
+	env.EphKey = nil
+	return seal(env, w, func(r *bmp.Recipient, envKey []byte) ([]byte, error) {
+		key, err := w.AesKeyOf(r.ToAddr)
+		if err != nil {
+			return nil, err
+		}
+		return bmailcrypt.Encrypt(key, envKey)
+	})
+}
+
+//SealEphemeral is Seal in bmp.CryptModeEphemeral, the envelope key is
+//wrapped by a new X25519 key that is dropped after, w gives only the sender.
+//The key says nothing of the sender, the envelope must get its FromSig
+//before it is sent, client.BMailClient.SendMail signs it.
+func SealEphemeral(env *bmp.BMailEnvelope, w Wallet) ([]byte, error) {
+	eph, err := ecies.Ephemeral()
+	if err != nil {
+		return nil, err
+	}
+	env.CryptMode = bmp.CryptModeEphemeral
+	env.EphKey = eph.PublicKey().Bytes()
+	return seal(env, w, func(r *bmp.Recipient, envKey []byte) ([]byte, error) {
+		key, err := ecies.SenderKey(eph, r.ToAddr)
+		if err != nil {
+			return nil, err
+		}
+		return ecies.Wrap(key, envKey)
+	})
+}
+
+func seal(env *bmp.BMailEnvelope, w Wallet, wrap func(r *bmp.Recipient, envKey []byte) ([]byte, error)) ([]byte, error) {
+	envKey := make([]byte, EnvKeySize)
+	if _, err := io.ReadFull(rand.Reader, envKey); err != nil {
+		return nil, err
+	}
+
+	for _, r := range env.RCPTs {
+		var err error
+		if r.AESKey, err = wrap(r, envKey); err != nil {
+			return nil, err
+		}
+	}
+
+	subject, err := bmailcrypt.Encrypt(envKey, []byte(env.Subject))
+	if err != nil {
+		return nil, err
+	}
+	body, err := bmailcrypt.Encrypt(envKey, []byte(env.MailBody))
+	if err != nil {
+		return nil, err
+	}
+	env.FromName = w.MailAddress()
+	env.FromAddr = w.Address()
+	env.Subject = base64.StdEncoding.EncodeToString(subject)
+	env.MailBody = base64.StdEncoding.EncodeToString(body)
+
+	return envKey, nil
+}
+
+//Open decrypt subject and body of a received envelope before Export, the
+//envelope key is returned for opening the attachments. In the static mode
+//only FromAddr and the recipient agree the key. In
+//bmp.CryptModeEphemeral anyone may have made it, FromSig must be made by
+//FromAddr or a sub-key it certified; revocations and the time the server
+//took the mail are checked by the Checker of the client.
+func Open(env *bmp.BMailEnvelope, w Wallet) ([]byte, error) {
+	var wrapped []byte
+	for _, r := range env.RCPTs {
+		if r.ToAddr == w.Address() || r.ToName == w.MailAddress() {
+			wrapped = r.AESKey
+			break
+		}
+	}
+	if len(wrapped) == 0 {
+		return nil, ErrNotRecipient
+	}
+	if err := env.CheckCryptMode(); err != nil {
+		return nil, err
+	}
+
+	var envKey []byte
+	if env.CryptMode&bmp.CryptModeEphemeral != 0 {
+		dh, ok := w.(signer.DHKey)
+		if !ok {
+			return nil, ErrNoDH
+		}
+		if err := env.VerifySender(nil, int64(env.DateSince1970)); err != nil {
+			return nil, err
+		}
+		key, err := ecies.RecipientKey(dh, env.EphKey)
+		if err != nil {
+			return nil, err
+		}
+		if envKey, err = ecies.Unwrap(key, wrapped); err != nil {
+			return nil, err
+		}
+	} else {
+		key, err := w.AesKeyOf(env.FromAddr)
+		if err != nil {
+			return nil, err
+		}
+		if envKey, err = bmailcrypt.Decrypt(key, wrapped); err != nil {
+			return nil, err
+		}
+	}
+
+	subject, err := openText(envKey, env.Subject)
+	if err != nil {
+		return nil, err
+	}
+	body, err := openText(envKey, env.MailBody)
+	if err != nil {
+		return nil, err
+	}
+	env.Subject = subject
+	env.MailBody = body
+
+	return envKey, nil
+}
+
+func openText(key []byte, s string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return "", err
+	}
+	plain, err := bmailcrypt.Decrypt(key, data)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+//SealEnvelope encrypt a binary envelope for the recipient peer of its
+//route, by the aes functions registered in bmprotocol
+func SealEnvelope(e *bmprotocol.Envelope, peer bmail.Address, w Wallet) (*bmprotocol.CryptEnvelope, error) {
+	key, err := w.AesKeyOf(peer)
+	if err != nil {
+		return nil, err
+	}
+	ce := bmprotocol.EncodeEnvelope(e, key)
+	if ce == nil {
+		return nil, errors.New("encode envelope failed")
+	}
+	return ce, nil
+}
+
+//SealEnvelopeEphemeral encrypt a binary envelope for peer in
+//bmprotocol.CryptModeEphemeral, no key of the sender is used
+func SealEnvelopeEphemeral(e *bmprotocol.Envelope, peer bmail.Address) (*bmprotocol.CryptEnvelope, error) {
+	eph, err := ecies.Ephemeral()
+	if err != nil {
+		return nil, err
+	}
+	key, err := ecies.SenderKey(eph, peer)
+	if err != nil {
+		return nil, err
+	}
+	ce := bmprotocol.EncodeEnvelope(e, key)
+	if ce == nil {
+		return nil, errors.New("encode envelope failed")
+	}
+	ce.Mode |= bmprotocol.CryptModeEphemeral
+	ce.Pubkeys = append([][]byte{eph.PublicKey().Bytes()}, ce.Pubkeys...)
+	return ce, nil
+}
+
+//OpenEnvelope decrypt a binary envelope sent by peer, in the mode of its
+//crypt desc. In bmprotocol.CryptModeEphemeral the key says nothing of
+//peer and a binary envelope has no signature of its sender, the caller
+//must not take peer as proven.
+func OpenEnvelope(ce *bmprotocol.CryptEnvelope, peer bmail.Address, w Wallet) (*bmprotocol.Envelope, error) {
+	if err := ce.CheckMode(); err != nil {
+		return nil, err
+	}
+
+	var (
+		key []byte
+		err error
+	)
+	if ce.Ephemeral() {
+		dh, ok := w.(signer.DHKey)
+		if !ok {
+			return nil, ErrNoDH
+		}
+		key, err = ecies.RecipientKey(dh, ce.Pubkeys[0])
+	} else {
+		key, err = w.AesKeyOf(peer)
+	}
+	if err != nil {
+		return nil, err
+	}
+	e := bmprotocol.DeCodeEnvelope(ce, key)
+	if e == nil {
+		return nil, errors.New("decode envelope failed")
+	}
+	return e, nil
+}
@@ -0,0 +1,395 @@
+package eml
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"sort"
+	"strings"
+	"time"
+)
+
+//a bmail is written as an ordinary RFC 5322 message, text/plain when it
+//has no file, multipart/mixed with the text first and one part per file
+//otherwise. The bmail fields a mail header has no place for are kept in
+//X-BMail headers so a message exported here imports back the same.
+
+const (
+	HeaderFrom    = "X-BMail-From"    //block chain address of the sender
+	HeaderRcpt    = "X-BMail-Rcpt"    //"mail name" block chain address, one per recipient
+	HeaderSession = "X-BMail-Session" //SessionID when it is not the Eid
+
+	//Message-IDs are <eid@MsgIDDomain>
+	MsgIDDomain = "bmail"
+)
+
+var (
+	//a message larger than this is refused by Import
+	MaxMessageSize int64 = 64 << 20
+	//multipart nesting deeper than this is refused
+	MaxPartDepth = 16
+)
+
+var (
+	ErrTooLarge = errors.New("message too large")
+	ErrTooDeep  = errors.New("multipart nested too deep")
+)
+
+//File is an attachment in plain, Type is its MIME type
+type File struct {
+	Name string
+	Type string
+	Data []byte
+}
+
+type message struct {
+	id         string
+	inReplyTo  string
+	references []string
+	from       string
+	to         []string
+	cc         []string
+	bcc        []string
+	date       time.Time
+	subject    string
+	body       string
+	extra      [][2]string
+	files      []*File
+}
+
+func (m *message) get(key string) []string {
+	var r []string
+	for _, kv := range m.extra {
+		if textproto.CanonicalMIMEHeaderKey(kv[0]) == textproto.CanonicalMIMEHeaderKey(key) {
+			r = append(r, kv[1])
+		}
+	}
+	return r
+}
+
+//msgID give the Message-ID of an eid, a byte the id syntax has no place
+//for is dropped
+func msgID(id string) string {
+	id = strings.Map(func(r rune) rune {
+		if r <= ' ' || r >= 0x7f || r == '<' || r == '>' {
+			return -1
+		}
+		return r
+	}, id)
+	if strings.Contains(id, "@") {
+		return "<" + id + ">"
+	}
+	return "<" + id + "@" + MsgIDDomain + ">"
+}
+
+//eidOf undo msgID, ids of other mail systems are kept whole
+func eidOf(id string) string {
+	id = strings.TrimSpace(id)
+	id = strings.TrimSuffix(strings.TrimPrefix(id, "<"), ">")
+	return strings.TrimSuffix(id, "@"+MsgIDDomain)
+}
+
+func addrList(names []string) string {
+	var r []string
+	for _, n := range names {
+		if n == "" {
+			continue
+		}
+		r = append(r, (&mail.Address{Address: n}).String())
+	}
+	return strings.Join(r, ", ")
+}
+
+func (m *message) write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	h := func(key, value string) {
+		value = headerValue(value)
+		if value != "" {
+			fmt.Fprintf(bw, "%s: %s\r\n", key, value)
+		}
+	}
+
+	h("Message-ID", msgID(m.id))
+	h("Date", m.date.Format(time.RFC1123Z))
+	h("From", addrList([]string{m.from}))
+	h("To", addrList(m.to))
+	h("Cc", addrList(m.cc))
+	h("Bcc", addrList(m.bcc))
+	h("Subject", mime.QEncoding.Encode("utf-8", m.subject))
+	if m.inReplyTo != "" {
+		h("In-Reply-To", msgID(m.inReplyTo))
+	}
+	var refs []string
+	for _, r := range m.references {
+		refs = append(refs, msgID(r))
+	}
+	h("References", strings.Join(refs, " "))
+	for _, kv := range m.extra {
+		h(kv[0], kv[1])
+	}
+	h("MIME-Version", "1.0")
+
+	if len(m.files) == 0 {
+		h("Content-Type", "text/plain; charset=utf-8")
+		h("Content-Transfer-Encoding", "quoted-printable")
+		bw.WriteString("\r\n")
+		if err := writeText(bw, m.body); err != nil {
+			return err
+		}
+		return bw.Flush()
+	}
+
+	mw := multipart.NewWriter(bw)
+	h("Content-Type", mime.FormatMediaType("multipart/mixed", map[string]string{"boundary": mw.Boundary()}))
+	bw.WriteString("\r\n")
+
+	pw, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return err
+	}
+	if err := writeText(pw, m.body); err != nil {
+		return err
+	}
+
+	for _, f := range m.files {
+		typ := f.Type
+		if typ == "" {
+			typ = "application/octet-stream"
+		}
+		pw, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {mime.FormatMediaType(typ, map[string]string{"name": f.Name})},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": f.Name})},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return err
+		}
+		if err := writeBase64(pw, f.Data); err != nil {
+			return err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return err
+	}
+
+	return bw.Flush()
+}
+
+//headerValue turn the control characters of a value to spaces, the
+//values come from the sender and a line end would add headers or parts
+func headerValue(s string) string {
+	return strings.TrimSpace(strings.Map(func(r rune) rune {
+		if r < ' ' || r == 0x7f {
+			return ' '
+		}
+		return r
+	}, s))
+}
+
+func writeText(w io.Writer, s string) error {
+	qw := quotedprintable.NewWriter(w)
+	if _, err := qw.Write([]byte(s)); err != nil {
+		return err
+	}
+	return qw.Close()
+}
+
+//writeBase64 break lines at 76 characters as RFC 2045 asks
+func writeBase64(w io.Writer, data []byte) error {
+	enc := base64.StdEncoding.EncodeToString(data)
+	for len(enc) > 0 {
+		n := 76
+		if n > len(enc) {
+			n = len(enc)
+		}
+		if _, err := io.WriteString(w, enc[:n]+"\r\n"); err != nil {
+			return err
+		}
+		enc = enc[n:]
+	}
+	return nil
+}
+
+var wordDecoder = &mime.WordDecoder{}
+
+func readMessage(r io.Reader) (*message, error) {
+	lr := &io.LimitedReader{R: r, N: MaxMessageSize + 1}
+	msg, err := mail.ReadMessage(bufio.NewReader(lr))
+	if err != nil {
+		return nil, err
+	}
+
+	m := &message{}
+	hd := msg.Header
+
+	m.id = eidOf(hd.Get("Message-ID"))
+	m.inReplyTo = eidOf(hd.Get("In-Reply-To"))
+	for _, ref := range strings.Fields(hd.Get("References")) {
+		m.references = append(m.references, eidOf(ref))
+	}
+	if m.date, err = hd.Date(); err != nil {
+		m.date = time.Time{}
+	}
+	if m.subject, err = wordDecoder.DecodeHeader(hd.Get("Subject")); err != nil {
+		m.subject = hd.Get("Subject")
+	}
+
+	from, err := addresses(hd, "From")
+	if err != nil {
+		return nil, err
+	}
+	if len(from) > 0 {
+		m.from = from[0]
+	}
+	if m.to, err = addresses(hd, "To"); err != nil {
+		return nil, err
+	}
+	if m.cc, err = addresses(hd, "Cc"); err != nil {
+		return nil, err
+	}
+	if m.bcc, err = addresses(hd, "Bcc"); err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(hd))
+	for k := range hd {
+		if strings.HasPrefix(k, "X-Bmail-") {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range hd[k] {
+			m.extra = append(m.extra, [2]string{k, v})
+		}
+	}
+
+	var html string
+	err = m.readPart(textproto.MIMEHeader(hd), msg.Body, 0, &html)
+	if err != nil {
+		return nil, err
+	}
+	if lr.N <= 0 {
+		return nil, ErrTooLarge
+	}
+	//a message with html only keeps it as the body
+	if m.body == "" {
+		m.body = html
+	}
+
+	return m, nil
+}
+
+func addresses(hd mail.Header, key string) ([]string, error) {
+	list, err := hd.AddressList(key)
+	if err == mail.ErrHeaderNotPresent {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("bad %s header: %v", key, err)
+	}
+	var r []string
+	for _, a := range list {
+		r = append(r, a.Address)
+	}
+	return r, nil
+}
+
+func (m *message) readPart(hd textproto.MIMEHeader, body io.Reader, depth int, html *string) error {
+	if depth > MaxPartDepth {
+		return ErrTooDeep
+	}
+
+	mediaType, params, err := mime.ParseMediaType(hd.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			p, err := mr.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := m.readPart(p.Header, p, depth+1, html); err != nil {
+				return err
+			}
+		}
+	}
+
+	data, err := ioutil.ReadAll(decodeTransfer(hd.Get("Content-Transfer-Encoding"), body))
+	if err != nil {
+		return err
+	}
+
+	disposition, dParams, _ := mime.ParseMediaType(hd.Get("Content-Disposition"))
+	name := dParams["filename"]
+	if name == "" {
+		name = params["name"]
+	}
+	if disposition == "attachment" || name != "" || !strings.HasPrefix(mediaType, "text/") {
+		if n, err := wordDecoder.DecodeHeader(name); err == nil {
+			name = n
+		}
+		m.files = append(m.files, &File{Name: name, Type: mediaType, Data: data})
+		return nil
+	}
+
+	//lines of a message end in CRLF, a bmail body uses LF
+	text := strings.Replace(string(data), "\r\n", "\n", -1)
+	switch {
+	case mediaType == "text/plain" && m.body == "":
+		m.body = text
+	case mediaType == "text/html" && *html == "":
+		*html = text
+	}
+	return nil
+}
+
+func decodeTransfer(cte string, r io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(cte)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, &skipSpace{r: r})
+	case "quoted-printable":
+		return quotedprintable.NewReader(r)
+	default:
+		return r
+	}
+}
+
+//skipSpace drop the line breaks of base64 parts
+type skipSpace struct {
+	r io.Reader
+}
+
+func (s *skipSpace) Read(p []byte) (int, error) {
+	for {
+		n, err := s.r.Read(p)
+		j := 0
+		for _, c := range p[:n] {
+			if c != '\r' && c != '\n' && c != ' ' && c != '\t' {
+				p[j] = c
+				j++
+			}
+		}
+		if j > 0 || err != nil {
+			return j, err
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package eml
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/realbmail/go-bmail-account"
+	"github.com/realbmail/go-bmail-protocol/bmp"
+	"github.com/realbmail/go-bmail-protocol/bmprotocol"
+	"github.com/realbmail/go-bmail-protocol/translayer"
+	"io"
+	"strings"
+	"time"
+)
+
+//Resolver find the block chain address of a mail name, Import use it for
+//addresses a message has no X-BMail header for
+type Resolver func(mailName string) (bmail.Address, error)
+
+func msTime(ms uint64) time.Time {
+	return time.Unix(0, int64(ms)*int64(time.Millisecond))
+}
+
+func sinceMs(t time.Time) uint64 {
+	if t.IsZero() {
+		return 0
+	}
+	return uint64(t.UnixNano() / int64(time.Millisecond))
+}
+
+//Export write env as a MIME message. Subject and body must be in plain,
+//see Open. files are the attachments in plain, in the order of
+//env.Attachments, the caller opens them by the client.
+func Export(w io.Writer, env *bmp.BMailEnvelope, files []*File) error {
+	m := &message{
+		id:         env.Eid,
+		inReplyTo:  env.InReplyTo,
+		references: env.References,
+		from:       env.FromName,
+		date:       msTime(env.DateSince1970),
+		subject:    env.Subject,
+		body:       env.MailBody,
+		files:      files,
+	}
+
+	if env.FromAddr != "" {
+		m.extra = append(m.extra, [2]string{HeaderFrom, env.FromAddr.String()})
+	}
+	if env.SessionID != "" && env.SessionID != env.Eid {
+		m.extra = append(m.extra, [2]string{HeaderSession, env.SessionID})
+	}
+
+	for _, r := range env.RCPTs {
+		switch r.RcptType {
+		case bmp.RcpTypeTo:
+			m.to = append(m.to, r.ToName)
+		case bmp.RcpTypeCC:
+			m.cc = append(m.cc, r.ToName)
+		case bmp.RcpTypeBcc:
+			m.bcc = append(m.bcc, r.ToName)
+		default:
+			continue
+		}
+		if r.ToAddr != "" {
+			m.extra = append(m.extra, [2]string{HeaderRcpt, r.ToName + " " + r.ToAddr.String()})
+		}
+	}
+
+	return m.write(w)
+}
+
+//uuidOf give the Eid of a Message-ID, a bmail uuid is itself and the id
+//of another mail system is hashed into one, a message without id gets a
+//new one
+func uuidOf(id string) uuid.UUID {
+	if id == "" {
+		return uuid.New()
+	}
+	if u, err := uuid.Parse(id); err == nil {
+		return u
+	}
+	hash := sha256.Sum256([]byte(id))
+	var u uuid.UUID
+	copy(u[:], hash[:])
+	return u
+}
+
+//Import read a MIME message into a plain envelope, see Seal to encrypt
+//it. A Message-ID, In-Reply-To or Reference that is no bmail uuid is
+//hashed into one. The attachments are returned as files, the caller seals and uploads
+//them. resolve may be nil when every address is known by X-BMail headers.
+func Import(r io.Reader, resolve Resolver) (*bmp.BMailEnvelope, []*File, error) {
+	m, err := readMessage(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	env := &bmp.BMailEnvelope{
+		Eid:           uuidOf(m.id).String(),
+		FromName:      m.from,
+		DateSince1970: sinceMs(m.date),
+		Subject:       m.subject,
+		MailBody:      m.body,
+	}
+	if m.inReplyTo != "" {
+		env.InReplyTo = uuidOf(m.inReplyTo).String()
+	}
+	for _, ref := range m.references {
+		env.References = append(env.References, uuidOf(ref).String())
+	}
+
+	addrs := make(map[string]bmail.Address)
+	for _, v := range m.get(HeaderRcpt) {
+		f := strings.Fields(v)
+		if len(f) == 2 {
+			addrs[f[0]] = bmail.Address(f[1])
+		}
+	}
+	lookup := func(name string) (bmail.Address, error) {
+		if addr, ok := addrs[name]; ok {
+			return addr, nil
+		}
+		if resolve == nil {
+			return "", fmt.Errorf("no block chain address of [%s]", name)
+		}
+		return resolve(name)
+	}
+
+	if from := m.get(HeaderFrom); len(from) > 0 {
+		env.FromAddr = bmail.Address(from[0])
+	} else if env.FromName != "" && resolve != nil {
+		if env.FromAddr, err = resolve(env.FromName); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	env.SessionID = env.Eid
+	if sid := m.get(HeaderSession); len(sid) > 0 {
+		env.SessionID = sid[0]
+	}
+
+	for _, l := range []struct {
+		names []string
+		typ   int8
+	}{{m.to, bmp.RcpTypeTo}, {m.cc, bmp.RcpTypeCC}, {m.bcc, bmp.RcpTypeBcc}} {
+		for _, name := range l.names {
+			addr, err := lookup(name)
+			if err != nil {
+				return nil, nil, err
+			}
+			env.RCPTs = append(env.RCPTs, &bmp.Recipient{
+				ToName:   name,
+				ToAddr:   addr,
+				RcptType: l.typ,
+			})
+		}
+	}
+
+	return env, m.files, nil
+}
+
+//ExportEnvelope write the binary envelope e as a MIME message, as Export.
+//e has no date, the message is dated now.
+func ExportEnvelope(w io.Writer, e *bmprotocol.Envelope, files []*File) error {
+	m := &message{
+		id:      uuid.UUID(e.EId).String(),
+		from:    e.EnvelopeRoute.From,
+		to:      e.To,
+		cc:      e.CC,
+		bcc:     e.BC,
+		date:    time.Now(),
+		subject: e.Subject,
+		body:    e.Data,
+		files:   files,
+	}
+
+	return m.write(w)
+}
+
+//ImportEnvelope read a MIME message into a binary envelope, the route is
+//left to the caller as it differs for every recipient. EId is made of the
+//Message-ID as Import does.
+func ImportEnvelope(r io.Reader) (*bmprotocol.Envelope, []*File, error) {
+	m, err := readMessage(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	e := &bmprotocol.Envelope{}
+	e.EnvelopeRoute.From = m.from
+	e.EId = translayer.EnveUniqID(uuidOf(m.id))
+
+	e.To = m.to
+	e.CC = m.cc
+	e.BC = m.bcc
+	e.Subject = m.subject
+	e.Data = m.body
+
+	return e, m.files, nil
+}
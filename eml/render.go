@@ -0,0 +1,47 @@
+package eml
+
+import (
+	"bytes"
+	"github.com/realbmail/go-bmail-protocol/bmp"
+	"github.com/realbmail/go-bmail-protocol/bmp/client"
+	"io"
+)
+
+//Fetcher get the sealed data of an attachment, DownloadAttachment of the
+//client is one
+type Fetcher func(eid string, att *bmp.Attachment) ([]byte, error)
+
+//Render open a received envelope by the wallet and write it as a MIME
+//message with its attachments in plain. env is opened in place.
+func Render(w io.Writer, env *bmp.BMailEnvelope, wallet Wallet, fetch Fetcher) error {
+	envKey, err := Open(env, wallet)
+	if err != nil {
+		return err
+	}
+
+	var files []*File
+	for _, att := range env.Attachments {
+		sealed, err := fetch(env.Eid, att)
+		if err != nil {
+			return err
+		}
+
+		var (
+			name  string
+			plain []byte
+		)
+		if att.Scheme == bmp.AttachSchemeStream {
+			buf := &bytes.Buffer{}
+			name, err = client.OpenAttachmentStream(envKey, att, bytes.NewReader(sealed), buf)
+			plain = buf.Bytes()
+		} else {
+			name, plain, err = client.OpenAttachment(envKey, att, sealed)
+		}
+		if err != nil {
+			return err
+		}
+		files = append(files, &File{Name: name, Type: att.FileType, Data: plain})
+	}
+
+	return Export(w, env, files)
+}
@@ -0,0 +1,62 @@
+package keystore
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"github.com/realbmail/go-bas-mail-server/bmailcrypt"
+	"github.com/realbmail/go-bmail-account"
+	"github.com/realbmail/go-bmail-protocol/ecies"
+)
+
+//Identity is an unlocked key, it is a signer.Key and a signer.DHKey
+type Identity struct {
+	name     string
+	mailName string
+	pub      ed25519.PublicKey
+	priv     ed25519.PrivateKey
+}
+
+func (id *Identity) Name() string {
+	return id.name
+}
+
+func (id *Identity) Address() bmail.Address {
+	return bmail.ToAddress(id.pub)
+}
+
+func (id *Identity) MailAddress() string {
+	return id.mailName
+}
+
+func (id *Identity) PublicKey() ed25519.PublicKey {
+	return id.pub
+}
+
+func (id *Identity) Sign(message []byte) ([]byte, error) {
+	if id.priv == nil {
+		return nil, errors.New("identity closed")
+	}
+	return ed25519.Sign(id.priv, message), nil
+}
+
+func (id *Identity) AesKeyOf(peer bmail.Address) ([]byte, error) {
+	if id.priv == nil {
+		return nil, errors.New("identity closed")
+	}
+	return bmailcrypt.GenerateAesKey(peer.ToPubKey(), id.priv)
+}
+
+func (id *Identity) X25519(peer []byte) ([]byte, error) {
+	if id.priv == nil {
+		return nil, errors.New("identity closed")
+	}
+	return ecies.X25519(id.priv, peer)
+}
+
+//Close wipe the private key from memory
+func (id *Identity) Close() {
+	for i := range id.priv {
+		id.priv[i] = 0
+	}
+	id.priv = nil
+}
@@ -0,0 +1,279 @@
+package keystore
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/BASChain/go-account"
+	"github.com/realbmail/go-bas-mail-server/bmailcrypt"
+	"github.com/realbmail/go-bmail-account"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+//Keystore keeps the named identities of a user in one file, every private
+//key is encrypted by the password of its identity. It does no terminal
+//I/O, passwords come from a PasswordProvider.
+
+const (
+	FileVersion = 1
+
+	//DefaultName is the identity a key file of the old clients is loaded as
+	DefaultName = "default"
+)
+
+var (
+	ErrNoIdentity  = errors.New("no such identity")
+	ErrExists      = errors.New("identity exists")
+	ErrBadPassword = errors.New("wrong password")
+	ErrBadVersion  = errors.New("unsupported keystore version")
+)
+
+//PasswordProvider give the password of an identity, create is true when
+//the key is about to be encrypted for the first time
+type PasswordProvider interface {
+	Password(name string, create bool) (string, error)
+}
+
+//StaticPassword is the same password for every identity
+type StaticPassword string
+
+func (sp StaticPassword) Password(name string, create bool) (string, error) {
+	if sp == "" {
+		return "", errors.New("empty password")
+	}
+	return string(sp), nil
+}
+
+type PasswordFunc func(name string, create bool) (string, error)
+
+func (pf PasswordFunc) Password(name string, create bool) (string, error) {
+	return pf(name, create)
+}
+
+type Entry struct {
+	Name      string `json:"name"`
+	MailName  string `json:"mail_name,omitempty"`
+	PubKey    string `json:"pub_key"`
+	CipherKey string `json:"cipher_key"`
+	Created   int64  `json:"created"` //ms since 1970
+}
+
+type file struct {
+	Version    int      `json:"version"`
+	Identities []*Entry `json:"identities"`
+}
+
+type Keystore struct {
+	path    string
+	pp      PasswordProvider
+	entries map[string]*Entry
+}
+
+//Open load the keystore at path, a missing file is an empty keystore. A key
+//file of the old test clients is loaded as DefaultName and written in the
+//current format by the next Save.
+func Open(path string, pp PasswordProvider) (*Keystore, error) {
+	ks := &Keystore{
+		path:    path,
+		pp:      pp,
+		entries: make(map[string]*Entry),
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return ks, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	f := &file{}
+	if err := json.Unmarshal(data, f); err != nil {
+		return nil, fmt.Errorf("bad keystore %s: %v", path, err)
+	}
+	switch f.Version {
+	case 0:
+		kj := &bmailcrypt.KeyJson{}
+		if err := json.Unmarshal(data, kj); err != nil || kj.PubKey == "" {
+			return nil, fmt.Errorf("bad keystore %s", path)
+		}
+		ks.entries[DefaultName] = &Entry{Name: DefaultName, PubKey: kj.PubKey, CipherKey: kj.CipherKey}
+	case FileVersion:
+		for _, e := range f.Identities {
+			if e.Name == "" || e.PubKey == "" {
+				return nil, fmt.Errorf("bad keystore %s: identity without name or key", path)
+			}
+			ks.entries[e.Name] = e
+		}
+	default:
+		return nil, ErrBadVersion
+	}
+	return ks, nil
+}
+
+func (ks *Keystore) Path() string {
+	return ks.path
+}
+
+//Names of the identities, sorted
+func (ks *Keystore) Names() []string {
+	names := make([]string, 0, len(ks.entries))
+	for name := range ks.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (ks *Keystore) Entry(name string) (*Entry, error) {
+	e, ok := ks.entries[name]
+	if !ok {
+		return nil, ErrNoIdentity
+	}
+	return e, nil
+}
+
+//Create generate a key for a new identity and save the keystore
+func (ks *Keystore) Create(name, mailName string) (*Identity, error) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return ks.Import(name, mailName, priv)
+}
+
+//Import add an existing key as a new identity and save the keystore
+func (ks *Keystore) Import(name, mailName string, priv ed25519.PrivateKey) (*Identity, error) {
+	if _, ok := ks.entries[name]; ok {
+		return nil, ErrExists
+	}
+	return ks.put(name, mailName, priv)
+}
+
+//Replace generate a new key for an identity and save the keystore by one
+//Save, the old key stays when the password or the save fails
+func (ks *Keystore) Replace(name, mailName string) (*Identity, error) {
+	if _, ok := ks.entries[name]; !ok {
+		return nil, ErrNoIdentity
+	}
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return ks.put(name, mailName, priv)
+}
+
+//put encrypt priv and save it as the key of name, a failed save restores
+//the entry name had
+func (ks *Keystore) put(name, mailName string, priv ed25519.PrivateKey) (*Identity, error) {
+	if name == "" {
+		return nil, errors.New("empty identity name")
+	}
+	if len(priv) != ed25519.PrivateKeySize {
+		return nil, errors.New("bad private key")
+	}
+
+	pass, err := ks.pp.Password(name, true)
+	if err != nil {
+		return nil, err
+	}
+	pub := priv.Public().(ed25519.PublicKey)
+	cipherTxt, err := account.EncryptSubPriKey(priv, pub, pass)
+	if err != nil {
+		return nil, err
+	}
+
+	old, had := ks.entries[name]
+	ks.entries[name] = &Entry{
+		Name:      name,
+		MailName:  mailName,
+		PubKey:    bmail.ToAddress(pub).String(),
+		CipherKey: cipherTxt,
+		Created:   time.Now().UnixNano() / int64(time.Millisecond),
+	}
+	if err := ks.Save(); err != nil {
+		if had {
+			ks.entries[name] = old
+		} else {
+			delete(ks.entries, name)
+		}
+		return nil, err
+	}
+	return &Identity{name: name, mailName: mailName, pub: pub, priv: priv}, nil
+}
+
+//Unlock decrypt the key of an identity
+func (ks *Keystore) Unlock(name string) (*Identity, error) {
+	e, ok := ks.entries[name]
+	if !ok {
+		return nil, ErrNoIdentity
+	}
+
+	pass, err := ks.pp.Password(name, false)
+	if err != nil {
+		return nil, err
+	}
+	pub := bmail.Address(e.PubKey).ToPubKey()
+	priv, err := account.DecryptSubPriKey(pub, e.CipherKey, pass)
+	if err != nil || len(priv) != ed25519.PrivateKeySize {
+		return nil, ErrBadPassword
+	}
+
+	return &Identity{name: name, mailName: e.MailName, pub: pub, priv: priv}, nil
+}
+
+//SetMailName bind an identity to a mail name, the key is not touched
+func (ks *Keystore) SetMailName(name, mailName string) error {
+	e, ok := ks.entries[name]
+	if !ok {
+		return ErrNoIdentity
+	}
+	old := e.MailName
+	e.MailName = mailName
+	if err := ks.Save(); err != nil {
+		e.MailName = old
+		return err
+	}
+	return nil
+}
+
+func (ks *Keystore) Remove(name string) error {
+	e, ok := ks.entries[name]
+	if !ok {
+		return ErrNoIdentity
+	}
+	delete(ks.entries, name)
+	if err := ks.Save(); err != nil {
+		ks.entries[name] = e
+		return err
+	}
+	return nil
+}
+
+//Save write the keystore in the current format, the file is replaced at
+//once so a crash leaves the old one
+func (ks *Keystore) Save() error {
+	f := &file{Version: FileVersion}
+	for _, name := range ks.Names() {
+		f.Identities = append(f.Identities, ks.entries[name])
+	}
+	data, err := json.MarshalIndent(f, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(ks.path), 0700); err != nil {
+		return err
+	}
+	tmp := ks.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, ks.path)
+}
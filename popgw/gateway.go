@@ -0,0 +1,165 @@
+package popgw
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"github.com/realbmail/go-bmail-protocol/bmp"
+	"github.com/realbmail/go-bmail-protocol/bpop"
+	"github.com/realbmail/go-bmail-protocol/eml"
+	"net"
+	"sync"
+	"time"
+)
+
+//the gateway shows the bmail inbox of one wallet to a desktop mail client
+//over POP3 on localhost:
+//
+//mail client --USER/PASS--> gateway --CmdDownload--> bmail server
+//mail client --RETR--> gateway (mails opened by the wallet, as .eml)
+//mail client --DELE, QUIT--> gateway --CmdDelete--> bmail server
+//
+//the password is a local one the user gives the mail client, the wallet
+//is opened already and never sees it. IMAP is not served.
+
+const (
+	DefaultMaxMails = 200
+	DefaultTimeout  = 10 * time.Minute
+)
+
+var ErrClosed = errors.New("gateway closed")
+
+//Mailbox is the bmail server side, client.BMailClient is one
+type Mailbox interface {
+	ReceiveEnv(timeSince1970 int64, olderThanSince bool, maxCount int) ([]*bmp.BMailEnvelope, error)
+	DeleteMail(eids []uuid.UUID) ([]bpop.CmdResult, error)
+	DownloadAttachment(eid string, att *bmp.Attachment) ([]byte, error)
+}
+
+type Config struct {
+	Addr     string        //listen address, keep it local: 127.0.0.1:1110
+	Hostname string        //name in the greeting
+	Password string        //what the mail client logs in with
+	MaxMails int           //newest mails shown, DefaultMaxMails when 0
+	Timeout  time.Duration //idle time of a session, DefaultTimeout when 0
+	Wallet   eml.Wallet    //user name is its mail address
+	Mailbox  Mailbox
+	//Skipped is told of a mail left out of the mailbox, nil for none
+	Skipped func(eid string, err error)
+}
+
+type Gateway struct {
+	conf *Config
+	ln   net.Listener
+	lock sync.Mutex
+	conn map[net.Conn]struct{}
+	wg   sync.WaitGroup
+	quit bool
+	//POP3 allows one session of a mailbox at a time
+	busy bool
+}
+
+func New(conf *Config) (*Gateway, error) {
+	if conf.Wallet == nil || conf.Mailbox == nil || conf.Password == "" {
+		return nil, errors.New("gateway needs wallet, mailbox and password")
+	}
+	c := *conf
+	if c.MaxMails <= 0 {
+		c.MaxMails = DefaultMaxMails
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = DefaultTimeout
+	}
+	if c.Hostname == "" {
+		c.Hostname = "localhost"
+	}
+
+	return &Gateway{conf: &c, conn: make(map[net.Conn]struct{})}, nil
+}
+
+func (g *Gateway) ListenAndServe() error {
+	ln, err := net.Listen("tcp", g.conf.Addr)
+	if err != nil {
+		return err
+	}
+	return g.Serve(ln)
+}
+
+//Serve accept sessions on ln until Close
+func (g *Gateway) Serve(ln net.Listener) error {
+	g.lock.Lock()
+	if g.quit {
+		g.lock.Unlock()
+		ln.Close()
+		return ErrClosed
+	}
+	g.ln = ln
+	g.lock.Unlock()
+
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			g.lock.Lock()
+			quit := g.quit
+			g.lock.Unlock()
+			if quit {
+				return ErrClosed
+			}
+			return err
+		}
+
+		g.lock.Lock()
+		g.conn[c] = struct{}{}
+		g.wg.Add(1)
+		g.lock.Unlock()
+
+		go func() {
+			defer g.wg.Done()
+			newSession(g, c).serve()
+
+			g.lock.Lock()
+			delete(g.conn, c)
+			g.lock.Unlock()
+		}()
+	}
+}
+
+func (g *Gateway) Addr() net.Addr {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.ln == nil {
+		return nil
+	}
+	return g.ln.Addr()
+}
+
+//Close stop accepting, drop open sessions and wait for them
+func (g *Gateway) Close() error {
+	g.lock.Lock()
+	g.quit = true
+	if g.ln != nil {
+		g.ln.Close()
+	}
+	for c := range g.conn {
+		c.Close()
+	}
+	g.lock.Unlock()
+
+	g.wg.Wait()
+	return nil
+}
+
+func (g *Gateway) lockMailbox() bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.busy {
+		return false
+	}
+	g.busy = true
+	return true
+}
+
+func (g *Gateway) unlockMailbox() {
+	g.lock.Lock()
+	g.busy = false
+	g.lock.Unlock()
+}
@@ -0,0 +1,81 @@
+package popgw
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/realbmail/go-bmail-protocol/bmp"
+	"github.com/realbmail/go-bmail-protocol/bpop"
+	"github.com/realbmail/go-bmail-protocol/eml"
+	"time"
+)
+
+type mail struct {
+	eid     uuid.UUID //its string is the uid of UIDL, of 0x21-0x7E only
+	data    []byte    //the .eml, CRLF lines
+	deleted bool
+}
+
+//load fetch the newest mails and render them, mails the wallet can't open
+//(sent by itself, broken, eid no uuid) are left out
+func (g *Gateway) load() ([]*mail, error) {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	envs, err := g.conf.Mailbox.ReceiveEnv(now, true, g.conf.MaxMails)
+	if err != nil {
+		return nil, err
+	}
+
+	var mails []*mail
+	for _, env := range envs {
+		eid, err := uuid.Parse(env.Eid)
+		if err != nil {
+			g.skip(env.Eid, err)
+			continue
+		}
+		data, err := g.render(env)
+		if err != nil {
+			g.skip(env.Eid, err)
+			continue
+		}
+		mails = append(mails, &mail{eid: eid, data: data})
+	}
+	return mails, nil
+}
+
+func (g *Gateway) skip(eid string, err error) {
+	if g.conf.Skipped != nil {
+		g.conf.Skipped(eid, err)
+	}
+}
+
+func (g *Gateway) render(env *bmp.BMailEnvelope) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := eml.Render(buf, env, g.conf.Wallet, g.conf.Mailbox.DownloadAttachment); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+//commit delete the mails marked by DELE
+func (g *Gateway) commit(mails []*mail) error {
+	var eids []uuid.UUID
+	for _, m := range mails {
+		if m.deleted {
+			eids = append(eids, m.eid)
+		}
+	}
+	if len(eids) == 0 {
+		return nil
+	}
+
+	results, err := g.conf.Mailbox.DeleteMail(eids)
+	if err != nil {
+		return err
+	}
+	for _, r := range results {
+		if r.Result != bpop.MailDeleteSuccess && r.Result != bpop.MailNotFound {
+			return fmt.Errorf("delete mail %s failed:%d", r.Eid, r.Result)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,271 @@
+package popgw
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	stateAuth = iota
+	stateTrans
+)
+
+type session struct {
+	gw     *Gateway
+	c      net.Conn
+	r      *textproto.Reader
+	w      *bufio.Writer
+	state  int
+	user   string
+	mails  []*mail
+	locked bool
+}
+
+func newSession(g *Gateway, c net.Conn) *session {
+	return &session{
+		gw: g,
+		c:  c,
+		r:  textproto.NewReader(bufio.NewReader(c)),
+		w:  bufio.NewWriter(c),
+	}
+}
+
+func (s *session) ok(format string, a ...interface{}) {
+	fmt.Fprintf(s.w, "+OK %s\r\n", fmt.Sprintf(format, a...))
+	s.w.Flush()
+}
+
+func (s *session) err(format string, a ...interface{}) {
+	fmt.Fprintf(s.w, "-ERR %s\r\n", fmt.Sprintf(format, a...))
+	s.w.Flush()
+}
+
+func (s *session) serve() {
+	defer s.c.Close()
+	defer func() {
+		if s.locked {
+			s.gw.unlockMailbox()
+		}
+	}()
+
+	s.c.SetDeadline(time.Now().Add(s.gw.conf.Timeout))
+	s.ok("%s BMail POP3 gateway ready", s.gw.conf.Hostname)
+
+	for {
+		s.c.SetDeadline(time.Now().Add(s.gw.conf.Timeout))
+		line, err := s.r.ReadLine()
+		if err != nil {
+			//a lost session deletes nothing, RFC 1939
+			return
+		}
+
+		f := strings.Fields(line)
+		if len(f) == 0 {
+			s.err("empty command")
+			continue
+		}
+		cmd, args := strings.ToUpper(f[0]), f[1:]
+
+		if cmd == "QUIT" {
+			s.quit()
+			return
+		}
+		if cmd == "CAPA" {
+			s.capa()
+			continue
+		}
+		if cmd == "NOOP" && s.state == stateTrans {
+			s.ok("")
+			continue
+		}
+
+		if s.state == stateAuth {
+			s.auth(cmd, args)
+		} else {
+			s.trans(cmd, args)
+		}
+	}
+}
+
+func (s *session) capa() {
+	s.ok("capability list follows")
+	s.w.WriteString("USER\r\nUIDL\r\nTOP\r\n.\r\n")
+	s.w.Flush()
+}
+
+func (s *session) auth(cmd string, args []string) {
+	switch cmd {
+	case "USER":
+		if len(args) != 1 {
+			s.err("usage: USER name")
+			return
+		}
+		s.user = args[0]
+		s.ok("send PASS")
+	case "PASS":
+		if s.user == "" {
+			s.err("send USER first")
+			return
+		}
+		//the password may hold spaces
+		pass := strings.Join(args, " ")
+		userOk := subtle.ConstantTimeCompare([]byte(s.user), []byte(s.gw.conf.Wallet.MailAddress())) == 1
+		passOk := subtle.ConstantTimeCompare([]byte(pass), []byte(s.gw.conf.Password)) == 1
+		if !userOk || !passOk {
+			s.user = ""
+			s.err("invalid user or password")
+			return
+		}
+		if !s.gw.lockMailbox() {
+			s.err("mailbox in use")
+			return
+		}
+		s.locked = true
+
+		mails, err := s.gw.load()
+		if err != nil {
+			s.gw.unlockMailbox()
+			s.locked = false
+			s.err("load mailbox failed: %s", err)
+			return
+		}
+		s.mails = mails
+		s.state = stateTrans
+		s.ok("%d messages", len(mails))
+	default:
+		s.err("command not valid now")
+	}
+}
+
+//msg find the mail numbered by arg, deleted mails are not found
+func (s *session) msg(arg string) (int, *mail) {
+	n, err := strconv.Atoi(arg)
+	if err != nil || n < 1 || n > len(s.mails) || s.mails[n-1].deleted {
+		s.err("no such message")
+		return 0, nil
+	}
+	return n, s.mails[n-1]
+}
+
+func (s *session) trans(cmd string, args []string) {
+	switch cmd {
+	case "STAT":
+		cnt, size := 0, 0
+		for _, m := range s.mails {
+			if !m.deleted {
+				cnt++
+				size += len(m.data)
+			}
+		}
+		s.ok("%d %d", cnt, size)
+	case "LIST", "UIDL":
+		if len(args) > 0 {
+			n, m := s.msg(args[0])
+			if m != nil {
+				s.ok("%d %s", n, s.info(cmd, m))
+			}
+			return
+		}
+		s.ok("listing follows")
+		for i, m := range s.mails {
+			if !m.deleted {
+				fmt.Fprintf(s.w, "%d %s\r\n", i+1, s.info(cmd, m))
+			}
+		}
+		s.w.WriteString(".\r\n")
+		s.w.Flush()
+	case "RETR":
+		if len(args) != 1 {
+			s.err("usage: RETR msg")
+			return
+		}
+		if _, m := s.msg(args[0]); m != nil {
+			s.ok("%d octets", len(m.data))
+			s.send(m.data, -1)
+		}
+	case "TOP":
+		if len(args) != 2 {
+			s.err("usage: TOP msg n")
+			return
+		}
+		lines, err := strconv.Atoi(args[1])
+		if err != nil || lines < 0 {
+			s.err("bad line count")
+			return
+		}
+		if _, m := s.msg(args[0]); m != nil {
+			s.ok("top of message follows")
+			s.send(m.data, lines)
+		}
+	case "DELE":
+		if len(args) != 1 {
+			s.err("usage: DELE msg")
+			return
+		}
+		if n, m := s.msg(args[0]); m != nil {
+			m.deleted = true
+			s.ok("message %d deleted", n)
+		}
+	case "RSET":
+		for _, m := range s.mails {
+			m.deleted = false
+		}
+		s.ok("")
+	default:
+		s.err("command not valid now")
+	}
+}
+
+func (s *session) info(cmd string, m *mail) string {
+	if cmd == "UIDL" {
+		return m.eid.String()
+	}
+	return strconv.Itoa(len(m.data))
+}
+
+//send write data dot stuffed, lines < 0 sends all of it, else the head
+//and that many lines of the body
+func (s *session) send(data []byte, lines int) {
+	if lines >= 0 {
+		end := bytes.Index(data, []byte("\r\n\r\n"))
+		if end < 0 {
+			end = len(data)
+		} else {
+			end += 4
+			for ; lines > 0 && end < len(data); lines-- {
+				i := bytes.Index(data[end:], []byte("\r\n"))
+				if i < 0 {
+					end = len(data)
+					break
+				}
+				end += i + 2
+			}
+		}
+		data = data[:end]
+	}
+
+	dw := textproto.NewWriter(s.w).DotWriter()
+	dw.Write(data)
+	dw.Close()
+	s.w.Flush()
+}
+
+//quit commit DELE when the session got to the transaction state
+func (s *session) quit() {
+	if s.state != stateTrans {
+		s.ok("bye")
+		return
+	}
+	if err := s.gw.commit(s.mails); err != nil {
+		s.err("some deleted messages not removed: %s", err)
+		return
+	}
+	s.ok("bye")
+}
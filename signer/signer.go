@@ -0,0 +1,43 @@
+package signer
+
+import (
+	"github.com/realbmail/go-bmail-account"
+)
+
+//Signer and KeyAgreer are all the mail paths use of a private key, so the
+//key can stay in a keystore, an agent or a device. Both may fail, a remote
+//key is behind I/O.
+
+type Signer interface {
+	Address() bmail.Address
+	Sign(message []byte) ([]byte, error)
+}
+
+//KeyAgreer agree the aes key shared with a peer address
+type KeyAgreer interface {
+	Address() bmail.Address
+	AesKeyOf(peer bmail.Address) ([]byte, error)
+}
+
+//DHKey do X25519 by the static key of the address turned to X25519, a
+//recipient of forward secret mail needs it
+type DHKey interface {
+	Address() bmail.Address
+	X25519(peer []byte) ([]byte, error)
+}
+
+//Key is a mail identity that both signs and agrees keys
+type Key interface {
+	Signer
+	KeyAgreer
+	MailAddress() string
+}
+
+//WalletSigner make a bmail.Wallet a Signer
+type WalletSigner struct {
+	bmail.Wallet
+}
+
+func (ws WalletSigner) Sign(message []byte) ([]byte, error) {
+	return ws.Wallet.Sign(message), nil
+}
@@ -0,0 +1,94 @@
+package smtpgw
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/realbmail/go-bmail-account"
+	"github.com/realbmail/go-bmail-protocol/bmp"
+	"github.com/realbmail/go-bmail-protocol/bmp/client"
+	"github.com/realbmail/go-bmail-protocol/eml"
+	"time"
+)
+
+//badMessage is a message the gateway can't convert, the smtp client must
+//not try it again
+type badMessage struct {
+	err error
+}
+
+func (bm *badMessage) Error() string {
+	return "bad message: " + bm.err.Error()
+}
+
+//header addresses are not looked up, only the smtp recipients are
+func noResolve(string) (bmail.Address, error) {
+	return "", nil
+}
+
+//deliver convert msg to a bmail of the gateway account and send it. The
+//sender of the message is written on top of the body, the bmail itself is
+//from the gateway. A recipient is To or CC as the headers say, Bcc when
+//the headers don't list it.
+func (g *Gateway) deliver(from string, rcpts []*rcpt, msg []byte) (string, error) {
+	env, files, err := eml.Import(bytes.NewReader(msg), noResolve)
+	if err != nil {
+		return "", &badMessage{err}
+	}
+
+	types := make(map[string]int8)
+	for _, r := range env.RCPTs {
+		if _, ok := types[r.ToName]; !ok {
+			types[r.ToName] = r.RcptType
+		}
+	}
+	env.RCPTs = nil
+	for _, r := range rcpts {
+		typ, ok := types[r.name]
+		if !ok {
+			typ = bmp.RcpTypeBcc
+		}
+		env.RCPTs = append(env.RCPTs, &bmp.Recipient{
+			ToName:   r.name,
+			ToAddr:   r.addr,
+			RcptType: typ,
+		})
+	}
+
+	sender := env.FromName
+	if sender == "" {
+		sender = from
+	}
+	env.MailBody = fmt.Sprintf("From: %s\n\n%s", sender, env.MailBody)
+
+	//ids of the outside world are no bmail eids
+	env.Eid = uuid.New().String()
+	env.SessionID = env.Eid
+	env.InReplyTo = ""
+	env.References = nil
+	if env.DateSince1970 == 0 {
+		env.DateSince1970 = uint64(time.Now().UnixNano() / int64(time.Millisecond))
+	}
+
+	envKey, err := eml.Seal(env, g.conf.Wallet)
+	if err != nil {
+		return "", err
+	}
+
+	for _, f := range files {
+		att, data, err := client.SealAttachment(envKey, f.Name, f.Type, f.Data)
+		if err != nil {
+			return "", err
+		}
+		if err := g.conf.Sender.UploadAttachment(env.Eid, att, bytes.NewReader(data)); err != nil {
+			return "", err
+		}
+		env.Attachments = append(env.Attachments, att)
+	}
+
+	if err := g.conf.Sender.SendMail(env); err != nil {
+		return "", err
+	}
+
+	return env.Eid, nil
+}
@@ -0,0 +1,160 @@
+package smtpgw
+
+import (
+	"errors"
+	"fmt"
+	"github.com/realbmail/go-bmail-account"
+	"github.com/realbmail/go-bmail-protocol/bmp"
+	"github.com/realbmail/go-bmail-protocol/eml"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+//the gateway takes plain SMTP from local systems (alerts, CI) and sends
+//every message as a bmail of its own account:
+//
+//smtp client --MAIL/RCPT/DATA--> gateway --EnvelopeSyn--> bmail server
+//
+//recipients are checked at RCPT, a name the resolver does not know is
+//refused there so the smtp client gets the error and not a bounce.
+
+const (
+	DefaultMaxSize  int64 = 25 << 20
+	DefaultMaxRcpts       = 100
+	DefaultTimeout        = 5 * time.Minute
+)
+
+var ErrClosed = errors.New("gateway closed")
+
+//Sender deliver a sealed envelope, client.BMailClient is one
+type Sender interface {
+	SendMail(env *bmp.BMailEnvelope) error
+	UploadAttachment(eid string, att *bmp.Attachment, data io.Reader) error
+}
+
+type Config struct {
+	Addr     string        //listen address, keep it local: 127.0.0.1:2525
+	Hostname string        //name in the greeting
+	MaxSize  int64         //bytes of a message, DefaultMaxSize when 0
+	MaxRcpts int           //recipients of a message, DefaultMaxRcpts when 0
+	Timeout  time.Duration //idle time of a session, DefaultTimeout when 0
+	Wallet   eml.Wallet    //the account mails are sent from
+	Resolve  eml.Resolver  //block chain address of a recipient
+	Sender   Sender
+}
+
+type Gateway struct {
+	conf *Config
+	ln   net.Listener
+	lock sync.Mutex
+	conn map[net.Conn]struct{}
+	wg   sync.WaitGroup
+	quit bool
+}
+
+func New(conf *Config) (*Gateway, error) {
+	if conf.Wallet == nil || conf.Resolve == nil || conf.Sender == nil {
+		return nil, errors.New("gateway needs wallet, resolver and sender")
+	}
+	c := *conf
+	if c.MaxSize <= 0 {
+		c.MaxSize = DefaultMaxSize
+	}
+	if c.MaxRcpts <= 0 {
+		c.MaxRcpts = DefaultMaxRcpts
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = DefaultTimeout
+	}
+	if c.Hostname == "" {
+		c.Hostname = "localhost"
+	}
+
+	return &Gateway{conf: &c, conn: make(map[net.Conn]struct{})}, nil
+}
+
+func (g *Gateway) ListenAndServe() error {
+	ln, err := net.Listen("tcp", g.conf.Addr)
+	if err != nil {
+		return err
+	}
+	return g.Serve(ln)
+}
+
+//Serve accept sessions on ln until Close
+func (g *Gateway) Serve(ln net.Listener) error {
+	g.lock.Lock()
+	if g.quit {
+		g.lock.Unlock()
+		ln.Close()
+		return ErrClosed
+	}
+	g.ln = ln
+	g.lock.Unlock()
+
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			g.lock.Lock()
+			quit := g.quit
+			g.lock.Unlock()
+			if quit {
+				return ErrClosed
+			}
+			return err
+		}
+
+		g.lock.Lock()
+		g.conn[c] = struct{}{}
+		g.wg.Add(1)
+		g.lock.Unlock()
+
+		go func() {
+			defer g.wg.Done()
+			newSession(g, c).serve()
+
+			g.lock.Lock()
+			delete(g.conn, c)
+			g.lock.Unlock()
+		}()
+	}
+}
+
+func (g *Gateway) Addr() net.Addr {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.ln == nil {
+		return nil
+	}
+	return g.ln.Addr()
+}
+
+//Close stop accepting, drop open sessions and wait for them
+func (g *Gateway) Close() error {
+	g.lock.Lock()
+	g.quit = true
+	if g.ln != nil {
+		g.ln.Close()
+	}
+	for c := range g.conn {
+		c.Close()
+	}
+	g.lock.Unlock()
+
+	g.wg.Wait()
+	return nil
+}
+
+//resolve treat an empty address as an unknown name
+func (g *Gateway) resolve(name string) (bmail.Address, error) {
+	addr, err := g.conf.Resolve(name)
+	if err != nil {
+		return "", err
+	}
+	if addr == "" {
+		return "", fmt.Errorf("no bmail account [%s]", name)
+	}
+	return addr, nil
+}
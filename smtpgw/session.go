@@ -0,0 +1,216 @@
+package smtpgw
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/realbmail/go-bmail-account"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//longest command line, RFC 5321 asks for 512 at least
+const maxLineSize = 1024
+
+type rcpt struct {
+	name string
+	addr bmail.Address
+}
+
+type session struct {
+	gw    *Gateway
+	c     net.Conn
+	r     *textproto.Reader
+	w     *bufio.Writer
+	helo  string
+	from  string
+	rcpts []*rcpt
+}
+
+func newSession(g *Gateway, c net.Conn) *session {
+	br := bufio.NewReaderSize(c, maxLineSize)
+	return &session{
+		gw: g,
+		c:  c,
+		r:  textproto.NewReader(br),
+		w:  bufio.NewWriter(c),
+	}
+}
+
+func (s *session) reply(code int, format string, a ...interface{}) {
+	fmt.Fprintf(s.w, "%d %s\r\n", code, fmt.Sprintf(format, a...))
+	s.w.Flush()
+}
+
+func (s *session) reset() {
+	s.from = ""
+	s.rcpts = nil
+}
+
+func (s *session) serve() {
+	defer s.c.Close()
+
+	s.c.SetDeadline(time.Now().Add(s.gw.conf.Timeout))
+	s.reply(220, "%s BMail SMTP gateway ready", s.gw.conf.Hostname)
+
+	for {
+		s.c.SetDeadline(time.Now().Add(s.gw.conf.Timeout))
+		line, err := s.r.ReadLine()
+		if err != nil {
+			return
+		}
+		if len(line) > maxLineSize {
+			s.reply(500, "line too long")
+			continue
+		}
+
+		verb, arg := line, ""
+		if i := strings.IndexByte(line, ' '); i > 0 {
+			verb, arg = line[:i], strings.TrimSpace(line[i+1:])
+		}
+
+		switch strings.ToUpper(verb) {
+		case "HELO":
+			s.helo = arg
+			s.reset()
+			s.reply(250, "%s", s.gw.conf.Hostname)
+		case "EHLO":
+			s.helo = arg
+			s.reset()
+			fmt.Fprintf(s.w, "250-%s\r\n", s.gw.conf.Hostname)
+			fmt.Fprintf(s.w, "250-SIZE %d\r\n", s.gw.conf.MaxSize)
+			s.reply(250, "8BITMIME")
+		case "MAIL":
+			s.mail(arg)
+		case "RCPT":
+			s.rcpt(arg)
+		case "DATA":
+			if !s.data() {
+				return
+			}
+		case "RSET":
+			s.reset()
+			s.reply(250, "OK")
+		case "NOOP":
+			s.reply(250, "OK")
+		case "VRFY":
+			s.reply(252, "send some mail, I'll try my best")
+		case "QUIT":
+			s.reply(221, "bye")
+			return
+		default:
+			s.reply(502, "command not implemented")
+		}
+	}
+}
+
+//path take the address out of "FROM:<a@b> SIZE=10"
+func path(arg, prefix string) (string, []string, bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", nil, false
+	}
+	arg = strings.TrimSpace(arg[len(prefix):])
+	if !strings.HasPrefix(arg, "<") {
+		return "", nil, false
+	}
+	end := strings.IndexByte(arg, '>')
+	if end < 0 {
+		return "", nil, false
+	}
+	return arg[1:end], strings.Fields(arg[end+1:]), true
+}
+
+func (s *session) mail(arg string) {
+	if s.helo == "" {
+		s.reply(503, "say HELO first")
+		return
+	}
+	if s.from != "" {
+		s.reply(503, "sender already given")
+		return
+	}
+	from, params, ok := path(arg, "FROM:")
+	if !ok {
+		s.reply(501, "syntax: MAIL FROM:<address>")
+		return
+	}
+	for _, p := range params {
+		if strings.HasPrefix(strings.ToUpper(p), "SIZE=") {
+			size, err := strconv.ParseInt(p[5:], 10, 64)
+			if err == nil && size > s.gw.conf.MaxSize {
+				s.reply(552, "message too large")
+				return
+			}
+		}
+	}
+	//the null sender of bounces is kept as it is
+	if from == "" {
+		from = "<>"
+	}
+	s.from = from
+	s.reply(250, "OK")
+}
+
+func (s *session) rcpt(arg string) {
+	if s.from == "" {
+		s.reply(503, "need MAIL first")
+		return
+	}
+	name, _, ok := path(arg, "TO:")
+	if !ok || name == "" {
+		s.reply(501, "syntax: RCPT TO:<address>")
+		return
+	}
+	if len(s.rcpts) >= s.gw.conf.MaxRcpts {
+		s.reply(452, "too many recipients")
+		return
+	}
+	addr, err := s.gw.resolve(name)
+	if err != nil {
+		s.reply(550, "no such bmail user <%s>", name)
+		return
+	}
+	s.rcpts = append(s.rcpts, &rcpt{name: name, addr: addr})
+	s.reply(250, "OK")
+}
+
+//data return false when the session is lost
+func (s *session) data() bool {
+	if len(s.rcpts) == 0 {
+		s.reply(503, "need RCPT first")
+		return true
+	}
+	s.reply(354, "end data with <CR><LF>.<CR><LF>")
+
+	dr := s.r.DotReader()
+	msg, err := ioutil.ReadAll(io.LimitReader(dr, s.gw.conf.MaxSize+1))
+	if err != nil {
+		return false
+	}
+	if int64(len(msg)) > s.gw.conf.MaxSize {
+		//drain the rest so the next command is read right
+		if _, err := io.Copy(ioutil.Discard, dr); err != nil {
+			return false
+		}
+		s.reset()
+		s.reply(552, "message too large")
+		return true
+	}
+
+	eid, err := s.gw.deliver(s.from, s.rcpts, msg)
+	s.reset()
+	if err != nil {
+		if _, ok := err.(*badMessage); ok {
+			s.reply(554, "%s", err)
+		} else {
+			s.reply(451, "delivery failed: %s", err)
+		}
+		return true
+	}
+	s.reply(250, "OK queued as %s", eid)
+	return true
+}
@@ -0,0 +1,115 @@
+package spec
+
+//Machine is what a side may send in each state of a connection, By is
+//the side sending Msg, a message type of translayer by its name
+type Machine struct {
+	Name        string       `json:"name"`
+	Stack       string       `json:"stack"`
+	Start       string       `json:"start"`
+	Note        string       `json:"note,omitempty"`
+	Transitions []Transition `json:"transitions"`
+}
+
+type Transition struct {
+	From string `json:"from"`
+	By   string `json:"by"`
+	Msg  string `json:"msg"`
+	To   string `json:"to"`
+}
+
+//request is a request of the client from state from and its answer
+func request(from, req, resp, to string) []Transition {
+	return []Transition{
+		{From: from, By: Client, Msg: req, To: req},
+		{From: req, By: Server, Msg: resp, To: to},
+	}
+}
+
+func join(ts ...[]Transition) []Transition {
+	var r []Transition
+	for _, t := range ts {
+		r = append(r, t...)
+	}
+	return r
+}
+
+var machines = []Machine{
+	{
+		Name:  "bmtp",
+		Stack: StackBM,
+		Start: "start",
+		Note: "every request carries the sn of the session, an answer gives the sn " +
+			"of the next request in NewSn; an attachment is sent chunk by chunk, " +
+			"each SEND_ATTACHMNENT answered before the next",
+		Transitions: join(
+			request("start", "HELLO", "HELLO_ACK", "session"),
+			request("session", "SEND_ENVELOPE", "RESP_ENVELOPE", "session"),
+			request("session", "SEND_CRYPT_ENVELOPE", "RESP_CRYPT_ENVELOPE", "session"),
+			request("session", "SEND_ATTACHMNENT", "RESP_ATTACHMENT", "session"),
+			request("session", "RETR_ATTACHMENT", "RETR_ATTACHMENT_RESP", "session"),
+		),
+	},
+	{
+		Name:  "bpop",
+		Stack: StackBM,
+		Start: "start",
+		Transitions: join(
+			request("start", "HELLO", "HELLO_ACK", "session"),
+			request("session", "STAT", "STAT_RESP", "session"),
+			request("session", "LIST", "LIST_RESP", "session"),
+			request("session", "RETR", "RETR_RESP", "session"),
+			request("session", "DELETE", "DELETE_RESP", "session"),
+		),
+	},
+	{
+		Name:  "contact",
+		Stack: StackBM,
+		Start: "start",
+		Note: "the answer of CONTACT_ADD is a ContactAddResp encrypted with its iv, " +
+			"it has no message type yet; CONTACT_DEL and CONTACT_PULL are reserved",
+		Transitions: join(
+			request("start", "CONTACT_HELLO", "CONTACT_HELLO_RESP", "session"),
+			[]Transition{{From: "session", By: Client, Msg: "CONTACT_ADD", To: "CONTACT_ADD"}},
+		),
+	},
+	{
+		Name:  "bmp",
+		Stack: StackJSON,
+		Start: "start",
+		Note: "HELLO is always BMAILVER1, the frames after are in the version the " +
+			"client chose from SupportVersion of HELLO_ACK; one request a connection",
+		Transitions: join(
+			request("start", "HELLO", "HELLO_ACK", "session"),
+			request("session", "SEND_CRYPT_ENVELOPE", "RESP_CRYPT_ENVELOPE", "done"),
+			request("session", "SEND_ATTACHMNENT", "RESP_ATTACHMENT", "done"),
+			request("session", "RETR_ATTACHMENT", "RETR_ATTACHMENT_RESP", "done"),
+			request("session", "CHECK_ATTACHMENT", "CHECK_ATTACHMENT_RESP", "done"),
+		),
+	},
+	{
+		Name:  "bpop-command",
+		Stack: StackJSON,
+		Start: "session",
+		Note: "after the HELLO of bmp, one command a connection; the server " +
+			"answers IDLE by IDLE_NOTIFY until the client sends IDLE_DONE, the " +
+			"last notify is of Kind done",
+		Transitions: join(
+			request("session", "STAT", "STAT_RESP", "done"),
+			request("session", "RETR", "RETR_RESP", "done"),
+			request("session", "DELETE", "DELETE_RESP", "done"),
+			request("session", "SYNC", "SYNC_RESP", "done"),
+			request("session", "SET_FLAGS", "SET_FLAGS_RESP", "done"),
+			request("session", "MOVE", "MOVE_RESP", "done"),
+			request("session", "LIST_FOLDERS", "LIST_FOLDERS_RESP", "done"),
+			request("session", "THREAD", "THREAD_RESP", "done"),
+			request("session", "SEARCH", "SEARCH_RESP", "done"),
+			[]Transition{
+				{From: "session", By: Client, Msg: "IDLE", To: "idle"},
+				{From: "idle", By: Server, Msg: "IDLE_NOTIFY", To: "idle"},
+				{From: "idle", By: Client, Msg: "IDLE_DONE", To: "closing"},
+				{From: "closing", By: Server, Msg: "IDLE_NOTIFY", To: "closing"},
+				{From: "closing", By: Server, Msg: "IDLE_NOTIFY", To: "done"},
+			},
+		),
+	},
+}
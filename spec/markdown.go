@@ -0,0 +1,179 @@
+package spec
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+const intro = `# BMail protocol, revision %d
+
+Written by cmd/bmspec from the Go types, do not edit. bmail.json is this
+document for programs, vectors.json the golden frames the tests check,
+bmailv2.cddl the schema of BMAILVER2.
+
+## Frames
+
+A frame is a head of 8 bytes, big endian, then dataLen bytes of data.
+
+| field | layout | |
+|---|---|---|
+| ver | u16 | version of the data, %s, best first |
+| typ | u16 | message type in the low 12 bits, compress codec in the high 4 |
+| dataLen | u32 | bytes after the head |
+
+Data of a typ with a codec in the high bits is compressed by it (1 gzip, 2
+zstd), a side only compresses with a codec the peer listed, in HELLO,
+HELLO_ACK or the Accept of a command.
+
+Two stacks use the head. Stack bmprotocol packs the fields of a message in
+order by their bm layout:
+
+| bm | |
+|---|---|
+| short | u16 length + bytes |
+| long | u32 length + bytes |
+| u8 u16 u32 u64 | big endian unsigned, a bool is u8 |
+| struct | the fields of the struct in place |
+| n16 of x, n32 of x | u16 or u32 count + the elements |
+
+Stack bmp (packages bmp and bpop) packs a message as JSON in BMAILVER1. In
+BMAILVER2 both stacks pack a message as CBOR, a map from the cbor key of a
+field to its value, see bmailv2.cddl. Hashes and signatures are over the
+canonical JSON of bmp and the bm layout of bmprotocol in every version,
+taken from the struct decoded, so a field a peer does not know breaks them:
+fields are added with a new version.
+
+Message types:
+
+| id | name | frames |
+|---|---|---|
+`
+
+//Markdown give the document of s for people
+func (s *Spec) Markdown() []byte {
+	b := &bytes.Buffer{}
+
+	var vers []string
+	for _, v := range s.Versions {
+		vers = append(vers, fmt.Sprint(v))
+	}
+	fmt.Fprintf(b, intro, s.Revision, strings.Join(vers, ", "))
+
+	for _, mt := range s.Types {
+		var fs []string
+		for _, f := range mt.Frames {
+			fs = append(fs, fmt.Sprintf("%s `%s`", f.Stack, f.Go))
+		}
+		if mt.Note != "" {
+			fs = append(fs, mt.Note)
+		}
+		fmt.Fprintf(b, "| %d | %s | %s |\n", mt.Id, mt.Name, strings.Join(fs, ", "))
+	}
+
+	b.WriteString("\n## Messages\n")
+	for _, mt := range s.Types {
+		if len(mt.Frames) == 0 {
+			continue
+		}
+		fmt.Fprintf(b, "\n### %s (%d)\n", mt.Name, mt.Id)
+		for _, f := range mt.Frames {
+			writeFrame(b, &f)
+		}
+	}
+
+	b.WriteString("\n## State machines\n")
+	for _, m := range s.Machines {
+		fmt.Fprintf(b, "\n### %s\n\nStack %s, from state %s.", m.Name, m.Stack, m.Start)
+		if m.Note != "" {
+			fmt.Fprintf(b, " %s.", upper(m.Note))
+		}
+		b.WriteString("\n\n| state | by | message | next |\n|---|---|---|---|\n")
+		for _, t := range m.Transitions {
+			fmt.Fprintf(b, "| %s | %s | %s | %s |\n", t.From, t.By, t.Msg, t.To)
+		}
+	}
+
+	b.WriteString(`
+## Canonical JSON
+
+A hash of bmp is the sha256 of the JSON encoding/json of Go writes, byte for
+byte:
+
+- an object holds the fields of its message in the order of its table, by
+  the name of the json column, no space or newline anywhere
+- a field of json ` + "`,omitempty`" + ` is left out when it is 0, false, "", null
+  or an empty list
+- a field of json ` + "`,text`" + ` is a string of its text form, a uuid in lower
+  case hex as 8-4-4-4-12
+- bytes are standard base64 with padding, null when not set; bytes[N] is a
+  list of N numbers
+- a list, or an object of a pointer, not set is null
+- numbers are decimal, no fraction or exponent
+- a string is UTF-8, bad bytes are U+FFFD. It escapes " and \ by a \, new
+  line, return, tab, backspace and form feed as \n \r \t \b \f, other
+  bytes below 0x20 and < > & U+2028 U+2029 as \u and 4 lower case hex
+  digits; Go before 1.22 wrote backspace and form feed by \u too
+
+inputs of a vector is the JSON each of its hashes is taken over.
+
+## Vectors
+
+vectors.json holds a frame of each message of the stacks in each version it
+is sent in, every field set. frame is the hex of the head, the data and the
+tail of file bytes of a chunk, uncompressed. value is the message as JSON,
+hashes the sha256 its signatures cover, inputs what the sha256 is of. An
+implementation unpacks frame to value and packs value to frame.
+`)
+	return b.Bytes()
+}
+
+func writeFrame(b *bytes.Buffer, f *Frame) {
+	vers := make([]string, len(f.Versions))
+	for i, v := range f.Versions {
+		vers[i] = fmt.Sprint(v)
+	}
+	fmt.Fprintf(b, "\n%s `%s`, %s to %s, versions %s.\n", f.Stack, f.Go, f.From, other(f.From), strings.Join(vers, ", "))
+	if f.Sign != "" {
+		fmt.Fprintf(b, "Signing: %s.\n", f.Sign)
+	}
+	if f.Tail != "" {
+		fmt.Fprintf(b, "After the fields: %s.\n", f.Tail)
+	}
+	if len(f.Fields) == 0 {
+		return
+	}
+
+	if f.Stack == StackBM {
+		b.WriteString("\n| field | type | bm | cbor |\n|---|---|---|---|\n")
+	} else {
+		b.WriteString("\n| field | type | json | cbor |\n|---|---|---|---|\n")
+	}
+	writeFields(b, f.Fields, "")
+}
+
+func writeFields(b *bytes.Buffer, fs []Field, prefix string) {
+	for _, fd := range fs {
+		layout := fd.BM
+		if layout == "" {
+			layout = fd.JSON
+		}
+		cbor := ""
+		if fd.Cbor != 0 {
+			cbor = fmt.Sprint(fd.Cbor)
+		}
+		fmt.Fprintf(b, "| %s%s | %s | %s | %s |\n", prefix, fd.Name, fd.Type, layout, cbor)
+		writeFields(b, fd.Fields, prefix+fd.Name+".")
+	}
+}
+
+func other(side string) string {
+	if side == Client {
+		return Server
+	}
+	return Client
+}
+
+func upper(s string) string {
+	return strings.ToUpper(s[:1]) + s[1:]
+}
@@ -0,0 +1,435 @@
+package spec
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"github.com/realbmail/go-bmail-protocol/bmp"
+	"github.com/realbmail/go-bmail-protocol/bmprotocol"
+	"github.com/realbmail/go-bmail-protocol/bpop"
+	"github.com/realbmail/go-bmail-protocol/translayer"
+	"reflect"
+	"strings"
+)
+
+//the protocol document is built from the Go types, cmd/bmspec writes it to
+//bmail.json, PROTOCOL.md and vectors.json of this directory and the tests
+//check the files are what the types give. Raise Revision when the wire
+//changes.
+const Revision = 1
+
+const (
+	//StackBM is package bmprotocol, fields packed by the bm layout
+	StackBM = "bmprotocol"
+	//StackJSON is package bmp and bpop, fields packed as JSON
+	StackJSON = "bmp"
+
+	Client = "client"
+	Server = "server"
+)
+
+type Spec struct {
+	Revision int       `json:"revision"`
+	Versions []uint16  `json:"versions"`
+	Head     []Field   `json:"head"`
+	Types    []MsgType `json:"types"`
+	Machines []Machine `json:"machines"`
+}
+
+//MsgType is a message type of translayer and the frames that carry it
+type MsgType struct {
+	Id     uint16  `json:"id"`
+	Name   string  `json:"name"`
+	Note   string  `json:"note,omitempty"`
+	Frames []Frame `json:"frames,omitempty"`
+}
+
+type Frame struct {
+	Stack    string   `json:"stack"`
+	Go       string   `json:"go"`
+	From     string   `json:"from"`
+	Versions []uint16 `json:"versions"`
+	Sign     string   `json:"sign,omitempty"`
+	Tail     string   `json:"tail,omitempty"` //bytes after the fields
+	Fields   []Field  `json:"fields,omitempty"`
+}
+
+//Field is a field of a frame. BM is its bm layout, JSON its name in JSON
+//and Cbor its key in BMAILVER2, Fields are the fields of a struct in it.
+type Field struct {
+	Name   string  `json:"name"`
+	Type   string  `json:"type"`
+	BM     string  `json:"bm,omitempty"`
+	JSON   string  `json:"json,omitempty"`
+	Cbor   uint64  `json:"cbor,omitempty"`
+	Fields []Field `json:"fields,omitempty"`
+}
+
+//frame tells how a message type is sent by one stack, msg gives an empty
+//message with its interfaces set, nil for a frame of header only
+type frame struct {
+	typ   uint16
+	stack string
+	from  string
+	msg   func() interface{}
+	v1    bool              //BMAILVER1 in every version
+	hand  map[string]string //bm layout of a message packed by hand
+	sign  string
+	tail  string
+	fix   func(v interface{}) //make an example pass the checks of Pack, or show an edge
+}
+
+const (
+	signSn    = "EnvelopeSig.Sn is the sn of the session, the sn of HELLO_ACK then NewSn of each answer; EnvelopeSig.Sig signs it"
+	signResp  = "Sn is the sn answered, NewSn the sn of the next frame"
+	signSyn   = "Sig = sign(SN of HELOACK) by the sender, or by the sub-key of Cert"
+	signAck   = "Hash = sha256(JSON of CmdCxt), Sig = sign(Hash) by the SrvBca of HELOACK"
+	signFile  = signSyn + "; Hash = sha256(file bytes)"
+	signFAck  = "Sig = sign(Hash) by the SrvBca of HELOACK"
+	signCheck = "Hash = sha256(JSON of the AttachmentCheck), Sig = sign(Hash) by the SrvBca of HELOACK"
+	signEnv   = signSyn + "; Hash = sha256(JSON of Env); Env.FromSig = sign(sha256(JSON of Env without FromSig)) by FromAddr or the sub-key of FromCert"
+	signEAck  = "Sig = sign(Hash of EnvelopeSyn) by the SrvBca of HELOACK"
+
+	helloLayout = "n16 of u8"
+)
+
+func cmdSyn(cmd bpop.Command) func() interface{} {
+	return func() interface{} {
+		return &bpop.CommandSyn{Cmd: reflect.New(reflect.TypeOf(cmd).Elem()).Interface().(bpop.Command)}
+	}
+}
+
+func cmdAck(cxt bpop.CommandContent) func() interface{} {
+	return func() interface{} {
+		return &bpop.CommandAck{CmdCxt: reflect.New(reflect.TypeOf(cxt).Elem()).Interface().(bpop.CommandContent)}
+	}
+}
+
+var frames = []frame{
+	{typ: translayer.HELLO, stack: StackBM, from: Client, msg: func() interface{} { return bmprotocol.NewBMHello() },
+		hand: map[string]string{"accept": helloLayout, "versions": helloLayout}},
+	{typ: translayer.HELLO, stack: StackJSON, from: Client, v1: true},
+	{typ: translayer.HELLO_ACK, stack: StackBM, from: Server, msg: func() interface{} { return bmprotocol.NewBMHelloACK(nil) },
+		hand: map[string]string{"sn": "short", "accept": helloLayout, "versions": helloLayout},
+		sign: "sn starts the session"},
+	{typ: translayer.HELLO_ACK, stack: StackJSON, from: Server, msg: func() interface{} { return &bmp.HELOACK{} },
+		sign: "SN starts the session, the requests sign it"},
+
+	{typ: translayer.SEND_ENVELOPE, stack: StackBM, from: Client, msg: func() interface{} { return bmprotocol.NewSendEnvelope() }, sign: signSn},
+	{typ: translayer.RESP_ENVELOPE, stack: StackBM, from: Server, msg: func() interface{} { return bmprotocol.NewRespSendEnvelope() },
+		sign: signResp + "; CxtHashSig is the signature of the server over the envelope"},
+	{typ: translayer.SEND_CRYPT_ENVELOPE, stack: StackBM, from: Client, msg: func() interface{} { return bmprotocol.NewSendCryptEnvelope() }, sign: signSn},
+	{typ: translayer.SEND_CRYPT_ENVELOPE, stack: StackJSON, from: Client, msg: func() interface{} { return &bmp.EnvelopeSyn{} }, sign: signEnv,
+		fix: fixEnvelopeSyn},
+	{typ: translayer.RESP_CRYPT_ENVELOPE, stack: StackBM, from: Server, msg: func() interface{} { return bmprotocol.NewRespSendCryptEnvelope() },
+		sign: signResp + "; CxtHashSig is the signature of the server over the envelope"},
+	{typ: translayer.RESP_CRYPT_ENVELOPE, stack: StackJSON, from: Server, msg: func() interface{} { return &bmp.EnvelopeAck{} }, sign: signEAck},
+
+	{typ: translayer.SEND_ATTACHMNENT, stack: StackBM, from: Client, msg: func() interface{} { return bmprotocol.NewSendAttachment() },
+		v1: true, sign: signSn, tail: "Length bytes of the file from Offset", fix: fixSendAttachment},
+	{typ: translayer.SEND_ATTACHMNENT, stack: StackJSON, from: Client, msg: func() interface{} { return &bmp.AttachmentSyn{} },
+		sign: signFile, tail: "Size raw bytes of the file, after the frame"},
+	{typ: translayer.RESP_ATTACHMENT, stack: StackBM, from: Server, msg: func() interface{} { return bmprotocol.NewRespSendAttachment() }, sign: signResp},
+	{typ: translayer.RESP_ATTACHMENT, stack: StackJSON, from: Server, msg: func() interface{} { return &bmp.AttachmentAck{} }, sign: signFAck},
+
+	{typ: translayer.STAT, stack: StackBM, from: Client, msg: func() interface{} { return bmprotocol.NewBPOPStat() }},
+	{typ: translayer.STAT, stack: StackJSON, from: Client, msg: cmdSyn(&bpop.CmdState{}), sign: signSyn},
+	{typ: translayer.STAT_RESP, stack: StackBM, from: Server, msg: func() interface{} { return bmprotocol.NewBPOPStatResp() }},
+	{typ: translayer.STAT_RESP, stack: StackJSON, from: Server, msg: cmdAck(&bpop.CmdStateAck{}), sign: signAck},
+	{typ: translayer.LIST, stack: StackBM, from: Client, msg: func() interface{} { return bmprotocol.NewBPOPList() }},
+	{typ: translayer.LIST_RESP, stack: StackBM, from: Server, msg: func() interface{} { return bmprotocol.NewBPOPListResp() }},
+	{typ: translayer.RETR, stack: StackBM, from: Client, msg: func() interface{} { return bmprotocol.NewBPOPRetr() }},
+	{typ: translayer.RETR, stack: StackJSON, from: Client, msg: cmdSyn(&bpop.CmdDownload{}), sign: signSyn},
+	{typ: translayer.RETR_RESP, stack: StackBM, from: Server, msg: func() interface{} { return bmprotocol.NewBPOPRetrResp() }},
+	{typ: translayer.RETR_RESP, stack: StackJSON, from: Server, msg: cmdAck(&bpop.CmdDownloadAck{}), sign: signAck},
+	{typ: translayer.DELETE, stack: StackBM, from: Client, msg: func() interface{} { return bmprotocol.NewBPOPDelete() },
+		sign: "Sn is the sn of the session, Sig signs it"},
+	{typ: translayer.DELETE, stack: StackJSON, from: Client, msg: cmdSyn(&bpop.CmdDelete{}), sign: signSyn},
+	{typ: translayer.DELETE_RESP, stack: StackBM, from: Server, msg: func() interface{} { return bmprotocol.NewBPOPDeleteResp() }},
+	{typ: translayer.DELETE_RESP, stack: StackJSON, from: Server, msg: cmdAck(&bpop.CmdDeleteAck{}), sign: signAck},
+
+	{typ: translayer.CONTACT_HELLO, stack: StackBM, from: Client, msg: func() interface{} { return bmprotocol.NewCryptContactHello() },
+		sign: "cipherText is the packed ContactHello {SelfMA, sn from the client} encrypted with iv"},
+	{typ: translayer.CONTACT_HELLO_RESP, stack: StackBM, from: Server, msg: func() interface{} { return bmprotocol.NewContactHelloResp() },
+		sign: "sigClientSN = sign(clientSN) by the server, serverSN is the sn of the next request"},
+	{typ: translayer.CONTACT_ADD, stack: StackBM, from: Client, msg: func() interface{} { return bmprotocol.NewCryptContactAdd() },
+		sign: "sigServerSn = sign(serverSN) by the client; cipherTxt is the packed ContactAdd encrypted with iv"},
+
+	{typ: translayer.SYNC, stack: StackJSON, from: Client, msg: cmdSyn(&bpop.CmdSync{}), sign: signSyn},
+	{typ: translayer.SYNC_RESP, stack: StackJSON, from: Server, msg: cmdAck(&bpop.CmdSyncAck{}), sign: signAck},
+	{typ: translayer.SET_FLAGS, stack: StackJSON, from: Client, msg: cmdSyn(&bpop.CmdSetFlags{}), sign: signSyn},
+	{typ: translayer.SET_FLAGS_RESP, stack: StackJSON, from: Server, msg: cmdAck(&bpop.CmdSetFlagsAck{}), sign: signAck},
+	{typ: translayer.MOVE, stack: StackJSON, from: Client, msg: cmdSyn(&bpop.CmdMove{}), sign: signSyn},
+	{typ: translayer.MOVE_RESP, stack: StackJSON, from: Server, msg: cmdAck(&bpop.CmdMoveAck{}), sign: signAck},
+	{typ: translayer.LIST_FOLDERS, stack: StackJSON, from: Client, msg: cmdSyn(&bpop.CmdListFolders{}), sign: signSyn},
+	{typ: translayer.LIST_FOLDERS_RESP, stack: StackJSON, from: Server, msg: cmdAck(&bpop.CmdListFoldersAck{}), sign: signAck},
+	{typ: translayer.IDLE, stack: StackJSON, from: Client, msg: cmdSyn(&bpop.CmdIdle{}), sign: signSyn},
+	{typ: translayer.IDLE_NOTIFY, stack: StackJSON, from: Server, msg: cmdAck(&bpop.CmdIdleNotify{}),
+		sign: signAck + "; CmdCxt.SN is the SN of HELOACK, Seq is 0 in the first notify and goes up by one each notify"},
+	{typ: translayer.IDLE_DONE, stack: StackJSON, from: Client},
+	{typ: translayer.THREAD, stack: StackJSON, from: Client, msg: cmdSyn(&bpop.CmdThread{}), sign: signSyn},
+	{typ: translayer.THREAD_RESP, stack: StackJSON, from: Server, msg: cmdAck(&bpop.CmdThreadAck{}), sign: signAck},
+	{typ: translayer.SEARCH, stack: StackJSON, from: Client, msg: cmdSyn(&bpop.CmdSearch{}), sign: signSyn},
+	{typ: translayer.SEARCH_RESP, stack: StackJSON, from: Server, msg: cmdAck(&bpop.CmdSearchAck{}), sign: signAck},
+
+	{typ: translayer.RETR_ATTACHMENT, stack: StackBM, from: Client, msg: func() interface{} { return bmprotocol.NewRetrAttachment() }, sign: signSn},
+	{typ: translayer.RETR_ATTACHMENT, stack: StackJSON, from: Client, msg: func() interface{} { return &bmp.AttachmentRetr{} }, sign: signFile},
+	{typ: translayer.RETR_ATTACHMENT_RESP, stack: StackBM, from: Server, msg: func() interface{} { return bmprotocol.NewRespRetrAttachment() },
+		v1: true, tail: "Length bytes of the file from Offset", fix: fixRespRetrAttachment},
+	{typ: translayer.RETR_ATTACHMENT_RESP, stack: StackJSON, from: Server, msg: func() interface{} { return &bmp.AttachmentRetrAck{} },
+		sign: signFAck, tail: "Size raw bytes of the file, after the frame"},
+	{typ: translayer.CHECK_ATTACHMENT, stack: StackJSON, from: Client, msg: func() interface{} { return &bmp.AttachmentCheck{} }, sign: signSyn},
+	{typ: translayer.CHECK_ATTACHMENT_RESP, stack: StackJSON, from: Server, msg: func() interface{} { return &bmp.AttachmentCheckAck{} }, sign: signCheck},
+}
+
+//notes of the types no frame carries
+var typeNotes = map[uint16]string{
+	translayer.CONTACT_DEL:  "reserved, no frame yet",
+	translayer.CONTACT_PULL: "reserved, no frame yet",
+}
+
+var headType = reflect.TypeOf(translayer.BMTransLayer{})
+var textMarshaler = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
+//Generate build the spec from the Go types
+func Generate() (*Spec, error) {
+	s := &Spec{
+		Revision: Revision,
+		Versions: translayer.SupportedVersion(),
+		Head: []Field{
+			{Name: "ver", Type: "uint16", BM: "u16"},
+			{Name: "typ", Type: "uint16", BM: "u16"},
+			{Name: "dataLen", Type: "uint32", BM: "u32"},
+		},
+		Machines: machines,
+	}
+
+	for typ := translayer.MIN_TYP + 1; typ < translayer.MAX_TYP; typ++ {
+		mt := MsgType{Id: typ, Name: translayer.MsgTypeName(typ), Note: typeNotes[typ]}
+		for i := range frames {
+			if frames[i].typ != typ {
+				continue
+			}
+			f, err := frames[i].describe()
+			if err != nil {
+				return nil, err
+			}
+			mt.Frames = append(mt.Frames, f)
+		}
+		s.Types = append(s.Types, mt)
+	}
+	return s, nil
+}
+
+//versions give the versions the frame is sent in
+func (f *frame) versions() []uint16 {
+	if f.v1 {
+		return []uint16{translayer.BMAILVER1}
+	}
+	return []uint16{translayer.BMAILVER1, translayer.BMAILVER2}
+}
+
+func (f *frame) describe() (Frame, error) {
+	fr := Frame{
+		Stack:    f.stack,
+		Go:       "header only",
+		From:     f.from,
+		Versions: f.versions(),
+		Sign:     f.sign,
+		Tail:     f.tail,
+	}
+	if f.msg == nil {
+		return fr, nil
+	}
+
+	v := reflect.ValueOf(f.msg())
+	if err := f.checkType(v.Interface()); err != nil {
+		return fr, err
+	}
+	fr.Go = v.Type().Elem().String()
+
+	var err error
+	fr.Fields, err = f.fields(v.Elem(), 0)
+	return fr, err
+}
+
+//checkType check the message tells the type it is listed by
+func (f *frame) checkType(m interface{}) error {
+	var typ uint16
+	switch m := m.(type) {
+	case interface{ GetMsgType() uint16 }:
+		typ = m.GetMsgType()
+	case interface{ MsgType() uint16 }:
+		typ = m.MsgType()
+	default:
+		return fmt.Errorf("spec: %T has no message type", m)
+	}
+	if typ != f.typ {
+		return fmt.Errorf("spec: %T is %s, listed as %s", m,
+			translayer.MsgTypeName(typ), translayer.MsgTypeName(f.typ))
+	}
+	return nil
+}
+
+const maxDepth = 8
+
+//fields describe the struct v, the interfaces in it by what they hold
+func (f *frame) fields(v reflect.Value, depth int) ([]Field, error) {
+	if depth > maxDepth {
+		return nil, fmt.Errorf("spec: %s too deep", v.Type())
+	}
+	t := v.Type()
+
+	keys, err := translayer.CborKeys(t)
+	if err != nil {
+		return nil, err
+	}
+	layout := f.hand
+	if f.stack == StackBM && (layout == nil || depth > 0) {
+		if layout, err = bmprotocol.Layout(t); err != nil {
+			return nil, err
+		}
+	}
+
+	var r []Field
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.Type == headType {
+			continue
+		}
+		fd := Field{Name: sf.Name, Type: typeName(sf.Type, v.Field(i))}
+		if !f.v1 {
+			fd.Cbor = keys[sf.Name]
+		}
+		if f.stack == StackBM {
+			fd.BM = layout[sf.Name]
+			if fd.BM == "" {
+				continue
+			}
+		} else {
+			if sf.PkgPath != "" || sf.Tag.Get("json") == "-" {
+				continue
+			}
+			fd.JSON = jsonLayout(sf)
+		}
+
+		if st, sv := structIn(sf.Type, v.Field(i)); st != nil {
+			if !sv.IsValid() {
+				sv = reflect.New(st).Elem()
+			}
+			if fd.Fields, err = f.fields(sv, depth+1); err != nil {
+				return nil, err
+			}
+		}
+		r = append(r, fd)
+	}
+	return r, nil
+}
+
+//jsonLayout give the JSON name of a field, ",omitempty" when it is left
+//out if zero and ",text" when it is written in its text form
+func jsonLayout(sf reflect.StructField) string {
+	opts := strings.Split(sf.Tag.Get("json"), ",")
+	name := opts[0]
+	if name == "" {
+		name = sf.Name
+	}
+	for _, o := range opts[1:] {
+		if o == "omitempty" {
+			name += ",omitempty"
+		}
+	}
+
+	t := sf.Type
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	if reflect.PtrTo(t).Implements(textMarshaler) {
+		name += ",text"
+	}
+	return name
+}
+
+//structIn give the struct a field of type t holds, through pointers,
+//slices and the value of an interface, sv is the struct when v holds one
+func structIn(t reflect.Type, v reflect.Value) (reflect.Type, reflect.Value) {
+	switch t.Kind() {
+	case reflect.Struct:
+		if isBytes(t) {
+			return nil, reflect.Value{}
+		}
+		return t, v
+	case reflect.Ptr, reflect.Slice:
+		if t.Kind() == reflect.Slice && isBytes(t) {
+			return nil, reflect.Value{}
+		}
+		return structIn(t.Elem(), reflect.Value{})
+	case reflect.Interface:
+		if !v.IsValid() || v.IsNil() {
+			return nil, reflect.Value{}
+		}
+		e := v.Elem()
+		if e.Kind() == reflect.Ptr {
+			e = e.Elem()
+		}
+		return structIn(e.Type(), e)
+	}
+	return nil, reflect.Value{}
+}
+
+func isBytes(t reflect.Type) bool {
+	return (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) && t.Elem().Kind() == reflect.Uint8
+}
+
+//typeName is the type of a field as the spec tells it, bytes[N] for an
+//array of N bytes, the struct an interface holds by its name
+func typeName(t reflect.Type, v reflect.Value) string {
+	switch {
+	case t.Kind() == reflect.Array && t.Elem().Kind() == reflect.Uint8:
+		return fmt.Sprintf("bytes[%d]", t.Len())
+	case isBytes(t):
+		return "bytes"
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Slice:
+		return "[]" + typeName(t.Elem(), reflect.Value{})
+	case reflect.Ptr:
+		return typeName(t.Elem(), reflect.Value{})
+	case reflect.Struct:
+		return t.Name()
+	case reflect.Interface:
+		if v.IsValid() && !v.IsNil() {
+			return typeName(v.Elem().Type(), reflect.Value{})
+		}
+		return t.Name()
+	}
+	return t.Kind().String()
+}
+
+//Files give the files of the document by name, as cmd/bmspec writes them
+func Files() (map[string][]byte, error) {
+	s, err := Generate()
+	if err != nil {
+		return nil, err
+	}
+	doc, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	vs, err := Vectors()
+	if err != nil {
+		return nil, err
+	}
+	vec, err := json.MarshalIndent(vs, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string][]byte{
+		"bmail.json":   append(doc, '\n'),
+		"vectors.json": append(vec, '\n'),
+		"PROTOCOL.md":  s.Markdown(),
+	}, nil
+}
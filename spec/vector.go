@@ -0,0 +1,300 @@
+package spec
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/realbmail/go-bmail-protocol/bmp"
+	"github.com/realbmail/go-bmail-protocol/bmprotocol"
+	"github.com/realbmail/go-bmail-protocol/bpop"
+	"github.com/realbmail/go-bmail-protocol/translayer"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+//Vector is a frame of every field set, in one version. Frame is the hex of
+//the head and the data, the tail of file bytes too. Value is the message
+//as JSON, Hashes the hex of the sha256 its signatures cover, by the field
+//they are of, Inputs the canonical JSON each of them is the sha256 of.
+type Vector struct {
+	Name   string            `json:"name"`
+	Stack  string            `json:"stack"`
+	Type   uint16            `json:"type"`
+	Ver    uint16            `json:"ver"`
+	Frame  string            `json:"frame"`
+	Value  json.RawMessage   `json:"value,omitempty"`
+	Hashes map[string]string `json:"hashes,omitempty"`
+	Inputs map[string]string `json:"inputs,omitempty"`
+}
+
+//Vectors build a vector of each frame in each version it is sent in
+func Vectors() ([]Vector, error) {
+	var r []Vector
+	for i := range frames {
+		f := &frames[i]
+		for _, ver := range f.versions() {
+			v, err := f.vector(ver)
+			if err != nil {
+				return nil, err
+			}
+			r = append(r, v)
+		}
+	}
+	return r, nil
+}
+
+//Example give the message of the vector of a frame, nil when there is
+//none or the frame is of header only
+func Example(stack string, typ uint16) interface{} {
+	for i := range frames {
+		f := &frames[i]
+		if f.stack == stack && f.typ == typ && f.msg != nil {
+			return f.example()
+		}
+	}
+	return nil
+}
+
+//Empty give an empty message of a frame to unpack a vector to, the
+//interfaces in it set
+func Empty(stack string, typ uint16) interface{} {
+	for i := range frames {
+		f := &frames[i]
+		if f.stack == stack && f.typ == typ && f.msg != nil {
+			return f.msg()
+		}
+	}
+	return nil
+}
+
+func (f *frame) example() interface{} {
+	m := f.msg()
+	fl := &filler{}
+	fl.fill(reflect.ValueOf(m), "", 0)
+	if f.fix != nil {
+		f.fix(m)
+	}
+	sigInputs(m)
+	return m
+}
+
+func (f *frame) vector(ver uint16) (Vector, error) {
+	name := fmt.Sprintf("%s/%s/v%d", f.stack, translayer.MsgTypeName(f.typ), ver)
+	v := Vector{Name: name, Stack: f.stack, Type: f.typ, Ver: ver}
+
+	if f.msg == nil {
+		h := bmp.Header{Ver: ver, MsgTyp: f.typ}
+		v.Frame = hex.EncodeToString(h.GetBytes())
+		return v, nil
+	}
+
+	m := f.example()
+	data, err := Pack(f.stack, ver, m)
+	if err != nil {
+		return v, fmt.Errorf("%s: %v", name, err)
+	}
+	v.Frame = hex.EncodeToString(data)
+	if v.Value, err = json.Marshal(m); err != nil {
+		return v, fmt.Errorf("%s: %v", name, err)
+	}
+	v.Hashes = Hashes(m)
+	if v.Inputs, err = HashInputs(m); err != nil {
+		return v, fmt.Errorf("%s: %v", name, err)
+	}
+	return v, nil
+}
+
+//Pack give the frame of m in version ver, uncompressed
+func Pack(stack string, ver uint16, m interface{}) ([]byte, error) {
+	if stack == StackJSON {
+		em, ok := m.(bmp.EnvelopeMsg)
+		if !ok {
+			return nil, fmt.Errorf("spec: %T is not a bmp message", m)
+		}
+		return bmp.PackFrame(ver, translayer.CompressNone, em)
+	}
+
+	vm, ok := m.(interface {
+		SetVersion(ver uint16)
+		io.WriterTo
+	})
+	if !ok {
+		return nil, fmt.Errorf("spec: %T is not a bmprotocol message", m)
+	}
+	vm.SetVersion(ver)
+	buf := &bytes.Buffer{}
+	if _, err := vm.WriteTo(buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+//sigInputs set the hash fields of m to what they are the hash of
+func sigInputs(m interface{}) {
+	switch m := m.(type) {
+	case *bmp.EnvelopeSyn:
+		m.Hash = m.Env.Hash()
+	case *bpop.CommandAck:
+		m.Hash = m.CmdCxt.Hash()
+	}
+}
+
+//Hashes give the hex of the sha256 the signatures of m cover
+func Hashes(m interface{}) map[string]string {
+	h := map[string]string{}
+	switch m := m.(type) {
+	case *bmp.EnvelopeSyn:
+		h["Env"] = hex.EncodeToString(m.Env.Hash())
+		h["Env.FromSig"] = hex.EncodeToString(m.Env.SigHash())
+	case *bmp.AttachmentCheck:
+		h["AttachmentCheck"] = hex.EncodeToString(m.Hash())
+	case *bpop.CommandSyn:
+		h["Cmd"] = hex.EncodeToString(m.Cmd.Hash())
+	case *bpop.CommandAck:
+		h["CmdCxt"] = hex.EncodeToString(m.CmdCxt.Hash())
+	default:
+		return nil
+	}
+	return h
+}
+
+//HashInputs give the canonical JSON each hash of Hashes is the sha256 of
+func HashInputs(m interface{}) (map[string]string, error) {
+	in := map[string]interface{}{}
+	switch m := m.(type) {
+	case *bmp.EnvelopeSyn:
+		unsigned := *m.Env
+		unsigned.FromSig = nil
+		in["Env"], in["Env.FromSig"] = m.Env, &unsigned
+	case *bmp.AttachmentCheck:
+		in["AttachmentCheck"] = m
+	case *bpop.CommandSyn:
+		in["Cmd"] = m.Cmd
+	case *bpop.CommandAck:
+		in["CmdCxt"] = m.CmdCxt
+	default:
+		return nil, nil
+	}
+
+	r := map[string]string{}
+	for k, v := range in {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		r[k] = string(data)
+	}
+	return r, nil
+}
+
+const fileData = "file bytes"
+
+//the escapes of the canonical JSON in a subject. Not \b and \f, Go before
+//1.22 wrote them as \u00XX, nor bad UTF-8, CBOR text can't hold it.
+const escapes = "<&> \"\\ \n\r\t\x01 \u2028\u2029 é"
+
+func fixEnvelopeSyn(v interface{}) {
+	v.(*bmp.EnvelopeSyn).Env.Subject += escapes
+}
+
+func fixSendAttachment(v interface{}) {
+	sa := v.(*bmprotocol.SendAttachment)
+	sa.FileSize = len(fileData)
+	sa.Offset, sa.Length = 0, int64(len(fileData))
+	sa.File = strings.NewReader(fileData)
+}
+
+func fixRespRetrAttachment(v interface{}) {
+	rra := v.(*bmprotocol.RespRetrAttachment)
+	rra.FileSize = len(fileData)
+	rra.Offset, rra.Length = 0, int64(len(fileData))
+	rra.Data = []byte(fileData)
+}
+
+//filler set every field of an example to a value of its own, a string to
+//the name of its field and a number, the others to the number
+type filler struct {
+	n int
+}
+
+func (fl *filler) next() int {
+	fl.n++
+	return fl.n
+}
+
+func (fl *filler) fill(v reflect.Value, name string, depth int) {
+	if depth > maxDepth {
+		return
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		if t == headType {
+			return
+		}
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			if sf.Tag.Get("bm") == "-" || sf.Tag.Get("cbor") == "-" || sf.Tag.Get("json") == "-" {
+				continue
+			}
+			f := v.Field(i)
+			if !f.CanSet() {
+				f = reflect.NewAt(f.Type(), unsafe.Pointer(f.UnsafeAddr())).Elem()
+			}
+			fl.fill(f, sf.Name, depth+1)
+		}
+
+	case reflect.Interface:
+		if !v.IsNil() {
+			fl.fill(v.Elem(), name, depth)
+		}
+
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		fl.fill(v.Elem(), name, depth)
+
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			n := fl.next()
+			v.SetBytes([]byte{byte(n), byte(n + 1), byte(n + 2)})
+			return
+		}
+		s := reflect.MakeSlice(v.Type(), 1, 1)
+		fl.fill(s.Index(0), name, depth)
+		v.Set(s)
+
+	case reflect.Array:
+		if v.Type().Elem().Kind() != reflect.Uint8 {
+			for i := 0; i < v.Len(); i++ {
+				fl.fill(v.Index(i), name, depth)
+			}
+			return
+		}
+		n := fl.next()
+		for i := 0; i < v.Len(); i++ {
+			v.Index(i).SetUint(uint64(byte(n + i)))
+		}
+
+	case reflect.String:
+		if name != "" {
+			name = strings.ToLower(name[:1]) + name[1:]
+		}
+		v.SetString(name + strconv.Itoa(fl.next()))
+
+	case reflect.Bool:
+		v.SetBool(true)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(int64(fl.next()))
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(uint64(fl.next()))
+	}
+}
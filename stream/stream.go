@@ -0,0 +1,261 @@
+package stream
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+//chunked authenticated encryption for big attachments, in the spirit of
+//age / STREAM:
+//
+//	header: magic(4) | salt(16)
+//	chunks: AES-256-GCM(payload key, nonce, plain chunk)
+//
+//payload key = HMAC-SHA256(file key, magic | salt)
+//nonce       = chunk counter(11, big endian) | last flag(1)
+//
+//every chunk but the last holds ChunkSize plain bytes, the last one holds
+//0..ChunkSize bytes and has the last flag set. A cut stream has no last
+//chunk, moved chunks fail the counter, so both are detected.
+
+const (
+	ChunkSize = 64 * 1024
+	Overhead  = 16 //gcm tag of every chunk
+	SaltSize  = 16
+	KeySize   = 32
+
+	magic        = "BMS1"
+	encChunkSize = ChunkSize + Overhead
+	nonceSize    = 12
+	headerSize   = len(magic) + SaltSize
+)
+
+var (
+	ErrBadHeader = errors.New("not a bmail stream")
+	ErrTruncated = errors.New("stream truncated")
+	ErrTrailing  = errors.New("data after last chunk")
+	ErrAuth      = errors.New("stream chunk authentication failed")
+)
+
+func payloadAead(key, salt []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, errors.New("stream key must be 32 bytes")
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(magic))
+	mac.Write(salt)
+
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+type nonce [nonceSize]byte
+
+func (n *nonce) set(counter uint64, last bool) {
+	binary.BigEndian.PutUint64(n[3:11], counter)
+	n[0], n[1], n[2] = 0, 0, 0
+	n[11] = 0
+	if last {
+		n[11] = 1
+	}
+}
+
+//CipherSize is the size of the stream of a plainSize bytes file
+func CipherSize(plainSize int64) int64 {
+	chunks := plainSize / ChunkSize
+	if plainSize%ChunkSize != 0 || plainSize == 0 {
+		chunks++
+	}
+	return int64(headerSize) + plainSize + chunks*Overhead
+}
+
+type Writer struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	buf     []byte
+	out     []byte
+	counter uint64
+	nonce   nonce
+	closed  bool
+}
+
+//NewWriter encrypt everything written to it into w, Close must be called
+//to write the last chunk.
+func NewWriter(key []byte, w io.Writer) (*Writer, error) {
+	salt := make([]byte, SaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	aead, err := payloadAead(key, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(append([]byte(magic), salt...)); err != nil {
+		return nil, err
+	}
+
+	return &Writer{
+		w:    w,
+		aead: aead,
+		buf:  make([]byte, 0, ChunkSize),
+		out:  make([]byte, 0, encChunkSize),
+	}, nil
+}
+
+func (sw *Writer) Write(p []byte) (int, error) {
+	if sw.closed {
+		return 0, errors.New("write to closed stream")
+	}
+
+	total := 0
+	for len(p) > 0 {
+		//a full chunk is only flushed when more data comes, the last
+		//chunk must be the one Close writes
+		if len(sw.buf) == ChunkSize {
+			if err := sw.flush(false); err != nil {
+				return total, err
+			}
+		}
+		n := copy(sw.buf[len(sw.buf):ChunkSize], p)
+		sw.buf = sw.buf[:len(sw.buf)+n]
+		p = p[n:]
+		total += n
+	}
+	return total, nil
+}
+
+func (sw *Writer) flush(last bool) error {
+	sw.nonce.set(sw.counter, last)
+	sw.out = sw.aead.Seal(sw.out[:0], sw.nonce[:], sw.buf, nil)
+	if _, err := sw.w.Write(sw.out); err != nil {
+		return err
+	}
+	sw.counter++
+	sw.buf = sw.buf[:0]
+	return nil
+}
+
+//Close write the last chunk, it does not close the underlying writer
+func (sw *Writer) Close() error {
+	if sw.closed {
+		return nil
+	}
+	sw.closed = true
+	return sw.flush(true)
+}
+
+type Reader struct {
+	r       io.Reader
+	aead    cipher.AEAD
+	in      []byte //encrypted chunk plus one byte read ahead
+	pending int    //bytes of in carried to the next chunk
+	plain   []byte
+	unread  []byte
+	counter uint64
+	nonce   nonce
+	done    bool
+	err     error
+}
+
+func NewReader(key []byte, r io.Reader) (*Reader, error) {
+	head := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, ErrBadHeader
+	}
+	if string(head[:len(magic)]) != magic {
+		return nil, ErrBadHeader
+	}
+	aead, err := payloadAead(key, head[len(magic):])
+	if err != nil {
+		return nil, err
+	}
+
+	return &Reader{
+		r:     r,
+		aead:  aead,
+		in:    make([]byte, encChunkSize+1),
+		plain: make([]byte, 0, ChunkSize),
+	}, nil
+}
+
+func (sr *Reader) Read(p []byte) (int, error) {
+	for len(sr.unread) == 0 {
+		if sr.err != nil {
+			return 0, sr.err
+		}
+		if sr.done {
+			return 0, io.EOF
+		}
+		sr.err = sr.next()
+	}
+
+	n := copy(p, sr.unread)
+	sr.unread = sr.unread[n:]
+	return n, nil
+}
+
+func (sr *Reader) next() error {
+	n, err := io.ReadFull(sr.r, sr.in[sr.pending:])
+	n += sr.pending
+	sr.pending = 0
+
+	last := false
+	switch err {
+	case nil:
+		//one byte more than a chunk, so this chunk is not the last
+	case io.EOF, io.ErrUnexpectedEOF:
+		last = true
+	default:
+		return err
+	}
+
+	chunk := sr.in[:n]
+	if !last {
+		chunk = sr.in[:encChunkSize]
+	}
+	if len(chunk) < Overhead {
+		return ErrTruncated
+	}
+
+	plain, err := sr.open(chunk, last)
+	if err != nil {
+		//the flag is authenticated, the other flag telling what is wrong
+		//only makes a better error
+		if _, err2 := sr.open(chunk, !last); err2 == nil {
+			if last {
+				return ErrTruncated
+			}
+			return ErrTrailing
+		}
+		return ErrAuth
+	}
+	if last && len(plain) == 0 && sr.counter > 0 {
+		//only an empty file has an empty last chunk
+		return ErrAuth
+	}
+	sr.counter++
+
+	if !last {
+		sr.in[0] = sr.in[encChunkSize]
+		sr.pending = 1
+	}
+
+	sr.unread = plain
+	sr.done = last
+	return nil
+}
+
+func (sr *Reader) open(chunk []byte, last bool) ([]byte, error) {
+	sr.nonce.set(sr.counter, last)
+	return sr.aead.Open(sr.plain[:0], sr.nonce[:], chunk, nil)
+}
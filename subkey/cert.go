@@ -0,0 +1,135 @@
+package subkey
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/realbmail/go-bmail-account"
+	"github.com/realbmail/go-bmail-protocol/signer"
+)
+
+//the bmail address is the master key of an identity, it is kept offline or
+//in an agent. A device signs by a sub-key the master has certified for a
+//while, a leaked sub-key is revoked and replaced without a new address:
+//
+//master --Cert--> sub-key --Sig--> HELO sn, envelope
+//
+//aes keys are still agreed by the master key, sub-keys only sign.
+
+const (
+	certTag   = "BMAIL-SUBKEY-CERT-1"
+	revokeTag = "BMAIL-SUBKEY-REVOKE-1"
+)
+
+var (
+	ErrBadCert      = errors.New("sub-key cert not signed by the master")
+	ErrCertExpired  = errors.New("sub-key cert out of its validity period")
+	ErrWrongMaster  = errors.New("sub-key cert of another identity")
+	ErrRevoked      = errors.New("sub-key revoked")
+	ErrBadSignature = errors.New("bad signature")
+	ErrUnsigned     = errors.New("not signed by the sender")
+)
+
+//Cert is the master's word that SubKey signs for it from NotBefore to
+//NotAfter, both ms since 1970
+type Cert struct {
+	Master    bmail.Address `json:"master"`
+	SubKey    bmail.Address `json:"subKey"`
+	Device    string        `json:"device,omitempty"`
+	NotBefore int64         `json:"notBefore"`
+	NotAfter  int64         `json:"notAfter"`
+	Sig       []byte        `json:"sig"`
+}
+
+func putString(buf *bytes.Buffer, s string) {
+	binary.Write(buf, binary.BigEndian, uint16(len(s)))
+	buf.WriteString(s)
+}
+
+//digest is what the master signs, every field in a fixed order
+func (c *Cert) digest() []byte {
+	buf := &bytes.Buffer{}
+	putString(buf, certTag)
+	putString(buf, c.Master.String())
+	putString(buf, c.SubKey.String())
+	putString(buf, c.Device)
+	binary.Write(buf, binary.BigEndian, c.NotBefore)
+	binary.Write(buf, binary.BigEndian, c.NotAfter)
+	hash := sha256.Sum256(buf.Bytes())
+	return hash[:]
+}
+
+//Issue certify sub for the identity of master
+func Issue(master signer.Signer, sub bmail.Address, device string, notBefore, notAfter int64) (*Cert, error) {
+	if notAfter <= notBefore {
+		return nil, fmt.Errorf("empty validity period %d-%d", notBefore, notAfter)
+	}
+	c := &Cert{
+		Master:    master.Address(),
+		SubKey:    sub,
+		Device:    device,
+		NotBefore: notBefore,
+		NotAfter:  notAfter,
+	}
+	sig, err := master.Sign(c.digest())
+	if err != nil {
+		return nil, err
+	}
+	c.Sig = sig
+	return c, nil
+}
+
+//Verify check the cert is signed by its master and valid at the time
+func (c *Cert) Verify(at int64) error {
+	if !bmail.Verify(c.Master, c.digest(), c.Sig) {
+		return ErrBadCert
+	}
+	if at < c.NotBefore || at > c.NotAfter {
+		return ErrCertExpired
+	}
+	return nil
+}
+
+//Revocation is the master's word that SubKey signs nothing any more
+type Revocation struct {
+	Master bmail.Address `json:"master"`
+	SubKey bmail.Address `json:"subKey"`
+	Time   int64         `json:"time"` //ms since 1970
+	Reason string        `json:"reason,omitempty"`
+	Sig    []byte        `json:"sig"`
+}
+
+func (r *Revocation) digest() []byte {
+	buf := &bytes.Buffer{}
+	putString(buf, revokeTag)
+	putString(buf, r.Master.String())
+	putString(buf, r.SubKey.String())
+	binary.Write(buf, binary.BigEndian, r.Time)
+	putString(buf, r.Reason)
+	hash := sha256.Sum256(buf.Bytes())
+	return hash[:]
+}
+
+func Revoke(master signer.Signer, sub bmail.Address, at int64, reason string) (*Revocation, error) {
+	r := &Revocation{
+		Master: master.Address(),
+		SubKey: sub,
+		Time:   at,
+		Reason: reason,
+	}
+	sig, err := master.Sign(r.digest())
+	if err != nil {
+		return nil, err
+	}
+	r.Sig = sig
+	return r, nil
+}
+
+func (r *Revocation) Verify() error {
+	if !bmail.Verify(r.Master, r.digest(), r.Sig) {
+		return ErrBadSignature
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package subkey
+
+import (
+	"github.com/realbmail/go-bmail-account"
+	"sync"
+)
+
+//RevocationList give the revocation of a sub-key, nil when it has none.
+//Where revocations are published is up to the app, Revocations keeps them
+//in memory.
+type RevocationList interface {
+	Revoked(master, sub bmail.Address) (*Revocation, error)
+}
+
+//revokeKey is a sub-key of a master, a revocation by one master says
+//nothing of the sub-key for another
+type revokeKey struct {
+	master, sub bmail.Address
+}
+
+type Revocations struct {
+	lock sync.RWMutex
+	m    map[revokeKey]*Revocation
+}
+
+func NewRevocations() *Revocations {
+	return &Revocations{m: make(map[revokeKey]*Revocation)}
+}
+
+//Add keep a revocation whose signature is good, the first one of a
+//sub-key stays
+func (rs *Revocations) Add(r *Revocation) error {
+	if err := r.Verify(); err != nil {
+		return err
+	}
+	k := revokeKey{r.Master, r.SubKey}
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	if _, ok := rs.m[k]; !ok {
+		rs.m[k] = r
+	}
+	return nil
+}
+
+func (rs *Revocations) Revoked(master, sub bmail.Address) (*Revocation, error) {
+	rs.lock.RLock()
+	defer rs.lock.RUnlock()
+	return rs.m[revokeKey{master, sub}], nil
+}
+
+//Checker verify a signature made in the name of an identity, by its master
+//key or by a certified sub-key that is not revoked
+type Checker struct {
+	Revocations RevocationList //revocations are not checked when nil
+}
+
+//CheckCert check cert lets a sub-key sign for master at the time
+func (c *Checker) CheckCert(master bmail.Address, cert *Cert, at int64) error {
+	if cert.Master != master {
+		return ErrWrongMaster
+	}
+	if err := cert.Verify(at); err != nil {
+		return err
+	}
+	if c == nil || c.Revocations == nil {
+		return nil
+	}
+	r, err := c.Revocations.Revoked(master, cert.SubKey)
+	if err != nil {
+		return err
+	}
+	if r != nil {
+		return ErrRevoked
+	}
+	return nil
+}
+
+//Verify check sig of msg, made at the time by master itself when cert is
+//nil, else by the sub-key of cert
+func (c *Checker) Verify(master bmail.Address, cert *Cert, msg, sig []byte, at int64) error {
+	by := master
+	if cert != nil {
+		if err := c.CheckCert(master, cert, at); err != nil {
+			return err
+		}
+		by = cert.SubKey
+	}
+	if !bmail.Verify(by, msg, sig) {
+		return ErrBadSignature
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package subkey
+
+import (
+	"errors"
+	"github.com/realbmail/go-bmail-account"
+	"github.com/realbmail/go-bmail-protocol/signer"
+)
+
+//Certified is a signer that signs by a sub-key, the clients send its cert
+//with every signature
+type Certified interface {
+	SubCert() *Cert
+}
+
+//Signer sign by the sub-key in the name of the master of the cert
+type Signer struct {
+	Sub  signer.Signer
+	Cert *Cert
+}
+
+func (s *Signer) Address() bmail.Address {
+	return s.Cert.Master
+}
+
+func (s *Signer) Sign(message []byte) ([]byte, error) {
+	return s.Sub.Sign(message)
+}
+
+func (s *Signer) SubCert() *Cert {
+	return s.Cert
+}
+
+//Key is a mail identity that signs by a sub-key and agrees aes keys by the
+//master key, which may be in an agent
+type Key struct {
+	*Signer
+	Agreer signer.KeyAgreer
+	Mail   string
+}
+
+func (k *Key) AesKeyOf(peer bmail.Address) ([]byte, error) {
+	return k.Agreer.AesKeyOf(peer)
+}
+
+//X25519 by the master key, an error when the agreer can't
+func (k *Key) X25519(peer []byte) ([]byte, error) {
+	dh, ok := k.Agreer.(signer.DHKey)
+	if !ok {
+		return nil, errors.New("master key can't do X25519")
+	}
+	return dh.X25519(peer)
+}
+
+func (k *Key) MailAddress() string {
+	return k.Mail
+}
@@ -0,0 +1,151 @@
+package test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"github.com/realbmail/go-bmail-protocol/agent"
+	"github.com/realbmail/go-bmail-protocol/keystore"
+	"github.com/realbmail/go-bmail-protocol/signer"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_AgentSign(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ks, _ := keystore.Open(filepath.Join(dir, "keys.json"), keystore.StaticPassword("123"))
+	id, err := ks.Create("alice", "alice@bmail.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bob, err := ks.Create("bob", "bob@bmail.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//the agent tightens a directory others can open
+	run := filepath.Join(dir, "run")
+	if err := os.Mkdir(run, 0755); err != nil {
+		t.Fatal(err)
+	}
+	sock := filepath.Join(run, "agent.sock")
+	srv := agent.NewServer()
+	srv.Add(id)
+	refuse := false
+	srv.Confirm = func(op string, key *agent.KeyInfo) bool {
+		return !refuse
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.ListenAndServe(sock)
+	}()
+	for i := 0; i < 50; i++ {
+		if _, err := os.Stat(sock); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if fi, err := os.Stat(run); err != nil || fi.Mode().Perm() != 0700 {
+		t.Fatal("socket directory is open to others", err)
+	}
+
+	ac, err := agent.Dial(sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ac.Close()
+
+	keys, err := ac.List()
+	if err != nil || len(keys) != 1 || keys[0].Addr != id.Address() {
+		t.Fatal("failed", err)
+	}
+	var k signer.Key
+	if k, err = ac.Key("alice@bmail.com"); err != nil {
+		t.Fatal(err)
+	}
+	msg := []byte("sn of the session")
+	sig, err := k.Sign(msg)
+	if err != nil || !ed25519.Verify(id.PublicKey(), msg, sig) {
+		t.Fatal("failed", err)
+	}
+	want, _ := id.AesKeyOf(bob.Address())
+	got, err := k.AesKeyOf(bob.Address())
+	if err != nil || len(got) == 0 || !bytes.Equal(want, got) {
+		t.Fatal("failed", err)
+	}
+
+	if _, err := ac.Key("bob@bmail.com"); err != agent.ErrNoKey {
+		t.Fatal("failed", err)
+	}
+	refuse = true
+	if _, err := k.Sign(msg); err != agent.ErrRefused {
+		t.Fatal("failed", err)
+	}
+
+	if err := srv.ListenAndServe(sock); err == nil {
+		t.Fatal("second agent on a live socket")
+	}
+	srv.Close()
+	if err := <-done; err != agent.ErrClosed {
+		t.Fatal("failed", err)
+	}
+	t.Log("pass")
+}
+
+//lateListener hand out its conns from Accept even after Close, as a
+//listener does with a client accepted while it is closed
+type lateListener struct {
+	net.Listener
+	conns chan net.Conn
+}
+
+func (ll *lateListener) Accept() (net.Conn, error) {
+	c, ok := <-ll.conns
+	if !ok {
+		return nil, errors.New("closed")
+	}
+	return c, nil
+}
+
+func (ll *lateListener) Close() error {
+	return nil
+}
+
+//a client accepted while Close runs is dropped, not served after it
+func Test_AgentCloseAccept(t *testing.T) {
+	srv := agent.NewServer()
+	ll := &lateListener{conns: make(chan net.Conn)}
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.Serve(ll)
+	}()
+
+	//a first client shows Serve is accepting before Close
+	first, _ := net.Pipe()
+	ll.conns <- first
+	srv.Close()
+
+	late, client := net.Pipe()
+	ll.conns <- late
+	close(ll.conns)
+	if err := <-done; err != agent.ErrClosed {
+		t.Fatal("failed", err)
+	}
+	client.SetDeadline(time.Now().Add(time.Second))
+	if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatal("client accepted after close kept", err)
+	}
+	t.Log("pass")
+}
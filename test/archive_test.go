@@ -0,0 +1,206 @@
+package test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"github.com/realbmail/go-bmail-protocol/archive"
+	"github.com/realbmail/go-bmail-protocol/bmp"
+	"github.com/realbmail/go-bmail-protocol/eml"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+//pagedSource serve mails the server took before the pivot, newest first,
+//mails from alice to bob
+type pagedSource struct {
+	testMailbox
+	calls      int
+	alice, bob *testWallet
+	received   map[string]int64
+}
+
+func newPagedSource() *pagedSource {
+	return &pagedSource{alice: newTestWallet("alice@bmail.com"), bob: newTestWallet("bob@bmail.com"), received: make(map[string]int64)}
+}
+
+func (ps *pagedSource) ReceivePage(timeSince1970 int64, olderThanSince bool, maxCount int) ([]*bmp.BMailEnvelope, map[string]int64, error) {
+	ps.calls++
+	envs := append([]*bmp.BMailEnvelope{}, ps.envs...)
+	sort.SliceStable(envs, func(i, j int) bool {
+		return ps.received[envs[i].Eid] > ps.received[envs[j].Eid]
+	})
+	var r []*bmp.BMailEnvelope
+	received := make(map[string]int64)
+	for _, env := range envs {
+		if at := ps.received[env.Eid]; at < timeSince1970 && len(r) < maxCount {
+			cp := *env
+			r = append(r, &cp)
+			received[env.Eid] = at
+		}
+	}
+	return r, received, nil
+}
+
+func (ps *pagedSource) addMail(t *testing.T, i int) *bmp.BMailEnvelope {
+	env := &bmp.BMailEnvelope{
+		Eid:           fmt.Sprintf("3f1c8a52-0000-4000-8000-%012d", i),
+		DateSince1970: uint64(i * 1000),
+		Subject:       fmt.Sprintf("mail %d", i),
+		MailBody:      "From the archive\r\n",
+		RCPTs:         []*bmp.Recipient{{ToName: "bob@bmail.com", ToAddr: ps.bob.addr, RcptType: bmp.RcpTypeTo}},
+	}
+	if _, err := eml.Seal(env, ps.alice); err != nil {
+		t.Fatal(err)
+	}
+	env.FromSig, _ = ps.alice.Sign(env.SigHash())
+	ps.envs = append(ps.envs, env)
+	ps.received[env.Eid] = int64(env.DateSince1970)
+	return env
+}
+
+func Test_ArchiveMaildir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "maildir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := newPagedSource()
+	for i := 1; i <= 5; i++ {
+		src.addMail(t, i)
+	}
+	//a mail bob can't open is skipped and not in the manifest
+	bad := src.addMail(t, 8)
+	bad.RCPTs[0].AESKey = nil
+	conf := &archive.Config{
+		Format:   archive.FormatMaildir,
+		Path:     dir,
+		PageSize: 2,
+		Wallet:   src.bob,
+		Source:   src,
+	}
+
+	r, err := archive.Run(conf)
+	if err != nil || r.Added != 5 || len(r.Skipped) != 1 || r.Skipped[0] != bad.Eid {
+		t.Fatal("failed", r, err)
+	}
+	mf, _ := ioutil.ReadFile(filepath.Join(dir, ".bmail-manifest"))
+	lines := bytes.Split(bytes.TrimSpace(mf), []byte("\n"))
+	rec := &archive.Record{}
+	if len(lines) != 6 || json.Unmarshal(lines[0], rec) != nil || rec.Eid == "" ||
+		!ed25519.Verify(src.alice.addr.ToPubKey(), src.envs[4].SigHash(), rec.Sig) {
+		t.Fatal("manifest has no eid and signature", string(mf))
+	}
+	files, _ := ioutil.ReadDir(filepath.Join(dir, "cur"))
+	if len(files) != 5 {
+		t.Fatal("failed")
+	}
+
+	//the next export adds the new mails and stops at the old ones
+	src.envs = src.envs[:5]
+	src.addMail(t, 6)
+	src.addMail(t, 7)
+	src.calls = 0
+	r, err = archive.Run(conf)
+	if err != nil || r.Added != 2 || r.Known != 0 || len(r.Skipped) != 0 || src.calls != 1 {
+		t.Fatal("failed", r, err, src.calls)
+	}
+	files, _ = ioutil.ReadDir(filepath.Join(dir, "cur"))
+	if len(files) != 7 {
+		t.Fatal("failed")
+	}
+
+	t.Log("pass")
+}
+
+func Test_ArchiveMbox(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := newPagedSource()
+	for i := 1; i <= 3; i++ {
+		src.addMail(t, i)
+	}
+	//a line end in the name of the sender must not start a forged mail
+	src.envs[0].FromName = "alice@bmail.com Mon Jan  1 00:00:00 2001\nFrom: x"
+	path := filepath.Join(dir, "inbox.mbox")
+	conf := &archive.Config{
+		Format: archive.FormatMbox,
+		Path:   path,
+		Wallet: src.bob,
+		Source: src,
+	}
+
+	//a broken export left mail 3 without its manifest line end
+	mf := path + ".bmail-manifest"
+	ioutil.WriteFile(mf, []byte(`{"eid":"3f1c8a52-0000-4000-8000-000000000003","date":3000}`+"\n"+`{"eid":"3f1c`), 0600)
+
+	r, err := archive.Run(conf)
+	if err != nil || r.Added != 2 || r.Known != 1 {
+		t.Fatal("failed", r, err)
+	}
+	data, _ := ioutil.ReadFile(path)
+	if bytes.Count(data, []byte("\nFrom ")) != 1 || !bytes.HasPrefix(data, []byte("From ")) ||
+		bytes.Count(data, []byte(">From the archive")) != 2 {
+		t.Fatal("failed", string(data))
+	}
+
+	r, err = archive.Run(conf)
+	if err != nil || r.Added != 0 {
+		t.Fatal("failed", r, err)
+	}
+
+	t.Log("pass")
+}
+
+//mails of one time across pages and a mail the sender dated back are all
+//exported
+func Test_ArchivePaging(t *testing.T) {
+	dir, err := ioutil.TempDir("", "maildir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := newPagedSource()
+	for i := 1; i <= 7; i++ {
+		env := src.addMail(t, i)
+		if i > 2 {
+			src.received[env.Eid] = 5000
+		}
+	}
+	conf := &archive.Config{
+		Format:   archive.FormatMaildir,
+		Path:     dir,
+		PageSize: 2,
+		Wallet:   src.bob,
+		Source:   src,
+	}
+	r, err := archive.Run(conf)
+	if err != nil || r.Added != 7 || r.Known != 0 || len(r.Skipped) != 0 {
+		t.Fatal("failed", r, err)
+	}
+
+	//the server took it after the export, the sender dated it before
+	late := src.addMail(t, 10)
+	late.DateSince1970 = 1
+	src.received[late.Eid] = 9000
+	src.calls = 0
+	r, err = archive.Run(conf)
+	if err != nil || r.Added != 1 || src.calls != 1 {
+		t.Fatal("failed", r, err, src.calls)
+	}
+	files, _ := ioutil.ReadDir(filepath.Join(dir, "cur"))
+	if len(files) != 8 {
+		t.Fatal("failed", len(files))
+	}
+	t.Log("pass")
+}
@@ -0,0 +1,148 @@
+package test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"github.com/realbmail/go-bmail-protocol/bmp"
+	"github.com/realbmail/go-bmail-protocol/bmp/client"
+	"github.com/realbmail/go-bmail-protocol/stream"
+	"testing"
+)
+
+func newEnvKey() []byte {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return key
+}
+
+func Test_SealAttachment(t *testing.T) {
+	envKey := newEnvKey()
+	plain := []byte("the attachment of a mail")
+	att, sealed, err := client.SealAttachment(envKey, "a.txt", "text/plain", plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if att.Size != int64(len(sealed)) || att.FileName == "a.txt" || bytes.Contains(sealed, plain) {
+		t.Fatal("not sealed", att.ToString())
+	}
+	name, data, err := client.OpenAttachment(envKey, att, sealed)
+	if err != nil || name != "a.txt" || !bytes.Equal(data, plain) {
+		t.Fatal("failed", name, err)
+	}
+
+	changed := append([]byte(nil), sealed...)
+	changed[0] ^= 1
+	if _, _, err := client.OpenAttachment(envKey, att, changed); err == nil {
+		t.Fatal("changed data opened")
+	}
+	if _, _, err := client.OpenAttachment(newEnvKey(), att, sealed); err == nil {
+		t.Fatal("opened by another key")
+	}
+
+	//a forward moves the file key only, the old key can't open it any more
+	newKey := newEnvKey()
+	att.Path = "p"
+	fwd, err := client.RewrapAttachment(envKey, newKey, att)
+	if err != nil || fwd.Path != "" || !bytes.Equal(fwd.Hash, att.Hash) || att.Path != "p" {
+		t.Fatal("failed", err)
+	}
+	if _, data, err := client.OpenAttachment(newKey, fwd, sealed); err != nil || !bytes.Equal(data, plain) {
+		t.Fatal("forward not opened", err)
+	}
+	if _, _, err := client.OpenAttachment(envKey, fwd, sealed); err == nil {
+		t.Fatal("forward opened by the old key")
+	}
+	if _, err := client.RewrapAttachment(newEnvKey(), newKey, att); err == nil {
+		t.Fatal("rewrapped by a wrong key")
+	}
+	t.Log("pass")
+}
+
+func Test_SealAttachmentStream(t *testing.T) {
+	envKey := newEnvKey()
+	plain := make([]byte, 2*stream.ChunkSize+100)
+	rand.Read(plain)
+
+	sealed := &bytes.Buffer{}
+	att, err := client.SealAttachmentStream(envKey, "a.bin", "application/octet-stream", bytes.NewReader(plain), sealed)
+	if err != nil || att.Scheme != bmp.AttachSchemeStream || att.Size != int64(sealed.Len()) {
+		t.Fatal("failed", err)
+	}
+	out := &bytes.Buffer{}
+	if name, err := client.OpenAttachmentStream(envKey, att, bytes.NewReader(sealed.Bytes()), out); err != nil ||
+		name != "a.bin" || !bytes.Equal(out.Bytes(), plain) {
+		t.Fatal("failed", name, err)
+	}
+
+	//a stream cut at a chunk end or inside one is not taken for the file
+	for _, n := range []int{sealed.Len() - 1, sealed.Len() / 2, 0} {
+		if _, err := client.OpenAttachmentStream(envKey, att, bytes.NewReader(sealed.Bytes()[:n]), &bytes.Buffer{}); err == nil {
+			t.Fatal("stream cut at", n, "opened")
+		}
+	}
+	if _, err := client.OpenAttachmentStream(newEnvKey(), att, bytes.NewReader(sealed.Bytes()), &bytes.Buffer{}); err == nil {
+		t.Fatal("opened by another key")
+	}
+	buffered, data, _ := client.SealAttachment(envKey, "a.bin", "", plain)
+	if _, err := client.OpenAttachmentStream(envKey, buffered, bytes.NewReader(data), &bytes.Buffer{}); err == nil {
+		t.Fatal("buffer scheme opened as a stream")
+	}
+	t.Log("pass")
+}
+
+func Test_AttachmentTransfer(t *testing.T) {
+	m := startMockBMTP(t)
+	defer m.Close()
+	cli := newMockClient(t)
+
+	envKey := newEnvKey()
+	eid := "3f1c8a52-0000-4000-8000-000000000001"
+	att, sealed, _ := client.SealAttachment(envKey, "a.txt", "text/plain", []byte("hello"))
+	other, _, _ := client.SealAttachment(envKey, "b.txt", "text/plain", []byte("world"))
+
+	if has, err := cli.CheckAttachments(eid, []*bmp.Attachment{att, other}); err != nil || len(has) != 2 || has[0] || has[1] {
+		t.Fatal("failed", has, err)
+	}
+	if err := cli.UploadAttachment(eid, att, bytes.NewReader(sealed)); err != nil || att.Path != "p" {
+		t.Fatal("failed", err)
+	}
+	if has, err := cli.CheckAttachments(eid, []*bmp.Attachment{att, other}); err != nil || !has[0] || has[1] {
+		t.Fatal("failed", has, err)
+	}
+
+	data, err := cli.DownloadAttachment(eid, att)
+	if err != nil || !bytes.Equal(data, sealed) {
+		t.Fatal("failed", err)
+	}
+	if _, plain, err := client.OpenAttachment(envKey, att, data); err != nil || string(plain) != "hello" {
+		t.Fatal("failed", err)
+	}
+	if _, err := cli.DownloadAttachment(eid, other); err == nil {
+		t.Fatal("attachment not stored downloaded")
+	}
+
+	//data changed on the server is found
+	changed := append([]byte(nil), sealed...)
+	changed[0] ^= 1
+	m.setBlob(att.Hash, changed)
+	if _, err := cli.DownloadAttachment(eid, att); err == nil {
+		t.Fatal("changed data downloaded")
+	}
+	m.setBlob(att.Hash, sealed)
+
+	//the size a sender put in the envelope is not a buffer size, the
+	//download fails when the server sends less
+	big := *att
+	big.Size = 1 << 40
+	m.retrSize = big.Size
+	if _, err := cli.DownloadAttachment(eid, &big); err == nil {
+		t.Fatal("short download taken")
+	}
+	m.retrSize = 0
+
+	//a size other than the envelope one is not read
+	if _, err := cli.DownloadAttachment(eid, &big); err == nil {
+		t.Fatal("size not checked")
+	}
+	t.Log("pass")
+}
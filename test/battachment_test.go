@@ -2,7 +2,7 @@ package test
 
 import (
 	"fmt"
-	"github.com/BASChain/go-bmail-protocol/bmprotocol"
+	"github.com/realbmail/go-bmail-protocol/bmprotocol"
 	"math/rand"
 	"testing"
 )
@@ -18,7 +18,7 @@ func Test_FileProperty(t *testing.T) {
 		break
 	}
 
-	fp := &bmprotocol.FileProperty{hash1, "hash1.txt", 0, 12221}
+	fp := &bmprotocol.FileProperty{Hash: hash1, FileName: "hash1.txt", FileSize: 12221}
 
 	data, _ := fp.Pack()
 	fmt.Println(fp.String())
@@ -63,8 +63,8 @@ func Test_Attachment(t *testing.T) {
 	//	break
 	//}
 
-	a := &bmprotocol.Attachment{"/smallfile/",
-		bmprotocol.FileProperty{hash1, "hash1.doc", 0, 1002000}}
+	a := &bmprotocol.Attachment{Path: "/smallfile/",
+		FileProperty: bmprotocol.FileProperty{Hash: hash1, FileName: "hash1.doc", FileSize: 1002000}}
 	data, _ := a.Pack()
 
 	fmt.Println(a.String())
@@ -0,0 +1,179 @@
+package test
+
+import (
+	"bytes"
+	"github.com/realbmail/go-bmail-protocol/bmprotocol"
+	"github.com/realbmail/go-bmail-protocol/translayer"
+	"io"
+	"testing"
+)
+
+type sizedMsg interface {
+	goldenMsg
+	Size() int
+	io.WriterTo
+}
+
+func Test_SizeWriteTo(t *testing.T) {
+	msgs := goldenMsgs()
+	for name, m := range msgs {
+		sm, ok := m.(sizedMsg)
+		if !ok {
+			t.Fatal(name, "has no Size or WriteTo")
+		}
+		data, err := m.Pack()
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		if sm.Size() != len(data) {
+			t.Fatal(name, "size", sm.Size(), "packed", len(data))
+		}
+
+		buf := &bytes.Buffer{}
+		n, err := sm.WriteTo(buf)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		if n != int64(len(data)) || !bytes.Equal(buf.Bytes(), data) {
+			t.Fatal(name, "WriteTo differs from Pack")
+		}
+	}
+	t.Log("pass")
+}
+
+func Test_WriteToAllocs(t *testing.T) {
+	se, rr := benchEnvelope(), benchRetrResp()
+	for _, m := range []sizedMsg{se, rr} {
+		if n := testing.AllocsPerRun(100, func() { m.WriteTo(io.Discard) }); n != 0 {
+			t.Fatal("WriteTo allocs", n)
+		}
+	}
+	t.Log("pass")
+}
+
+func Test_ReadFrame(t *testing.T) {
+	rr := benchRetrResp()
+	rra := goldenMsgs()["RespRetrAttachment"].(*bmprotocol.RespRetrAttachment)
+
+	buf := &bytes.Buffer{}
+	rr.WriteTo(buf)
+	rra.WriteTo(buf)
+	data, _ := rr.Pack()
+	cdata, err := bmprotocol.CompressPack(append([]byte(nil), data...), translayer.CompressGzip)
+	if err != nil || len(cdata) >= len(data) {
+		t.Fatal("not compressed", err)
+	}
+	buf.Write(cdata)
+
+	for i, m := range []goldenMsg{rr, rra, rr} {
+		f, err := bmprotocol.ReadFrame(buf, 0)
+		if err != nil {
+			t.Fatal(i, err)
+		}
+		if f.GetMsgType() != m.(interface{ GetMsgType() uint16 }).GetMsgType() {
+			t.Fatal(i, "wrong type")
+		}
+		want, _ := packData(m)
+		if !bytes.Equal(f.Data, want) {
+			t.Fatal(i, "wrong data")
+		}
+		f.Release()
+	}
+	if _, err := bmprotocol.ReadFrame(buf, 0); err != io.EOF {
+		t.Fatal("read past the frames", err)
+	}
+
+	rr.WriteTo(buf)
+	if _, err := bmprotocol.ReadFrame(buf, 16); err != bmprotocol.ErrFrameTooLarge {
+		t.Fatal("large frame read", err)
+	}
+
+	buf.Reset()
+	buf.Write(data[:len(data)-1])
+	if _, err := bmprotocol.ReadFrame(buf, 0); err != io.ErrUnexpectedEOF {
+		t.Fatal("short frame read", err)
+	}
+	t.Log("pass")
+}
+
+func benchEnvelope() *bmprotocol.SendEnvelope {
+	se := goldenMsgs()["SendEnvelope"].(*bmprotocol.SendEnvelope)
+	se.Data = string(bytes.Repeat([]byte("mail body "), 400))
+	return se
+}
+
+//benchRetrResp is a retrieval of 32 mails of 4KB each
+func benchRetrResp() *bmprotocol.BPOPRetrResp {
+	rr := goldenMsgs()["BPOPRetrResp"].(*bmprotocol.BPOPRetrResp)
+	ce := rr.Mails[0]
+	ce.CipherTxt = make([]byte, 4096)
+	rr.Mails = nil
+	for i := 0; i < 32; i++ {
+		rr.Mails = append(rr.Mails, ce)
+	}
+	rr.RetrCount = 32
+	return rr
+}
+
+//roundTripPack send m the old way, Pack then read by fresh buffers
+func roundTripPack(b *testing.B, m goldenMsg, got goldenMsg) {
+	buf := &bytes.Buffer{}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		data, err := m.Pack()
+		if err != nil {
+			b.Fatal(err)
+		}
+		buf.Write(data)
+
+		head := make([]byte, translayer.BMHeadSize())
+		io.ReadFull(buf, head)
+		bmtl := &translayer.BMTransLayer{}
+		bmtl.UnPack(head)
+		body := make([]byte, bmtl.GetDataLen())
+		io.ReadFull(buf, body)
+		if _, err := got.UnPack(body); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+//roundTripWriteTo send m by WriteTo and read it by pooled frames
+func roundTripWriteTo(b *testing.B, m sizedMsg, got goldenMsg) {
+	buf := &bytes.Buffer{}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		if _, err := m.WriteTo(buf); err != nil {
+			b.Fatal(err)
+		}
+
+		f, err := bmprotocol.ReadFrame(buf, 0)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := got.UnPack(f.Data); err != nil {
+			b.Fatal(err)
+		}
+		f.Release()
+	}
+}
+
+func Benchmark_EnvelopePack(b *testing.B) {
+	roundTripPack(b, benchEnvelope(), &bmprotocol.SendEnvelope{})
+}
+
+func Benchmark_EnvelopeWriteTo(b *testing.B) {
+	roundTripWriteTo(b, benchEnvelope(), &bmprotocol.SendEnvelope{})
+}
+
+func Benchmark_RetrRespPack(b *testing.B) {
+	roundTripPack(b, benchRetrResp(), &bmprotocol.BPOPRetrResp{})
+}
+
+func Benchmark_RetrRespWriteTo(b *testing.B) {
+	roundTripWriteTo(b, benchRetrResp(), &bmprotocol.BPOPRetrResp{})
+}
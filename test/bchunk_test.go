@@ -0,0 +1,230 @@
+package test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"github.com/realbmail/go-bmail-protocol/bmprotocol"
+	"github.com/realbmail/go-bmail-protocol/translayer"
+	"io"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func newChunkAttachment(file []byte) *bmprotocol.SendAttachment {
+	sa := bmprotocol.NewSendAttachment()
+	sa.Hash = []byte("hash of file")
+	sa.FileName = "chunk.bin"
+	sa.FileSize = len(file)
+	sa.Sn = []byte("session sn")
+	sa.Sig = []byte("session sig")
+	sa.EId[0] = 7
+	sa.File = bytes.NewReader(file)
+
+	return sa
+}
+
+//read in small pieces so the head is copied across many Read calls
+func readSmall(r io.Reader) ([]byte, error) {
+	var out []byte
+	p := make([]byte, 3)
+	for {
+		n, err := r.Read(p)
+		out = append(out, p[:n]...)
+		if err == io.EOF {
+			return out, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func Test_SendAttachmentChunk(t *testing.T) {
+	file := make([]byte, 1000)
+	rand.Read(file)
+
+	sa := newChunkAttachment(file)
+	sa.NextChunk(900, 300)
+	if sa.Offset != 900 || sa.Length != 100 {
+		t.Fatal("failed", sa.Offset, sa.Length)
+	}
+
+	sar, err := sa.GetReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := readSmall(sar)
+	if err != nil || len(data) != sar.GetTotalSize() {
+		t.Fatal("failed", err, len(data))
+	}
+
+	bmtl := &translayer.BMTransLayer{}
+	offset, _ := bmtl.UnPack(data)
+	if int(bmtl.GetDataLen()) != len(data)-offset {
+		t.Fatal("failed data len", bmtl.GetDataLen())
+	}
+
+	saUnPack := &bmprotocol.SendAttachment{}
+	of, err := saUnPack.UnPack(data[offset:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	offset += of
+
+	if saUnPack.Offset != 900 || saUnPack.Length != 100 || saUnPack.EId != sa.EId ||
+		!bytes.Equal(data[offset:], file[900:]) {
+		t.Fatal("failed")
+	}
+
+	t.Log("pass")
+}
+
+func Test_SendAttachmentProbe(t *testing.T) {
+	sa := newChunkAttachment(make([]byte, 10))
+	sa.NextChunk(0, 0)
+
+	sar, err := sa.GetReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := readSmall(sar)
+	if err != nil || len(data) != sar.GetTotalSize() {
+		t.Fatal("failed", err)
+	}
+
+	sa.NextChunk(5, 10)
+	sa.Offset = 8
+	if _, err := sa.GetReader(); err == nil {
+		t.Fatal("chunk out of file not refused")
+	}
+
+	//Offset+Length overflows to a size in the file
+	sa.Offset, sa.Length = math.MaxInt64, 1
+	if _, err := sa.GetReader(); err == nil {
+		t.Fatal("overflowed chunk not refused")
+	}
+	data, err = bmprotocol.Marshal(sa)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&bmprotocol.SendAttachment{}).UnPack(data); err == nil {
+		t.Fatal("overflowed chunk unpacked")
+	}
+	sa.NextChunk(5, math.MaxInt64)
+	if sa.Length != 5 {
+		t.Fatal("failed", sa.Length)
+	}
+
+	t.Log("pass")
+}
+
+func Test_RespSendAttachment(t *testing.T) {
+	rsa := bmprotocol.NewRespSendAttachment()
+	rsa.Hash = []byte("hash of file")
+	rsa.FileName = "chunk.bin"
+	rsa.FileSize = 1000
+	rsa.Sn = []byte("sn")
+	rsa.NewSn = []byte("new sn")
+	rsa.ErrId = 0
+	rsa.Received = 900
+
+	data, err := rsa.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bmtl := &translayer.BMTransLayer{}
+	offset, _ := bmtl.UnPack(data)
+
+	rsaUnPack := &bmprotocol.RespSendAttachment{}
+	rsaUnPack.BMTransLayer = *bmtl
+	of, err := rsaUnPack.UnPack(data[offset:])
+	if err != nil || offset+of != len(data) {
+		t.Fatal("failed", err)
+	}
+
+	if rsaUnPack.Received != 900 || !bytes.Equal(rsaUnPack.NewSn, rsa.NewSn) {
+		t.Fatal("failed")
+	}
+
+	t.Log("pass")
+}
+
+func Test_RetrAttachment(t *testing.T) {
+	ra := bmprotocol.NewRetrAttachment()
+	ra.Hash = []byte("hash of file")
+	ra.FileSize = 1000
+	ra.Sn = []byte("session sn")
+	ra.Sig = []byte("session sig")
+	ra.Path = "/big/file"
+	ra.Offset = 100
+	ra.Length = 200
+
+	data, err := ra.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bmtl := &translayer.BMTransLayer{}
+	offset, _ := bmtl.UnPack(data)
+
+	raUnPack := &bmprotocol.RetrAttachment{}
+	raUnPack.BMTransLayer = *bmtl
+	raUnPack.UnPack(data[offset:])
+
+	if ra.String() != raUnPack.String() {
+		t.Fatal("failed")
+	}
+
+	t.Log("pass")
+}
+
+func Test_RespRetrAttachment(t *testing.T) {
+	rra := bmprotocol.NewRespRetrAttachment()
+	rra.Hash = []byte("hash of file")
+	rra.FileSize = 1000
+	rra.Offset = 100
+	rra.Length = 3
+	rra.Data = []byte{1, 2, 3}
+
+	data, err := rra.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bmtl := &translayer.BMTransLayer{}
+	offset, _ := bmtl.UnPack(data)
+
+	rraUnPack := &bmprotocol.RespRetrAttachment{}
+	rraUnPack.BMTransLayer = *bmtl
+	of, err := rraUnPack.UnPack(data[offset:])
+	if err != nil || offset+of != len(data) {
+		t.Fatal("failed", err)
+	}
+	if rra.String() != rraUnPack.String() || !bytes.Equal(rraUnPack.Data, rra.Data) {
+		t.Fatal("failed")
+	}
+
+	rra.Data = rra.Data[:2]
+	if _, err := rra.Pack(); err == nil {
+		t.Fatal("short data not refused")
+	}
+
+	t.Log("pass")
+}
+
+func Test_FilePropertyVerify(t *testing.T) {
+	file := []byte("content of the attachment")
+	hash := sha256.Sum256(file)
+
+	fp := &bmprotocol.FileProperty{Hash: hash[:], FileSize: len(file)}
+	if fp.Verify(bytes.NewReader(file)) != nil {
+		t.Fatal("failed")
+	}
+	if fp.Verify(bytes.NewReader(file[1:])) == nil {
+		t.Fatal("short file not refused")
+	}
+
+	t.Log("pass")
+}
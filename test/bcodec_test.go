@@ -0,0 +1,335 @@
+package test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"github.com/realbmail/go-bmail-protocol/bmprotocol"
+	"github.com/realbmail/go-bmail-protocol/translayer"
+	"io"
+	"testing"
+)
+
+//goldenPack is the data of messages packed by the hand-written Pack of the
+//baseline, heads cut off, the codec gives the same bytes. ContactHello and
+//ContactAdd were unpacked and packed again by it, ContactAddResp had no
+//UnPack and the same layout.
+var goldenPack = map[string]string{
+	"EnvelopeRoute":         "000f616c69636540626d61696c2e636f6d000d626f6240626d61696c2e636f6d000000010010000102030405060708090a0b0c0d0e0f",
+	"EnvelopeSig":           "0007736e2d30313233000e7369672d30313233343536373839",
+	"EnvelopeCryptDesc":     "0000000b00000002000301020300020405",
+	"FileProperty":          "0002aabb0005612e7478740000000201000004d2",
+	"Attachment":            "00022f700002aabb0005612e7478740000000201000004d2",
+	"EnvelopeContent":       "00000001000d626f6240626d61696c2e636f6d00000001000f6361726f6c40626d61696c2e636f6d00000002000e6461766540626d61696c2e636f6d000d65766540626d61696c2e636f6d0000000268690000000568656c6c6f000100022f700002aabb0005612e7478740000000201000004d2",
+	"Envelope":              "0007736e2d30313233000e7369672d30313233343536373839000f616c69636540626d61696c2e636f6d000d626f6240626d61696c2e636f6d000000010010000102030405060708090a0b0c0d0e0f0000000b0000000200030102030002040500000001000d626f6240626d61696c2e636f6d00000001000f6361726f6c40626d61696c2e636f6d00000002000e6461766540626d61696c2e636f6d000d65766540626d61696c2e636f6d0000000268690000000568656c6c6f000100022f700002aabb0005612e7478740000000201000004d2",
+	"CryptEnvelope":         "0007736e2d30313233000e7369672d30313233343536373839000f616c69636540626d61696c2e636f6d000d626f6240626d61696c2e636f6d000000010010000102030405060708090a0b0c0d0e0f0000000b0000000200030102030002040500000006636970686572",
+	"ConfirmEnvelope":       "0002736e00056e6577736e0010000102030405060708090a0b0c0d0e0f0002687300000003",
+	"ListNode":              "0000000700000400",
+	"DelSection":            "0000000100000005",
+	"DelSectionResult":      "000000010000000500000001",
+	"Cell":                  "000331323300066d6f62696c65",
+	"BMailAddrss":           "000d626f6240626d61696c2e636f6d0003626f620006667269656e64000331323300066d6f62696c650020202122232425262728292a2b2c2d2e2f303132333435363738393a3b3c3d3e3f",
+	"GroupDesc":             "0020202122232425262728292a2b2c2d2e2f303132333435363738393a3b3c3d3e3f0000000100047465616d",
+	"ContactHello":          "000f616c69636540626d61696c2e636f6d00100102030405060708090a0b0c0d0e0f10",
+	"ContactAdd":            "0010404142434445464748494a4b4c4d4e4f00037369670010808182838485868788898a8b8c8d8e8f00000001000d626f6240626d61696c2e636f6d0003626f620006667269656e64000331323300066d6f62696c650020202122232425262728292a2b2c2d2e2f303132333435363738393a3b3c3d3e3f000000010020202122232425262728292a2b2c2d2e2f303132333435363738393a3b3c3d3e3f0000000100047465616d",
+	"ContactAddResp":        "0010404142434445464748494a4b4c4d4e4f00100102030405060708090a0b0c0d0e0f100010808182838485868788898a8b8c8d8e8f00000002",
+	"SendEnvelope":          "0007736e2d30313233000e7369672d30313233343536373839000f616c69636540626d61696c2e636f6d000d626f6240626d61696c2e636f6d000000010010000102030405060708090a0b0c0d0e0f0000000b0000000200030102030002040500000001000d626f6240626d61696c2e636f6d00000001000f6361726f6c40626d61696c2e636f6d00000002000e6461766540626d61696c2e636f6d000d65766540626d61696c2e636f6d0000000268690000000568656c6c6f000100022f700002aabb0005612e7478740000000201000004d2",
+	"SendCryptEnvelope":     "0007736e2d30313233000e7369672d30313233343536373839000f616c69636540626d61696c2e636f6d000d626f6240626d61696c2e636f6d000000010010000102030405060708090a0b0c0d0e0f0000000b0000000200030102030002040500000006636970686572",
+	"RespSendEnvelope":      "0002736e00056e6577736e0010000102030405060708090a0b0c0d0e0f0002687300000003",
+	"RespSendCryptEnvelope": "0002736e00056e6577736e0010000102030405060708090a0b0c0d0e0f0002687300000003",
+}
+
+//goldenNew is the data of messages the codec packs unlike the baseline, it
+//is the codec checked against itself. The baseline sent only the head of
+//the BPOP messages, CryptContactHello, ContactHelloResp and CryptContactAdd
+//(SetData was commented out), BMHello and BMHelloACK gained the versions
+//and RespSendAttachment, SendAttachment, RetrAttachment and
+//RespRetrAttachment the range fields after it.
+var goldenNew = map[string]string{
+	"BMHello":            "000101",
+	"BMHelloACK":         "0002736e000101",
+	"RespSendAttachment": "0002aabb0005612e7478740000000201000004d20002736e00056e6577736e0010000102030405060708090a0b0c0d0e0f000000010000000000000064",
+	"RetrAttachment":     "0002aabb0005612e7478740000000201000004d20007736e2d30313233000e7369672d303132333435363738390010000102030405060708090a0b0c0d0e0f00022f70000000000000000a0000000000000014",
+	"RespRetrAttachment": "0002aabb0005612e7478740000000201000004d20010000102030405060708090a0b0c0d0e0f0000000000000000000000000000000300000000616263",
+	"BPOPStatResp":       "000000640000000a000000000bebc200000000003b9aca00",
+	"BPOPList":           "000000030000000a",
+	"BPOPListResp":       "000000030000000a0000000200000007000004000000000800000800",
+	"BPOPRetr":           "0000000300000002",
+	"BPOPRetrResp":       "000000010007736e2d30313233000e7369672d30313233343536373839000f616c69636540626d61696c2e636f6d000d626f6240626d61696c2e636f6d000000010010000102030405060708090a0b0c0d0e0f0000000b0000000200030102030002040500000006636970686572000000030000000100000009",
+	"BPOPDelete":         "0000000100000001000000050002736e0003736967",
+	"BPOPDeleteResp":     "000000010000000100000005000000000002736e",
+	"CryptContactHello":  "0010404142434445464748494a4b4c4d4e4f00000006636970686572",
+	"ContactHelloResp":   "0010404142434445464748494a4b4c4d4e4f00100102030405060708090a0b0c0d0e0f1000037369670010808182838485868788898a8b8c8d8e8f",
+	"CryptContactAdd":    "0010404142434445464748494a4b4c4d4e4f00037369670010808182838485868788898a8b8c8d8e8f00000006636970686572",
+	"SendAttachment":     "0002aabb0005612e7478740000000201000004d20007736e2d30313233000e7369672d303132333435363738390010000102030405060708090a0b0c0d0e0f00000000000000000000000000000000",
+}
+
+//goldenHex is the golden data of the message name
+func goldenHex(name string) (string, bool) {
+	if h, ok := goldenPack[name]; ok {
+		return h, true
+	}
+	h, ok := goldenNew[name]
+	return h, ok
+}
+
+type goldenMsg interface {
+	Pack() ([]byte, error)
+	UnPack(data []byte) (int, error)
+}
+
+func goldenEId() (e translayer.EnveUniqID) {
+	for i := range e {
+		e[i] = byte(i)
+	}
+	return
+}
+
+func goldenGid() (g bmprotocol.Gid) {
+	for i := range g {
+		g[i] = byte(0x20 + i)
+	}
+	return
+}
+
+//goldenMsgs build the messages of goldenPack and goldenNew, the ones with
+//unexported fields are empty and checked by unpack and pack again
+func goldenMsgs() map[string]goldenMsg {
+	route := bmprotocol.EnvelopeRoute{From: "alice@bmail.com", RecpAddr: "bob@bmail.com", RecpAddrType: 1, EId: goldenEId()}
+	sig := bmprotocol.EnvelopeSig{Sn: []byte("sn-0123"), Sig: []byte("sig-0123456789")}
+	cd := bmprotocol.EnvelopeCryptDesc{Mode: 11, Pubkeys: [][]byte{{1, 2, 3}, {4, 5}}}
+	fp := bmprotocol.FileProperty{Hash: []byte{0xaa, 0xbb}, FileName: "a.txt", FileType: 2, IsEnCrypt: true, FileSize: 1234}
+	att := bmprotocol.Attachment{Path: "/p", FileProperty: fp}
+	content := bmprotocol.EnvelopeContent{
+		To:      []string{"bob@bmail.com"},
+		CC:      []string{"carol@bmail.com"},
+		BC:      []string{"dave@bmail.com", "eve@bmail.com"},
+		Subject: "hi",
+		Data:    "hello",
+		Files:   []bmprotocol.Attachment{att},
+	}
+	env := bmprotocol.Envelope{EnvelopeSig: sig, EnvelopeRoute: route, EnvelopeCryptDesc: cd, EnvelopeContent: content}
+	cenv := bmprotocol.CryptEnvelope{EnvelopeSig: sig, EnvelopeRoute: route, EnvelopeCryptDesc: cd, CipherTxt: []byte("cipher")}
+	conf := bmprotocol.ConfirmEnvelope{Sn: []byte("sn"), NewSn: []byte("newsn"), EId: goldenEId(), CxtHashSig: []byte("hs"), ErrId: 3}
+	cell := bmprotocol.Cell{PhoneNum: "123", PhoneType: "mobile"}
+	bma := bmprotocol.BMailAddrss{MailAddress: "bob@bmail.com", Alias: "bob", Desc: "friend", Phone: cell, GroupId: goldenGid()}
+	gd := bmprotocol.GroupDesc{GroupId: goldenGid(), GroupType: 1, GroupName: "team"}
+
+	se := bmprotocol.NewSendEnvelope()
+	se.Envelope = env
+	sce := bmprotocol.NewSendCryptEnvelope()
+	sce.CryptEnvelope = cenv
+	rse := bmprotocol.NewRespSendEnvelope()
+	rse.ConfirmEnvelope = conf
+	rsce := bmprotocol.NewRespSendCryptEnvelope()
+	rsce.ConfirmEnvelope = conf
+	helo := bmprotocol.NewBMHello()
+	helo.SetAccept([]uint16{1})
+	ack := bmprotocol.NewBMHelloACK([]byte("sn"))
+	ack.SetAccept([]uint16{1})
+
+	rsa := bmprotocol.NewRespSendAttachment()
+	rsa.FileProperty, rsa.Sn, rsa.NewSn, rsa.EId, rsa.ErrId, rsa.Received = fp, []byte("sn"), []byte("newsn"), goldenEId(), 1, 100
+	ra := bmprotocol.NewRetrAttachment()
+	ra.FileProperty, ra.EnvelopeSig, ra.EId, ra.Path, ra.Offset, ra.Length = fp, sig, goldenEId(), "/p", 10, 20
+	rra := bmprotocol.NewRespRetrAttachment()
+	rra.FileProperty, rra.EId, rra.Length, rra.Data = fp, goldenEId(), 3, []byte("abc")
+
+	stat := bmprotocol.NewBPOPStatResp()
+	stat.Total, stat.Received, stat.TotalStoredBytes, stat.TotalSpaceBytes = 100, 10, 200000000, 1000000000
+	list := bmprotocol.NewBPOPList()
+	list.BeginID, list.ListCount = 3, 10
+	lr := bmprotocol.NewBPOPListResp()
+	lr.BeginID, lr.ListCount, lr.Nodes = 3, 10, []*bmprotocol.ListNode{{ID: 7, SizeOfBytes: 1024}, {ID: 8, SizeOfBytes: 2048}}
+	retr := bmprotocol.NewBPOPRetr()
+	retr.BeginID, retr.RetrCount = 3, 2
+	rr := bmprotocol.NewBPOPRetrResp()
+	rr.Mails, rr.BeginID, rr.RetrCount, rr.TotalCount = []bmprotocol.CryptEnvelope{cenv}, 3, 1, 9
+	del := bmprotocol.NewBPOPDelete()
+	del.Section, del.Sn, del.Sig = []bmprotocol.DelSection{{Begin: 1, End: 5}}, []byte("sn"), []byte("sig")
+	dr := bmprotocol.NewBPOPDeleteResp()
+	dr.Result = []bmprotocol.DelSectionResult{{DelSection: bmprotocol.DelSection{Begin: 1, End: 5}}}
+	dr.Sn = []byte("sn")
+
+	return map[string]goldenMsg{
+		"EnvelopeRoute":         &route,
+		"EnvelopeSig":           &sig,
+		"EnvelopeCryptDesc":     &cd,
+		"FileProperty":          &fp,
+		"Attachment":            &att,
+		"EnvelopeContent":       &content,
+		"Envelope":              &env,
+		"CryptEnvelope":         &cenv,
+		"ConfirmEnvelope":       &conf,
+		"ListNode":              &bmprotocol.ListNode{ID: 7, SizeOfBytes: 1024},
+		"DelSection":            &bmprotocol.DelSection{Begin: 1, End: 5},
+		"DelSectionResult":      &bmprotocol.DelSectionResult{DelSection: bmprotocol.DelSection{Begin: 1, End: 5}, ErroCode: 1},
+		"Cell":                  &cell,
+		"BMailAddrss":           &bma,
+		"GroupDesc":             &gd,
+		"ContactHello":          &bmprotocol.ContactHello{},
+		"ContactAdd":            &bmprotocol.ContactAdd{},
+		"ContactAddResp":        &bmprotocol.ContactAddResp{},
+		"SendEnvelope":          se,
+		"SendCryptEnvelope":     sce,
+		"RespSendEnvelope":      rse,
+		"RespSendCryptEnvelope": rsce,
+		"BMHello":               helo,
+		"BMHelloACK":            ack,
+		"RespSendAttachment":    rsa,
+		"RetrAttachment":        ra,
+		"RespRetrAttachment":    rra,
+		"BPOPStatResp":          stat,
+		"BPOPList":              list,
+		"BPOPListResp":          lr,
+		"BPOPRetr":              retr,
+		"BPOPRetrResp":          rr,
+		"BPOPDelete":            del,
+		"BPOPDeleteResp":        dr,
+		"CryptContactHello":     bmprotocol.NewCryptContactHello(),
+		"ContactHelloResp":      bmprotocol.NewContactHelloResp(),
+		"CryptContactAdd":       bmprotocol.NewCryptContactAdd(),
+	}
+}
+
+//goldenUnPacked are the messages with unexported fields, they are built by
+//unpacking the golden data
+var goldenUnPacked = map[string]bool{
+	"ContactHello":      true,
+	"ContactAdd":        true,
+	"ContactAddResp":    true,
+	"CryptContactHello": true,
+	"ContactHelloResp":  true,
+	"CryptContactAdd":   true,
+}
+
+//packData pack m and cut off the head of a message that has one
+func packData(m goldenMsg) ([]byte, error) {
+	data, err := m.Pack()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := m.(interface{ GetMsgType() uint16 }); !ok {
+		return data, nil
+	}
+	bmtl := &translayer.BMTransLayer{}
+	if _, err := bmtl.UnPack(data); err != nil {
+		return nil, err
+	}
+	data = data[translayer.BMHeadSize():]
+	if int(bmtl.GetDataLen()) != len(data) {
+		return nil, errors.New("data length in the head is wrong")
+	}
+	return data, nil
+}
+
+func Test_CodecGolden(t *testing.T) {
+	msgs := goldenMsgs()
+	for name, m := range msgs {
+		h, ok := goldenHex(name)
+		if !ok {
+			t.Fatal("no golden data", name)
+		}
+		want, _ := hex.DecodeString(h)
+
+		if goldenUnPacked[name] {
+			if n, err := m.UnPack(want); err != nil || n != len(want) {
+				t.Fatal(name, "unpack failed", err)
+			}
+		}
+
+		got, err := packData(m)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("%s packed\n%x\nwant\n%x", name, got, want)
+		}
+
+		n, err := m.UnPack(want)
+		if err != nil || n != len(want) {
+			t.Fatal(name, "unpack failed", err)
+		}
+		if got, _ = packData(m); !bytes.Equal(got, want) {
+			t.Fatal(name, "pack after unpack differs")
+		}
+	}
+	t.Log("pass")
+}
+
+func Test_CodecGoldenChunkHead(t *testing.T) {
+	want, _ := hex.DecodeString(goldenNew["SendAttachment"])
+
+	sa := bmprotocol.NewSendAttachment()
+	sa.FileProperty = bmprotocol.FileProperty{Hash: []byte{0xaa, 0xbb}, FileName: "a.txt", FileType: 2, IsEnCrypt: true, FileSize: 1234}
+	sa.EnvelopeSig = bmprotocol.EnvelopeSig{Sn: []byte("sn-0123"), Sig: []byte("sig-0123456789")}
+	sa.EId = goldenEId()
+
+	sar, err := sa.GetReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, sar.GetTotalSize())
+	if _, err := io.ReadFull(sar, data); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data[translayer.BMHeadSize():], want) {
+		t.Fatal("failed")
+	}
+
+	got := &bmprotocol.SendAttachment{}
+	if n, err := got.UnPack(want); err != nil || n != len(want) || got.FileName != "a.txt" || got.EId != sa.EId {
+		t.Fatal("failed", err)
+	}
+	t.Log("pass")
+}
+
+//the tags give the bytes of the bmcommon.go helpers, the count of
+//PackLongStringArray is 16 bits
+func Test_CodecTags(t *testing.T) {
+	type tagged struct {
+		Short []string `bm:"short"`
+		Long  []string `bm:"long,n16"`
+		Blob  []byte   `bm:"long"`
+		Small int      `bm:"u16"`
+		Skip  int      `bm:"-"`
+	}
+	v := &tagged{Short: []string{"a", "bc"}, Long: []string{"long"}, Blob: []byte{1, 2}, Small: 7, Skip: 9}
+	data, err := bmprotocol.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var want []byte
+	b, _ := bmprotocol.PackShortStringArray(v.Short)
+	want = append(want, b...)
+	b, _ = bmprotocol.PackLongStringArray(v.Long)
+	want = append(want, b...)
+	b, _ = bmprotocol.PackLongBytes(v.Blob)
+	want = append(want, b...)
+	want = append(want, translayer.UInt16ToBuf(7)...)
+	if !bytes.Equal(data, want) {
+		t.Fatal("failed")
+	}
+
+	got := &tagged{}
+	if n, err := bmprotocol.Unmarshal(data, got); err != nil || n != len(data) {
+		t.Fatal("failed", err)
+	}
+	if got.Short[1] != "bc" || got.Long[0] != "long" || got.Small != 7 || got.Skip != 0 {
+		t.Fatal("failed")
+	}
+	for i := 0; i < len(data); i++ {
+		if _, err := bmprotocol.Unmarshal(data[:i], &tagged{}); err == nil {
+			t.Fatal("short data unpacked")
+		}
+	}
+
+	type bad struct {
+		F float64
+	}
+	if _, err := bmprotocol.Marshal(&bad{}); err == nil {
+		t.Fatal("float packed")
+	}
+	t.Log("pass")
+}
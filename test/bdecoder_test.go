@@ -0,0 +1,228 @@
+package test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"github.com/realbmail/go-bmail-protocol/bmprotocol"
+	"github.com/realbmail/go-bmail-protocol/translayer"
+	"sort"
+	"testing"
+)
+
+//unpackers give an empty message of every kind, the index in the list is
+//the first input of Fuzz_UnPack
+var unpackers = []struct {
+	name string
+	new  func() goldenMsg
+}{
+	{"EnvelopeRoute", func() goldenMsg { return &bmprotocol.EnvelopeRoute{} }},
+	{"EnvelopeSig", func() goldenMsg { return &bmprotocol.EnvelopeSig{} }},
+	{"EnvelopeCryptDesc", func() goldenMsg { return &bmprotocol.EnvelopeCryptDesc{} }},
+	{"FileProperty", func() goldenMsg { return &bmprotocol.FileProperty{} }},
+	{"Attachment", func() goldenMsg { return &bmprotocol.Attachment{} }},
+	{"EnvelopeContent", func() goldenMsg { return &bmprotocol.EnvelopeContent{} }},
+	{"Envelope", func() goldenMsg { return &bmprotocol.Envelope{} }},
+	{"CryptEnvelope", func() goldenMsg { return &bmprotocol.CryptEnvelope{} }},
+	{"ConfirmEnvelope", func() goldenMsg { return &bmprotocol.ConfirmEnvelope{} }},
+	{"ListNode", func() goldenMsg { return &bmprotocol.ListNode{} }},
+	{"DelSection", func() goldenMsg { return &bmprotocol.DelSection{} }},
+	{"DelSectionResult", func() goldenMsg { return &bmprotocol.DelSectionResult{} }},
+	{"Cell", func() goldenMsg { return &bmprotocol.Cell{} }},
+	{"BMailAddrss", func() goldenMsg { return &bmprotocol.BMailAddrss{} }},
+	{"GroupDesc", func() goldenMsg { return &bmprotocol.GroupDesc{} }},
+	{"ContactHello", func() goldenMsg { return &bmprotocol.ContactHello{} }},
+	{"ContactAdd", func() goldenMsg { return &bmprotocol.ContactAdd{} }},
+	{"ContactAddResp", func() goldenMsg { return &bmprotocol.ContactAddResp{} }},
+	{"SendEnvelope", func() goldenMsg { return bmprotocol.NewSendEnvelope() }},
+	{"SendCryptEnvelope", func() goldenMsg { return bmprotocol.NewSendCryptEnvelope() }},
+	{"RespSendEnvelope", func() goldenMsg { return bmprotocol.NewRespSendEnvelope() }},
+	{"RespSendCryptEnvelope", func() goldenMsg { return bmprotocol.NewRespSendCryptEnvelope() }},
+	{"BMHello", func() goldenMsg { return bmprotocol.NewBMHello() }},
+	{"BMHelloACK", func() goldenMsg { return bmprotocol.NewBMHelloACK(nil) }},
+	{"RespSendAttachment", func() goldenMsg { return bmprotocol.NewRespSendAttachment() }},
+	{"RetrAttachment", func() goldenMsg { return bmprotocol.NewRetrAttachment() }},
+	{"RespRetrAttachment", func() goldenMsg { return bmprotocol.NewRespRetrAttachment() }},
+	{"BPOPStatResp", func() goldenMsg { return bmprotocol.NewBPOPStatResp() }},
+	{"BPOPList", func() goldenMsg { return bmprotocol.NewBPOPList() }},
+	{"BPOPListResp", func() goldenMsg { return bmprotocol.NewBPOPListResp() }},
+	{"BPOPRetr", func() goldenMsg { return bmprotocol.NewBPOPRetr() }},
+	{"BPOPRetrResp", func() goldenMsg { return bmprotocol.NewBPOPRetrResp() }},
+	{"BPOPDelete", func() goldenMsg { return bmprotocol.NewBPOPDelete() }},
+	{"BPOPDeleteResp", func() goldenMsg { return bmprotocol.NewBPOPDeleteResp() }},
+	{"CryptContactHello", func() goldenMsg { return bmprotocol.NewCryptContactHello() }},
+	{"ContactHelloResp", func() goldenMsg { return bmprotocol.NewContactHelloResp() }},
+	{"CryptContactAdd", func() goldenMsg { return bmprotocol.NewCryptContactAdd() }},
+	{"SendAttachment", func() goldenMsg { return &sendAttachmentMsg{bmprotocol.NewSendAttachment()} }},
+	{"BMTransLayer", func() goldenMsg { return &translayer.BMTransLayer{} }},
+}
+
+//sendAttachmentMsg is a SendAttachment packed by its reader, the chunk
+//left out
+type sendAttachmentMsg struct {
+	*bmprotocol.SendAttachment
+}
+
+func (m *sendAttachmentMsg) Pack() ([]byte, error) {
+	m.Length = 0
+	sar, err := m.GetReader()
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, sar.GetTotalSize())
+	_, err = sar.Read(data)
+	return data, err
+}
+
+//checkUnPack unpack data, it must not panic nor read past data. What is
+//unpacked must pack and unpack to the same bytes.
+func checkUnPack(t *testing.T, i int, data []byte) {
+	m := unpackers[i].new()
+	n, err := m.UnPack(data)
+	if err != nil {
+		return
+	}
+	if n < 0 || n > len(data) {
+		t.Fatal(unpackers[i].name, "read", n, "of", len(data))
+	}
+
+	packed, err := packData(m)
+	if err != nil {
+		return
+	}
+	if _, ok := m.(*translayer.BMTransLayer); ok {
+		return
+	}
+	m2 := unpackers[i].new()
+	if _, err := m2.UnPack(packed); err != nil {
+		t.Fatal(unpackers[i].name, "packed data not unpacked", err)
+	}
+	again, err := packData(m2)
+	if err != nil || !bytes.Equal(packed, again) {
+		t.Fatal(unpackers[i].name, "pack after unpack differs", err)
+	}
+}
+
+func Fuzz_UnPack(f *testing.F) {
+	var names []string
+	for _, golden := range []map[string]string{goldenPack, goldenNew} {
+		for name := range golden {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		h, _ := goldenHex(name)
+		data, _ := hex.DecodeString(h)
+		for i := range unpackers {
+			if unpackers[i].name == name {
+				f.Add(uint8(i), data)
+			}
+		}
+	}
+
+	f.Fuzz(func(t *testing.T, i uint8, data []byte) {
+		checkUnPack(t, int(i)%len(unpackers), data)
+	})
+}
+
+//every message unpacks every cut of its golden data without a panic
+func Test_UnPackShortData(t *testing.T) {
+	for i := range unpackers {
+		want, ok := goldenHex(unpackers[i].name)
+		if !ok {
+			continue
+		}
+		data, _ := hex.DecodeString(want)
+		for l := 0; l < len(data); l++ {
+			checkUnPack(t, i, data[:l])
+		}
+	}
+	t.Log("pass")
+}
+
+func Test_DecoderLimits(t *testing.T) {
+	huge := []byte{0xff, 0xff, 0xff, 0xff}
+
+	//counts that ask for more elements than the data holds
+	lr := bmprotocol.NewBPOPListResp()
+	data := append(make([]byte, 8), huge...)
+	if _, err := lr.UnPack(append(data, make([]byte, 64)...)); err == nil {
+		t.Fatal("list count over the data unpacked")
+	}
+
+	rr := bmprotocol.NewBPOPRetrResp()
+	if _, err := rr.UnPack(append(huge, make([]byte, 64)...)); err == nil {
+		t.Fatal("mail count over the data unpacked")
+	}
+
+	ca := &bmprotocol.ContactAdd{}
+	data = []byte{0, 0, 0, 0, 0, 0}
+	if _, err := ca.UnPack(append(append(data, huge...), make([]byte, 64)...)); err == nil {
+		t.Fatal("mail address count over the data unpacked")
+	}
+
+	//limits of a decoder
+	ld := &bmprotocol.BPOPListResp{}
+	ld.Nodes = make([]*bmprotocol.ListNode, 10)
+	for i := range ld.Nodes {
+		ld.Nodes[i] = &bmprotocol.ListNode{ID: i}
+	}
+	packed, err := bmprotocol.Marshal(ld)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := bmprotocol.NewDecoder(packed)
+	d.MaxCount = 9
+	if err := d.Decode(&bmprotocol.BPOPListResp{}); !errors.Is(err, bmprotocol.ErrLimit) || d.Offset() != 0 {
+		t.Fatal("count limit not kept", err)
+	}
+	d.MaxCount = 10
+	got := &bmprotocol.BPOPListResp{}
+	if err := d.Decode(got); err != nil || d.Offset() != len(packed) || got.Nodes[9].ID != 9 {
+		t.Fatal("failed", err)
+	}
+
+	fp := &bmprotocol.FileProperty{FileName: "0123456789"}
+	packed, _ = bmprotocol.Marshal(fp)
+	d = bmprotocol.NewDecoder(packed)
+	d.MaxBytes = 9
+	if err := d.Decode(&bmprotocol.FileProperty{}); !errors.Is(err, bmprotocol.ErrLimit) {
+		t.Fatal("bytes limit not kept", err)
+	}
+
+	data, _ = hex.DecodeString(goldenPack["Envelope"])
+	d = bmprotocol.NewDecoder(data)
+	d.MaxDepth = 3
+	if err := d.Decode(&bmprotocol.Envelope{}); !errors.Is(err, bmprotocol.ErrLimit) {
+		t.Fatal("depth limit not kept", err)
+	}
+	d.MaxDepth = 4
+	if err := d.Decode(&bmprotocol.Envelope{}); err != nil {
+		t.Fatal(err)
+	}
+	t.Log("pass")
+}
+
+//a [N]byte field takes N bytes only, not a cut or padded id
+func Test_UnPackArrayLength(t *testing.T) {
+	route := func(eid []byte) []byte {
+		data := []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, byte(len(eid))}
+		return append(data, eid...)
+	}
+	eid := make([]byte, len(translayer.EnveUniqID{}))
+	for i := range eid {
+		eid[i] = byte(i + 1)
+	}
+
+	er := &bmprotocol.EnvelopeRoute{}
+	if _, err := bmprotocol.Unmarshal(route(eid), er); err != nil || !bytes.Equal(er.EId[:], eid) {
+		t.Fatal("failed", err)
+	}
+	for _, bad := range [][]byte{eid[:len(eid)-1], append(eid, 0), nil} {
+		if _, err := bmprotocol.Unmarshal(route(bad), &bmprotocol.EnvelopeRoute{}); err == nil {
+			t.Fatal(len(bad), "bytes unpacked to an eid")
+		}
+	}
+	t.Log("pass")
+}
@@ -2,7 +2,7 @@ package test
 
 import (
 	"fmt"
-	"github.com/BASChain/go-bmail-protocol/bmprotocol"
+	"github.com/realbmail/go-bmail-protocol/bmprotocol"
 	"math/rand"
 	"testing"
 )
@@ -23,7 +23,7 @@ func Test_EnvelopeHead(t *testing.T) {
 		}
 		break
 	}
-	eh.LPubKey = pubkey
+	copy(eh.EId[:], pubkey)
 
 	data, _ := eh.Pack()
 
@@ -72,8 +72,8 @@ func Test_EnvelopeContent(t *testing.T) {
 		break
 	}
 
-	ec.Files = []bmprotocol.Attachment{{"", bmprotocol.FileProperty{hash1, "name.doc", 0, 10200}},
-		{"", bmprotocol.FileProperty{hash2, "name2.xls", 1, 20400}}}
+	ec.Files = []bmprotocol.Attachment{{FileProperty: bmprotocol.FileProperty{Hash: hash1, FileName: "name.doc", FileSize: 10200}},
+		{FileProperty: bmprotocol.FileProperty{Hash: hash2, FileName: "name2.xls", FileType: 1, FileSize: 20400}}}
 
 	data, _ := ec.Pack()
 
@@ -90,8 +90,8 @@ func Test_EnvelopeContent(t *testing.T) {
 	}
 }
 
-func Test_EnvelopeTail(t *testing.T) {
-	et := &bmprotocol.EnvelopeTail{}
+func Test_EnvelopeSig(t *testing.T) {
+	et := &bmprotocol.EnvelopeSig{}
 
 	iv := make([]byte, 16)
 
@@ -113,13 +113,13 @@ func Test_EnvelopeTail(t *testing.T) {
 		break
 	}
 
-	et.IV = iv
+	et.Sn = iv
 	et.Sig = sig
 
 	data, _ := et.Pack()
 	fmt.Println(et.String())
 
-	etUnpack := &bmprotocol.EnvelopeTail{}
+	etUnpack := &bmprotocol.EnvelopeSig{}
 	etUnpack.UnPack(data)
 
 	fmt.Println(etUnpack.String())
@@ -135,7 +135,7 @@ func Test_EnvelopeTail(t *testing.T) {
 func Test_Envelop(t *testing.T) {
 	e := &bmprotocol.Envelope{}
 
-	eh := &e.EnvelopeHead
+	eh := &e.EnvelopeRoute
 
 	eh.From = "a@bas"
 	eh.RecpAddr = "b@bas"
@@ -150,7 +150,7 @@ func Test_Envelop(t *testing.T) {
 		}
 		break
 	}
-	eh.LPubKey = pubkey
+	copy(eh.EId[:], pubkey)
 
 	ec := &e.EnvelopeContent
 	ec.To = []string{"toa@bas", "tob@bas", "toc@bas"}
@@ -180,10 +180,10 @@ func Test_Envelop(t *testing.T) {
 		break
 	}
 
-	ec.Files = []bmprotocol.Attachment{{"", bmprotocol.FileProperty{hash1, "name.doc", 0, 10200}},
-		{"", bmprotocol.FileProperty{hash2, "name2.xls", 1, 20400}}}
+	ec.Files = []bmprotocol.Attachment{{FileProperty: bmprotocol.FileProperty{Hash: hash1, FileName: "name.doc", FileSize: 10200}},
+		{FileProperty: bmprotocol.FileProperty{Hash: hash2, FileName: "name2.xls", FileType: 1, FileSize: 20400}}}
 
-	et := &e.EnvelopeTail
+	et := &e.EnvelopeSig
 	iv := make([]byte, 16)
 
 	for {
@@ -204,7 +204,7 @@ func Test_Envelop(t *testing.T) {
 		break
 	}
 
-	et.IV = iv
+	et.Sn = iv
 	et.Sig = sig
 
 	data, _ := e.Pack()
@@ -236,7 +236,7 @@ func Test_CryptEnvelope(t *testing.T) {
 
 	ce := &bmprotocol.CryptEnvelope{}
 	ce.CipherTxt = crypttxt
-	eh := &ce.EnvelopeHead
+	eh := &ce.EnvelopeRoute
 	eh.From = "a@bas"
 	eh.RecpAddr = "b@bas"
 	eh.RecpAddrType = 1
@@ -250,9 +250,9 @@ func Test_CryptEnvelope(t *testing.T) {
 		}
 		break
 	}
-	eh.LPubKey = pubkey
+	copy(eh.EId[:], pubkey)
 
-	et := &ce.EnvelopeTail
+	et := &ce.EnvelopeSig
 
 	iv := make([]byte, 16)
 
@@ -274,7 +274,7 @@ func Test_CryptEnvelope(t *testing.T) {
 		break
 	}
 
-	et.IV = iv
+	et.Sn = iv
 	et.Sig = sig
 
 	fmt.Println(ce.String())
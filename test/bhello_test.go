@@ -2,8 +2,8 @@ package test
 
 import (
 	"fmt"
-	"github.com/BASChain/go-bmail-protocol/bmprotocol"
-	"github.com/BASChain/go-bmail-protocol/translayer"
+	"github.com/realbmail/go-bmail-protocol/bmprotocol"
+	"github.com/realbmail/go-bmail-protocol/translayer"
 	"math/rand"
 	"testing"
 )
@@ -0,0 +1,151 @@
+package test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/realbmail/go-bmail-protocol/blobstore"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_BlobStoreRefs(t *testing.T) {
+	root := t.TempDir()
+
+	bs, err := blobstore.Open(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := []byte("attachment sent to many recipients")
+	hash := sha256.Sum256(data)
+
+	if bs.Has(hash[:]) {
+		t.Fatal("failed")
+	}
+
+	//upload in two chunks, the second resumes at Received
+	n, err := bs.Append(hash[:], 0, int64(len(data)), bytes.NewReader(data[:10]))
+	if err != nil || n != 10 {
+		t.Fatal("failed", n, err)
+	}
+	if _, err := bs.Append(hash[:], 5, int64(len(data)), bytes.NewReader(data[5:])); err != blobstore.ErrBadOffset {
+		t.Fatal("bad offset not refused")
+	}
+	received, _ := bs.Received(hash[:])
+	n, err = bs.Append(hash[:], received, int64(len(data)), bytes.NewReader(data[received:]))
+	if err != nil || n != int64(len(data)) || !bs.Has(hash[:]) {
+		t.Fatal("failed", n, err)
+	}
+
+	if bs.AddRef(hash[:], "eid1") != nil || bs.AddRef(hash[:], "eid2") != nil {
+		t.Fatal("failed")
+	}
+
+	//refs survive a restart
+	bs, err = blobstore.Open(root)
+	if err != nil || bs.RefCount(hash[:]) != 2 {
+		t.Fatal("failed", err)
+	}
+
+	orphans, _ := bs.Release("eid1")
+	if len(orphans) != 0 {
+		t.Fatal("failed")
+	}
+	removed, _ := bs.GC(0)
+	if removed != 0 || !bs.Has(hash[:]) {
+		t.Fatal("referenced blob removed")
+	}
+
+	f, err := bs.Open(hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := ioutil.ReadAll(f)
+	f.Close()
+	if !bytes.Equal(got, data) {
+		t.Fatal("failed")
+	}
+
+	orphans, _ = bs.Release("eid2")
+	if len(orphans) != 1 || !bytes.Equal(orphans[0], hash[:]) {
+		t.Fatal("failed")
+	}
+	if removed, _ = bs.GC(time.Hour); removed != 0 {
+		t.Fatal("young blob removed")
+	}
+	if removed, _ = bs.GC(0); removed != 1 || bs.Has(hash[:]) {
+		t.Fatal("failed", removed)
+	}
+
+	t.Log("pass")
+}
+
+func Test_BlobStoreHashMismatch(t *testing.T) {
+	bs, err := blobstore.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := []byte("data")
+	hash := sha256.Sum256([]byte("other data"))
+
+	if err := bs.Put(hash[:], int64(len(data)), bytes.NewReader(data)); err != blobstore.ErrHashMismatch {
+		t.Fatal("bad blob accepted", err)
+	}
+	if bs.Has(hash[:]) || bs.AddRef(hash[:], "eid") != blobstore.ErrNotFound {
+		t.Fatal("failed")
+	}
+
+	t.Log("pass")
+}
+
+//a ref added while GC runs is never left without its blob
+func Test_BlobStoreAddRefGC(t *testing.T) {
+	root := t.TempDir()
+	bs, err := blobstore.Open(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := []byte("data")
+	hash := sha256.Sum256(data)
+	h := hex.EncodeToString(hash[:])
+	path := filepath.Join(root, "blobs", h[:2], h)
+	old := time.Now().Add(-2 * time.Hour)
+	for i := 0; i < 50; i++ {
+		if err := bs.Put(hash[:], int64(len(data)), bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+		//a blob stored long ago and left without ref
+		os.Chtimes(path, old, old)
+
+		ref := fmt.Sprint("eid", i)
+		done := make(chan error)
+		go func() { done <- bs.AddRef(hash[:], ref) }()
+		bs.GC(time.Hour)
+		err := <-done
+		if err == nil && !bs.Has(hash[:]) {
+			t.Fatal("ref", ref, "left without its blob")
+		}
+		if err != nil && err != blobstore.ErrNotFound {
+			t.Fatal(err)
+		}
+		bs.Release(ref)
+	}
+
+	//Put of a stored blob renews it
+	bs.Put(hash[:], int64(len(data)), bytes.NewReader(data))
+	os.Chtimes(path, old, old)
+	if err := bs.Put(hash[:], int64(len(data)), bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if removed, _ := bs.GC(time.Hour); removed != 0 {
+		t.Fatal("renewed blob removed")
+	}
+	t.Log("pass")
+}
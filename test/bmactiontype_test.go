@@ -3,7 +3,7 @@ package test
 import (
 	"crypto/rand"
 	"fmt"
-	"github.com/BASChain/go-bmail-protocol/translayer"
+	"github.com/realbmail/go-bmail-protocol/translayer"
 	"testing"
 )
 
@@ -0,0 +1,215 @@
+package test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"github.com/realbmail/go-bmail-protocol/bmclient"
+	"github.com/realbmail/go-bmail-protocol/bmprotocol"
+	"github.com/realbmail/go-bmail-protocol/translayer"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+//mockBM serve the file on the BMTP port of localhost in the bm stack, a
+//chunk it sends is at most maxChunk bytes
+type mockBM struct {
+	ln       net.Listener
+	file     []byte
+	maxChunk int64
+
+	lock sync.Mutex
+	asks []*bmprotocol.RetrAttachment
+}
+
+func startMockBM(t *testing.T, file []byte) *mockBM {
+	ln, err := net.Listen("tcp4", "127.0.0.1:"+strconv.Itoa(translayer.BMTP_PORT))
+	if err != nil {
+		t.Skip("BMTP port not free:", err)
+	}
+	m := &mockBM{ln: ln, file: file, maxChunk: 7}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go m.serve(c)
+		}
+	}()
+	return m
+}
+
+func (m *mockBM) Close() {
+	m.ln.Close()
+}
+
+func (m *mockBM) serve(c net.Conn) {
+	defer c.Close()
+
+	f, err := bmprotocol.ReadFrame(c, 0)
+	if err != nil || f.GetMsgType() != translayer.HELLO {
+		return
+	}
+	f.Release()
+	ack, _ := bmprotocol.NewBMHelloACK([]byte("sn")).Pack()
+	if _, err := c.Write(ack); err != nil {
+		return
+	}
+
+	for {
+		f, err := bmprotocol.ReadFrame(c, 0)
+		if err != nil || f.GetMsgType() != translayer.RETR_ATTACHMENT {
+			return
+		}
+		ra := bmprotocol.NewRetrAttachment()
+		_, err = ra.UnPack(f.Data)
+		f.Release()
+		if err != nil {
+			return
+		}
+		l := ra.Length
+		if l > m.maxChunk {
+			l = m.maxChunk
+		}
+		resp := bmprotocol.NewRespRetrAttachment()
+		resp.FileProperty, resp.EId, resp.Offset, resp.Length = ra.FileProperty, ra.EId, ra.Offset, l
+
+		m.lock.Lock()
+		m.asks = append(m.asks, ra)
+		resp.Data = m.file[ra.Offset : ra.Offset+l]
+		m.lock.Unlock()
+		if _, err := resp.WriteTo(c); err != nil {
+			return
+		}
+	}
+}
+
+//setFile change the file served and forget the chunks asked for
+func (m *mockBM) setFile(file []byte) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.file, m.asks = file, nil
+}
+
+//asked is the end of the furthest chunk asked for
+func (m *mockBM) asked() int64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var end int64
+	for _, ra := range m.asks {
+		if ra.Offset+ra.Length > end {
+			end = ra.Offset + ra.Length
+		}
+	}
+	return end
+}
+
+func newBMClient(t *testing.T) *bmclient.BMClient {
+	c := bmclient.NewClient(net.IPv4(127, 0, 0, 1), 5)
+	if c == nil {
+		t.Fatal("no connection")
+	}
+	if err := c.HeloSendAndRcv(); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func newRetr(c *bmclient.BMClient, file []byte, offset, length int64) *bmprotocol.RetrAttachment {
+	hash := sha256.Sum256(file)
+	ra := bmprotocol.NewRetrAttachment()
+	ra.Hash, ra.FileName, ra.FileSize = hash[:], "a.bin", len(file)
+	ra.Sn, ra.Sig = c.GetSn(), []byte("sig")
+	ra.EId[0] = 1
+	ra.Offset, ra.Length = offset, length
+	return ra
+}
+
+func Test_BMClientRetrAttachment(t *testing.T) {
+	file := make([]byte, 100)
+	rand.Read(file)
+	m := startMockBM(t, file)
+	defer m.Close()
+
+	c := newBMClient(t)
+	defer c.Close()
+
+	out := &bytes.Buffer{}
+	if n, err := c.RetrAttachment(newRetr(c, file, 0, 0), 16, out); err != nil || n != 100 || !bytes.Equal(out.Bytes(), file) {
+		t.Fatal("failed", n, err)
+	}
+
+	//a changed file is found
+	changed := append([]byte(nil), file...)
+	changed[50] ^= 1
+	m.setFile(changed)
+	if _, err := c.RetrAttachment(newRetr(c, file, 0, 0), 16, &bytes.Buffer{}); err == nil {
+		t.Fatal("changed file not found")
+	}
+	m.setFile(file)
+
+	//a range is what was asked, no byte more
+	out.Reset()
+	if n, err := c.RetrAttachment(newRetr(c, file, 10, 20), 16, out); err != nil || n != 20 ||
+		!bytes.Equal(out.Bytes(), file[10:30]) || m.asked() != 30 {
+		t.Fatal("failed", n, err, m.asked())
+	}
+
+	if _, err := c.RetrAttachment(newRetr(c, file, 101, 0), 16, out); err == nil {
+		t.Fatal("offset out of file asked")
+	}
+	t.Log("pass")
+}
+
+func Test_BMClientRetrAttachmentResume(t *testing.T) {
+	file := make([]byte, 100)
+	rand.Read(file)
+	m := startMockBM(t, file)
+	defer m.Close()
+
+	c := newBMClient(t)
+	defer c.Close()
+
+	path := filepath.Join(t.TempDir(), "a.bin")
+	resume := func(prev []byte) error {
+		ioutil.WriteFile(path, prev, 0600)
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		_, err = c.RetrAttachment(newRetr(c, file, int64(len(prev)), 0), 16, f)
+		return err
+	}
+
+	if err := resume(file[:40]); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := ioutil.ReadFile(path); !bytes.Equal(got, file) {
+		t.Fatal("failed")
+	}
+
+	//the bytes of the earlier download are checked too
+	prev := append([]byte(nil), file[:40]...)
+	prev[3] ^= 1
+	if err := resume(prev); err == nil {
+		t.Fatal("bad start of the file not found")
+	}
+	if err := resume(file[:20]); err != nil {
+		t.Fatal(err)
+	}
+
+	//a resumed download needs its file to be checked
+	if _, err := c.RetrAttachment(newRetr(c, file, 40, 0), 16, &bytes.Buffer{}); err == nil {
+		t.Fatal("resumed download not checked")
+	}
+	t.Log("pass")
+}
@@ -2,8 +2,8 @@ package test
 
 import (
 	"fmt"
-	"github.com/BASChain/go-bmail-protocol/bmprotocol"
-	"github.com/BASChain/go-bmail-protocol/translayer"
+	"github.com/realbmail/go-bmail-protocol/bmprotocol"
+	"github.com/realbmail/go-bmail-protocol/translayer"
 	"math/rand"
 	"testing"
 )
@@ -21,7 +21,7 @@ func fillEH(eh *bmprotocol.EnvelopeRoute) {
 		}
 		break
 	}
-	eh.LPubKey = pubkey
+	copy(eh.EId[:], pubkey)
 }
 
 func fillET(et *bmprotocol.EnvelopeSig) {
@@ -77,14 +77,14 @@ func fillEC(ec *bmprotocol.EnvelopeContent) {
 		break
 	}
 
-	ec.Files = []bmprotocol.Attachment{{"", bmprotocol.FileProperty{hash1, "name.doc", 0, 10200}},
-		{"", bmprotocol.FileProperty{hash2, "name2.xls", 1, 20400}}}
+	ec.Files = []bmprotocol.Attachment{{FileProperty: bmprotocol.FileProperty{Hash: hash1, FileName: "name.doc", FileSize: 10200}},
+		{FileProperty: bmprotocol.FileProperty{Hash: hash2, FileName: "name2.xls", FileType: 1, FileSize: 20400}}}
 }
 
 func Test_SendEnvelope(t *testing.T) {
 	se := bmprotocol.NewSendEnvelope()
 
-	eh := &se.Envelope.EnvelopeHead
+	eh := &se.Envelope.EnvelopeRoute
 
 	fillEH(eh)
 
@@ -188,7 +188,7 @@ func Test_SendCryptEnvelope(t *testing.T) {
 
 	es := &sce.CryptEnvelope.EnvelopeSig
 	fillET(es)
-	eh := &sce.CryptEnvelope.EnvelopeHead
+	eh := &sce.CryptEnvelope.EnvelopeRoute
 	fillEH(eh)
 
 	newsn := make([]byte, 64)
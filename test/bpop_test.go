@@ -3,8 +3,8 @@ package test
 import (
 	"crypto/rand"
 	"fmt"
-	"github.com/BASChain/go-bmail-protocol/bmprotocol"
-	"github.com/BASChain/go-bmail-protocol/translayer"
+	"github.com/realbmail/go-bmail-protocol/bmprotocol"
+	"github.com/realbmail/go-bmail-protocol/translayer"
 	"testing"
 )
 
@@ -184,7 +184,7 @@ func newCE() *bmprotocol.CryptEnvelope {
 
 	ce := &bmprotocol.CryptEnvelope{}
 	ce.CipherTxt = crypttxt
-	eh := &ce.EnvelopeHead
+	eh := &ce.EnvelopeRoute
 	eh.From = "a@bas"
 	eh.RecpAddr = "b@bas"
 	eh.RecpAddrType = 1
@@ -198,9 +198,9 @@ func newCE() *bmprotocol.CryptEnvelope {
 		}
 		break
 	}
-	eh.LPubKey = pubkey
+	copy(eh.EId[:], pubkey)
 
-	et := &ce.EnvelopeTail
+	et := &ce.EnvelopeSig
 
 	iv := make([]byte, 16)
 
@@ -222,7 +222,7 @@ func newCE() *bmprotocol.CryptEnvelope {
 		break
 	}
 
-	et.IV = iv
+	et.Sn = iv
 	et.Sig = sig
 
 	return ce
@@ -263,7 +263,7 @@ func Test_BPOPRetrResp(t *testing.T) {
 func Test_BPOPDelete(t *testing.T) {
 	bd := bmprotocol.NewBPOPDelete()
 	bd.Section = []bmprotocol.DelSection{
-		{200, 0}, {230, 239}, {190, 0},
+		{Begin: 200}, {Begin: 230, End: 239}, {Begin: 190},
 	}
 
 	iv := make([]byte, 16)
@@ -311,9 +311,9 @@ func Test_BPOPDelete(t *testing.T) {
 func Test_BPOPDeleteResp(t *testing.T) {
 	bd := bmprotocol.NewBPOPDeleteResp()
 	bd.Result = []bmprotocol.DelSectionResult{
-		{bmprotocol.DelSection{200, 0}, 0},
-		{bmprotocol.DelSection{230, 239}, 0},
-		{bmprotocol.DelSection{190, 0}, 1},
+		{DelSection: bmprotocol.DelSection{Begin: 200}},
+		{DelSection: bmprotocol.DelSection{Begin: 230, End: 239}},
+		{DelSection: bmprotocol.DelSection{Begin: 190}, ErroCode: 1},
 	}
 
 	iv := make([]byte, 16)
@@ -0,0 +1,44 @@
+package test
+
+import (
+	"github.com/realbmail/go-bmail-protocol/bmp"
+	"github.com/realbmail/go-bmail-protocol/bpop"
+	"testing"
+)
+
+func Test_CmdSearchMatch(t *testing.T) {
+	env := &bmp.BMailEnvelope{FromName: "Alice@bas", FromAddr: "BMa", DateSince1970: 1000,
+		RCPTs: []*bmp.Recipient{{ToName: "bob@bas", ToAddr: "BMb", RcptType: bmp.RcpTypeTo}}}
+	meta := &bpop.MailMeta{Flags: bpop.FlagSeen | bpop.FlagStarred, Folder: "work"}
+
+	cases := []struct {
+		q    bpop.CmdSearch
+		want bool
+	}{
+		{bpop.CmdSearch{}, true},
+		{bpop.CmdSearch{FromName: "alice"}, true},
+		{bpop.CmdSearch{FromAddr: "BMx"}, false},
+		{bpop.CmdSearch{ToName: "BOB", ToAddr: "BMb"}, true},
+		{bpop.CmdSearch{ToName: "bob", ToAddr: "BMa"}, false},
+		{bpop.CmdSearch{Since: 1000, Before: 1001}, true},
+		{bpop.CmdSearch{Before: 1000}, false},
+		{bpop.CmdSearch{MinSize: 10, MaxSize: 100}, true},
+		{bpop.CmdSearch{MaxSize: 49}, false},
+		{bpop.CmdSearch{FlagsSet: bpop.FlagStarred}, true},
+		{bpop.CmdSearch{FlagsUnset: bpop.FlagSeen}, false},
+		{bpop.CmdSearch{Folder: "work"}, true},
+		{bpop.CmdSearch{Folder: bpop.FolderInbox}, false},
+	}
+
+	for i, c := range cases {
+		if c.q.Match(env, meta, 50) != c.want {
+			t.Fatal("failed case", i)
+		}
+	}
+
+	if !(&bpop.CmdSearch{Folder: bpop.FolderInbox}).Match(env, nil, 50) {
+		t.Fatal("failed nil meta")
+	}
+
+	t.Log("pass")
+}
@@ -0,0 +1,67 @@
+package test
+
+import (
+	"fmt"
+	"github.com/realbmail/go-bmail-protocol/bmp"
+	"testing"
+)
+
+func Test_ReplyThread(t *testing.T) {
+	a := &bmp.BMailEnvelope{Eid: "a", FromName: "x@bas", FromAddr: "BMx", DateSince1970: 1,
+		RCPTs: []*bmp.Recipient{{ToName: "y@bas", ToAddr: "BMy", RcptType: bmp.RcpTypeTo},
+			{ToName: "z@bas", ToAddr: "BMz", RcptType: bmp.RcpTypeCC}}}
+
+	b := a.ReplyAll("y@bas", "BMy")
+	b.Eid = "b"
+	b.DateSince1970 = 2
+
+	if b.SessionID != "a" || b.InReplyTo != "a" || len(b.References) != 1 {
+		t.Fatal("failed")
+	}
+	if len(b.RCPTs) != 2 || b.RCPTs[0].ToAddr != "BMx" || b.RCPTs[1].ToAddr != "BMz" {
+		t.Fatal("failed")
+	}
+
+	c := b.Reply("x@bas", "BMx")
+	c.Eid = "c"
+	c.DateSince1970 = 3
+
+	d := a.Forward("z@bas", "BMz")
+	d.Eid = "d"
+	d.DateSince1970 = 4
+
+	other := &bmp.BMailEnvelope{Eid: "o", DateSince1970: 0}
+
+	//c arrives first, b is missing from the download
+	threads := bmp.GroupThreads([]*bmp.BMailEnvelope{c, other, d, a, a})
+
+	if len(threads) != 2 || threads[0].SessionID != "a" || threads[0].Count != 3 {
+		t.Fatal("failed")
+	}
+
+	th := threads[0]
+	fmt.Println(th.SessionID, th.Count, th.Latest)
+
+	if len(th.Roots) != 1 || th.Roots[0].Env != a {
+		t.Fatal("failed")
+	}
+
+	children := th.Roots[0].Children
+	if len(children) != 2 || children[0].Env != c || children[1].Env != d {
+		t.Fatal("failed")
+	}
+
+	t.Log("pass")
+}
+
+func Test_GroupThreadsLoop(t *testing.T) {
+	a := &bmp.BMailEnvelope{Eid: "a", SessionID: "s", InReplyTo: "b"}
+	b := &bmp.BMailEnvelope{Eid: "b", SessionID: "s", InReplyTo: "a"}
+
+	threads := bmp.GroupThreads([]*bmp.BMailEnvelope{a, b})
+	if len(threads) != 1 || len(threads[0].Roots) != 1 {
+		t.Fatal("failed")
+	}
+
+	t.Log("pass")
+}
@@ -0,0 +1,298 @@
+package test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"github.com/realbmail/go-bmail-protocol/bmp"
+	"github.com/realbmail/go-bmail-protocol/bmprotocol"
+	"github.com/realbmail/go-bmail-protocol/bpop"
+	"github.com/realbmail/go-bmail-protocol/translayer"
+	"reflect"
+	"testing"
+)
+
+//goldenCbor is CmdDownload{MailAddr: "a", MailCnt: 2, Direction: true,
+//TimePivot: -1}, Owner and Folder are zero and left out
+const goldenCbor = "a4016161030204f50520"
+
+func Test_CborGolden(t *testing.T) {
+	cd := &bpop.CmdDownload{MailAddr: "a", MailCnt: 2, Direction: true, TimePivot: -1}
+	data, err := translayer.CborMarshal(cd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(data) != goldenCbor {
+		t.Fatal("cbor changed", hex.EncodeToString(data))
+	}
+
+	got := &bpop.CmdDownload{}
+	if err := translayer.CborUnmarshal(data, got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, cd) {
+		t.Fatal("unmarshal differs", got)
+	}
+	t.Log("pass")
+}
+
+func Test_CborBadData(t *testing.T) {
+	bad := map[string]string{
+		"truncated":    goldenCbor[:len(goldenCbor)-2],
+		"trailing":     goldenCbor + "00",
+		"key order":    "a20302016161",
+		"same key":     "a2016161016162",
+		"huge map":     "bbffffffffffffffff",
+		"huge string":  "a1017bffffffffffffffff",
+		"indefinite":   "bf016161ff",
+		"tag":          "a101c16161",
+		"wrong type":   "a10102",
+		"wrong scalar": "a10302f5",
+	}
+	for name, h := range bad {
+		data, _ := hex.DecodeString(h)
+		if err := translayer.CborUnmarshal(data, &bpop.CmdDownload{}); !errors.Is(err, translayer.ErrCbor) {
+			t.Fatal(name, "not refused", err)
+		}
+	}
+
+	//unknown keys are skipped
+	data, _ := hex.DecodeString("a2016161188261ff")
+	cd := &bpop.CmdDownload{}
+	if err := translayer.CborUnmarshal(data, cd); err != nil || cd.MailAddr != "a" {
+		t.Fatal("unknown key", err)
+	}
+	t.Log("pass")
+}
+
+//cborBig has elements that take far more memory than their one byte of
+//an empty map
+type cborBig struct {
+	Items []struct {
+		Data [1024]byte
+	}
+	Note string
+}
+
+//cborList is a struct of key 1 holding n empty maps
+func cborList(n int) []byte {
+	data := []byte{0xa1, 0x01, 0x9a, byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)}
+	return append(data, bytes.Repeat([]byte{0xa0}, n)...)
+}
+
+func Test_CborLimits(t *testing.T) {
+	if err := translayer.CborUnmarshal(cborList(1<<16+1), &cborBig{}); !errors.Is(err, translayer.ErrCborLimit) {
+		t.Fatal("count over MaxCount", err)
+	}
+
+	//64 KiB of data would make 64 MiB of slices
+	l := translayer.CborLimits{MaxBytes: 1 << 20, MaxCount: 1 << 16}
+	if err := translayer.CborUnmarshalLimits(cborList(1<<16), &cborBig{}, l); !errors.Is(err, translayer.ErrCborLimit) {
+		t.Fatal("slices over MaxBytes", err)
+	}
+	v := &cborBig{}
+	if err := translayer.CborUnmarshalLimits(cborList(1<<10), v, l); err != nil || len(v.Items) != 1<<10 {
+		t.Fatal("in the limits", err)
+	}
+
+	l.MaxBytes = 4
+	if err := translayer.CborUnmarshalLimits([]byte("\xa1\x02\x65hello"), &cborBig{}, l); !errors.Is(err, translayer.ErrCborLimit) {
+		t.Fatal("text over MaxBytes", err)
+	}
+
+	//bmprotocol takes its Limits
+	if _, err := bmprotocol.UnPackAs(translayer.BMAILVER2, cborList(1<<16+1), &cborBig{}); !errors.Is(err, translayer.ErrCborLimit) {
+		t.Fatal("UnPackAs", err)
+	}
+	t.Log("pass")
+}
+
+func Test_ChooseVersion(t *testing.T) {
+	cases := []struct {
+		peer []uint16
+		want uint16
+	}{
+		{nil, translayer.BMAILVER1},
+		{[]uint16{translayer.BMAILVER1}, translayer.BMAILVER1},
+		{[]uint16{translayer.BMAILVER1, translayer.BMAILVER2}, translayer.BMAILVER2},
+		{[]uint16{9}, translayer.BMAILVER1},
+	}
+	for _, c := range cases {
+		if v := translayer.ChooseVersion(c.peer); v != c.want {
+			t.Fatal(c.peer, "chose", v)
+		}
+	}
+	t.Log("pass")
+}
+
+func Test_CborBmp(t *testing.T) {
+	codec, err := bmp.CodecOf(translayer.BMAILVER2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bmp.CodecOf(9); err == nil {
+		t.Fatal("unknown version has a codec")
+	}
+
+	ack := &bmp.HELOACK{SrvBca: "BC1", ErrCode: 2, SupportVersion: translayer.SupportedVersion()}
+	ack.SN[3] = 7
+	got := &bmp.HELOACK{}
+	cborRoundTrip(t, codec, ack, got)
+
+	env := &bmp.BMailEnvelope{
+		Eid:      "eid",
+		FromAddr: "alice@bmail.com",
+		RCPTs:    []*bmp.Recipient{{ToName: "bob", ToAddr: "bob@bmail.com", AESKey: []byte{1, 2}}},
+		Subject:  "hi",
+		MailBody: "body",
+	}
+	syn := &bmp.EnvelopeSyn{Sig: []byte("sig"), Hash: []byte("hash"), Env: env}
+	cborRoundTrip(t, codec, syn, &bmp.EnvelopeSyn{})
+
+	cs := &bpop.CommandSyn{Sig: []byte("sig"), Cmd: &bpop.CmdDownload{MailAddr: "a", MailCnt: 5}}
+	gotCs := &bpop.CommandSyn{Cmd: &bpop.CmdDownload{}}
+	cborRoundTrip(t, codec, cs, gotCs)
+
+	ca := &bpop.CommandAck{ErrorCode: 1, CmdCxt: &bpop.CmdDownloadAck{
+		CryptEps: []*bmp.BMailEnvelope{env},
+		Meta:     []*bpop.MailMeta{{Flags: 3, Folder: "inbox"}},
+	}}
+	cborRoundTrip(t, codec, ca, &bpop.CommandAck{CmdCxt: &bpop.CmdDownloadAck{}})
+	t.Log("pass")
+}
+
+func cborRoundTrip(t *testing.T, codec bmp.Codec, v, got interface{}) {
+	data, err := codec.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := codec.Unmarshal(data, got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, v) {
+		t.Fatalf("round trip differs %+v", got)
+	}
+	again, _ := codec.Marshal(got)
+	if !bytes.Equal(again, data) {
+		t.Fatal("not deterministic")
+	}
+}
+
+func Test_BmprotocolV2(t *testing.T) {
+	type versioned interface {
+		sizedMsg
+		SetVersion(ver uint16)
+	}
+
+	msgs := goldenMsgs()
+	for name, m := range msgs {
+		vm, ok := m.(versioned)
+		if !ok || name == "RespRetrAttachment" {
+			continue
+		}
+		v1, _ := vm.Pack()
+		vm.SetVersion(translayer.BMAILVER2)
+		data, err := vm.Pack()
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		if vm.Size() != len(data) {
+			t.Fatal(name, "size", vm.Size(), "packed", len(data))
+		}
+		buf := &bytes.Buffer{}
+		vm.WriteTo(buf)
+		if !bytes.Equal(buf.Bytes(), data) {
+			t.Fatal(name, "WriteTo differs from Pack")
+		}
+
+		f, err := bmprotocol.ReadFrame(bytes.NewReader(data), 0)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		if f.GetVersion() != translayer.BMAILVER2 {
+			t.Fatal(name, "version", f.GetVersion())
+		}
+		got := reflect.New(reflect.TypeOf(m).Elem()).Interface().(versioned)
+		reflect.ValueOf(got).Elem().FieldByName("BMTransLayer").Set(reflect.ValueOf(f.BMTransLayer))
+		if _, err := got.UnPack(f.Data); err != nil {
+			t.Fatal(name, err)
+		}
+		f.Release()
+		again, err := got.Pack()
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		if !bytes.Equal(again, data) {
+			t.Fatal(name, "unpacked differs")
+		}
+		if name != "BPOPStat" && bytes.Equal(v1, data) {
+			t.Fatal(name, "packed as version 1")
+		}
+	}
+	t.Log("pass")
+}
+
+func Test_HelloVersions(t *testing.T) {
+	helo := bmprotocol.NewBMHello()
+	helo.SetVersions(translayer.SupportedVersion())
+	data, err := packData(helo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := &bmprotocol.BMHello{}
+	if _, err := got.UnPack(data); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.GetVersions(), translayer.SupportedVersion()) {
+		t.Fatal("versions", got.GetVersions())
+	}
+
+	ack := bmprotocol.NewBMHelloACK([]byte("sn"))
+	ack.SetVersions(translayer.SupportedVersion())
+	data, err = packData(ack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotAck := &bmprotocol.BMHelloACK{}
+	if _, err := gotAck.UnPack(data); err != nil {
+		t.Fatal(err)
+	}
+	if translayer.ChooseVersion(gotAck.GetVersions()) != translayer.BMAILVER2 {
+		t.Fatal("ack versions", gotAck.GetVersions())
+	}
+
+	//a hello of an old peer has no versions
+	old := bmprotocol.NewBMHello()
+	data, _ = packData(old)
+	got = &bmprotocol.BMHello{}
+	if _, err := got.UnPack(data); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.GetVersions()) != 0 || translayer.ChooseVersion(got.GetVersions()) != translayer.BMAILVER1 {
+		t.Fatal("old hello", got.GetVersions())
+	}
+	t.Log("pass")
+}
+
+//what CborUnmarshal takes is what CborMarshal gives back
+func Fuzz_CborUnmarshal(f *testing.F) {
+	data, _ := hex.DecodeString(goldenCbor)
+	f.Add(data)
+	syn, _ := translayer.CborMarshal(&bmp.EnvelopeSyn{Sig: []byte("sig"), Env: &bmp.BMailEnvelope{Subject: "hi"}})
+	f.Add(syn)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		syn := &bmp.EnvelopeSyn{}
+		if err := translayer.CborUnmarshal(data, syn); err != nil {
+			return
+		}
+		again, err := translayer.CborMarshal(syn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := &bmp.EnvelopeSyn{}
+		if err := translayer.CborUnmarshal(again, got); err != nil || !reflect.DeepEqual(got, syn) {
+			t.Fatal("round trip differs", err)
+		}
+	})
+}
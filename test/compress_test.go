@@ -0,0 +1,104 @@
+package test
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/realbmail/go-bmail-protocol/bmprotocol"
+	"github.com/realbmail/go-bmail-protocol/translayer"
+	"testing"
+)
+
+func Test_CompressNegotiate(t *testing.T) {
+	helo := bmprotocol.NewBMHello()
+	helo.SetAccept(translayer.SupportedCompress())
+	data, err := helo.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	helo2 := &bmprotocol.BMHello{}
+	of, _ := helo2.BMTransLayer.UnPack(data)
+	if _, err := helo2.UnPack(data[of:]); err != nil {
+		t.Fatal(err)
+	}
+	if translayer.ChooseCompress(helo2.GetAccept()) != translayer.CompressGzip {
+		t.Fatal("failed")
+	}
+
+	//an ack of a server before compress carries the sn only
+	old := bmprotocol.NewBMHelloACK([]byte("sn"))
+	data, _ = old.Pack()
+	ack := &bmprotocol.BMHelloACK{}
+	if _, err := ack.UnPack(data[translayer.BMHeadSize():]); err != nil {
+		t.Fatal(err)
+	}
+	if translayer.ChooseCompress(ack.GetAccept()) != translayer.CompressNone {
+		t.Fatal("failed")
+	}
+
+	t.Log("pass")
+}
+
+func Test_CompressPack(t *testing.T) {
+	sn := bytes.Repeat([]byte("bmail "), 1000)
+	ack := bmprotocol.NewBMHelloACK(sn)
+	ack.SetAccept([]uint16{translayer.CompressGzip})
+	data, _ := ack.Pack()
+
+	cData, err := bmprotocol.CompressPack(data, translayer.CompressGzip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println("plain", len(data), "compressed", len(cData))
+
+	bmtl := &translayer.BMTransLayer{}
+	of, err := bmtl.UnPack(cData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bmtl.GetMsgType() != translayer.HELLO_ACK || bmtl.GetCompress() != translayer.CompressGzip {
+		t.Fatal("failed")
+	}
+
+	body, err := bmprotocol.UnCompressData(bmtl, cData[of:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	ack2 := &bmprotocol.BMHelloACK{}
+	if _, err := ack2.UnPack(body); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(ack2.GetSn(), sn) || int(bmtl.GetDataLen()) != len(body) {
+		t.Fatal("failed")
+	}
+
+	//small messages are not worth it
+	small, _ := bmprotocol.NewBMHelloACK([]byte("sn")).Pack()
+	r, _ := bmprotocol.CompressPack(small, translayer.CompressGzip)
+	if !bytes.Equal(r, small) {
+		t.Fatal("failed")
+	}
+
+	t.Log("pass")
+}
+
+func Test_DecompressBomb(t *testing.T) {
+	bomb := make([]byte, 1<<20)
+	cData, ok := translayer.Compress(translayer.CompressGzip, bomb)
+	if !ok {
+		t.Fatal("failed")
+	}
+
+	limit := translayer.MaxDecompressSize
+	translayer.MaxDecompressSize = 1 << 16
+	defer func() { translayer.MaxDecompressSize = limit }()
+
+	if _, err := translayer.Decompress(translayer.CompressGzip, cData); err != translayer.ErrDecompressTooLarge {
+		t.Fatal("failed", err)
+	}
+	if _, err := translayer.Decompress(translayer.CompressZstd, cData); err == nil {
+		t.Fatal("failed")
+	}
+
+	t.Log("pass")
+}
@@ -0,0 +1,112 @@
+package test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"github.com/realbmail/go-bmail-protocol/bmp"
+	"github.com/realbmail/go-bmail-protocol/bmprotocol"
+	"github.com/realbmail/go-bmail-protocol/ecies"
+	"github.com/realbmail/go-bmail-protocol/eml"
+	"github.com/realbmail/go-bmail-protocol/subkey"
+	"testing"
+)
+
+func Test_EciesKeyConvert(t *testing.T) {
+	for i := 0; i < 16; i++ {
+		pub, priv, _ := ed25519.GenerateKey(nil)
+		xpub, err := ecies.PublicKey(pub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		xpriv, err := ecies.PrivateKey(priv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(xpub.Bytes(), xpriv.PublicKey().Bytes()) {
+			t.Fatal("X25519 key of ed25519 public and private key differ")
+		}
+	}
+	t.Log("pass")
+}
+
+func Test_EciesSealOpen(t *testing.T) {
+	_, bob := testIdentities(t)
+	alice := newTestWallet("alice@bmail.com")
+
+	env := &bmp.BMailEnvelope{
+		Subject:  "hi",
+		MailBody: "forward secret",
+		RCPTs:    []*bmp.Recipient{{ToName: "bob@bmail.com", ToAddr: bob.Address(), RcptType: bmp.RcpTypeTo}},
+	}
+	envKey, err := eml.SealEphemeral(env, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.CryptMode != bmp.CryptModeEphemeral || len(env.EphKey) != 32 {
+		t.Fatal("mode not signalled")
+	}
+
+	//the key proves no sender, an unsigned mail or one signed by another
+	//key in the name of alice is not opened
+	cp := *env
+	if _, err := eml.Open(&cp, bob); err != subkey.ErrUnsigned {
+		t.Fatal("unsigned ephemeral mail opened", err)
+	}
+	mallory := newTestWallet("mallory@bmail.com")
+	cp.FromSig, _ = mallory.Sign(cp.SigHash())
+	if _, err := eml.Open(&cp, bob); err != subkey.ErrBadSignature {
+		t.Fatal("forged sender opened", err)
+	}
+	env.FromSig, _ = alice.Sign(env.SigHash())
+
+	cp = *env
+	opened, err := eml.Open(&cp, bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(opened, envKey) {
+		t.Fatal("failed")
+	}
+
+	//testWallet does no X25519, it must fail and say why
+	cp = *env
+	cp.RCPTs = []*bmp.Recipient{{ToName: "alice@bmail.com", ToAddr: alice.addr, AESKey: env.RCPTs[0].AESKey}}
+	if _, err := eml.Open(&cp, alice); err != eml.ErrNoDH {
+		t.Fatal("failed", err)
+	}
+
+	cp = *env
+	cp.CryptMode |= 1 << 12
+	if _, err := eml.Open(&cp, bob); err == nil {
+		t.Fatal("unknown crypt mode opened")
+	}
+	t.Log("pass")
+}
+
+func Test_EciesCryptDesc(t *testing.T) {
+	ecd := &bmprotocol.EnvelopeCryptDesc{Mode: 11}
+	if ecd.Ephemeral() || ecd.CheckMode() != nil {
+		t.Fatal("failed")
+	}
+	ecd.Mode |= bmprotocol.CryptModeEphemeral
+	if ecd.CheckMode() == nil {
+		t.Fatal("ephemeral mode without key passed")
+	}
+	ecd.Pubkeys = [][]byte{make([]byte, 32)}
+	data, err := ecd.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := &bmprotocol.EnvelopeCryptDesc{}
+	if _, err := got.UnPack(data); err != nil {
+		t.Fatal(err)
+	}
+	if !got.Ephemeral() || got.CheckMode() != nil {
+		t.Fatal("failed")
+	}
+	got.Mode |= 1 << 20
+	if got.CheckMode() == nil {
+		t.Fatal("unknown mode passed")
+	}
+	t.Log("pass")
+}
@@ -0,0 +1,147 @@
+package test
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/realbmail/go-bmail-account"
+	"github.com/realbmail/go-bmail-protocol/bmp"
+	"github.com/realbmail/go-bmail-protocol/eml"
+	"strings"
+	"testing"
+)
+
+func Test_EmlRoundTrip(t *testing.T) {
+	env := &bmp.BMailEnvelope{
+		Eid:           "3f1c8a52-0000-4000-8000-000000000001",
+		FromName:      "alice@bmail.com",
+		FromAddr:      "BMalice",
+		DateSince1970: 1600000000000,
+		Subject:       "héllo\r\nBcc: evil@x.com",
+		MailBody:      "line one\nline two with a very long text " + strings.Repeat("x", 100),
+		SessionID:     "3f1c8a52-0000-4000-8000-000000000000",
+		InReplyTo:     "3f1c8a52-0000-4000-8000-000000000000",
+		References:    []string{"3f1c8a52-0000-4000-8000-000000000000"},
+		RCPTs: []*bmp.Recipient{
+			{ToName: "bob@bmail.com", ToAddr: "BMbob", RcptType: bmp.RcpTypeTo},
+			{ToName: "carol@bmail.com", ToAddr: "BMcarol", RcptType: bmp.RcpTypeCC},
+			{ToName: "dave@bmail.com", ToAddr: "BMdave", RcptType: bmp.RcpTypeBcc},
+		},
+	}
+	files := []*eml.File{{Name: "报告.pdf", Type: "application/pdf", Data: bytes.Repeat([]byte{0, 1, 2, 255}, 100)}}
+
+	buf := &bytes.Buffer{}
+	if err := eml.Export(buf, env, files); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println(buf.String())
+
+	env2, files2, err := eml.Import(buf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env2.Eid != env.Eid || env2.Subject != env.Subject || env2.MailBody != env.MailBody ||
+		env2.FromAddr != env.FromAddr || env2.DateSince1970 != env.DateSince1970 ||
+		env2.SessionID != env.SessionID || env2.InReplyTo != env.InReplyTo ||
+		len(env2.References) != 1 || env2.References[0] != env.References[0] {
+		t.Fatal("failed", env2.ToString())
+	}
+	if len(env2.RCPTs) != 3 {
+		t.Fatal("failed")
+	}
+	for i, r := range env2.RCPTs {
+		if r.ToName != env.RCPTs[i].ToName || r.ToAddr != env.RCPTs[i].ToAddr || r.RcptType != env.RCPTs[i].RcptType {
+			t.Fatal("failed", r.ToString())
+		}
+	}
+	if len(files2) != 1 || files2[0].Name != files[0].Name || files2[0].Type != files[0].Type ||
+		!bytes.Equal(files2[0].Data, files[0].Data) {
+		t.Fatal("failed")
+	}
+
+	t.Log("pass")
+}
+
+const foreignEml = "From: \"Eve\" <eve@mail.com>\r\n" +
+	"To: bob@bmail.com, \"Carol\" <carol@bmail.com>\r\n" +
+	"Subject: =?utf-8?q?caf=C3=A9?=\r\n" +
+	"Message-ID: <abc.123@mail.com>\r\n" +
+	"Date: Mon, 02 Jan 2006 15:04:05 +0000\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=\"outer\"\r\n" +
+	"\r\n" +
+	"--outer\r\n" +
+	"Content-Type: multipart/alternative; boundary=\"inner\"\r\n" +
+	"\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/html; charset=utf-8\r\n" +
+	"\r\n" +
+	"<p>hi</p>\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"hi =C3=A9\r\n" +
+	"--inner--\r\n" +
+	"--outer\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"Content-Disposition: attachment; filename=\"a.txt\"\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"aGVs\r\nbG8=\r\n" +
+	"--outer--\r\n"
+
+func Test_EmlImportForeign(t *testing.T) {
+	addrs := map[string]string{"eve@mail.com": "BMeve", "bob@bmail.com": "BMbob", "carol@bmail.com": "BMcarol"}
+	resolve := func(name string) (bmail.Address, error) {
+		return bmail.Address(addrs[name]), nil
+	}
+
+	env, files, err := eml.Import(strings.NewReader(foreignEml), resolve)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := uuid.Parse(env.Eid); err != nil || env.Subject != "café" || env.MailBody != "hi é" ||
+		env.FromName != "eve@mail.com" || env.FromAddr != "BMeve" || len(env.RCPTs) != 2 ||
+		env.RCPTs[1].ToAddr != "BMcarol" || env.RCPTs[1].RcptType != bmp.RcpTypeTo {
+		t.Fatal("failed", env.ToString())
+	}
+	if len(files) != 1 || files[0].Name != "a.txt" || string(files[0].Data) != "hello" {
+		t.Fatal("failed")
+	}
+
+	if _, _, err := eml.Import(strings.NewReader(foreignEml), nil); err == nil {
+		t.Fatal("unknown address must fail without resolver")
+	}
+
+	e, files, err := eml.ImportEnvelope(strings.NewReader(foreignEml))
+	if err != nil || len(e.To) != 2 || e.CC != nil || e.Data != "hi é" || len(files) != 1 {
+		t.Fatal("failed", err)
+	}
+	if uuid.UUID(e.EId).String() != env.Eid {
+		t.Fatal("Import and ImportEnvelope give the message other eids")
+	}
+
+	t.Log("pass")
+}
+
+//what the sender writes must not make headers or parts of its own
+func Test_EmlHeaderInjection(t *testing.T) {
+	env := &bmp.BMailEnvelope{
+		Eid:        "3f1c8a52\r\nX-Evil: eid",
+		FromName:   "alice@bmail.com\r\nX-Evil: from",
+		FromAddr:   "BMalice\nX-Evil: addr",
+		SessionID:  "s\r\n\r\nX-Evil: body",
+		InReplyTo:  "r>\r\nX-Evil: reply",
+		References: []string{"a\r\nX-Evil: ref"},
+		RCPTs:      []*bmp.Recipient{{ToName: "bob@bmail.com\r\nX-Evil: to", ToAddr: "BMbob", RcptType: bmp.RcpTypeTo}},
+	}
+	buf := &bytes.Buffer{}
+	if err := eml.Export(buf, env, nil); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "\nX-Evil") || strings.Contains(buf.String(), "\r\n\r\nX-Evil") {
+		t.Fatal("header injected", buf.String())
+	}
+	t.Log("pass")
+}
@@ -0,0 +1,139 @@
+package test
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/realbmail/go-bmail-protocol/bpop"
+	"github.com/realbmail/go-bmail-protocol/translayer"
+	"sort"
+	"testing"
+)
+
+//folderBox keep the flags and folder of every mail, a change is logged
+//to the changeLog for sync
+type folderBox struct {
+	*changeLog
+	mails map[uuid.UUID]*bpop.MailMeta
+}
+
+func (fb *folderBox) add(eid uuid.UUID) {
+	fb.mails[eid] = &bpop.MailMeta{Eid: eid, Folder: bpop.FolderInbox}
+	fb.change(bpop.ChangeNew, eid, 0, bpop.FolderInbox)
+}
+
+//each apply op to every mail of eids it has
+func (fb *folderBox) each(eids []uuid.UUID, op func(*bpop.MailMeta)) []bpop.CmdResult {
+	var r []bpop.CmdResult
+	for _, eid := range eids {
+		m, ok := fb.mails[eid]
+		if !ok {
+			r = append(r, bpop.CmdResult{Eid: eid, Result: bpop.MailNotFound})
+			continue
+		}
+		op(m)
+		r = append(r, bpop.CmdResult{Eid: eid})
+	}
+	return r
+}
+
+func (fb *folderBox) setFlags(data []byte) (int, bpop.CommandContent) {
+	cmd := &bpop.CmdSetFlags{}
+	if err := json.Unmarshal(data, cmd); err != nil {
+		return -1, &bpop.CmdSetFlagsAck{}
+	}
+	r := fb.each(cmd.Eids, func(m *bpop.MailMeta) {
+		m.Flags = (m.Flags | cmd.SetFlags) &^ cmd.ClearFlags
+		fb.change(bpop.ChangeFlags, m.Eid, m.Flags, "")
+	})
+	return bpop.EC_Success, &bpop.CmdSetFlagsAck{Result: r}
+}
+
+func (fb *folderBox) move(data []byte) (int, bpop.CommandContent) {
+	cmd := &bpop.CmdMove{}
+	if err := json.Unmarshal(data, cmd); err != nil {
+		return -1, &bpop.CmdMoveAck{}
+	}
+	r := fb.each(cmd.Eids, func(m *bpop.MailMeta) {
+		m.Folder = cmd.Folder
+		fb.change(bpop.ChangeMoved, m.Eid, 0, cmd.Folder)
+	})
+	return bpop.EC_Success, &bpop.CmdMoveAck{Result: r}
+}
+
+func (fb *folderBox) listFolders(data []byte) (int, bpop.CommandContent) {
+	folders := make(map[string]*bpop.Folder)
+	for _, m := range fb.mails {
+		f, ok := folders[m.Folder]
+		if !ok {
+			f = &bpop.Folder{Name: m.Folder}
+			folders[m.Folder] = f
+		}
+		f.Total++
+		if m.Flags&bpop.FlagSeen == 0 {
+			f.Unread++
+		}
+	}
+	ack := &bpop.CmdListFoldersAck{}
+	for _, f := range folders {
+		ack.Folders = append(ack.Folders, f)
+	}
+	sort.Slice(ack.Folders, func(i, j int) bool { return ack.Folders[i].Name < ack.Folders[j].Name })
+	return bpop.EC_Success, ack
+}
+
+func Test_FolderCommands(t *testing.T) {
+	m, cl := startChangeLog(t)
+	defer m.Close()
+
+	fb := &folderBox{changeLog: cl, mails: make(map[uuid.UUID]*bpop.MailMeta)}
+	m.cmds[translayer.SET_FLAGS] = fb.setFlags
+	m.cmds[translayer.MOVE] = fb.move
+	m.cmds[translayer.LIST_FOLDERS] = fb.listFolders
+
+	eids := []uuid.UUID{uuid.New(), uuid.New(), uuid.New()}
+	for _, eid := range eids {
+		fb.add(eid)
+	}
+	cli := newMockClient(t)
+
+	//SetFlags is applied before ClearFlags
+	r, err := cli.SetFlags(eids[:2], bpop.FlagSeen|bpop.FlagStarred, bpop.FlagStarred)
+	if err != nil || len(r) != 2 || fb.mails[eids[0]].Flags != bpop.FlagSeen {
+		t.Fatal("failed", r, err)
+	}
+	missing := uuid.New()
+	r, err = cli.MoveMail([]uuid.UUID{eids[1], missing}, "Work")
+	if err != nil || len(r) != 2 || r[0].Result != bpop.MailDeleteSuccess || r[1].Result != bpop.MailNotFound ||
+		fb.mails[eids[1]].Folder != "Work" {
+		t.Fatal("failed", r, err)
+	}
+
+	folders, err := cli.ListFolders()
+	if err != nil || len(folders) != 2 {
+		t.Fatal("failed", err)
+	}
+	if f := folders[0]; f.Name != bpop.FolderInbox || f.Total != 2 || f.Unread != 1 {
+		t.Fatal("failed", f)
+	}
+	if f := folders[1]; f.Name != "Work" || f.Total != 1 || f.Unread != 0 {
+		t.Fatal("failed", f)
+	}
+
+	//other devices see the changes by sync
+	ack, err := cli.SyncEnv(3, 10)
+	if err != nil || len(ack.Changes) != 3 {
+		t.Fatal("failed", err)
+	}
+	if c := ack.Changes[0]; c.Kind != bpop.ChangeFlags || c.Eid != eids[0] || c.Flags != bpop.FlagSeen {
+		t.Fatal("failed", c)
+	}
+	if c := ack.Changes[2]; c.Kind != bpop.ChangeMoved || c.Eid != eids[1] || c.Folder != "Work" {
+		t.Fatal("failed", c)
+	}
+
+	m.forge = true
+	if _, err := cli.ListFolders(); err == nil {
+		t.Fatal("forged ack taken")
+	}
+	t.Log("pass")
+}
@@ -0,0 +1,125 @@
+package test
+
+import (
+	"github.com/google/uuid"
+	"github.com/realbmail/go-bmail-protocol/bpop"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newMailNotify(seq uint64) *bpop.CmdIdleNotify {
+	return &bpop.CmdIdleNotify{Seq: seq, Kind: bpop.NotifyNewMail, Mails: []*bpop.MailSummary{{Eid: uuid.New()}}}
+}
+
+func Test_IdleNotify(t *testing.T) {
+	m := startMockBMTP(t)
+	defer m.Close()
+
+	//seqs start at 0
+	m.idle = []*bpop.CmdIdleNotify{
+		newMailNotify(0),
+		{Seq: 1, Kind: bpop.NotifyKeepAlive},
+		newMailNotify(2),
+	}
+	is, err := newMockClient(t).Idle(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if s := <-is.Notify(); s == nil || s.Eid != m.idle[i*2].Mails[0].Eid {
+			t.Fatal("failed", i)
+		}
+	}
+	if err := is.Close(); err != nil || is.Err() != nil {
+		t.Fatal(err, is.Err())
+	}
+	if _, ok := <-is.Notify(); ok {
+		t.Fatal("notify not closed")
+	}
+	t.Log("pass")
+}
+
+func Test_IdleNotifyOrder(t *testing.T) {
+	m := startMockBMTP(t)
+	defer m.Close()
+
+	//a frame after seq 0 is checked too
+	m.idle = []*bpop.CmdIdleNotify{newMailNotify(0), newMailNotify(5)}
+	is, err := newMockClient(t).Idle(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer is.Close()
+
+	<-is.Notify()
+	if _, ok := <-is.Notify(); ok {
+		t.Fatal("mail out of order notified")
+	}
+	if is.Err() == nil || !strings.Contains(is.Err().Error(), "out of order") {
+		t.Fatal("failed", is.Err())
+	}
+	t.Log("pass")
+}
+
+//a notify of another session or not starting at seq 0 is not taken, nor
+//an IDLE the server refused
+func Test_IdleReplay(t *testing.T) {
+	m := startMockBMTP(t)
+	defer m.Close()
+
+	replayed := newMailNotify(0)
+	replayed.SN[0] = 1
+	for _, c := range []struct {
+		idle []*bpop.CmdIdleNotify
+		code int
+		err  string
+	}{
+		{[]*bpop.CmdIdleNotify{replayed}, 0, "another session"},
+		{[]*bpop.CmdIdleNotify{{Seq: 0, Kind: bpop.NotifyKeepAlive}, replayed}, 0, "another session"},
+		{[]*bpop.CmdIdleNotify{newMailNotify(1)}, 0, "out of order"},
+		{nil, -1, "server error"},
+	} {
+		m.idle, m.idleCode = c.idle, c.code
+		is, err := newMockClient(t).Idle(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := <-is.Notify(); ok {
+			t.Fatal("mail notified, want", c.err)
+		}
+		if is.Err() == nil || !strings.Contains(is.Err().Error(), c.err) {
+			t.Fatal("failed", is.Err(), "want", c.err)
+		}
+		is.Close()
+	}
+	t.Log("pass")
+}
+
+//keep alives after IDLE_DONE don't hold Close past its deadline
+func Test_IdleClose(t *testing.T) {
+	m := startMockBMTP(t)
+	defer m.Close()
+
+	m.idleTick = 200 * time.Millisecond
+	is, err := newMockClient(t).Idle(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(500 * time.Millisecond)
+
+	closed := make(chan struct{})
+	go func() {
+		is.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(3 * time.Second):
+		t.Fatal("close held by keep alives")
+	}
+	if is.Err() != nil {
+		t.Fatal(is.Err())
+	}
+	t.Log("pass")
+}
@@ -0,0 +1,180 @@
+package test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/BASChain/go-account"
+	"github.com/realbmail/go-bas-mail-server/bmailcrypt"
+	"github.com/realbmail/go-bmail-account"
+	"github.com/realbmail/go-bmail-protocol/keystore"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_KeystoreIdentities(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keystore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "keys.json")
+
+	ks, err := keystore.Open(path, keystore.StaticPassword("123"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice, err := ks.Create("alice", "alice@bmail.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ks.Create("work", "alice@work.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ks.Create("alice", ""); err != keystore.ErrExists {
+		t.Fatal("failed", err)
+	}
+
+	ks, err = keystore.Open(path, keystore.StaticPassword("123"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println(ks.Names())
+	if names := ks.Names(); len(names) != 2 || names[0] != "alice" || names[1] != "work" {
+		t.Fatal("failed", names)
+	}
+	id, err := ks.Unlock("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.MailAddress() != "alice@bmail.com" || !bytes.Equal(id.PublicKey(), alice.PublicKey()) {
+		t.Fatal("failed")
+	}
+	msg := []byte("helo")
+	sig, err := id.Sign(msg)
+	if err != nil || !ed25519.Verify(alice.PublicKey(), msg, sig) {
+		t.Fatal("failed")
+	}
+
+	wrong, _ := keystore.Open(path, keystore.StaticPassword("456"))
+	if _, err := wrong.Unlock("alice"); err != keystore.ErrBadPassword {
+		t.Fatal("failed", err)
+	}
+	if _, err := ks.Unlock("bob"); err != keystore.ErrNoIdentity {
+		t.Fatal("failed", err)
+	}
+
+	if err := ks.Remove("work"); err != nil {
+		t.Fatal(err)
+	}
+	ks, _ = keystore.Open(path, keystore.StaticPassword("123"))
+	if len(ks.Names()) != 1 {
+		t.Fatal("failed", ks.Names())
+	}
+	t.Log("pass")
+}
+
+//a replace that fails keeps the old key, in memory and in the file
+func Test_KeystoreReplace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keystore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "keys.json")
+
+	fail := false
+	pp := keystore.PasswordFunc(func(name string, create bool) (string, error) {
+		if fail {
+			return "", errors.New("passwords not match")
+		}
+		return "123", nil
+	})
+	ks, _ := keystore.Open(path, pp)
+	old, err := ks.Create("alice", "alice@bmail.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ks.Replace("bob", ""); err != keystore.ErrNoIdentity {
+		t.Fatal("failed", err)
+	}
+
+	fail = true
+	if _, err := ks.Replace("alice", "alice@bmail.com"); err == nil {
+		t.Fatal("replaced without a password")
+	}
+	ks, _ = keystore.Open(path, pp)
+	fail = false
+	if id, err := ks.Unlock("alice"); err != nil || !bytes.Equal(id.PublicKey(), old.PublicKey()) {
+		t.Fatal("old key lost", err)
+	}
+
+	id, err := ks.Replace("alice", "alice@bmail.com")
+	if err != nil || bytes.Equal(id.PublicKey(), old.PublicKey()) {
+		t.Fatal("failed", err)
+	}
+	ks, _ = keystore.Open(path, pp)
+	if got, err := ks.Unlock("alice"); err != nil || !bytes.Equal(got.PublicKey(), id.PublicKey()) || len(ks.Names()) != 1 {
+		t.Fatal("failed", err)
+	}
+	t.Log("pass")
+}
+
+func Test_KeystoreOldKeyFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keystore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ed25519key.test")
+
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	cipherTxt, err := account.EncryptSubPriKey(priv, pub, "123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := json.Marshal(&bmailcrypt.KeyJson{PubKey: bmail.ToAddress(pub).String(), CipherKey: cipherTxt})
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	asked := 0
+	pp := keystore.PasswordFunc(func(name string, create bool) (string, error) {
+		asked++
+		return "123", nil
+	})
+	ks, err := keystore.Open(path, pp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := ks.Unlock(keystore.DefaultName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(id.PublicKey(), pub) || asked != 1 {
+		t.Fatal("failed")
+	}
+
+	if err := ks.SetMailName(keystore.DefaultName, "bob@bmail.com"); err != nil {
+		t.Fatal(err)
+	}
+	data, _ = ioutil.ReadFile(path)
+	fmt.Println(string(data))
+	ks, err = keystore.Open(path, pp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, _ := ks.Entry(keystore.DefaultName); e == nil || e.MailName != "bob@bmail.com" {
+		t.Fatal("failed")
+	}
+
+	ioutil.WriteFile(path, []byte(`{"version":9,"identities":[]}`), 0600)
+	if _, err := keystore.Open(path, pp); err != keystore.ErrBadVersion {
+		t.Fatal("failed", err)
+	}
+	t.Log("pass")
+}
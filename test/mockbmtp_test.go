@@ -0,0 +1,305 @@
+package test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"github.com/realbmail/go-bas-mail-server/bmailcrypt"
+	"github.com/realbmail/go-bmail-account"
+	"github.com/realbmail/go-bmail-protocol/bmp"
+	"github.com/realbmail/go-bmail-protocol/bmp/client"
+	"github.com/realbmail/go-bmail-protocol/bpop"
+	"github.com/realbmail/go-bmail-protocol/translayer"
+	resolver "github.com/realbmail/go-bmail-resolver"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+//testWallet is a signer.Key of an ed25519 key of its own, it does no
+//X25519
+type testWallet struct {
+	addr bmail.Address
+	name string
+	priv ed25519.PrivateKey
+}
+
+func newTestWallet(name string) *testWallet {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	return &testWallet{addr: bmail.ToAddress(pub), name: name, priv: priv}
+}
+
+func (tw *testWallet) Address() bmail.Address {
+	return tw.addr
+}
+
+func (tw *testWallet) MailAddress() string {
+	return tw.name
+}
+
+func (tw *testWallet) Sign(m []byte) ([]byte, error) {
+	return ed25519.Sign(tw.priv, m), nil
+}
+
+func (tw *testWallet) AesKeyOf(peer bmail.Address) ([]byte, error) {
+	return bmailcrypt.GenerateAesKey(peer.ToPubKey(), tw.priv)
+}
+
+//mockServer is the key of the mock server, the resolver gives its
+//address as the bca of every domain
+var mockServer = newTestWallet("server")
+
+//testResolver send every domain to the local mock server
+type testResolver struct {
+	resolver.NameResolver
+}
+
+func (tr *testResolver) DomainMX(domain string) ([]net.IP, []bmail.Address) {
+	return []net.IP{net.IPv4(127, 0, 0, 1)}, []bmail.Address{mockServer.addr}
+}
+
+//mockBMTP answer the JSON bmp stack on the BMTP port of localhost,
+//mails sent to it are put to envs, RETR gets inbox. IDLE gets idle, then
+//keep alives every idleTick if it is set, else NotifyDone after IDLE_DONE,
+//or an ack of idleCode alone if it is set. The notifies are signed for the
+//session.
+//Uploaded attachments are kept in blobs by hash, a download of one claims
+//retrSize bytes if it is set.
+//A bpop command of a type in cmds is answered by it, the ack is signed
+//by mockServer, or by another key when forge is set.
+type mockBMTP struct {
+	ln       net.Listener
+	envs     chan *bmp.BMailEnvelope
+	inbox    *bpop.CmdDownloadAck
+	idle     []*bpop.CmdIdleNotify
+	idleTick time.Duration
+	idleCode int
+	cmds     map[uint16]cmdHandler
+	forge    bool
+	retrSize int64
+
+	lock  sync.Mutex
+	blobs map[string][]byte
+}
+
+//cmdHandler answer the json of a bpop command by an error code and the
+//content of the ack
+type cmdHandler func(cmd []byte) (int, bpop.CommandContent)
+
+func startMockBMTP(t *testing.T) *mockBMTP {
+	ln, err := net.Listen("tcp4", "127.0.0.1:"+strconv.Itoa(translayer.BMTP_PORT))
+	if err != nil {
+		t.Skip("BMTP port not free:", err)
+	}
+	m := &mockBMTP{ln: ln, envs: make(chan *bmp.BMailEnvelope, 16), blobs: make(map[string][]byte)}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go m.serve(c)
+		}
+	}()
+	return m
+}
+
+//newMockClient is a client of alice@bmail.com to the mock server
+func newMockClient(t *testing.T) *client.BMailClient {
+	cli, err := client.NewClient(&client.ClientConf{Resolver: &testResolver{}, Wallet: newTestWallet("alice@bmail.com")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cli
+}
+
+func (m *mockBMTP) Close() {
+	m.ln.Close()
+}
+
+func readFrame(c net.Conn) (*bmp.Header, []byte, error) {
+	h := &bmp.Header{Ver: translayer.BMAILVER1}
+	buf := make([]byte, h.GetLen())
+	if _, err := io.ReadFull(c, buf); err != nil {
+		return nil, nil, err
+	}
+	if _, err := h.Derive(buf); err != nil {
+		return nil, nil, err
+	}
+	body := make([]byte, h.MsgLen)
+	if _, err := io.ReadFull(c, body); err != nil {
+		return nil, nil, err
+	}
+	return h, body, nil
+}
+
+func writeFrame(c net.Conn, typ uint16, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	h := &bmp.Header{Ver: translayer.BMAILVER1, MsgTyp: typ, MsgLen: len(data)}
+	_, err = c.Write(append(h.GetBytes(), data...))
+	return err
+}
+
+func (m *mockBMTP) serve(c net.Conn) {
+	defer c.Close()
+
+	if h, _, err := readFrame(c); err != nil || h.MsgTyp != translayer.HELLO {
+		return
+	}
+	ack := &bmp.HELOACK{SrvBca: mockServer.addr}
+	rand.Read(ack.SN[:])
+	if err := writeFrame(c, translayer.HELLO_ACK, ack); err != nil {
+		return
+	}
+
+	h, body, err := readFrame(c)
+	if err != nil {
+		return
+	}
+	switch h.MsgTyp {
+	case translayer.SEND_CRYPT_ENVELOPE:
+		syn := &bmp.EnvelopeSyn{}
+		if err := json.Unmarshal(body, syn); err != nil {
+			return
+		}
+		m.envs <- syn.Env
+		sig, _ := mockServer.Sign(syn.Hash)
+		writeFrame(c, translayer.RESP_CRYPT_ENVELOPE, &bmp.EnvelopeAck{Hash: syn.Hash, Sig: sig})
+	case translayer.RETR:
+		inbox := m.inbox
+		if inbox == nil {
+			inbox = &bpop.CmdDownloadAck{}
+		}
+		writeFrame(c, translayer.RETR_RESP, &bpop.CommandAck{CmdCxt: inbox})
+	case translayer.SEND_ATTACHMNENT:
+		syn := &bmp.AttachmentSyn{}
+		if err := json.Unmarshal(body, syn); err != nil {
+			return
+		}
+		data := &bytes.Buffer{}
+		if _, err := io.CopyN(data, c, syn.Size); err != nil {
+			return
+		}
+		m.setBlob(syn.Hash, data.Bytes())
+		sig, _ := mockServer.Sign(syn.Hash)
+		writeFrame(c, translayer.RESP_ATTACHMENT, &bmp.AttachmentAck{Hash: syn.Hash, Sig: sig, Path: "p"})
+	case translayer.CHECK_ATTACHMENT:
+		check := &bmp.AttachmentCheck{}
+		if err := json.Unmarshal(body, check); err != nil {
+			return
+		}
+		hash := check.Hash()
+		sig, _ := mockServer.Sign(hash)
+		checkAck := &bmp.AttachmentCheckAck{Hash: hash, Sig: sig}
+		for _, h := range check.Hashes {
+			_, ok := m.blob(h)
+			checkAck.Has = append(checkAck.Has, ok)
+		}
+		writeFrame(c, translayer.CHECK_ATTACHMENT_RESP, checkAck)
+	case translayer.RETR_ATTACHMENT:
+		retr := &bmp.AttachmentRetr{}
+		if err := json.Unmarshal(body, retr); err != nil {
+			return
+		}
+		data, ok := m.blob(retr.Hash)
+		if !ok {
+			writeFrame(c, translayer.RETR_ATTACHMENT_RESP, &bmp.AttachmentRetrAck{ErrorCode: 1})
+			return
+		}
+		size := int64(len(data))
+		if m.retrSize > 0 {
+			size = m.retrSize
+		}
+		sig, _ := mockServer.Sign(retr.Hash)
+		if err := writeFrame(c, translayer.RETR_ATTACHMENT_RESP, &bmp.AttachmentRetrAck{Hash: retr.Hash, Sig: sig, Size: size}); err != nil {
+			return
+		}
+		c.Write(data)
+	case translayer.IDLE:
+		m.serveIdle(c, ack.SN)
+	default:
+		if handle, ok := m.cmds[h.MsgTyp]; ok {
+			m.serveCommand(c, body, handle)
+		}
+	}
+}
+
+func (m *mockBMTP) setBlob(hash, data []byte) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.blobs[string(hash)] = data
+}
+
+func (m *mockBMTP) blob(hash []byte) ([]byte, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	data, ok := m.blobs[string(hash)]
+	return data, ok
+}
+
+func (m *mockBMTP) serveCommand(c net.Conn, body []byte, handle cmdHandler) {
+	syn := &struct {
+		Cmd json.RawMessage `json:"cmd"`
+	}{}
+	if err := json.Unmarshal(body, syn); err != nil {
+		return
+	}
+	code, cxt := handle(syn.Cmd)
+	hash := cxt.Hash()
+	sig, _ := mockServer.Sign(hash)
+	if m.forge {
+		sig, _ = newTestWallet("forger").Sign(hash)
+	}
+	writeFrame(c, cxt.MsgType(), &bpop.CommandAck{ErrorCode: code, Hash: hash, Sig: sig, CmdCxt: cxt})
+}
+
+//writeNotify sign n for the session sn, a notify with an SN of its own
+//keeps it, it is one of another session
+func writeNotify(c net.Conn, sn bmp.BMailSN, n *bpop.CmdIdleNotify) error {
+	cp := *n
+	if cp.SN == (bmp.BMailSN{}) {
+		cp.SN = sn
+	}
+	hash := cp.Hash()
+	sig, _ := mockServer.Sign(hash)
+	return writeFrame(c, translayer.IDLE_NOTIFY, &bpop.CommandAck{Hash: hash, Sig: sig, CmdCxt: &cp})
+}
+
+func (m *mockBMTP) serveIdle(c net.Conn, sn bmp.BMailSN) {
+	if m.idleCode != bpop.EC_Success {
+		writeFrame(c, translayer.IDLE_NOTIFY, &bpop.CommandAck{ErrorCode: m.idleCode, CmdCxt: &bpop.CmdIdleNotify{}})
+		return
+	}
+
+	var next uint64
+	for _, n := range m.idle {
+		if err := writeNotify(c, sn, n); err != nil {
+			return
+		}
+		next = n.Seq + 1
+	}
+
+	if m.idleTick > 0 {
+		//IDLE_DONE is not answered
+		for ; ; next++ {
+			time.Sleep(m.idleTick)
+			if err := writeNotify(c, sn, &bpop.CmdIdleNotify{Seq: next, Kind: bpop.NotifyKeepAlive}); err != nil {
+				return
+			}
+		}
+	}
+
+	if h, _, err := readFrame(c); err != nil || h.MsgTyp != translayer.IDLE_DONE {
+		return
+	}
+	writeNotify(c, sn, &bpop.CmdIdleNotify{Seq: next, Kind: bpop.NotifyDone})
+}
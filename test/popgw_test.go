@@ -0,0 +1,135 @@
+package test
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/realbmail/go-bmail-protocol/bmp"
+	"github.com/realbmail/go-bmail-protocol/bpop"
+	"github.com/realbmail/go-bmail-protocol/eml"
+	"github.com/realbmail/go-bmail-protocol/popgw"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+type testMailbox struct {
+	envs    []*bmp.BMailEnvelope
+	deleted []uuid.UUID
+}
+
+func (tm *testMailbox) ReceiveEnv(timeSince1970 int64, olderThanSince bool, maxCount int) ([]*bmp.BMailEnvelope, error) {
+	return tm.envs, nil
+}
+
+func (tm *testMailbox) DeleteMail(eids []uuid.UUID) ([]bpop.CmdResult, error) {
+	tm.deleted = append(tm.deleted, eids...)
+	var r []bpop.CmdResult
+	for _, eid := range eids {
+		r = append(r, bpop.CmdResult{Eid: eid, Result: bpop.MailDeleteSuccess})
+	}
+	return r, nil
+}
+
+func (tm *testMailbox) DownloadAttachment(eid string, att *bmp.Attachment) ([]byte, error) {
+	return nil, fmt.Errorf("no attachment")
+}
+
+func popCmd(t *testing.T, c *textproto.Conn, cmd string) string {
+	if err := c.PrintfLine("%s", cmd); err != nil {
+		t.Fatal(err)
+	}
+	line, err := c.ReadLine()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println(cmd, "=>", line)
+	return line
+}
+
+func Test_POP3Gateway(t *testing.T) {
+	me, alice := newTestWallet("bob@bmail.com"), newTestWallet("alice@bmail.com")
+	box := &testMailbox{}
+	for i := 0; i < 4; i++ {
+		env := &bmp.BMailEnvelope{
+			Eid:      fmt.Sprintf("3f1c8a52-0000-4000-8000-00000000000%d", i),
+			Subject:  fmt.Sprintf("mail %d", i),
+			MailBody: "hello bob",
+			RCPTs:    []*bmp.Recipient{{ToName: "bob@bmail.com", ToAddr: me.addr, RcptType: bmp.RcpTypeTo}},
+		}
+		if _, err := eml.Seal(env, alice); err != nil {
+			t.Fatal(err)
+		}
+		box.envs = append(box.envs, env)
+	}
+	//mails bob can't open or delete are left out, an eid of no uuid would
+	//break UIDL
+	box.envs[3].Eid = "x\r\n+OK"
+	box.envs = append(box.envs, &bmp.BMailEnvelope{Eid: "3f1c8a52-0000-4000-8000-000000000009"})
+
+	var skipped []string
+	gw, err := popgw.New(&popgw.Config{Password: "secret", Wallet: me, Mailbox: box,
+		Skipped: func(eid string, err error) { skipped = append(skipped, eid) }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go gw.Serve(ln)
+	defer gw.Close()
+
+	c, err := textproto.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if line, _ := c.ReadLine(); !strings.HasPrefix(line, "+OK") {
+		t.Fatal("failed", line)
+	}
+
+	popCmd(t, c, "USER bob@bmail.com")
+	if !strings.HasPrefix(popCmd(t, c, "PASS wrong"), "-ERR") {
+		t.Fatal("failed")
+	}
+	popCmd(t, c, "USER bob@bmail.com")
+	if line := popCmd(t, c, "PASS secret"); line != "+OK 3 messages" {
+		t.Fatal("failed", line)
+	}
+	if len(skipped) != 2 {
+		t.Fatal("failed", skipped)
+	}
+
+	popCmd(t, c, "UIDL")
+	lines, err := c.ReadDotLines()
+	if err != nil || len(lines) != 3 || lines[1] != "2 3f1c8a52-0000-4000-8000-000000000001" {
+		t.Fatal("failed", lines)
+	}
+
+	popCmd(t, c, "RETR 1")
+	body, err := c.ReadDotLines()
+	if err != nil || !strings.Contains(strings.Join(body, "\n"), "From: <alice@bmail.com>") ||
+		!strings.Contains(strings.Join(body, "\n"), "Subject: mail 0") {
+		t.Fatal("failed", body)
+	}
+
+	if !strings.HasPrefix(popCmd(t, c, "DELE 2"), "+OK") {
+		t.Fatal("failed")
+	}
+	if !strings.HasPrefix(popCmd(t, c, "RETR 2"), "-ERR") {
+		t.Fatal("failed")
+	}
+	if line := popCmd(t, c, "STAT"); !strings.HasPrefix(line, "+OK 2 ") {
+		t.Fatal("failed", line)
+	}
+	if len(box.deleted) != 0 {
+		t.Fatal("DELE must wait for QUIT")
+	}
+	popCmd(t, c, "QUIT")
+	if len(box.deleted) != 1 {
+		t.Fatal("failed")
+	}
+
+	t.Log("pass")
+}
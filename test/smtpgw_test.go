@@ -0,0 +1,84 @@
+package test
+
+import (
+	"errors"
+	"fmt"
+	"github.com/realbmail/go-bmail-account"
+	"github.com/realbmail/go-bmail-protocol/bmp"
+	"github.com/realbmail/go-bmail-protocol/bmp/client"
+	"github.com/realbmail/go-bmail-protocol/eml"
+	"github.com/realbmail/go-bmail-protocol/smtpgw"
+	"net"
+	"net/smtp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_SMTPGateway(t *testing.T) {
+	srv := startMockBMTP(t)
+	defer srv.Close()
+
+	wallet := newTestWallet("alerts@bmail.com")
+	cli, err := client.NewClient(&client.ClientConf{Resolver: &testResolver{}, Wallet: wallet})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bob, carol := newTestWallet("bob@bmail.com"), newTestWallet("carol@bmail.com")
+	users := map[string]bmail.Address{"bob@bmail.com": bob.addr, "carol@bmail.com": carol.addr}
+	gw, err := smtpgw.New(&smtpgw.Config{
+		Wallet: wallet,
+		Sender: cli,
+		Resolve: func(name string) (bmail.Address, error) {
+			if addr, ok := users[name]; ok {
+				return addr, nil
+			}
+			return "", errors.New("unknown")
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go gw.Serve(ln)
+	defer gw.Close()
+
+	msg := "From: ci@example.com\r\n" +
+		"To: bob@bmail.com\r\n" +
+		"Subject: build failed\r\n" +
+		"\r\n" +
+		"job 42 failed\r\n"
+	err = smtp.SendMail(ln.Addr().String(), nil, "ci@example.com",
+		[]string{"bob@bmail.com", "carol@bmail.com"}, []byte(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var env *bmp.BMailEnvelope
+	select {
+	case env = <-srv.envs:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no mail delivered")
+	}
+	fmt.Println(env.ToString())
+	if env.FromAddr != wallet.addr || len(env.RCPTs) != 2 ||
+		env.RCPTs[0].RcptType != bmp.RcpTypeTo || env.RCPTs[0].ToAddr != bob.addr ||
+		env.RCPTs[1].RcptType != bmp.RcpTypeBcc || env.RCPTs[1].ToAddr != carol.addr {
+		t.Fatal("failed")
+	}
+	if _, err := eml.Open(env, carol); err != nil || env.Subject != "build failed" {
+		t.Fatal("recipient can't open the mail", err)
+	}
+
+	err = smtp.SendMail(ln.Addr().String(), nil, "ci@example.com",
+		[]string{"nobody@bmail.com"}, []byte(msg))
+	if err == nil || !strings.Contains(err.Error(), "550") {
+		t.Fatal("unknown recipient must be refused", err)
+	}
+
+	t.Log("pass")
+}
@@ -0,0 +1,162 @@
+package test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/realbmail/go-bmail-protocol/bmp"
+	"github.com/realbmail/go-bmail-protocol/bmprotocol"
+	"github.com/realbmail/go-bmail-protocol/spec"
+	"github.com/realbmail/go-bmail-protocol/translayer"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const specDir = "../spec"
+
+//the document in spec is what the Go types give, go run ./cmd/bmspec
+//writes it again after a change
+func Test_SpecFiles(t *testing.T) {
+	files, err := spec.Files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range files {
+		got, err := ioutil.ReadFile(filepath.Join(specDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatal(name, "is out of date, run go run ./cmd/bmspec")
+		}
+	}
+	t.Log("pass")
+}
+
+func Test_SpecTypes(t *testing.T) {
+	s, err := spec.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := map[string]bool{}
+	frames := map[string]string{} //stack/name -> side sending it
+	for _, mt := range s.Types {
+		if mt.Name == "" || names[mt.Name] {
+			t.Fatal("type", mt.Id, "has no name of its own")
+		}
+		names[mt.Name] = true
+		if len(mt.Frames) == 0 && mt.Note == "" {
+			t.Fatal(mt.Name, "has no frame")
+		}
+		for _, f := range mt.Frames {
+			frames[f.Stack+"/"+mt.Name] = f.From
+		}
+	}
+	if len(s.Types) != int(translayer.MAX_TYP-translayer.MIN_TYP-1) {
+		t.Fatal("types", len(s.Types))
+	}
+
+	for _, m := range s.Machines {
+		for _, tr := range m.Transitions {
+			by, ok := frames[m.Stack+"/"+tr.Msg]
+			if !ok || by != tr.By {
+				t.Fatal(m.Name, tr.Msg, "is not sent by", tr.By, "in", m.Stack)
+			}
+		}
+	}
+	t.Log("pass")
+}
+
+//every vector unpacks to its value and packs to its frame again
+func Test_SpecVectors(t *testing.T) {
+	data, err := ioutil.ReadFile(filepath.Join(specDir, "vectors.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var vs []spec.Vector
+	if err := json.Unmarshal(data, &vs); err != nil {
+		t.Fatal(err)
+	}
+	if len(vs) == 0 {
+		t.Fatal("no vectors")
+	}
+
+	for _, v := range vs {
+		frame, err := hex.DecodeString(v.Frame)
+		if err != nil {
+			t.Fatal(v.Name, err)
+		}
+		bmtl := &translayer.BMTransLayer{}
+		if _, err := bmtl.UnPack(frame); err != nil {
+			t.Fatal(v.Name, err)
+		}
+		body := frame[translayer.BMHeadSize():]
+		if bmtl.GetVersion() != v.Ver || bmtl.GetMsgType() != v.Type || int(bmtl.GetDataLen()) != len(body) {
+			t.Fatal(v.Name, "bad head", bmtl.String())
+		}
+
+		m := spec.Empty(v.Stack, v.Type)
+		if m == nil {
+			if len(body) != 0 || v.Value != nil {
+				t.Fatal(v.Name, "header only frame with data")
+			}
+			continue
+		}
+
+		if v.Stack == spec.StackJSON {
+			codec, err := bmp.CodecOf(v.Ver)
+			if err != nil {
+				t.Fatal(v.Name, err)
+			}
+			if err := codec.Unmarshal(body, m); err != nil {
+				t.Fatal(v.Name, err)
+			}
+		} else {
+			reflect.ValueOf(m).Elem().FieldByName("BMTransLayer").Set(reflect.ValueOf(*bmtl))
+			n, err := m.(interface{ UnPack([]byte) (int, error) }).UnPack(body)
+			if err != nil {
+				t.Fatal(v.Name, err)
+			}
+			if sa, ok := m.(*bmprotocol.SendAttachment); ok {
+				sa.File = bytes.NewReader(body[n:])
+			}
+		}
+
+		again, err := spec.Pack(v.Stack, v.Ver, m)
+		if err != nil {
+			t.Fatal(v.Name, err)
+		}
+		if !bytes.Equal(again, frame) {
+			t.Fatal(v.Name, "packs to", hex.EncodeToString(again))
+		}
+
+		value, _ := json.Marshal(m)
+		if !sameJSON(value, v.Value) {
+			t.Fatal(v.Name, "unpacks to", string(value))
+		}
+		if h := spec.Hashes(m); !reflect.DeepEqual(h, v.Hashes) {
+			t.Fatal(v.Name, "hashes", h)
+		}
+		if in, err := spec.HashInputs(m); err != nil || !reflect.DeepEqual(in, v.Inputs) {
+			t.Fatal(v.Name, "inputs", in, err)
+		}
+		for k, in := range v.Inputs {
+			if hash := sha256.Sum256([]byte(in)); hex.EncodeToString(hash[:]) != v.Hashes[k] {
+				t.Fatal(v.Name, "input of", k, "is not what", v.Hashes[k], "is the hash of")
+			}
+		}
+	}
+	t.Log("pass")
+}
+
+func sameJSON(a, b []byte) bool {
+	var va, vb interface{}
+	if json.Unmarshal(a, &va) != nil || json.Unmarshal(b, &vb) != nil {
+		return false
+	}
+	return reflect.DeepEqual(va, vb)
+}
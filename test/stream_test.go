@@ -0,0 +1,102 @@
+package test
+
+import (
+	"bytes"
+	"github.com/realbmail/go-bmail-protocol/stream"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+func sealStream(t *testing.T, key, plain []byte) []byte {
+	buf := &bytes.Buffer{}
+	sw, err := stream.NewWriter(key, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//odd write sizes cross chunk borders
+	for p := plain; len(p) > 0; {
+		n := 1000
+		if n > len(p) {
+			n = len(p)
+		}
+		sw.Write(p[:n])
+		p = p[n:]
+	}
+	if err := sw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func openStream(key, data []byte) ([]byte, error) {
+	sr, err := stream.NewReader(key, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(sr)
+}
+
+func Test_StreamRoundTrip(t *testing.T) {
+	key := make([]byte, stream.KeySize)
+	rand.Read(key)
+
+	for _, size := range []int{0, 1, stream.ChunkSize - 1, stream.ChunkSize, stream.ChunkSize + 1, 3 * stream.ChunkSize} {
+		plain := make([]byte, size)
+		rand.Read(plain)
+
+		data := sealStream(t, key, plain)
+		if int64(len(data)) != stream.CipherSize(int64(size)) {
+			t.Fatal("cipher size not match", size, len(data))
+		}
+
+		got, err := openStream(key, data)
+		if err != nil || !bytes.Equal(got, plain) {
+			t.Fatal("failed", size, err)
+		}
+	}
+
+	t.Log("pass")
+}
+
+func Test_StreamTamper(t *testing.T) {
+	key := make([]byte, stream.KeySize)
+	rand.Read(key)
+
+	plain := make([]byte, 3*stream.ChunkSize+10)
+	rand.Read(plain)
+	data := sealStream(t, key, plain)
+
+	head := len(data) - 3*(stream.ChunkSize+stream.Overhead) - 10 - stream.Overhead
+	chunk := stream.ChunkSize + stream.Overhead
+
+	//cut at a chunk border
+	if _, err := openStream(key, data[:head+2*chunk]); err != stream.ErrTruncated {
+		t.Fatal("cut stream not found", err)
+	}
+
+	//cut inside a chunk
+	if _, err := openStream(key, data[:len(data)-5]); err != stream.ErrAuth {
+		t.Fatal("cut chunk not found", err)
+	}
+
+	//data after the last chunk
+	if _, err := openStream(key, append(append([]byte{}, data...), data[head:head+chunk]...)); err == nil {
+		t.Fatal("trailing data not found")
+	}
+
+	//swap two chunks
+	swapped := append([]byte{}, data...)
+	copy(swapped[head:], data[head+chunk:head+2*chunk])
+	copy(swapped[head+chunk:], data[head:head+chunk])
+	if _, err := openStream(key, swapped); err != stream.ErrAuth {
+		t.Fatal("reorder not found", err)
+	}
+
+	other := make([]byte, stream.KeySize)
+	if _, err := openStream(other, data); err != stream.ErrAuth {
+		t.Fatal("wrong key not found", err)
+	}
+
+	t.Log("pass")
+}
@@ -0,0 +1,183 @@
+package test
+
+import (
+	"github.com/google/uuid"
+	"github.com/realbmail/go-bmail-protocol/bmp"
+	"github.com/realbmail/go-bmail-protocol/bmp/client"
+	"github.com/realbmail/go-bmail-protocol/bpop"
+	"github.com/realbmail/go-bmail-protocol/keystore"
+	"github.com/realbmail/go-bmail-protocol/subkey"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testIdentities(t *testing.T) (*keystore.Identity, *keystore.Identity) {
+	dir, err := ioutil.TempDir("", "subkey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ks, _ := keystore.Open(filepath.Join(dir, "keys.json"), keystore.StaticPassword("123"))
+	master, err := ks.Create("master", "alice@bmail.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err := ks.Create("laptop", "alice@bmail.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return master, sub
+}
+
+func Test_SubKeyChain(t *testing.T) {
+	master, sub := testIdentities(t)
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	day := int64(24 * time.Hour / time.Millisecond)
+
+	cert, err := subkey.Issue(master, sub.Address(), "laptop", now-day, now+day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rl := subkey.NewRevocations()
+	c := &subkey.Checker{Revocations: rl}
+
+	msg := []byte("sn")
+	sig, _ := sub.Sign(msg)
+	if err := c.Verify(master.Address(), cert, msg, sig, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Verify(master.Address(), nil, msg, sig, now); err != subkey.ErrBadSignature {
+		t.Fatal("sub-key signed without a cert", err)
+	}
+	if err := c.Verify(sub.Address(), cert, msg, sig, now); err != subkey.ErrWrongMaster {
+		t.Fatal("failed", err)
+	}
+	if err := c.Verify(master.Address(), cert, msg, sig, now+2*day); err != subkey.ErrCertExpired {
+		t.Fatal("failed", err)
+	}
+
+	forged := *cert
+	forged.NotAfter = now + 365*day
+	if err := c.Verify(master.Address(), &forged, msg, sig, now); err != subkey.ErrBadCert {
+		t.Fatal("failed", err)
+	}
+
+	r, err := subkey.Revoke(master, sub.Address(), now, "laptop lost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad := *r
+	bad.Reason = "other"
+	if err := rl.Add(&bad); err == nil {
+		t.Fatal("revocation with a bad signature added")
+	}
+	if err := rl.Add(r); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Verify(master.Address(), cert, msg, sig, now); err != subkey.ErrRevoked {
+		t.Fatal("failed", err)
+	}
+
+	//a revocation of the sub-key by another master lifts nothing
+	mallory := newTestWallet("mallory@bmail.com")
+	mr, err := subkey.Revoke(mallory, sub.Address(), now, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rl.Add(mr); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Verify(master.Address(), cert, msg, sig, now); err != subkey.ErrRevoked {
+		t.Fatal("revocation replaced by another master", err)
+	}
+	t.Log("pass")
+}
+
+func Test_SubKeySendMail(t *testing.T) {
+	m := startMockBMTP(t)
+	defer m.Close()
+
+	master, sub := testIdentities(t)
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	cert, err := subkey.Issue(master, sub.Address(), "laptop", now-1000, now+60000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := &subkey.Key{
+		Signer: &subkey.Signer{Sub: sub, Cert: cert},
+		Agreer: master,
+		Mail:   "alice@bmail.com",
+	}
+	rl := subkey.NewRevocations()
+	checker := &subkey.Checker{Revocations: rl}
+	cli, err := client.NewClient(&client.ClientConf{Resolver: &testResolver{}, Wallet: key, Checker: checker})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := &bmp.BMailEnvelope{
+		Eid:           "3f1c8a52-0000-4000-8000-000000000044",
+		FromName:      "alice@bmail.com",
+		FromAddr:      master.Address(),
+		DateSince1970: uint64(now),
+		RCPTs:         []*bmp.Recipient{{ToName: "bob@bmail.com", ToAddr: "BMbob", RcptType: bmp.RcpTypeTo}},
+	}
+	if err := cli.SendMail(env); err != nil {
+		t.Fatal(err)
+	}
+	got := <-m.envs
+	if got.FromCert == nil || got.FromCert.SubKey != sub.Address() {
+		t.Fatal("no sub-key cert in the envelope")
+	}
+	if err := got.VerifySender(checker, now); err != nil {
+		t.Fatal(err)
+	}
+	//the cert is checked at the time the server received the mail, not
+	//the time the sender gives
+	old := *got
+	old.DateSince1970 = uint64(now)
+	if err := old.VerifySender(checker, now+120000); err != subkey.ErrCertExpired {
+		t.Fatal("failed", err)
+	}
+	unsigned := *got
+	unsigned.FromSig = nil
+	if err := unsigned.VerifySender(checker, now); err != subkey.ErrUnsigned {
+		t.Fatal("unsigned envelope passed", err)
+	}
+	got.Subject = "changed"
+	if err := got.VerifySender(checker, now); err != subkey.ErrBadSignature {
+		t.Fatal("failed", err)
+	}
+
+	//a client with a checker drops unsigned mail and mail of a cert out of
+	//date when the server took it
+	nosig := *got
+	nosig.Eid, nosig.FromSig = "3f1c8a52-0000-4000-8000-000000000045", nil
+	late := *got
+	late.Eid, late.Subject = "3f1c8a52-0000-4000-8000-000000000046", ""
+	late.FromSig, _ = sub.Sign(late.SigHash())
+	lateEid, _ := uuid.Parse(late.Eid)
+	got.Subject = ""
+	m.inbox = &bpop.CmdDownloadAck{
+		CryptEps: []*bmp.BMailEnvelope{got, &nosig, &late},
+		Meta:     []*bpop.MailMeta{{Eid: lateEid, Received: now + 120000}},
+	}
+	envs, err := cli.ReceiveEnv(now, true, 10)
+	if err != nil || len(envs) != 1 || envs[0].Eid != env.Eid {
+		t.Fatal("failed", envs, err)
+	}
+
+	r, _ := subkey.Revoke(master, sub.Address(), now, "")
+	rl.Add(r)
+	if err := cli.SendMail(env); err != subkey.ErrRevoked {
+		t.Fatal("sent by a revoked sub-key", err)
+	}
+	if envs, err := cli.ReceiveEnv(now, true, 10); err != nil || len(envs) != 0 {
+		t.Fatal("mail of a revoked sub-key kept", envs, err)
+	}
+	t.Log("pass")
+}
@@ -0,0 +1,119 @@
+package test
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/realbmail/go-bmail-protocol/bmp/client"
+	"github.com/realbmail/go-bmail-protocol/bpop"
+	"github.com/realbmail/go-bmail-protocol/translayer"
+	"testing"
+)
+
+//changeLog is the mailbox of the mock server by its changes, tokens up to
+//expired can't be synced from any more
+type changeLog struct {
+	changes []*bpop.MailChange
+	expired uint64
+	mail    string
+}
+
+func (cl *changeLog) change(kind int, eid uuid.UUID, flags uint32, folder string) {
+	cl.changes = append(cl.changes, &bpop.MailChange{
+		Token:  uint64(len(cl.changes) + 1),
+		Kind:   kind,
+		Eid:    eid,
+		Flags:  flags,
+		Folder: folder,
+	})
+}
+
+func (cl *changeLog) sync(data []byte) (int, bpop.CommandContent) {
+	cmd := &bpop.CmdSync{}
+	if err := json.Unmarshal(data, cmd); err != nil {
+		return -1, &bpop.CmdSyncAck{}
+	}
+	cl.mail = cmd.MailAddr
+	if cmd.ChangeToken != 0 && cmd.ChangeToken <= cl.expired {
+		return bpop.EC_Token_Expired, &bpop.CmdSyncAck{}
+	}
+
+	ack := &bpop.CmdSyncAck{ChangeToken: cmd.ChangeToken}
+	for _, c := range cl.changes {
+		if c.Token <= cmd.ChangeToken {
+			continue
+		}
+		if len(ack.Changes) == cmd.MailCnt {
+			ack.More = true
+			break
+		}
+		ack.Changes = append(ack.Changes, c)
+		ack.ChangeToken = c.Token
+	}
+	if len(ack.Changes) == 0 {
+		return bpop.EC_No_Mail, &bpop.CmdSyncAck{}
+	}
+	return bpop.EC_Success, ack
+}
+
+func startChangeLog(t *testing.T) (*mockBMTP, *changeLog) {
+	m := startMockBMTP(t)
+	cl := &changeLog{}
+	m.cmds = map[uint16]cmdHandler{translayer.SYNC: cl.sync}
+	return m, cl
+}
+
+func Test_SyncEnv(t *testing.T) {
+	m, cl := startChangeLog(t)
+	defer m.Close()
+
+	eids := []uuid.UUID{uuid.New(), uuid.New(), uuid.New()}
+	for _, eid := range eids {
+		cl.change(bpop.ChangeNew, eid, 0, bpop.FolderInbox)
+	}
+	cli := newMockClient(t)
+
+	//changes come in pages while More is set
+	var (
+		token uint64
+		got   []uuid.UUID
+	)
+	for more := true; more; {
+		ack, err := cli.SyncEnv(token, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range ack.Changes {
+			got = append(got, c.Eid)
+		}
+		token, more = ack.ChangeToken, ack.More
+	}
+	if len(got) != 3 || got[0] != eids[0] || got[2] != eids[2] || token != 3 || cl.mail != "alice@bmail.com" {
+		t.Fatal("failed", got, token, cl.mail)
+	}
+
+	//nothing new keeps the token
+	ack, err := cli.SyncEnv(token, 2)
+	if err != nil || ack.ChangeToken != token || len(ack.Changes) != 0 {
+		t.Fatal("failed", ack, err)
+	}
+
+	cl.change(bpop.ChangeDeleted, eids[1], 0, "")
+	ack, err = cli.SyncEnv(token, 2)
+	if err != nil || len(ack.Changes) != 1 || ack.Changes[0].Kind != bpop.ChangeDeleted || ack.ChangeToken != 4 {
+		t.Fatal("failed", ack, err)
+	}
+
+	cl.expired = 2
+	if _, err := cli.SyncEnv(1, 2); err != client.ErrTokenExpired {
+		t.Fatal("expired token not reported", err)
+	}
+	if ack, err = cli.SyncEnv(0, 10); err != nil || len(ack.Changes) != 4 {
+		t.Fatal("sync from 0 failed", err)
+	}
+
+	m.forge = true
+	if _, err := cli.SyncEnv(0, 10); err == nil {
+		t.Fatal("forged ack taken")
+	}
+	t.Log("pass")
+}
@@ -61,14 +61,29 @@ func (bmtl *BMTransLayer) GetVersion() uint16 {
 	return bmtl.ver
 }
 
+//SetVersion set the version of the frame, it selects the codec of the body
+func (bmtl *BMTransLayer) SetVersion(ver uint16) {
+	bmtl.ver = ver
+}
+
 func (bmtl *BMTransLayer) GetMsgType() uint16 {
-	return bmtl.typ
+	return MsgTypeOf(bmtl.typ)
+}
+
+//GetCompress return the codec of the data after the head
+func (bmtl *BMTransLayer) GetCompress() uint16 {
+	return CompressOf(bmtl.typ)
+}
+
+func (bmtl *BMTransLayer) SetCompress(compress uint16) {
+	bmtl.typ = WithCompress(bmtl.typ, compress)
 }
 
 func (bmtl *BMTransLayer) String() string {
 	s := fmt.Sprintf("Version: %-4d", bmtl.ver)
 	//s += fmt.Sprintf("CryptType: %-4d", bmtl.cryptType)
-	s += fmt.Sprintf("MsgType: %-4d", bmtl.typ)
+	s += fmt.Sprintf("MsgType: %-4d", bmtl.GetMsgType())
+	s += fmt.Sprintf("Compress: %-4d", bmtl.GetCompress())
 	s += fmt.Sprintf("DataLength:%-8d\r\n", bmtl.dataLen)
 
 	return s
@@ -118,27 +133,29 @@ func UInt64ToBuf(ui64 uint64) []byte {
 }
 
 func (bmtl *BMTransLayer) Pack() ([]byte, error) {
-
-	if bmtl.typ <= MIN_TYP || bmtl.typ > MAX_TYP {
-		return nil, errors.New("BMail Action Type Error")
+	r := make([]byte, BMHeadSize())
+	if err := bmtl.PackTo(r); err != nil {
+		return nil, err
 	}
 
-	var r []byte
-
-	bufl := UInt16ToBuf(uint16(bmtl.ver))
-	r = append(r, bufl...)
-	//
-	//bufl = UInt16ToBuf(uint16(bmtl.cryptType))
-	//r = append(r, bufl...)
+	return r, nil
+}
 
-	bufl = UInt16ToBuf(uint16(bmtl.typ))
-	r = append(r, bufl...)
+//PackTo pack the head into the first BMHeadSize bytes of buf
+func (bmtl *BMTransLayer) PackTo(buf []byte) error {
 
-	bufl = UInt32ToBuf(bmtl.dataLen)
+	if typ := bmtl.GetMsgType(); typ <= MIN_TYP || typ > MAX_TYP {
+		return errors.New("BMail Action Type Error")
+	}
+	if len(buf) < BMHeadSize() {
+		return errors.New("buffer too small for the head")
+	}
 
-	r = append(r, bufl...)
+	binary.BigEndian.PutUint16(buf, bmtl.ver)
+	binary.BigEndian.PutUint16(buf[Uint16Size:], bmtl.typ)
+	binary.BigEndian.PutUint32(buf[2*Uint16Size:], bmtl.dataLen)
 
-	return r, nil
+	return nil
 }
 
 func (bmtl *BMTransLayer) UnPack(data []byte) (int, error) {
@@ -156,7 +173,7 @@ func (bmtl *BMTransLayer) UnPack(data []byte) (int, error) {
 	bmtl.typ = binary.BigEndian.Uint16(data[offset:])
 	offset += Uint16Size
 
-	if bmtl.typ <= MIN_TYP || bmtl.typ >= MAX_TYP {
+	if typ := bmtl.GetMsgType(); typ <= MIN_TYP || typ >= MAX_TYP {
 		return 0, errors.New("BMail Action Type Error")
 	}
 
@@ -0,0 +1,542 @@
+package translayer
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"sync"
+	"unsafe"
+)
+
+//the body of a BMAILVER2 frame is CBOR (RFC 8949), described by
+//spec/bmailv2.cddl. A struct is a map of uint keys, the key of a field is
+//its `cbor:"N"` tag, or the key before it + 1, so a struct with no tags
+//numbers its fields 1, 2, 3... in order. Keys go out in order, zero values
+//are left out and unknown keys are skipped. Hashes and signatures are over
+//the JSON of the struct decoded, a field a peer does not know is lost and
+//the hash of what is left is not the one signed, so fields are added with a
+//new version, not only with a new key.
+//
+//bool, ints, string, []byte and [N]byte, slices, pointers (nil is null)
+//and structs are supported, an embedded BMTransLayer is the head and
+//`cbor:"-"` fields are not packed. An interface field is packed as the
+//value it holds, it is unpacked into the pointer it holds before.
+
+const (
+	cborUint byte = iota << 5
+	cborNegInt
+	cborBytes
+	cborText
+	cborArray
+	cborMap
+	cborTag
+	cborSimple
+)
+
+const (
+	cborFalse = cborSimple | 20
+	cborTrue  = cborSimple | 21
+	cborNull  = cborSimple | 22
+
+	cborMaxDepth = 32
+)
+
+var ErrCbor = errors.New("bad cbor data")
+var ErrCborLimit = errors.New("cbor: over the limit")
+
+//CborLimits bound what CborUnmarshal takes from a peer
+type CborLimits struct {
+	MaxBytes int //of a bytes or text item, and of all slices made
+	MaxCount int //elements of an array
+}
+
+//DefaultCborLimits are the limits of CborUnmarshal, the DefaultLimits of
+//bmprotocol are the same
+var DefaultCborLimits = CborLimits{
+	MaxBytes: MaxDecompressSize,
+	MaxCount: 1 << 16,
+}
+
+var bmtlType = reflect.TypeOf(BMTransLayer{})
+
+type cborField struct {
+	key   uint64
+	index int
+	name  string
+}
+
+var cborFields sync.Map //reflect.Type -> []cborField
+
+func cborFieldsOf(t reflect.Type) ([]cborField, error) {
+	if fs, ok := cborFields.Load(t); ok {
+		return fs.([]cborField), nil
+	}
+
+	var (
+		fs  []cborField
+		key uint64
+	)
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("cbor")
+		if tag == "-" || sf.Type == bmtlType {
+			continue
+		}
+		if tag == "" {
+			key++
+		} else {
+			k, err := strconv.ParseUint(tag, 10, 32)
+			if err != nil || k <= key {
+				return nil, fmt.Errorf("cbor: bad key of %s.%s", t.Name(), sf.Name)
+			}
+			key = k
+		}
+		fs = append(fs, cborField{key: key, index: i, name: sf.Name})
+	}
+
+	cborFields.Store(t, fs)
+	return fs, nil
+}
+
+//CborKeys give the key of each field of struct type t packed as BMAILVER2,
+//by the name of the field
+func CborKeys(t reflect.Type) (map[string]uint64, error) {
+	fs, err := cborFieldsOf(t)
+	if err != nil {
+		return nil, err
+	}
+	keys := make(map[string]uint64, len(fs))
+	for _, f := range fs {
+		keys[f.name] = f.key
+	}
+	return keys, nil
+}
+
+//cborFieldOf give a settable field, unexported ones too
+func cborFieldOf(v reflect.Value, i int) reflect.Value {
+	f := v.Field(i)
+	if f.CanSet() {
+		return f
+	}
+	return reflect.NewAt(f.Type(), unsafe.Pointer(f.UnsafeAddr())).Elem()
+}
+
+func isBytesType(t reflect.Type) bool {
+	return (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) && t.Elem().Kind() == reflect.Uint8
+}
+
+//CborMarshal pack the struct v points to as BMAILVER2
+func CborMarshal(v interface{}) ([]byte, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return nil, errors.New("cbor: need a pointer to struct")
+	}
+	return cborAppend(nil, rv.Elem(), 0)
+}
+
+func cborHead(dst []byte, major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return append(dst, major|byte(n))
+	case n <= math.MaxUint8:
+		return append(dst, major|24, byte(n))
+	case n <= math.MaxUint16:
+		return append(dst, major|25, byte(n>>8), byte(n))
+	case n <= math.MaxUint32:
+		return append(dst, major|26, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	return append(dst, major|27, byte(n>>56), byte(n>>48), byte(n>>40), byte(n>>32),
+		byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+}
+
+func cborAppend(dst []byte, v reflect.Value, depth int) ([]byte, error) {
+	if depth > cborMaxDepth {
+		return nil, errors.New("cbor: too deep")
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return append(dst, cborTrue), nil
+		}
+		return append(dst, cborFalse), nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i := v.Int()
+		if i < 0 {
+			return cborHead(dst, cborNegInt, uint64(-1-i)), nil
+		}
+		return cborHead(dst, cborUint, uint64(i)), nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return cborHead(dst, cborUint, v.Uint()), nil
+
+	case reflect.String:
+		dst = cborHead(dst, cborText, uint64(v.Len()))
+		return append(dst, v.String()...), nil
+
+	case reflect.Slice, reflect.Array:
+		if isBytesType(v.Type()) {
+			if v.Kind() == reflect.Array && !v.CanAddr() {
+				c := reflect.New(v.Type()).Elem()
+				c.Set(v)
+				v = c
+			}
+			dst = cborHead(dst, cborBytes, uint64(v.Len()))
+			return append(dst, v.Bytes()...), nil
+		}
+		dst = cborHead(dst, cborArray, uint64(v.Len()))
+		var err error
+		for i := 0; i < v.Len(); i++ {
+			if dst, err = cborAppend(dst, v.Index(i), depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return dst, nil
+
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return append(dst, cborNull), nil
+		}
+		return cborAppend(dst, v.Elem(), depth+1)
+
+	case reflect.Struct:
+		if !v.CanAddr() {
+			c := reflect.New(v.Type()).Elem()
+			c.Set(v)
+			v = c
+		}
+		fs, err := cborFieldsOf(v.Type())
+		if err != nil {
+			return nil, err
+		}
+		n := 0
+		for _, f := range fs {
+			if !cborFieldOf(v, f.index).IsZero() {
+				n++
+			}
+		}
+		dst = cborHead(dst, cborMap, uint64(n))
+		for _, f := range fs {
+			fv := cborFieldOf(v, f.index)
+			if fv.IsZero() {
+				continue
+			}
+			dst = cborHead(dst, cborUint, f.key)
+			if dst, err = cborAppend(dst, fv, depth+1); err != nil {
+				return nil, fmt.Errorf("cbor: pack %s: %w", f.name, err)
+			}
+		}
+		return dst, nil
+	}
+
+	return nil, fmt.Errorf("cbor: type %s not supported", v.Type())
+}
+
+type cborDecoder struct {
+	CborLimits
+	data   []byte
+	offset int
+	alloc  int //bytes of the slices made
+}
+
+//CborUnmarshal unpack BMAILVER2 data to the struct v points to with
+//DefaultCborLimits, the whole data must be one item
+func CborUnmarshal(data []byte, v interface{}) error {
+	return CborUnmarshalLimits(data, v, DefaultCborLimits)
+}
+
+//CborUnmarshalLimits is CborUnmarshal with the limits l
+func CborUnmarshalLimits(data []byte, v interface{}, l CborLimits) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return errors.New("cbor: need a pointer to struct")
+	}
+	d := &cborDecoder{CborLimits: l, data: data}
+	if err := d.decode(rv.Elem(), 0); err != nil {
+		return err
+	}
+	if d.offset != len(data) {
+		return fmt.Errorf("cbor: %d bytes after the data: %w", len(data)-d.offset, ErrCbor)
+	}
+	return nil
+}
+
+func (d *cborDecoder) left() int {
+	return len(d.data) - d.offset
+}
+
+//head read the major type and argument of the next item, null is major
+//cborSimple with n 22
+func (d *cborDecoder) head() (byte, uint64, error) {
+	if d.left() < 1 {
+		return 0, 0, ErrCbor
+	}
+	b := d.data[d.offset]
+	d.offset++
+	major, ai := b&0xe0, b&0x1f
+
+	var size int
+	switch {
+	case ai < 24:
+		return major, uint64(ai), nil
+	case ai == 24:
+		size = 1
+	case ai == 25:
+		size = 2
+	case ai == 26:
+		size = 4
+	case ai == 27:
+		size = 8
+	default:
+		//indefinite lengths and reserved values are not used
+		return 0, 0, ErrCbor
+	}
+	if d.left() < size {
+		return 0, 0, ErrCbor
+	}
+	var n uint64
+	for _, c := range d.data[d.offset : d.offset+size] {
+		n = n<<8 | uint64(c)
+	}
+	d.offset += size
+	return major, n, nil
+}
+
+//length check a length or count n can be held by the bytes left
+func (d *cborDecoder) length(n uint64) (int, error) {
+	if n > uint64(d.left()) {
+		return 0, ErrCbor
+	}
+	return int(n), nil
+}
+
+func (d *cborDecoder) bytes(n uint64) ([]byte, error) {
+	l, err := d.length(n)
+	if err != nil {
+		return nil, err
+	}
+	if l > d.MaxBytes {
+		return nil, fmt.Errorf("cbor: %d bytes: %w", l, ErrCborLimit)
+	}
+	b := d.data[d.offset : d.offset+l]
+	d.offset += l
+	return b, nil
+}
+
+//skip an item of a key not known
+func (d *cborDecoder) skip(depth int) error {
+	if depth > cborMaxDepth {
+		return ErrCbor
+	}
+	major, n, err := d.head()
+	if err != nil {
+		return err
+	}
+	switch major {
+	case cborBytes, cborText:
+		_, err = d.bytes(n)
+		return err
+	case cborArray, cborMap:
+		l, err := d.length(n)
+		if err != nil {
+			return err
+		}
+		if major == cborMap {
+			l *= 2
+		}
+		for i := 0; i < l; i++ {
+			if err := d.skip(depth + 1); err != nil {
+				return err
+			}
+		}
+	case cborTag:
+		return ErrCbor
+	}
+	return nil
+}
+
+func (d *cborDecoder) isNull() bool {
+	if d.left() > 0 && d.data[d.offset] == cborNull {
+		d.offset++
+		return true
+	}
+	return false
+}
+
+func (d *cborDecoder) decode(v reflect.Value, depth int) error {
+	if depth > cborMaxDepth {
+		return fmt.Errorf("cbor: too deep: %w", ErrCbor)
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if d.isNull() {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return d.decode(v.Elem(), depth+1)
+
+	case reflect.Interface:
+		if d.isNull() {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		if v.IsNil() || v.Elem().Kind() != reflect.Ptr {
+			return fmt.Errorf("cbor: can't unpack %s of no value", v.Type())
+		}
+		return d.decode(v.Elem().Elem(), depth+1)
+	}
+
+	major, n, err := d.head()
+	if err != nil {
+		return err
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if major != cborSimple || (n != 20 && n != 21) {
+			return ErrCbor
+		}
+		v.SetBool(n == 21)
+		return nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var i int64
+		switch {
+		case major == cborUint && n <= math.MaxInt64:
+			i = int64(n)
+		case major == cborNegInt && n <= math.MaxInt64:
+			i = -1 - int64(n)
+		default:
+			return ErrCbor
+		}
+		if v.OverflowInt(i) {
+			return fmt.Errorf("cbor: %d overflows %s: %w", i, v.Type(), ErrCbor)
+		}
+		v.SetInt(i)
+		return nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if major != cborUint {
+			return ErrCbor
+		}
+		if v.OverflowUint(n) {
+			return fmt.Errorf("cbor: %d overflows %s: %w", n, v.Type(), ErrCbor)
+		}
+		v.SetUint(n)
+		return nil
+
+	case reflect.String:
+		if major != cborText {
+			return ErrCbor
+		}
+		b, err := d.bytes(n)
+		if err != nil {
+			return err
+		}
+		v.SetString(string(b))
+		return nil
+
+	case reflect.Slice, reflect.Array:
+		if isBytesType(v.Type()) {
+			if major != cborBytes {
+				return ErrCbor
+			}
+			b, err := d.bytes(n)
+			if err != nil {
+				return err
+			}
+			if v.Kind() == reflect.Array {
+				if len(b) != v.Len() {
+					return fmt.Errorf("cbor: %d bytes for %s: %w", len(b), v.Type(), ErrCbor)
+				}
+				reflect.Copy(v, reflect.ValueOf(b))
+				return nil
+			}
+			v.SetBytes(append([]byte(nil), b...))
+			return nil
+		}
+
+		if major != cborArray {
+			return ErrCbor
+		}
+		//every element takes one byte at the least
+		l, err := d.length(n)
+		if err != nil {
+			return err
+		}
+		if v.Kind() == reflect.Array {
+			if l != v.Len() {
+				return ErrCbor
+			}
+		} else {
+			//an element of one byte can take far more memory, the slices
+			//made are bounded apart from the data
+			size := uint64(l) * uint64(v.Type().Elem().Size())
+			if l > d.MaxCount || size > uint64(d.MaxBytes-d.alloc) {
+				return fmt.Errorf("cbor: %d elements of %s: %w", l, v.Type(), ErrCborLimit)
+			}
+			d.alloc += int(size)
+			v.Set(reflect.MakeSlice(v.Type(), l, l))
+		}
+		for i := 0; i < l; i++ {
+			if err := d.decode(v.Index(i), depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case reflect.Struct:
+		if major != cborMap {
+			return ErrCbor
+		}
+		l, err := d.length(n)
+		if err != nil {
+			return err
+		}
+		fs, err := cborFieldsOf(v.Type())
+		if err != nil {
+			return err
+		}
+		var last uint64
+		for i := 0; i < l; i++ {
+			km, key, err := d.head()
+			if err != nil {
+				return err
+			}
+			if km != cborUint || (i > 0 && key <= last) {
+				return fmt.Errorf("cbor: keys of %s out of order: %w", v.Type().Name(), ErrCbor)
+			}
+			last = key
+
+			f := cborFieldByKey(fs, key)
+			if f == nil {
+				if err := d.skip(depth + 1); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := d.decode(cborFieldOf(v, f.index), depth+1); err != nil {
+				return fmt.Errorf("cbor: unpack %s: %w", f.name, err)
+			}
+		}
+		return nil
+	}
+
+	return fmt.Errorf("cbor: type %s not supported", v.Type())
+}
+
+func cborFieldByKey(fs []cborField, key uint64) *cborField {
+	for i := range fs {
+		if fs[i].key == key {
+			return &fs[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package translayer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+)
+
+//a frame body may be compressed, the codec is put in the high bits of the
+//message type of the frame head. Each side only compresses with a codec
+//the peer listed (HELOACK for the server, the hello/command of the client),
+//so old peers never see it.
+//
+//bodies carry envelopes already encrypted by the sender, the codec only
+//packs the framing and base64 around them. Mail text is not compressed
+//before encryption, it mixes text an attacker chooses with secrets and the
+//compressed length would leak them.
+const (
+	CompressNone uint16 = iota
+	CompressGzip
+	CompressZstd
+)
+
+const (
+	compressShift        = 12
+	msgTypeMask   uint16 = 1<<compressShift - 1
+)
+
+var (
+	//frames smaller than this are sent as they are
+	CompressMinSize = 512
+	//a body growing over this when decompressed is refused
+	MaxDecompressSize = 64 << 20
+)
+
+var ErrDecompressTooLarge = errors.New("decompressed data too large")
+
+type Compressor interface {
+	Compress(data []byte) ([]byte, error)
+	//Decompress must not return more than limit bytes
+	Decompress(data []byte, limit int) ([]byte, error)
+}
+
+//codecs by preference, best first
+var compressors = []struct {
+	id  uint16
+	cmp Compressor
+}{
+	{CompressGzip, gzipCompressor{}},
+}
+
+//RegCompressor add a codec, zstd is not built in and is added this way
+func RegCompressor(id uint16, cmp Compressor) {
+	for i := range compressors {
+		if compressors[i].id == id {
+			compressors[i].cmp = cmp
+			return
+		}
+	}
+	compressors = append([]struct {
+		id  uint16
+		cmp Compressor
+	}{{id, cmp}}, compressors...)
+}
+
+func getCompressor(id uint16) Compressor {
+	for _, c := range compressors {
+		if c.id == id {
+			return c.cmp
+		}
+	}
+	return nil
+}
+
+//SupportedCompress list the codecs of this side, best first
+func SupportedCompress() []uint16 {
+	var r []uint16
+	for _, c := range compressors {
+		r = append(r, c.id)
+	}
+	return r
+}
+
+//ChooseCompress pick the best codec both sides have, CompressNone if none
+func ChooseCompress(peer []uint16) uint16 {
+	for _, c := range compressors {
+		for _, p := range peer {
+			if p == c.id {
+				return c.id
+			}
+		}
+	}
+	return CompressNone
+}
+
+func MsgTypeOf(typ uint16) uint16 {
+	return typ & msgTypeMask
+}
+
+func CompressOf(typ uint16) uint16 {
+	return typ >> compressShift
+}
+
+func WithCompress(typ, compress uint16) uint16 {
+	return MsgTypeOf(typ) | compress<<compressShift
+}
+
+//Compress return data packed by codec id, ok is false when data is kept
+//as it is (too small, no gain or unknown codec)
+func Compress(id uint16, data []byte) ([]byte, bool) {
+	cmp := getCompressor(id)
+	if cmp == nil || len(data) < CompressMinSize {
+		return data, false
+	}
+	r, err := cmp.Compress(data)
+	if err != nil || len(r) >= len(data) {
+		return data, false
+	}
+	return r, true
+}
+
+func Decompress(id uint16, data []byte) ([]byte, error) {
+	if id == CompressNone {
+		return data, nil
+	}
+	cmp := getCompressor(id)
+	if cmp == nil {
+		return nil, errors.New("unknown compress codec")
+	}
+	return cmp.Decompress(data, MaxDecompressSize)
+}
+
+type gzipCompressor struct{}
+
+func (gzipCompressor) Compress(data []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	w := gzip.NewWriter(buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCompressor) Decompress(data []byte, limit int) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	out, err := ioutil.ReadAll(io.LimitReader(r, int64(limit)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > limit {
+		return nil, ErrDecompressTooLarge
+	}
+	return out, nil
+}
@@ -32,9 +32,92 @@ const (
 	CONTACT_DEL
 	CONTACT_PULL
 
+	//bpop sync
+	SYNC
+	SYNC_RESP
+
+	//bpop flags and folders
+	SET_FLAGS
+	SET_FLAGS_RESP
+	MOVE
+	MOVE_RESP
+	LIST_FOLDERS
+	LIST_FOLDERS_RESP
+
+	//bpop push
+	IDLE
+	IDLE_NOTIFY
+	IDLE_DONE
+
+	//bpop thread
+	THREAD
+	THREAD_RESP
+
+	//bpop search
+	SEARCH
+	SEARCH_RESP
+
+	//attachment download
+	RETR_ATTACHMENT
+	RETR_ATTACHMENT_RESP
+	CHECK_ATTACHMENT
+	CHECK_ATTACHMENT_RESP
+
 	MAX_TYP
 )
 
+var msgTypeNames = [MAX_TYP]string{
+	HELLO:                 "HELLO",
+	HELLO_ACK:             "HELLO_ACK",
+	SEND_ENVELOPE:         "SEND_ENVELOPE",
+	RESP_ENVELOPE:         "RESP_ENVELOPE",
+	SEND_CRYPT_ENVELOPE:   "SEND_CRYPT_ENVELOPE",
+	RESP_CRYPT_ENVELOPE:   "RESP_CRYPT_ENVELOPE",
+	SEND_ATTACHMNENT:      "SEND_ATTACHMNENT",
+	RESP_ATTACHMENT:       "RESP_ATTACHMENT",
+	STAT:                  "STAT",
+	STAT_RESP:             "STAT_RESP",
+	LIST:                  "LIST",
+	LIST_RESP:             "LIST_RESP",
+	RETR:                  "RETR",
+	RETR_RESP:             "RETR_RESP",
+	DELETE:                "DELETE",
+	DELETE_RESP:           "DELETE_RESP",
+	CONTACT_HELLO:         "CONTACT_HELLO",
+	CONTACT_HELLO_RESP:    "CONTACT_HELLO_RESP",
+	CONTACT_ADD:           "CONTACT_ADD",
+	CONTACT_DEL:           "CONTACT_DEL",
+	CONTACT_PULL:          "CONTACT_PULL",
+	SYNC:                  "SYNC",
+	SYNC_RESP:             "SYNC_RESP",
+	SET_FLAGS:             "SET_FLAGS",
+	SET_FLAGS_RESP:        "SET_FLAGS_RESP",
+	MOVE:                  "MOVE",
+	MOVE_RESP:             "MOVE_RESP",
+	LIST_FOLDERS:          "LIST_FOLDERS",
+	LIST_FOLDERS_RESP:     "LIST_FOLDERS_RESP",
+	IDLE:                  "IDLE",
+	IDLE_NOTIFY:           "IDLE_NOTIFY",
+	IDLE_DONE:             "IDLE_DONE",
+	THREAD:                "THREAD",
+	THREAD_RESP:           "THREAD_RESP",
+	SEARCH:                "SEARCH",
+	SEARCH_RESP:           "SEARCH_RESP",
+	RETR_ATTACHMENT:       "RETR_ATTACHMENT",
+	RETR_ATTACHMENT_RESP:  "RETR_ATTACHMENT_RESP",
+	CHECK_ATTACHMENT:      "CHECK_ATTACHMENT",
+	CHECK_ATTACHMENT_RESP: "CHECK_ATTACHMENT_RESP",
+}
+
+//MsgTypeName give the name of message type typ, "" for none
+func MsgTypeName(typ uint16) string {
+	typ = MsgTypeOf(typ)
+	if typ >= MAX_TYP {
+		return ""
+	}
+	return msgTypeNames[typ]
+}
+
 const (
 	Uin8Size   int = 1
 	Uint16Size int = 2
@@ -0,0 +1,22 @@
+package translayer
+
+//versions of the frame body this side reads, best first. BMAILVER1 is the
+//bm layout of bmprotocol and JSON of bmp, BMAILVER2 is CBOR.
+var versions = []uint16{BMAILVER2, BMAILVER1}
+
+//SupportedVersion list the versions of this side, best first
+func SupportedVersion() []uint16 {
+	return append([]uint16(nil), versions...)
+}
+
+//ChooseVersion pick the best version both sides have, BMAILVER1 if none
+func ChooseVersion(peer []uint16) uint16 {
+	for _, v := range versions {
+		for _, p := range peer {
+			if p == v {
+				return v
+			}
+		}
+	}
+	return BMAILVER1
+}